@@ -65,5 +65,5 @@ func parseOneLineTass(line string) (uint16, string, error) {
 }
 
 func makeTassCmd(asmBin, sourceDir string, outName string, progName string, binDir string, obFile string) *exec.Cmd {
-	return exec.Command(asmBin, "-I", sourceDir, "-o", outName, "-a", progName)
+	return exec.Command(asmBin, "-I", sourceDir, "-o", outName, "-a", "-L", ListingFileName(outName), "--line-numbers", progName)
 }
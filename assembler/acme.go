@@ -44,5 +44,5 @@ func parseOneLineAcme(line string) (uint16, string, error) {
 }
 
 func makeAcmeCmd(asmBin, sourceDir string, outName string, progName string, binDir string, obFile string) *exec.Cmd {
-	return exec.Command(asmBin, "-I", sourceDir, "-o", outName, "-f", "cbm", progName)
+	return exec.Command(asmBin, "-I", sourceDir, "-o", outName, "-f", "cbm", "-r", ListingFileName(outName), progName)
 }
@@ -2,10 +2,12 @@ package assembler
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path"
+	"strings"
 )
 
 type Assembler interface {
@@ -15,6 +17,15 @@ type Assembler interface {
 	GetDefaultSrc() string
 }
 
+// ListingFileName returns the name of the listing which is written next to the given binary. Its
+// format depends on the assembler: ACME writes a report file, 64tass and ca65 write listings.
+func ListingFileName(binaryName string) string {
+	return strings.TrimSuffix(binaryName, ".bin") + ".lst"
+}
+
+// errSkipLine is returned by a LineParseFunc for lines which do not define a label
+var errSkipLine = errors.New("line does not define a label")
+
 type LineParseFunc func(string) (uint16, string, error)
 type GenCommandFunc func(asmBin string, sourceDir string, outName string, progName string, binDir string, obFile string) *exec.Cmd
 
@@ -32,6 +43,10 @@ func ParseLabelFile(fileName string, parseOneLine LineParseFunc) (map[uint16][]s
 
 	for fileScanner.Scan() {
 		addr, label, err := parseOneLine(fileScanner.Text())
+		if errors.Is(err, errSkipLine) {
+			continue
+		}
+
 		if err != nil {
 			return nil, fmt.Errorf("error reading label file: %v", err)
 		}
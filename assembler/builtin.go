@@ -0,0 +1,136 @@
+package assembler
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// BuiltinAsmImpl assembles programs in ACME syntax without calling an external assembler. In
+// addition to the binary it writes a label file in the format of ACME's symbol list and a listing
+// in the format of ACME's report file.
+type BuiltinAsmImpl struct {
+	srcDir       string
+	binDir       string
+	testDir      string
+	errorMessage string
+	labels       map[uint16][]string
+}
+
+func NewBuiltin(srcDir string, binDir string, testDir string) *BuiltinAsmImpl {
+	return &BuiltinAsmImpl{
+		srcDir:       srcDir,
+		binDir:       binDir,
+		testDir:      testDir,
+		errorMessage: "",
+		labels:       map[uint16][]string{},
+	}
+}
+
+func (b *BuiltinAsmImpl) ParseLabelFile(fileName string) (map[uint16][]string, error) {
+	return ParseLabelFile(fileName, parseOneLineAcme)
+}
+
+func (b *BuiltinAsmImpl) GetErrorMessage() string {
+	return b.errorMessage
+}
+
+func (b *BuiltinAsmImpl) GetDefaultSrc() string {
+	return defaultDrvAcme
+}
+
+// Labels returns the global labels of the program which has been assembled last
+func (b *BuiltinAsmImpl) Labels() map[uint16][]string {
+	return b.labels
+}
+
+func (b *BuiltinAsmImpl) Assemble(fileName string) (string, error) {
+	mlProg := path.Join(b.binDir, fmt.Sprintf("%s.bin", fileName))
+	mlLabels := path.Join(b.binDir, fmt.Sprintf("%s.lbl", fileName))
+	mlSrc := path.Join(b.testDir, fileName)
+
+	b.errorMessage = ""
+
+	prog, err := AssembleFile(mlSrc, []string{b.srcDir})
+	if err != nil {
+		b.errorMessage = err.Error()
+		return "", fmt.Errorf("unable to assemble '%s'", fileName)
+	}
+
+	data := append([]byte{byte(prog.LoadAddress), byte(prog.LoadAddress >> 8)}, prog.Code...)
+
+	if err = os.WriteFile(mlProg, data, 0600); err != nil {
+		return "", fmt.Errorf("unable to write '%s': %v", mlProg, err)
+	}
+
+	if err = os.WriteFile(mlLabels, []byte(formatLabelsAcme(prog.Labels)), 0600); err != nil {
+		return "", fmt.Errorf("unable to write '%s': %v", mlLabels, err)
+	}
+
+	if err = os.WriteFile(ListingFileName(mlProg), []byte(formatReportAcme(prog.Listing)), 0600); err != nil {
+		return "", fmt.Errorf("unable to write '%s': %v", ListingFileName(mlProg), err)
+	}
+
+	b.labels = prog.Labels
+
+	return mlProg, nil
+}
+
+// formatLabelsAcme creates a symbol list in the format used by ACME
+func formatLabelsAcme(labels map[uint16][]string) string {
+	lines := []string{}
+
+	for addr, names := range labels {
+		for _, j := range names {
+			lines = append(lines, fmt.Sprintf("\t%s\t= $%04x\n", j, addr))
+		}
+	}
+
+	sort.Strings(lines)
+
+	return strings.Join(lines, "")
+}
+
+// formatReportAcme creates a report file in the format used by ACME. In contrast to ACME only the
+// lines which have generated code are contained in the report.
+func formatReportAcme(listing []ListingLine) string {
+	var res strings.Builder
+	sources := map[string][]string{}
+	currentFile := ""
+
+	for _, j := range listing {
+		if j.File != currentFile {
+			currentFile = j.File
+			fmt.Fprintf(&res, "\n; ******** Source: %s\n", j.File)
+		}
+
+		lines, ok := sources[j.File]
+		if !ok {
+			// The file has been read successfully by the assembler
+			data, _ := os.ReadFile(j.File)
+			lines = strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+			sources[j.File] = lines
+		}
+
+		text := ""
+		if j.Line <= len(lines) {
+			text = lines[j.Line-1]
+		}
+
+		hexBytes := ""
+		for i, b := range j.Bytes {
+			if i == 8 {
+				hexBytes += "..."
+				break
+			}
+
+			hexBytes += fmt.Sprintf("%02x", b)
+		}
+
+		fmt.Fprintf(&res, "%6d  %04x %-19s %s\n", j.Line, j.Address, hexBytes, text)
+	}
+
+	return res.String()
+}
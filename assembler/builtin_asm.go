@@ -0,0 +1,952 @@
+package assembler
+
+import (
+	"6502profiler/cpu"
+	"6502profiler/disasm"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Program is the result of running the builtin assembler
+type Program struct {
+	LoadAddress uint16
+	Code        []byte
+	// Labels contains the global labels in the form returned by ParseLabelFile
+	Labels map[uint16][]string
+	// Listing contains the source lines which have generated code in the order of assembly
+	Listing []ListingLine
+}
+
+// ListingLine describes the bytes generated by a source line
+type ListingLine struct {
+	File    string
+	Line    int
+	Address uint16
+	Bytes   []byte
+}
+
+const maxPasses = 16
+const maxIncludeDepth = 32
+const defaultBuiltinCpu = "6502"
+
+// The CPUs which can be selected by !cpu. The names are the same as in ACME.
+var builtinCpus = map[string]cpu.CpuModel{
+	"6502":     cpu.Model6502,
+	"nmos6502": cpu.Model6510,
+	"6510":     cpu.Model6510,
+	"65c02":    cpu.Model65C02,
+	"r65c02":   cpu.ModelR65C02,
+	"w65c02":   cpu.ModelW65C02S,
+}
+
+type asmError struct {
+	file string
+	line int
+	msg  string
+}
+
+func (a *asmError) Error() string {
+	return fmt.Sprintf("Error - File %s, line %d: %s", a.file, a.line, a.msg)
+}
+
+type undefinedRef struct {
+	file string
+	line int
+	name string
+}
+
+// builtinAsm is a two pass assembler for a subset of the ACME syntax. Further passes are made as
+// long as symbols are defined by expressions which refer to symbols defined later in the source.
+type builtinAsm struct {
+	includeDirs []string
+	cpuName     string
+	opcodes     map[string]map[string]byte
+	mnemonics   map[string]bool
+	symbols     map[string]int64
+	globals     map[string]bool
+	// Symbols defined in the current pass
+	defined   map[string]bool
+	pass      int
+	changed   bool
+	undefined []undefinedRef
+	pc        int64
+	pcDefined bool
+	zone      int
+	numZones  int
+	// Name of the last global label. Cheap local labels (@name) belong to it.
+	lastGlobal string
+	// The addressing mode of each instruction is fixed in the pass in which it is first seen
+	modes      map[int]string
+	instrIndex int
+	mem        []byte
+	low        int64
+	high       int64
+	macros     map[string]*macro
+	listing    []ListingLine
+	file       string
+	line       int
+	depth      int
+}
+
+func newBuiltinAsm(includeDirs []string) *builtinAsm {
+	res := &builtinAsm{
+		includeDirs: includeDirs,
+		mnemonics:   map[string]bool{},
+		symbols:     map[string]int64{},
+		globals:     map[string]bool{},
+		modes:       map[int]string{},
+	}
+
+	for _, model := range builtinCpus {
+		for mnemonic := range disasm.Opcodes(model) {
+			res.mnemonics[mnemonic] = true
+		}
+	}
+
+	return res
+}
+
+// AssembleFile assembles the named source file which has to be written in ACME syntax. Files
+// referenced by !source or !binary are searched relative to the current directory and then in
+// includeDirs.
+func AssembleFile(fileName string, includeDirs []string) (*Program, error) {
+	a := newBuiltinAsm(includeDirs)
+	lastNumUndefined := -1
+
+	for {
+		if err := a.runPass(fileName); err != nil {
+			return nil, err
+		}
+
+		if (len(a.undefined) == 0) && !a.changed {
+			break
+		}
+
+		noProgress := !a.changed && (len(a.undefined) == lastNumUndefined)
+
+		if noProgress || (a.pass >= maxPasses) {
+			if len(a.undefined) != 0 {
+				u := a.undefined[0]
+				return nil, &asmError{u.file, u.line, fmt.Sprintf("symbol '%s' undefined", u.name)}
+			}
+
+			return nil, fmt.Errorf("values of symbols do not settle after %d passes", a.pass)
+		}
+
+		lastNumUndefined = len(a.undefined)
+	}
+
+	if a.low < 0 {
+		return nil, fmt.Errorf("no code generated from '%s'", fileName)
+	}
+
+	return a.program(), nil
+}
+
+func (a *builtinAsm) program() *Program {
+	res := &Program{
+		LoadAddress: uint16(a.low),
+		Code:        a.mem[a.low : a.high+1],
+		Labels:      map[uint16][]string{},
+		Listing:     a.listing,
+	}
+
+	names := []string{}
+	for name := range a.globals {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := a.symbols[name]
+		if (value >= 0) && (value <= 0xFFFF) {
+			res.Labels[uint16(value)] = append(res.Labels[uint16(value)], name)
+		}
+	}
+
+	return res
+}
+
+func (a *builtinAsm) runPass(fileName string) error {
+	a.pass++
+	a.changed = false
+	a.undefined = nil
+	a.defined = map[string]bool{}
+	a.pc, a.pcDefined = 0, false
+	a.zone, a.numZones = 0, 0
+	a.lastGlobal = ""
+	a.instrIndex = 0
+	a.mem = make([]byte, 0x10000)
+	a.low, a.high = -1, -1
+	a.macros = map[string]*macro{}
+	a.listing = nil
+
+	// Can not fail
+	_ = a.setCpu(defaultBuiltinCpu)
+
+	return a.assembleFile(fileName)
+}
+
+func (a *builtinAsm) setCpu(name string) error {
+	model, ok := builtinCpus[name]
+	if !ok {
+		return fmt.Errorf("CPU '%s' is not supported", name)
+	}
+
+	a.cpuName = name
+	a.opcodes = disasm.Opcodes(model)
+
+	return nil
+}
+
+func (a *builtinAsm) assembleFile(fileName string) error {
+	if a.depth >= maxIncludeDepth {
+		return fmt.Errorf("too many nested source files")
+	}
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return fmt.Errorf("unable to read source file: %v", err)
+	}
+
+	lines := []sourceLine{}
+	for i, j := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		lines = append(lines, sourceLine{fileName, i + 1, j})
+	}
+
+	oldZone := a.zone
+	a.depth++
+
+	defer func() {
+		a.zone = oldZone
+		a.depth--
+	}()
+
+	// Each source file has its own zone for local labels
+	a.numZones++
+	a.zone = a.numZones
+
+	return a.assembleLines(lines)
+}
+
+// assembleLines assembles a sequence of source lines. Blocks, i.e. lines between a pseudo opcode
+// ending with { and the matching }, are handled by assembleBlock.
+func (a *builtinAsm) assembleLines(lines []sourceLine) error {
+	oldFile, oldLine := a.file, a.line
+
+	defer func() {
+		a.file, a.line = oldFile, oldLine
+	}()
+
+	for i := 0; i < len(lines); i++ {
+		var err error
+
+		a.file, a.line = lines[i].file, lines[i].num
+		code := lines[i].code()
+
+		if strings.HasPrefix(code, "!") && strings.HasSuffix(code, "{") {
+			i, err = a.assembleBlock(lines, i)
+		} else {
+			err = a.assembleLine(code)
+		}
+
+		if err == nil {
+			continue
+		}
+
+		var nestedErr *asmError
+		if errors.As(err, &nestedErr) {
+			return err
+		}
+
+		return &asmError{a.file, a.line, err.Error()}
+	}
+
+	return nil
+}
+
+// splitOutsideQuotes splits s at each occurrence of sep which is not part of a string or a
+// character constant
+func splitOutsideQuotes(s string, sep byte) []string {
+	res := []string{}
+	var quote byte
+	start := 0
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case (s[i] == '"') || (s[i] == '\''):
+			quote = s[i]
+		case s[i] == sep:
+			res = append(res, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(res, s[start:])
+}
+
+func (a *builtinAsm) assembleLine(code string) error {
+	// A colon separates statements
+	for _, j := range splitOutsideQuotes(code, ':') {
+		if err := a.assembleStatement(strings.TrimSpace(j)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *builtinAsm) assembleStatement(stmt string) error {
+	if stmt == "" {
+		return nil
+	}
+
+	if stmt[0] == '!' {
+		return a.assembleDirective(stmt)
+	}
+
+	if stmt[0] == '+' {
+		return a.callMacro(stmt[1:])
+	}
+
+	if stmt[0] == '*' {
+		rest := strings.TrimSpace(stmt[1:])
+		if !strings.HasPrefix(rest, "=") {
+			return fmt.Errorf("syntax error")
+		}
+
+		value, err := a.evalDefined(rest[1:])
+		if err != nil {
+			return err
+		}
+
+		if (value < 0) || (value > 0xFFFF) {
+			return fmt.Errorf("program counter $%X out of range", value)
+		}
+
+		a.pc, a.pcDefined = value, true
+
+		return nil
+	}
+
+	if !isIdentStart(stmt[0]) {
+		return fmt.Errorf("syntax error")
+	}
+
+	end := 1
+	for (end < len(stmt)) && isIdentChar(stmt[end]) {
+		end++
+	}
+
+	word := stmt[:end]
+	rest := strings.TrimSpace(stmt[end:])
+
+	if strings.HasPrefix(rest, "=") {
+		value, known, err := a.eval(rest[1:])
+		if (err != nil) || !known {
+			return err
+		}
+
+		return a.defineSymbol(word, value)
+	}
+
+	if a.mnemonics[strings.ToUpper(word)] {
+		return a.assembleInstruction(strings.ToUpper(word), rest)
+	}
+
+	if !a.pcDefined {
+		return fmt.Errorf("program counter undefined")
+	}
+
+	if err := a.defineSymbol(word, a.pc); err != nil {
+		return err
+	}
+
+	if rest == "" {
+		return nil
+	}
+
+	if !isIdentStart(rest[0]) && (rest[0] != '!') && (rest[0] != '+') {
+		return fmt.Errorf("syntax error")
+	}
+
+	return a.assembleStatement(rest)
+}
+
+// symbolKey returns the name under which a symbol is stored. Local labels start with a dot and
+// belong to the current zone. Cheap local labels start with @ and belong to the last global label.
+func (a *builtinAsm) symbolKey(name string) string {
+	switch name[0] {
+	case '.':
+		return fmt.Sprintf("%s#%d", name, a.zone)
+	case '@':
+		return a.lastGlobal + name
+	}
+
+	return name
+}
+
+func (a *builtinAsm) defineSymbol(name string, value int64) error {
+	key := a.symbolKey(name)
+
+	if a.defined[key] {
+		return fmt.Errorf("symbol '%s' already defined", name)
+	}
+
+	a.defined[key] = true
+
+	if old, ok := a.symbols[key]; ok && (old != value) {
+		a.changed = true
+	}
+
+	a.symbols[key] = value
+
+	if (name[0] != '.') && (name[0] != '@') {
+		a.globals[name] = true
+		a.lastGlobal = name
+	}
+
+	return nil
+}
+
+func (a *builtinAsm) resolve(name string) (int64, bool) {
+	value, ok := a.symbols[a.symbolKey(name)]
+	return value, ok
+}
+
+// eval evaluates an expression. known is false if the expression refers to symbols which are
+// not defined yet.
+func (a *builtinAsm) eval(text string) (value int64, known bool, err error) {
+	p := &exprParser{text: text, pc: a.pc, pcDefined: a.pcDefined, resolve: a.resolve}
+
+	value, err = p.parse()
+	if err != nil {
+		return 0, false, err
+	}
+
+	for _, j := range p.undefined {
+		a.undefined = append(a.undefined, undefinedRef{a.file, a.line, j})
+	}
+
+	return value, len(p.undefined) == 0, nil
+}
+
+// evalDefined evaluates an expression which must not refer to symbols defined later in the source
+func (a *builtinAsm) evalDefined(text string) (int64, error) {
+	value, known, err := a.eval(text)
+	if err != nil {
+		return 0, err
+	}
+
+	if !known {
+		return 0, fmt.Errorf("value of '%s' depends on symbols which are defined later", strings.TrimSpace(text))
+	}
+
+	return value, nil
+}
+
+func (a *builtinAsm) emit(data ...byte) error {
+	if !a.pcDefined {
+		return fmt.Errorf("program counter undefined")
+	}
+
+	last := len(a.listing) - 1
+	if (last < 0) || (a.listing[last].File != a.file) || (a.listing[last].Line != a.line) ||
+		(int64(a.listing[last].Address)+int64(len(a.listing[last].Bytes)) != a.pc) {
+		a.listing = append(a.listing, ListingLine{a.file, a.line, uint16(a.pc), nil})
+		last++
+	}
+
+	for _, j := range data {
+		if a.pc > 0xFFFF {
+			return fmt.Errorf("program counter exceeds $FFFF")
+		}
+
+		a.listing[last].Bytes = append(a.listing[last].Bytes, j)
+
+		a.mem[a.pc] = j
+
+		if (a.low < 0) || (a.pc < a.low) {
+			a.low = a.pc
+		}
+
+		if a.pc > a.high {
+			a.high = a.pc
+		}
+
+		a.pc++
+	}
+
+	return nil
+}
+
+func checkRange(value int64, known bool, min int64, max int64, what string) error {
+	if known && ((value < min) || (value > max)) {
+		return fmt.Errorf("%s $%X out of range", what, value)
+	}
+
+	return nil
+}
+
+// chooseMode selects either the zero page or the absolute variant of an addressing mode. An
+// empty string means that the instruction has no such variant. The decision is made when the
+// instruction is seen for the first time. Operands which are not known at that point are
+// assumed to be absolute addresses.
+func (a *builtinAsm) chooseMode(index int, modes map[string]byte, zpMode string, absMode string, value int64, known bool) (string, error) {
+	if mode, ok := a.modes[index]; ok {
+		return mode, nil
+	}
+
+	_, hasZp := modes[zpMode]
+	_, hasAbs := modes[absMode]
+
+	var mode string
+
+	switch {
+	case hasZp && (!hasAbs || (known && (value >= 0) && (value <= 0xFF))):
+		mode = zpMode
+	case hasAbs:
+		mode = absMode
+	default:
+		return "", fmt.Errorf("addressing mode not supported by instruction")
+	}
+
+	a.modes[index] = mode
+
+	return mode, nil
+}
+
+// matchingParen returns the position of the parenthesis which closes the one at the start of s
+func matchingParen(s string) int {
+	depth := 0
+	var quote byte
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case (s[i] == '"') || (s[i] == '\''):
+			quote = s[i]
+		case s[i] == '(':
+			depth++
+		case s[i] == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// splitIndex splits an operand into the address expression and the name of the index register
+func splitIndex(operand string) (string, string, error) {
+	parts := splitOutsideQuotes(operand, ',')
+
+	switch len(parts) {
+	case 1:
+		return operand, "", nil
+	case 2:
+		index := strings.ToLower(strings.TrimSpace(parts[1]))
+		if (index != "x") && (index != "y") {
+			return "", "", fmt.Errorf("illegal index register '%s'", strings.TrimSpace(parts[1]))
+		}
+
+		return parts[0], index, nil
+	}
+
+	return "", "", fmt.Errorf("syntax error in operand '%s'", operand)
+}
+
+// indirectModes determines the addressing modes for operands in parentheses. ok is false if the
+// parentheses only group an expression.
+func indirectModes(operand string) (expr string, zpMode string, absMode string, ok bool, err error) {
+	if !strings.HasPrefix(operand, "(") {
+		return "", "", "", false, nil
+	}
+
+	closing := matchingParen(operand)
+	if closing < 0 {
+		return "", "", "", false, fmt.Errorf("missing ')' in operand '%s'", operand)
+	}
+
+	inner := operand[1:closing]
+	suffix := strings.ToLower(strings.ReplaceAll(operand[closing+1:], " ", ""))
+
+	switch suffix {
+	case ",y":
+		return inner, "izy", "", true, nil
+	case "":
+		expr, index, err := splitIndex(inner)
+		if err != nil {
+			return "", "", "", false, err
+		}
+
+		switch index {
+		case "":
+			return expr, "izp", "ind", true, nil
+		case "x":
+			return expr, "izx", "iax", true, nil
+		}
+
+		return "", "", "", false, fmt.Errorf("illegal index register in operand '%s'", operand)
+	}
+
+	return "", "", "", false, nil
+}
+
+func (a *builtinAsm) assembleInstruction(mnemonic string, operand string) error {
+	modes, ok := a.opcodes[mnemonic]
+	if !ok {
+		return fmt.Errorf("instruction '%s' is not supported by CPU '%s'", strings.ToLower(mnemonic), a.cpuName)
+	}
+
+	index := a.instrIndex
+	a.instrIndex++
+
+	if operand == "" {
+		for _, mode := range []string{"imp", "acc"} {
+			if op, ok := modes[mode]; ok {
+				return a.emit(op)
+			}
+		}
+
+		return fmt.Errorf("operand missing")
+	}
+
+	if operand[0] == '#' {
+		op, ok := modes["imm"]
+		if !ok {
+			return fmt.Errorf("immediate addressing not supported by instruction")
+		}
+
+		value, known, err := a.eval(operand[1:])
+		if err != nil {
+			return err
+		}
+
+		if err = checkRange(value, known, -128, 0xFF, "immediate value"); err != nil {
+			return err
+		}
+
+		return a.emit(op, byte(value))
+	}
+
+	if op, ok := modes["zpr"]; ok {
+		return a.assembleBitBranch(op, operand)
+	}
+
+	if op, ok := modes["rel"]; ok {
+		target, known, err := a.eval(operand)
+		if err != nil {
+			return err
+		}
+
+		offset := target - (a.pc + 2)
+		if err = checkRange(offset, known, -128, 127, "branch offset"); err != nil {
+			return err
+		}
+
+		return a.emit(op, byte(offset))
+	}
+
+	expr, zpMode, absMode, isIndirect, err := indirectModes(operand)
+	if err != nil {
+		return err
+	}
+
+	if !isIndirect {
+		var indexReg string
+
+		expr, indexReg, err = splitIndex(operand)
+		if err != nil {
+			return err
+		}
+
+		zpMode, absMode = map[string]string{"": "zp", "x": "zpx", "y": "zpy"}[indexReg], map[string]string{"": "abs", "x": "abx", "y": "aby"}[indexReg]
+	}
+
+	value, known, err := a.eval(expr)
+	if err != nil {
+		return err
+	}
+
+	mode, err := a.chooseMode(index, modes, zpMode, absMode, value, known)
+	if err != nil {
+		return err
+	}
+
+	if mode == zpMode {
+		if err = checkRange(value, known, 0, 0xFF, "zero page address"); err != nil {
+			return err
+		}
+
+		return a.emit(modes[mode], byte(value))
+	}
+
+	if err = checkRange(value, known, 0, 0xFFFF, "address"); err != nil {
+		return err
+	}
+
+	return a.emit(modes[mode], byte(value), byte(value>>8))
+}
+
+// assembleBitBranch assembles BBRx and BBSx which use a zero page address and a branch target
+func (a *builtinAsm) assembleBitBranch(op byte, operand string) error {
+	parts := splitOutsideQuotes(operand, ',')
+	if len(parts) != 2 {
+		return fmt.Errorf("syntax error in operand '%s'", operand)
+	}
+
+	zp, zpKnown, err := a.eval(parts[0])
+	if err != nil {
+		return err
+	}
+
+	target, targetKnown, err := a.eval(parts[1])
+	if err != nil {
+		return err
+	}
+
+	if err = checkRange(zp, zpKnown, 0, 0xFF, "zero page address"); err != nil {
+		return err
+	}
+
+	offset := target - (a.pc + 3)
+	if err = checkRange(offset, targetKnown, -128, 127, "branch offset"); err != nil {
+		return err
+	}
+
+	return a.emit(op, byte(zp), byte(offset))
+}
+
+// unquote returns the contents of a string in double quotes
+func unquote(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+
+	if (len(s) < 2) || (s[0] != '"') || (s[len(s)-1] != '"') {
+		return "", false
+	}
+
+	return s[1 : len(s)-1], true
+}
+
+// findFile returns the path of a file referenced by !source or !binary
+func (a *builtinAsm) findFile(arg string) (string, error) {
+	name, ok := unquote(arg)
+	if !ok {
+		arg = strings.TrimSpace(arg)
+		if (len(arg) < 2) || (arg[0] != '<') || (arg[len(arg)-1] != '>') {
+			return "", fmt.Errorf("file name expected")
+		}
+
+		name = arg[1 : len(arg)-1]
+	}
+
+	candidates := []string{name}
+	for _, j := range a.includeDirs {
+		candidates = append(candidates, path.Join(j, name))
+	}
+
+	for _, j := range candidates {
+		if _, err := os.Stat(j); err == nil {
+			return j, nil
+		}
+	}
+
+	return "", fmt.Errorf("file '%s' not found", name)
+}
+
+func (a *builtinAsm) emitValues(args string, width int) error {
+	min, max, what := int64(-128), int64(0xFF), "byte value"
+	if width == 2 {
+		min, max, what = -32768, 0xFFFF, "word value"
+	}
+
+	for _, j := range splitOutsideQuotes(args, ',') {
+		value, known, err := a.eval(j)
+		if err != nil {
+			return err
+		}
+
+		if err = checkRange(value, known, min, max, what); err != nil {
+			return err
+		}
+
+		if width == 1 {
+			err = a.emit(byte(value))
+		} else {
+			err = a.emit(byte(value), byte(value>>8))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *builtinAsm) emitText(args string) error {
+	for _, j := range splitOutsideQuotes(args, ',') {
+		if text, ok := unquote(j); ok {
+			if err := a.emit([]byte(text)...); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := a.emitValues(j, 1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *builtinAsm) emitFill(args string) error {
+	parts := splitOutsideQuotes(args, ',')
+	if len(parts) > 2 {
+		return fmt.Errorf("syntax error in '%s'", args)
+	}
+
+	count, err := a.evalDefined(parts[0])
+	if err != nil {
+		return err
+	}
+
+	if (count < 0) || (count > 0x10000) {
+		return fmt.Errorf("illegal fill count %d", count)
+	}
+
+	var value int64
+	var known bool = true
+
+	if len(parts) == 2 {
+		value, known, err = a.eval(parts[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = checkRange(value, known, -128, 0xFF, "fill value"); err != nil {
+		return err
+	}
+
+	for i := int64(0); i < count; i++ {
+		if err = a.emit(byte(value)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *builtinAsm) emitBinary(args string) error {
+	parts := splitOutsideQuotes(args, ',')
+	if len(parts) > 3 {
+		return fmt.Errorf("syntax error in '%s'", args)
+	}
+
+	fileName, err := a.findFile(parts[0])
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return fmt.Errorf("unable to read binary file: %v", err)
+	}
+
+	var size int64 = int64(len(data))
+	var skip int64
+
+	if (len(parts) > 1) && (strings.TrimSpace(parts[1]) != "") {
+		if size, err = a.evalDefined(parts[1]); err != nil {
+			return err
+		}
+	}
+
+	if len(parts) > 2 {
+		if skip, err = a.evalDefined(parts[2]); err != nil {
+			return err
+		}
+	}
+
+	if (skip < 0) || (size < 0) || (skip+size > int64(len(data))) {
+		return fmt.Errorf("size or skip value exceed length of '%s'", fileName)
+	}
+
+	return a.emit(data[skip : skip+size]...)
+}
+
+// splitDirective separates the name of a pseudo opcode from its arguments
+func splitDirective(stmt string) (string, string) {
+	if pos := strings.IndexAny(stmt, " \t"); pos >= 0 {
+		return stmt[:pos], strings.TrimSpace(stmt[pos:])
+	}
+
+	return stmt, ""
+}
+
+func (a *builtinAsm) assembleDirective(stmt string) error {
+	name, args := splitDirective(stmt)
+
+	switch strings.ToLower(name) {
+	case "!byte", "!by", "!08", "!8":
+		return a.emitValues(args, 1)
+	case "!word", "!wo", "!16":
+		return a.emitValues(args, 2)
+	case "!text", "!tx":
+		return a.emitText(args)
+	case "!fill", "!fi":
+		return a.emitFill(args)
+	case "!binary", "!bin":
+		return a.emitBinary(args)
+	case "!source", "!src":
+		fileName, err := a.findFile(args)
+		if err != nil {
+			return err
+		}
+
+		return a.assembleFile(fileName)
+	case "!skip", "!sk":
+		count, err := a.evalDefined(args)
+		if err != nil {
+			return err
+		}
+
+		if !a.pcDefined {
+			return fmt.Errorf("program counter undefined")
+		}
+
+		a.pc += count
+
+		return nil
+	case "!cpu":
+		return a.setCpu(strings.ToLower(args))
+	case "!zone", "!zn":
+		a.numZones++
+		a.zone = a.numZones
+
+		return nil
+	case "!to", "!sl", "!symbollist":
+		// The names of the output files are determined by the caller
+		return nil
+	}
+
+	return fmt.Errorf("unknown pseudo opcode '%s'", name)
+}
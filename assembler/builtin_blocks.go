@@ -0,0 +1,217 @@
+package assembler
+
+import (
+	"fmt"
+	"strings"
+)
+
+type sourceLine struct {
+	file string
+	num  int
+	text string
+}
+
+// code returns the line without its comment and surrounding white space
+func (s sourceLine) code() string {
+	return strings.TrimSpace(splitOutsideQuotes(s.text, ';')[0])
+}
+
+type macro struct {
+	params []string
+	body   []sourceLine
+}
+
+// blockEnd returns the index of the line which contains the } that closes the block opened at the
+// end of lines[start]
+func blockEnd(lines []sourceLine, start int) (int, error) {
+	depth := 1
+
+	for i := start + 1; i < len(lines); i++ {
+		code := lines[i].code()
+		var quote byte
+
+		for k := 0; k < len(code); k++ {
+			switch {
+			case quote != 0:
+				if code[k] == quote {
+					quote = 0
+				}
+			case (code[k] == '"') || (code[k] == '\''):
+				quote = code[k]
+			case code[k] == '{':
+				depth++
+			case code[k] == '}':
+				depth--
+				if depth == 0 {
+					return i, nil
+				}
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("block is not closed")
+}
+
+// assembleBlock handles the pseudo opcodes which are followed by a block in braces, i.e. !macro,
+// !if, !ifdef, !ifndef and !zone. Conditional blocks can have an else block. The index of the
+// line which closes the block is returned.
+func (a *builtinAsm) assembleBlock(lines []sourceLine, start int) (int, error) {
+	end, err := blockEnd(lines, start)
+	if err != nil {
+		return start, err
+	}
+
+	body := lines[start+1 : end]
+	var elseBody []sourceLine
+
+	closing := strings.Fields(strings.TrimPrefix(lines[end].code(), "}"))
+	switch {
+	case len(closing) == 0:
+	case (len(closing) == 2) && (closing[0] == "else") && (closing[1] == "{"):
+		elseEnd, err := blockEnd(lines, end)
+		if err != nil {
+			return start, err
+		}
+
+		elseBody = lines[end+1 : elseEnd]
+		end = elseEnd
+	default:
+		return start, fmt.Errorf("syntax error after end of block")
+	}
+
+	header := lines[start].code()
+	name, args := splitDirective(strings.TrimSpace(strings.TrimSuffix(header, "{")))
+	name = strings.ToLower(name)
+
+	if (elseBody != nil) && (name != "!if") && (name != "!ifdef") && (name != "!ifndef") {
+		return start, fmt.Errorf("else is not allowed after %s", name)
+	}
+
+	switch name {
+	case "!macro":
+		return end, a.defineMacro(args, body)
+	case "!zone", "!zn":
+		oldZone := a.zone
+		a.numZones++
+		a.zone = a.numZones
+
+		err = a.assembleLines(body)
+		a.zone = oldZone
+
+		return end, err
+	case "!if", "!ifdef", "!ifndef":
+		var condition bool
+
+		switch name {
+		case "!if":
+			value, err := a.evalDefined(args)
+			if err != nil {
+				return start, err
+			}
+
+			condition = value != 0
+		case "!ifdef":
+			condition = a.defined[a.symbolKey(args)]
+		default:
+			condition = !a.defined[a.symbolKey(args)]
+		}
+
+		if condition {
+			return end, a.assembleLines(body)
+		}
+
+		return end, a.assembleLines(elseBody)
+	}
+
+	return start, fmt.Errorf("pseudo opcode %s can not be followed by a block", name)
+}
+
+func (a *builtinAsm) defineMacro(args string, body []sourceLine) error {
+	name, params := splitDirective(args)
+	if (name == "") || !isIdentStart(name[0]) {
+		return fmt.Errorf("macro name expected")
+	}
+
+	if _, ok := a.macros[name]; ok {
+		return fmt.Errorf("macro '%s' already defined", name)
+	}
+
+	m := &macro{params: []string{}, body: body}
+
+	if params != "" {
+		for _, j := range strings.Split(params, ",") {
+			j = strings.TrimSpace(j)
+			if (j == "") || !isIdentStart(j[0]) {
+				return fmt.Errorf("illegal parameter '%s' of macro '%s'", j, name)
+			}
+
+			m.params = append(m.params, j)
+		}
+	}
+
+	a.macros[name] = m
+
+	return nil
+}
+
+// callMacro expands a macro. The arguments are evaluated in the zone of the caller. The body is
+// assembled in a zone of its own in which the parameters are defined as symbols.
+func (a *builtinAsm) callMacro(call string) error {
+	name, args := splitDirective(call)
+
+	m, ok := a.macros[name]
+	if !ok {
+		return fmt.Errorf("macro '%s' not defined", name)
+	}
+
+	argList := []string{}
+	if args != "" {
+		argList = splitOutsideQuotes(args, ',')
+	}
+
+	if len(argList) != len(m.params) {
+		return fmt.Errorf("macro '%s' expects %d arguments", name, len(m.params))
+	}
+
+	type argValue struct {
+		value int64
+		known bool
+	}
+
+	values := []argValue{}
+	for _, j := range argList {
+		value, known, err := a.eval(j)
+		if err != nil {
+			return err
+		}
+
+		values = append(values, argValue{value, known})
+	}
+
+	if a.depth >= maxIncludeDepth {
+		return fmt.Errorf("too many nested macro calls")
+	}
+
+	oldZone := a.zone
+	a.depth++
+	a.numZones++
+	a.zone = a.numZones
+
+	defer func() {
+		a.zone = oldZone
+		a.depth--
+	}()
+
+	for i, j := range m.params {
+		// Parameters which depend on symbols defined later remain undefined in this pass
+		if !values[i].known {
+			continue
+		}
+
+		if err := a.defineSymbol(j, values[i].value); err != nil {
+			return err
+		}
+	}
+
+	return a.assembleLines(m.body)
+}
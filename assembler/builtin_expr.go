@@ -0,0 +1,329 @@
+package assembler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// symbolResolver returns the value of a symbol. ok is false if the symbol is not (yet) defined.
+type symbolResolver func(name string) (value int64, ok bool)
+
+// exprParser evaluates expressions in ACME syntax. Operators in ascending order of priority:
+// | & (<v >v) (<< >>) (+ -) (* / %) (!v -v). Numbers can be given in decimal, in hex ($ or 0x),
+// in binary (% or 0b) or as a character constant. Undefined symbols evaluate to 0 and are
+// recorded in undefined.
+type exprParser struct {
+	text      string
+	pos       int
+	pc        int64
+	pcDefined bool
+	resolve   symbolResolver
+	undefined []string
+}
+
+func isIdentStart(c byte) bool {
+	return (c == '_') || (c == '.') || (c == '@') || ((c >= 'a') && (c <= 'z')) || ((c >= 'A') && (c <= 'Z'))
+}
+
+func isIdentChar(c byte) bool {
+	return (c == '_') || ((c >= '0') && (c <= '9')) || ((c >= 'a') && (c <= 'z')) || ((c >= 'A') && (c <= 'Z'))
+}
+
+func (e *exprParser) skipSpace() {
+	for (e.pos < len(e.text)) && ((e.text[e.pos] == ' ') || (e.text[e.pos] == '\t')) {
+		e.pos++
+	}
+}
+
+func (e *exprParser) peek() byte {
+	e.skipSpace()
+
+	if e.pos >= len(e.text) {
+		return 0
+	}
+
+	return e.text[e.pos]
+}
+
+// accept consumes op if it is the next token
+func (e *exprParser) accept(op string) bool {
+	e.skipSpace()
+
+	if !strings.HasPrefix(e.text[e.pos:], op) {
+		return false
+	}
+
+	e.pos += len(op)
+
+	return true
+}
+
+func (e *exprParser) parse() (int64, error) {
+	res, err := e.parseOr()
+	if err != nil {
+		return 0, err
+	}
+
+	if e.peek() != 0 {
+		return 0, fmt.Errorf("syntax error in expression '%s'", e.text)
+	}
+
+	return res, nil
+}
+
+func (e *exprParser) parseOr() (int64, error) {
+	res, err := e.parseAnd()
+	if err != nil {
+		return 0, err
+	}
+
+	for e.accept("|") {
+		v, err := e.parseAnd()
+		if err != nil {
+			return 0, err
+		}
+
+		res |= v
+	}
+
+	return res, nil
+}
+
+func (e *exprParser) parseAnd() (int64, error) {
+	res, err := e.parseByteSelect()
+	if err != nil {
+		return 0, err
+	}
+
+	for e.accept("&") {
+		v, err := e.parseByteSelect()
+		if err != nil {
+			return 0, err
+		}
+
+		res &= v
+	}
+
+	return res, nil
+}
+
+// parseByteSelect handles the low and high byte operators. As in ACME they apply to the whole
+// arithmetic expression which follows, i.e. <label+1 is the low byte of label+1.
+func (e *exprParser) parseByteSelect() (int64, error) {
+	if e.accept("<") {
+		v, err := e.parseShift()
+		return v & 0xFF, err
+	}
+
+	if e.accept(">") {
+		v, err := e.parseShift()
+		return (v >> 8) & 0xFF, err
+	}
+
+	return e.parseShift()
+}
+
+func (e *exprParser) parseShift() (int64, error) {
+	res, err := e.parseSum()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		var shiftLeft bool
+
+		switch {
+		case e.accept("<<"):
+			shiftLeft = true
+		case e.accept(">>"):
+			shiftLeft = false
+		default:
+			return res, nil
+		}
+
+		v, err := e.parseSum()
+		if err != nil {
+			return 0, err
+		}
+
+		if v < 0 {
+			return 0, fmt.Errorf("negative shift count in expression '%s'", e.text)
+		}
+
+		if shiftLeft {
+			res <<= v
+		} else {
+			res >>= v
+		}
+	}
+}
+
+func (e *exprParser) parseSum() (int64, error) {
+	res, err := e.parseProduct()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		var v int64
+
+		switch e.peek() {
+		case '+':
+			e.pos++
+			v, err = e.parseProduct()
+			res += v
+		case '-':
+			e.pos++
+			v, err = e.parseProduct()
+			res -= v
+		default:
+			return res, nil
+		}
+
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (e *exprParser) parseProduct() (int64, error) {
+	res, err := e.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		op := e.peek()
+		if (op != '*') && (op != '/') && (op != '%') {
+			return res, nil
+		}
+
+		e.pos++
+
+		v, err := e.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+
+		if op == '*' {
+			res *= v
+			continue
+		}
+
+		if v == 0 {
+			// Undefined symbols evaluate to 0 during the first pass
+			if len(e.undefined) != 0 {
+				res = 0
+				continue
+			}
+
+			return 0, fmt.Errorf("division by zero in expression '%s'", e.text)
+		}
+
+		if op == '/' {
+			res /= v
+		} else {
+			res %= v
+		}
+	}
+}
+
+func (e *exprParser) parseUnary() (int64, error) {
+	switch e.peek() {
+	case '-':
+		e.pos++
+		v, err := e.parseUnary()
+		return -v, err
+	case '!':
+		e.pos++
+		v, err := e.parseUnary()
+		return ^v, err
+	}
+
+	return e.parsePrimary()
+}
+
+func (e *exprParser) parseNumber(digits string, base int) (int64, error) {
+	start := e.pos
+
+	for (e.pos < len(e.text)) && strings.ContainsRune(digits, rune(e.text[e.pos])) {
+		e.pos++
+	}
+
+	v, err := strconv.ParseInt(e.text[start:e.pos], base, 64)
+	if err != nil {
+		return 0, fmt.Errorf("illegal number in expression '%s'", e.text)
+	}
+
+	return v, nil
+}
+
+func (e *exprParser) parsePrimary() (int64, error) {
+	c := e.peek()
+
+	switch {
+	case c == '(':
+		e.pos++
+
+		v, err := e.parseOr()
+		if err != nil {
+			return 0, err
+		}
+
+		if !e.accept(")") {
+			return 0, fmt.Errorf("missing ')' in expression '%s'", e.text)
+		}
+
+		return v, nil
+	case c == '$':
+		e.pos++
+		return e.parseNumber("0123456789abcdefABCDEF", 16)
+	case c == '%':
+		e.pos++
+		return e.parseNumber("01", 2)
+	case (c == '0') && (e.pos+1 < len(e.text)) && ((e.text[e.pos+1] == 'x') || (e.text[e.pos+1] == 'X')):
+		e.pos += 2
+		return e.parseNumber("0123456789abcdefABCDEF", 16)
+	case (c == '0') && (e.pos+1 < len(e.text)) && ((e.text[e.pos+1] == 'b') || (e.text[e.pos+1] == 'B')):
+		e.pos += 2
+		return e.parseNumber("01", 2)
+	case (c >= '0') && (c <= '9'):
+		return e.parseNumber("0123456789", 10)
+	case (c == '\'') || (c == '"'):
+		if (e.pos+2 >= len(e.text)) || (e.text[e.pos+2] != c) {
+			return 0, fmt.Errorf("illegal character constant in expression '%s'", e.text)
+		}
+
+		v := int64(e.text[e.pos+1])
+		e.pos += 3
+
+		return v, nil
+	case c == '*':
+		e.pos++
+
+		if !e.pcDefined {
+			return 0, fmt.Errorf("program counter undefined")
+		}
+
+		return e.pc, nil
+	case isIdentStart(c):
+		start := e.pos
+		e.pos++
+
+		for (e.pos < len(e.text)) && isIdentChar(e.text[e.pos]) {
+			e.pos++
+		}
+
+		name := e.text[start:e.pos]
+
+		v, ok := e.resolve(name)
+		if !ok {
+			e.undefined = append(e.undefined, name)
+		}
+
+		return v, nil
+	}
+
+	return 0, fmt.Errorf("syntax error in expression '%s'", e.text)
+}
@@ -0,0 +1,288 @@
+package assembler
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func assembleSource(t *testing.T, src string) (*Program, error) {
+	t.Helper()
+
+	dir := t.TempDir()
+	fileName := path.Join(dir, "test.a")
+
+	if err := os.WriteFile(fileName, []byte(src), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return AssembleFile(fileName, []string{dir})
+}
+
+func checkProgram(t *testing.T, src string, loadAddress uint16, expected []byte) *Program {
+	t.Helper()
+
+	prog, err := assembleSource(t, src)
+	if err != nil {
+		t.Fatalf("unable to assemble: %v", err)
+	}
+
+	if prog.LoadAddress != loadAddress {
+		t.Fatalf("wrong load address: $%04X", prog.LoadAddress)
+	}
+
+	if !bytes.Equal(prog.Code, expected) {
+		t.Fatalf("wrong code: % X", prog.Code)
+	}
+
+	return prog
+}
+
+func TestBuiltinAddressingModes(t *testing.T) {
+	src := `
+* = $0800
+!cpu 65c02
+ptr = $fb
+
+main
+    lda #$12
+    sta $d020
+    lda ptr
+    lda (ptr),y
+    lda (ptr,x)
+    lda (ptr)
+    sta $1000,x
+    ldx ptr,y
+    jmp ($fffc)
+    jmp (table,x)
+    asl
+    inc
+    rts
+table
+`
+	checkProgram(t, src, 0x0800, []byte{
+		0xA9, 0x12, 0x8D, 0x20, 0xD0, 0xA5, 0xFB, 0xB1, 0xFB, 0xA1, 0xFB, 0xB2, 0xFB,
+		0x9D, 0x00, 0x10, 0xB6, 0xFB, 0x6C, 0xFC, 0xFF, 0x7C, 0x1B, 0x08, 0x0A, 0x1A, 0x60,
+	})
+}
+
+func TestBuiltinForwardReferences(t *testing.T) {
+	// Forward references to zero page addresses use absolute addressing
+	src := `
+* = $c000
+    lda later
+    bne skip
+    nop
+skip
+    jsr sub
+    brk
+sub rts
+later = $10
+`
+	prog := checkProgram(t, src, 0xC000, []byte{0xAD, 0x10, 0x00, 0xD0, 0x01, 0xEA, 0x20, 0x0A, 0xC0, 0x00, 0x60})
+
+	if (len(prog.Labels[0xC006]) != 1) || (prog.Labels[0xC006][0] != "skip") {
+		t.Fatalf("wrong labels: %v", prog.Labels)
+	}
+
+	if prog.Labels[0x0010][0] != "later" {
+		t.Fatalf("assignment not in labels: %v", prog.Labels)
+	}
+}
+
+func TestBuiltinLocalLabels(t *testing.T) {
+	src := `
+* = $0800
+first
+.loop dex
+    bne .loop
+@cheap
+    rts
+!zone
+second
+.loop dey
+    bne .loop
+    bcc @cheap
+@cheap
+    rts
+`
+	prog := checkProgram(t, src, 0x0800, []byte{0xCA, 0xD0, 0xFD, 0x60, 0x88, 0xD0, 0xFD, 0x90, 0x00, 0x60})
+
+	for _, names := range prog.Labels {
+		for _, j := range names {
+			if (j != "first") && (j != "second") {
+				t.Fatalf("local label '%s' exported", j)
+			}
+		}
+	}
+}
+
+func TestBuiltinDataDirectives(t *testing.T) {
+	src := `
+*=$1000
+    !byte 1, $ff, -1, 'A', %101
+    !word $1234, end
+    !text "AB", 0
+    !fill 3, $ea
+    !by <end, >end, <end+1
+end: !08 * - $1000
+`
+	checkProgram(t, src, 0x1000, []byte{
+		0x01, 0xFF, 0xFF, 0x41, 0x05, 0x34, 0x12, 0x12, 0x10, 0x41, 0x42, 0x00, 0xEA, 0xEA, 0xEA, 0x12, 0x10, 0x13, 0x12,
+	})
+}
+
+func TestBuiltinExpressions(t *testing.T) {
+	src := `
+* = $0800
+a = 2 + 3 * 4
+b = (2 + 3) * 4
+c = 1 << 4 | 3
+d = $ff & !$0f
+e = 17 / 5 + 17 % 5
+    !byte a, b, c, d, e
+`
+	checkProgram(t, src, 0x0800, []byte{14, 20, 0x13, 0xF0, 5})
+}
+
+func TestBuiltinSource(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.WriteFile(path.Join(dir, "lib.a"), []byte(".local nop\n    jmp .local\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	main := path.Join(dir, "main.a")
+	if err := os.WriteFile(main, []byte("* = $0800\n.local rts\n!source \"lib.a\"\n    jmp .local\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	prog, err := AssembleFile(main, []string{dir})
+	if err != nil {
+		t.Fatalf("unable to assemble: %v", err)
+	}
+
+	if !bytes.Equal(prog.Code, []byte{0x60, 0xEA, 0x4C, 0x01, 0x08, 0x4C, 0x00, 0x08}) {
+		t.Fatalf("wrong code: % X", prog.Code)
+	}
+}
+
+func TestBuiltinBitInstructions(t *testing.T) {
+	src := `
+!cpu r65c02
+* = $0800
+loop
+    rmb3 $12
+    bbs7 $12, loop
+`
+	checkProgram(t, src, 0x0800, []byte{0x37, 0x12, 0xFF, 0x12, 0xFB})
+}
+
+func TestBuiltinErrors(t *testing.T) {
+	tests := map[string]string{
+		"* = $0800\n lda missing\n":              "line 2: symbol 'missing' undefined",
+		"* = $0800\n stz $12\n":                  "instruction 'stz' is not supported by CPU '6502'",
+		"* = $0800\nl1 nop\nl1 nop\n":            "line 3: symbol 'l1' already defined",
+		" nop\n":                                 "program counter undefined",
+		"* = $0800\nl bne far\n!fill 200\nfar\n": "branch offset",
+		"* = $0800\n lda #$100\n":                "immediate value $100 out of range",
+		"* = $0800\n!cpu 65816\n":                "CPU '65816' is not supported",
+		"* = $0800\n!foo\n":                      "unknown pseudo opcode '!foo'",
+	}
+
+	for src, expected := range tests {
+		_, err := assembleSource(t, src)
+		if err == nil {
+			t.Fatalf("no error for '%s'", src)
+		}
+
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("wrong error for '%s': %v", src, err)
+		}
+	}
+}
+
+func TestBuiltinAssemble(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.WriteFile(path.Join(dir, "test.a"), []byte("* = $0800\nmain\n lda #1\n brk\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	asm := NewBuiltin(dir, dir, dir)
+
+	binName, err := asm.Assemble("test.a")
+	if err != nil {
+		t.Fatalf("unable to assemble: %v", err)
+	}
+
+	data, err := os.ReadFile(binName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, []byte{0x00, 0x08, 0xA9, 0x01, 0x00}) {
+		t.Fatalf("wrong binary: % X", data)
+	}
+
+	labels, err := asm.ParseLabelFile(path.Join(dir, "test.a.lbl"))
+	if err != nil {
+		t.Fatalf("unable to parse label file: %v", err)
+	}
+
+	if (labels[0x0800][0] != "main") || (asm.Labels()[0x0800][0] != "main") {
+		t.Fatalf("wrong labels: %v", labels)
+	}
+}
+
+func TestBuiltinMacros(t *testing.T) {
+	src := `
+!macro load16 .val, .addr {
+    lda #<.val
+    sta .addr
+    lda #>.val
+    sta .addr+1
+}
+
+!macro skipZero .addr {
+    lda .addr
+    beq .done
+    inc .addr
+.done
+}
+
+* = $0800
+    +load16 $1234, $fb
+    +skipZero $fb
+    +skipZero $fc
+`
+	checkProgram(t, src, 0x0800, []byte{
+		0xA9, 0x34, 0x85, 0xFB, 0xA9, 0x12, 0x85, 0xFC,
+		0xA5, 0xFB, 0xF0, 0x02, 0xE6, 0xFB,
+		0xA5, 0xFC, 0xF0, 0x02, 0xE6, 0xFC,
+	})
+}
+
+func TestBuiltinConditionals(t *testing.T) {
+	src := `
+FAST = 1
+* = $0800
+!ifdef FAST {
+    nop
+} else {
+    brk
+}
+!ifndef FAST {
+    brk
+}
+!if FAST - 1 {
+    brk
+} else {
+    !skip 2
+    rts
+}
+`
+	checkProgram(t, src, 0x0800, []byte{0xEA, 0x00, 0x00, 0x60})
+}
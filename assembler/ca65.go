@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os/exec"
 	"path"
+	"path/filepath"
+	"strings"
 )
 
 type Ca65AsmImpl struct {
@@ -26,8 +28,20 @@ func NewCa65(path string, srcDir string, binDir string, testDir string, startAdd
 	}
 }
 
+// DebugInfoFileName returns the name of the debug info file which is written next to the given binary
+func DebugInfoFileName(binaryName string) string {
+	return strings.TrimSuffix(binaryName, ".bin") + ".dbg"
+}
+
+// ParseLabelFile reads a debug info file written by ld65 if the file name ends with .dbg. Labels
+// defined in a scope are then qualified by the name of the scope. All other files are read as VICE
+// label files.
 func (c *Ca65AsmImpl) ParseLabelFile(fileName string) (map[uint16][]string, error) {
-	return nil, fmt.Errorf("unsupported: ca65 is unable to create an easily parseable symbol list file")
+	if strings.EqualFold(filepath.Ext(fileName), ".dbg") {
+		return ParseDebugInfoLabels(fileName)
+	}
+
+	return ParseViceLabelFile(fileName)
 }
 
 func (c *Ca65AsmImpl) GetErrorMessage() string {
@@ -46,13 +60,16 @@ main:
 func (c *Ca65AsmImpl) Assemble(fileName string) (string, error) {
 	mlProg := path.Join(c.binDir, fmt.Sprintf("%s.bin", fileName))
 	mlObj := path.Join(c.binDir, fmt.Sprintf("%s.obj", fileName))
+	mlLabels := path.Join(c.binDir, fmt.Sprintf("%s.lbl", fileName))
 	mlSrc := path.Join(c.testDir, fileName)
 	asmCommand := path.Join(c.binPath, "ca65")
 	linkCommand := path.Join(c.binPath, "cl65")
 
 	asmCmd := exec.Command(asmCommand,
+		"-g",
 		"-I", c.srcDir,
 		"-o", mlObj,
+		"-l", ListingFileName(mlProg),
 		mlSrc,
 	)
 
@@ -61,6 +78,8 @@ func (c *Ca65AsmImpl) Assemble(fileName string) (string, error) {
 	linkCmd := exec.Command(linkCommand,
 		"-C", "c64-asm.cfg",
 		"--start-addr", loadAddress,
+		"-Ln", mlLabels,
+		"-Wl", "--dbgfile,"+DebugInfoFileName(mlProg),
 		"-o", mlProg,
 		mlObj,
 	)
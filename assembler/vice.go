@@ -0,0 +1,92 @@
+package assembler
+
+import (
+	"6502profiler/srcmap"
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// parseOneLineVice parses a line of a VICE label file like `al C:0801 .main`. ld65 writes the same
+// format without the memory space, e.g. `al 000801 .main`. Other monitor commands are skipped.
+func parseOneLineVice(line string) (uint16, string, error) {
+	r := regexp.MustCompile(`^al\s+(?:[[:alpha:]]+:)?([[:xdigit:]]{1,6})\s+\.?(\S+)\s*$`)
+
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "al ") && !strings.HasPrefix(trimmed, "al\t") {
+		return 0, "", errSkipLine
+	}
+
+	matches := r.FindStringSubmatch(trimmed)
+	if matches == nil {
+		return 0, "", fmt.Errorf("can not parse label file line '%s'", line)
+	}
+
+	// Can not fail as the regex ensures that only valid hex numbers are parsed
+	res, _ := strconv.ParseUint(matches[1], 16, 32)
+	if res > 0xFFFF {
+		return 0, "", fmt.Errorf("address of label '%s' is out of range", matches[2])
+	}
+
+	return uint16(res), matches[2], nil
+}
+
+// ParseViceLabelFile reads a label file in the format used by the VICE monitor. Such files are written
+// by ld65 (option -Ln) and many other tools.
+func ParseViceLabelFile(fileName string) (map[uint16][]string, error) {
+	return ParseLabelFile(fileName, parseOneLineVice)
+}
+
+// IsViceLabelFile returns true if the named file has the extension .vs or if its first line which is
+// not empty defines a label in the format used by the VICE monitor
+func IsViceLabelFile(fileName string) bool {
+	if strings.EqualFold(filepath.Ext(fileName), ".vs") {
+		return true
+	}
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		return false
+	}
+	defer func() { f.Close() }()
+
+	fileScanner := bufio.NewScanner(f)
+	for fileScanner.Scan() {
+		line := strings.TrimSpace(fileScanner.Text())
+		if line != "" {
+			_, _, err := parseOneLineVice(line)
+			return err == nil
+		}
+	}
+
+	return false
+}
+
+// ParseDebugInfoLabels returns the labels contained in a debug info file written by ld65. Labels
+// defined in a scope are qualified by the name of the scope, e.g. sub::loop.
+func ParseDebugInfoLabels(fileName string) (map[uint16][]string, error) {
+	info, err := srcmap.LoadDebugInfo(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	return info.Labels(), nil
+}
+
+// LoadLabels reads a label file. VICE label files and ld65 debug info files are accepted for all
+// assemblers. All other files have to be in the format of the label files written by asm.
+func LoadLabels(asm Assembler, fileName string) (map[uint16][]string, error) {
+	if strings.EqualFold(filepath.Ext(fileName), ".dbg") {
+		return ParseDebugInfoLabels(fileName)
+	}
+
+	if IsViceLabelFile(fileName) {
+		return ParseViceLabelFile(fileName)
+	}
+
+	return asm.ParseLabelFile(fileName)
+}
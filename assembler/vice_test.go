@@ -0,0 +1,64 @@
+package assembler
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLineParsingVice(t *testing.T) {
+	s1 := "al C:0801 .main"
+	s2 := "al 000810 .sub::loop"
+	s3 := "al C:fd .LOOKUP_SCRATCH3"
+
+	addr, label, err := parseOneLineVice(s1)
+	if (addr != 0x0801) || (label != "main") || (err != nil) {
+		t.Fatalf("Matching first test line failed: %d, '%s'", addr, label)
+	}
+
+	addr, label, err = parseOneLineVice(s2)
+	if (addr != 0x0810) || (label != "sub::loop") || (err != nil) {
+		t.Fatalf("Matching second test line failed: %d, '%s'", addr, label)
+	}
+
+	addr, label, err = parseOneLineVice(s3)
+	if (addr != 0x00fd) || (label != "LOOKUP_SCRATCH3") || (err != nil) {
+		t.Fatalf("Matching third test line failed %d, '%s'", addr, label)
+	}
+
+	if _, _, err = parseOneLineVice("al C:10000 .bank1"); err == nil {
+		t.Fatal("Address out of range accepted")
+	}
+}
+
+func TestLoadLabelsVice(t *testing.T) {
+	dir := t.TempDir()
+
+	vice := path.Join(dir, "prog.lbl")
+	if err := os.WriteFile(vice, []byte("al C:0801 .main\nbreak 0810\n\nal C:0810 .loop\nal C:0801 .start\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	acme := path.Join(dir, "prog.txt")
+	if err := os.WriteFile(acme, []byte("\tmain\t= $801\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsViceLabelFile(vice) || IsViceLabelFile(acme) {
+		t.Fatal("VICE label file not recognized")
+	}
+
+	labels, err := LoadLabels(NewACME("", "", "", ""), vice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if (len(labels) != 2) || (len(labels[0x0801]) != 2) || (labels[0x0801][1] != "start") || (labels[0x0810][0] != "loop") {
+		t.Fatalf("Wrong labels: %v", labels)
+	}
+
+	labels, err = LoadLabels(NewACME("", "", "", ""), acme)
+	if (err != nil) || (labels[0x0801][0] != "main") {
+		t.Fatalf("Wrong labels: %v %v", labels, err)
+	}
+}
@@ -22,15 +22,24 @@ type CaseExec struct {
 	ReportSummary      SummaryReporter
 	SubCaseReporter    verifier.SubcaseProcessor
 	ReportTestInfo     TestInfoReporter
-	CurrentCpu         *cpu.CPU6502
+	CaseFinished       CaseFinishedReporter
+	CurrentCpu         cpu.Processor
 	trapAddress        uint16
 	placeholderWrapper *memory.PlaceholderWrapper
+	tracer             cpu.Tracer
+	baseline           verifier.Baseline
+	recordBaseline     bool
+	tolerance          float64
 }
 
 type AsmErrorReporter func(errMsg string)
 type SummaryReporter func()
 type TestInfoReporter func(string, *verifier.TestCase)
 
+// CaseFinishedReporter is called after a test case has been executed successfully. The final state
+// of the CPU is available in CurrentCpu.
+type CaseFinishedReporter func(string, *verifier.TestCase)
+
 func NewCaseExec(c emuconfig.CpuProvider, a emuconfig.AsmProvider, repo verifier.CaseRepo, v bool) *CaseExec {
 	res := CaseExec{
 		cpuProv:            nil,
@@ -56,6 +65,25 @@ func (t *CaseExec) SetTrapAddress(a uint16) {
 	t.trapAddress = a
 }
 
+// SetTracer causes all test cases which are executed afterwards to be traced by tr
+func (t *CaseExec) SetTracer(tr cpu.Tracer) {
+	t.tracer = tr
+}
+
+// CheckBaseline causes test cases to fail if they use more than tolerance percent clock cycles above
+// the values stored in b
+func (t *CaseExec) CheckBaseline(b verifier.Baseline, tolerance float64) {
+	t.baseline = b
+	t.recordBaseline = false
+	t.tolerance = tolerance
+}
+
+// RecordBaseline stores the clock cycles used by all test cases which are executed afterwards in b
+func (t *CaseExec) RecordBaseline(b verifier.Baseline) {
+	t.baseline = b
+	t.recordBaseline = true
+}
+
 func (t *CaseExec) LoadAndExecuteCase(testCaseName string) error {
 	caseFileName := testCaseName
 
@@ -82,6 +110,10 @@ func (t *CaseExec) ExecuteCase(testCaseName string, testCase *verifier.TestCase)
 
 	t.CurrentCpu = cpu
 
+	if t.tracer != nil {
+		cpu.SetTracer(t.tracer)
+	}
+
 	assembler := t.asmProv.GetAssembler()
 	var subcaseProc verifier.SubcaseProcessor = nil
 
@@ -100,6 +132,18 @@ func (t *CaseExec) ExecuteCase(testCaseName string, testCase *verifier.TestCase)
 		return fmt.Errorf("test case '%s' failed: %v", testCase.Name, err)
 	}
 
+	if t.baseline != nil {
+		if t.recordBaseline {
+			t.baseline.Record(testCaseName, cpu.NumCycles())
+		} else if err = t.baseline.Check(testCaseName, cpu.NumCycles(), t.tolerance); err != nil {
+			return fmt.Errorf("test case '%s' failed: %v", testCase.Name, err)
+		}
+	}
+
+	if t.CaseFinished != nil {
+		t.CaseFinished(testCaseName, testCase)
+	}
+
 	t.ReportSummary()
 
 	return nil
@@ -170,19 +214,19 @@ func (t *CaseExec) ExecuteSetupProgram(setupPrgName string) error {
 // ------------------------------------------------------------------------------
 
 type snapshotCpuProvider struct {
-	cpu *cpu.CPU6502
+	cpu cpu.Processor
 	ce  *CaseExec
 	p   *memory.PlaceholderWrapper
 }
 
-func newSnapshotProvider(cpu *cpu.CPU6502, c *CaseExec) (emuconfig.CpuProvider, error) {
+func newSnapshotProvider(cpu cpu.Processor, c *CaseExec) (emuconfig.CpuProvider, error) {
 	cpu.Reset()
-	cpu.Mem.TakeSnapshot()
+	cpu.GetMem().TakeSnapshot()
 	var placeholder *memory.PlaceholderWrapper = nil
 
 	if c.trapAddress != emuconfig.IllegalTrapAddress {
-		placeholder = memory.NewPlaceholderWrapper(cpu.Mem, c.trapAddress)
-		cpu.Mem = placeholder.Wrapper
+		placeholder = memory.NewPlaceholderWrapper(cpu.GetMem(), c.trapAddress)
+		cpu.SetMem(placeholder.Wrapper)
 	}
 
 	return &snapshotCpuProvider{
@@ -192,8 +236,8 @@ func newSnapshotProvider(cpu *cpu.CPU6502, c *CaseExec) (emuconfig.CpuProvider,
 	}, nil
 }
 
-func (c *snapshotCpuProvider) NewCpu() (*cpu.CPU6502, error) {
-	c.cpu.Mem.RestoreSnapshot()
+func (c *snapshotCpuProvider) NewCpu() (cpu.Processor, error) {
+	c.cpu.GetMem().RestoreSnapshot()
 	c.cpu.Reset()
 	c.ce.placeholderWrapper = c.p
 
@@ -219,15 +263,15 @@ func newWrapperCpuProvider(o emuconfig.CpuProvider, c *CaseExec) *wrapperCpuProv
 	}
 }
 
-func (w *wrapperCpuProvider) NewCpu() (*cpu.CPU6502, error) {
+func (w *wrapperCpuProvider) NewCpu() (cpu.Processor, error) {
 	cpu, err := w.originalProv.NewCpu()
 	if err != nil {
 		return nil, err
 	}
 
 	if w.caseExec.trapAddress != emuconfig.IllegalTrapAddress {
-		w.caseExec.placeholderWrapper = memory.NewPlaceholderWrapper(cpu.Mem, w.caseExec.trapAddress)
-		cpu.Mem = w.caseExec.placeholderWrapper.Wrapper
+		w.caseExec.placeholderWrapper = memory.NewPlaceholderWrapper(cpu.GetMem(), w.caseExec.trapAddress)
+		cpu.SetMem(w.caseExec.placeholderWrapper.Wrapper)
 		// w.caseExec.placeholderWrapper.f is nil here
 	}
 
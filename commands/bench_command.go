@@ -0,0 +1,165 @@
+package commands
+
+import (
+	"6502profiler/emuconfig"
+	"6502profiler/util"
+	"6502profiler/verifier"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Maximum length of a bar in the histogram
+const histogramWidth = 40
+
+// parsePercentiles converts a comma separated list of percentages
+func parsePercentiles(spec string) ([]float64, error) {
+	res := []float64{}
+
+	if spec == "" {
+		return res, nil
+	}
+
+	for _, j := range strings.Split(spec, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(j), 64)
+		if (err != nil) || (p < 0.0) || (p > 100.0) {
+			return nil, fmt.Errorf("'%s' is not a valid percentile", j)
+		}
+
+		res = append(res, p)
+	}
+
+	return res, nil
+}
+
+func printBenchRuns(w io.Writer, title string, runs []verifier.BenchRun) {
+	fmt.Fprintf(w, "\n%s\n", title)
+
+	for _, j := range runs {
+		fmt.Fprintf(w, "%10d  run %-6d %s\n", j.Cycles, j.Run, j.Input)
+	}
+}
+
+// printBenchResult shows the statistics, the histogram and the best and worst runs of a benchmark
+func printBenchResult(w io.Writer, res *verifier.BenchResult, percentiles []float64, numBuckets int, top int) {
+	fmt.Fprintf(w, "%-10s %10d\n", "Runs", len(res.Runs))
+	fmt.Fprintf(w, "%-10s %10d\n", "Min", res.Min())
+	fmt.Fprintf(w, "%-10s %10d\n", "Max", res.Max())
+	fmt.Fprintf(w, "%-10s %13.2f\n", "Mean", res.Mean())
+	fmt.Fprintf(w, "%-10s %10d\n", "Median", res.Median())
+	fmt.Fprintf(w, "%-10s %13.2f\n", "Std dev", res.StdDev())
+
+	for _, p := range percentiles {
+		fmt.Fprintf(w, "%-10s %10d\n", "P"+strconv.FormatFloat(p, 'f', -1, 64), res.Percentile(p))
+	}
+
+	buckets := res.Histogram(numBuckets)
+	if len(buckets) != 0 {
+		maxCount := 0
+		for _, j := range buckets {
+			if j.Count > maxCount {
+				maxCount = j.Count
+			}
+		}
+
+		fmt.Fprintf(w, "\nHistogram of clock cycles\n")
+
+		for _, j := range buckets {
+			bar := strings.Repeat("#", (j.Count*histogramWidth+maxCount-1)/maxCount)
+			fmt.Fprintf(w, "%10d - %10d %8d  %s\n", j.From, j.To, j.Count, bar)
+		}
+	}
+
+	if top > 0 {
+		printBenchRuns(w, "Best runs", res.Best(top))
+		printBenchRuns(w, "Worst runs", res.Worst(top))
+	}
+}
+
+func BenchCommand(arguments []string) error {
+	var config *emuconfig.Config = emuconfig.DefaultConfig()
+	var err error
+
+	benchFlags := flag.NewFlagSet("6502profiler bench", flag.ContinueOnError)
+	configName := benchFlags.String("c", "", "Config file name")
+	testCasePath := benchFlags.String("t", "", "Test case file whose test driver is run")
+	numRuns := benchFlags.Uint("n", 1000, "Number of runs")
+	percentileSpec := benchFlags.String("percentiles", "90,99", "Comma separated list of percentiles which are shown")
+	numBuckets := benchFlags.Uint("buckets", 10, "Number of buckets of the histogram")
+	top := benchFlags.Uint("top", 5, "Number of best and worst runs which are shown together with their inputs")
+	csvFileName := benchFlags.String("csv", "", "Path to a CSV file which receives the clock cycles and the input of each run")
+
+	if err = benchFlags.Parse(arguments); err != nil {
+		os.Exit(util.ExitErrorSyntax)
+	}
+
+	if *configName != "" {
+		config, err = emuconfig.NewConfigFromFile(*configName)
+		if err != nil {
+			return fmt.Errorf("error loading config: %v", err)
+		}
+	}
+
+	if *testCasePath == "" {
+		return fmt.Errorf("test case path has to be specified")
+	}
+
+	if *numRuns == 0 {
+		return fmt.Errorf("at least one run is needed")
+	}
+
+	percentiles, err := parsePercentiles(*percentileSpec)
+	if err != nil {
+		return err
+	}
+
+	repo, err := config.GetCaseRepo()
+	if err != nil {
+		return err
+	}
+
+	caseFileName := *testCasePath
+	if !strings.HasSuffix(caseFileName, verifier.TestCaseExtension) {
+		caseFileName += verifier.TestCaseExtension
+	}
+
+	testCase, err := repo.Get(caseFileName)
+	if err != nil {
+		return fmt.Errorf("unable to load test case file: %v", err)
+	}
+
+	processor, err := config.NewCpu()
+	if err != nil {
+		return fmt.Errorf("error processing config: %v", err)
+	}
+
+	asm := config.GetAssembler()
+
+	res, err := testCase.Bench(processor, asm, repo.GetScriptPath(), *numRuns, caseFileName+".ident")
+	if err != nil {
+		if errMsg := asm.GetErrorMessage(); errMsg != "" {
+			fmt.Println(errMsg)
+		}
+
+		return fmt.Errorf("benchmark '%s' failed: %v", testCase.Name, err)
+	}
+
+	printBenchResult(os.Stdout, res, percentiles, int(*numBuckets), int(*top))
+
+	if *csvFileName != "" {
+		f, err := os.Create(*csvFileName)
+		if err != nil {
+			return fmt.Errorf("unable to write CSV file: %v", err)
+		}
+		defer func() { f.Close() }()
+
+		if err = res.WriteCSV(f); err != nil {
+			return fmt.Errorf("unable to write CSV file: %v", err)
+		}
+	}
+
+	return nil
+}
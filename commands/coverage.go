@@ -0,0 +1,134 @@
+package commands
+
+import (
+	"6502profiler/assembler"
+	"6502profiler/coverage"
+	"6502profiler/cpu"
+	"6502profiler/emuconfig"
+	"6502profiler/verifier"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// coverageCollector merges the instructions executed by the test drivers of all test cases
+type coverageCollector struct {
+	config   *emuconfig.Config
+	recorder *coverage.Recorder
+	report   *coverage.Report
+	err      error
+}
+
+func newCoverageCollector(config *emuconfig.Config) *coverageCollector {
+	return &coverageCollector{
+		config:   config,
+		recorder: coverage.NewRecorder(),
+	}
+}
+
+// isInSourceDir returns true for files stored below AcmeSrcDir. If the test cases are not stored in
+// the same directory their files are left out.
+func (c *coverageCollector) isInSourceDir(fileName string) bool {
+	isBelow := func(dir string) bool {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			return false
+		}
+
+		rel, err := filepath.Rel(absDir, fileName)
+
+		return (err == nil) && (rel != "..") && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+	}
+
+	if !isBelow(c.config.AcmeSrcDir) {
+		return false
+	}
+
+	return (filepath.Clean(c.config.AcmeSrcDir) == filepath.Clean(c.config.AcmeTestDir)) || !isBelow(c.config.AcmeTestDir)
+}
+
+// add merges the instructions executed by the test case which has just finished
+func (c *coverageCollector) add(p cpu.Processor, testCase *verifier.TestCase) {
+	defer c.recorder.Reset()
+
+	if (c.err != nil) || (p == nil) {
+		return
+	}
+
+	if c.report == nil {
+		c.report = coverage.NewReport(p.Model(), c.isInSourceDir)
+	}
+
+	binaryName := path.Join(c.config.AcmeBinDir, testCase.TestDriverSource+".bin")
+
+	data, err := os.ReadFile(binaryName)
+	if (err == nil) && (len(data) < 3) {
+		err = fmt.Errorf("'%s' contains no code", binaryName)
+	}
+
+	if err != nil {
+		c.err = fmt.Errorf("unable to determine coverage of '%s': %v", testCase.Name, err)
+		return
+	}
+
+	start := uint16(data[0]) | (uint16(data[1]) << 8)
+	end := start + uint16(len(data)-3)
+
+	// ld65 debug info covers all source files while ca65 listings only contain the main file
+	listing := ""
+	if _, err := os.Stat(assembler.DebugInfoFileName(binaryName)); err == nil {
+		listing = assembler.DebugInfoFileName(binaryName)
+	}
+
+	src, err := loadSourceMap(listing, binaryName, c.config)
+	if err != nil {
+		c.err = fmt.Errorf("unable to determine coverage of '%s': %v", testCase.Name, err)
+		return
+	}
+
+	labelFileName := listing
+	if labelFileName == "" {
+		labelFileName = strings.TrimSuffix(binaryName, ".bin") + ".lbl"
+	}
+
+	labels := map[uint16][]string{}
+	if _, err := os.Stat(labelFileName); err == nil {
+		if labels, err = assembler.LoadLabels(c.config.GetAssembler(), labelFileName); err != nil {
+			c.err = fmt.Errorf("unable to determine coverage of '%s': %v", testCase.Name, err)
+			return
+		}
+	}
+
+	c.report.Add(src, labels, start, end, c.recorder.Counts(), c.recorder.Branches())
+}
+
+// write prints a summary of the coverage and stores it in the named lcov tracefile
+func (c *coverageCollector) write(w io.Writer, fileName string) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	if c.report == nil {
+		return nil
+	}
+
+	fmt.Fprintln(w)
+	if err := c.report.WriteSummary(w); err != nil {
+		return err
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("unable to write coverage: %v", err)
+	}
+	defer func() { f.Close() }()
+
+	if err = c.report.WriteLcov(f, "verifyall"); err != nil {
+		return fmt.Errorf("unable to write coverage: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package commands
+
+import (
+	"6502profiler/assembler"
+	"6502profiler/caseexec"
+	"6502profiler/cpu"
+	"6502profiler/dap"
+	"6502profiler/debugger"
+	"6502profiler/emuconfig"
+	"6502profiler/srcmap"
+	"6502profiler/util"
+	"6502profiler/verifier"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// dapLaunchArgs are the attributes of a launch configuration
+type dapLaunchArgs struct {
+	Program       string   `json:"program"`
+	TestCase      string   `json:"testCase"`
+	Config        string   `json:"config"`
+	Label         string   `json:"label"`
+	Listing       []string `json:"listing"`
+	ListingFormat string   `json:"listingFormat"`
+	StopOnEntry   bool     `json:"stopOnEntry"`
+	TrapAddress   *uint    `json:"trapAddress"`
+	Lua           string   `json:"lua"`
+	History       *int     `json:"history"`
+}
+
+func DapCommand(arguments []string) error {
+	dapFlags := flag.NewFlagSet("6502profiler dap", flag.ContinueOnError)
+
+	if err := dapFlags.Parse(arguments); err != nil {
+		os.Exit(util.ExitErrorSyntax)
+	}
+
+	// The protocol uses stdout. Everything the program or the Lua scripts print is shown in the
+	// debug console of the client instead.
+	protocolOut := os.Stdout
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+
+	os.Stdout = w
+	defer func() { os.Stdout = protocolOut; w.Close() }()
+
+	server := dap.NewServer(os.Stdin, protocolOut, launchDap)
+
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				server.Output("stdout", string(buf[:n]))
+			}
+
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return server.Serve()
+}
+
+func launchDap(rawArgs json.RawMessage) (*dap.Launch, error) {
+	var args dapLaunchArgs
+	var err error
+
+	if len(rawArgs) != 0 {
+		if err = json.Unmarshal(rawArgs, &args); err != nil {
+			return nil, fmt.Errorf("invalid launch arguments: %v", err)
+		}
+	}
+
+	config := emuconfig.DefaultConfig()
+
+	if args.Config != "" {
+		config, err = emuconfig.NewConfigFromFile(args.Config)
+		if err != nil {
+			return nil, fmt.Errorf("error loading config: %v", err)
+		}
+	}
+
+	if (args.Program == "") == (args.TestCase == "") {
+		return nil, fmt.Errorf("either a program or a test case has to be specified")
+	}
+
+	labels, err := loadLabels(config.GetAssembler(), args.Label)
+	if err != nil {
+		return nil, err
+	}
+
+	trapAddress := uint(emuconfig.IllegalTrapAddress)
+	if args.TrapAddress != nil {
+		trapAddress = *args.TrapAddress
+	}
+
+	res := &dap.Launch{
+		Labels:        labels,
+		Listings:      args.Listing,
+		ListingFormat: args.ListingFormat,
+		RelocBase:     uint32(config.Ca65StartAddress),
+		StopOnEntry:   args.StopOnEntry,
+		History:       debugger.DefaultHistoryLength,
+	}
+
+	if args.History != nil {
+		res.History = *args.History
+	}
+
+	if res.ListingFormat == "" {
+		res.ListingFormat = srcmap.FormatForAssembler(config.AsmType)
+	}
+
+	if args.TestCase != "" {
+		return launchDapCase(config, &args, trapAddress, res)
+	}
+
+	if len(res.Listings) == 0 {
+		if _, err := os.Stat(assembler.ListingFileName(args.Program)); err == nil {
+			res.Listings = []string{assembler.ListingFileName(args.Program)}
+		}
+	}
+
+	res.Run = func(dbg *debugger.Debugger) (cpu.Processor, error) {
+		processor, err := config.NewCpu()
+		if err != nil {
+			return nil, fmt.Errorf("error processing config: %v", err)
+		}
+
+		processor.SetTracer(dbg)
+
+		_, _, err = LoadAndRunBinary(processor, &args.Program, &trapAddress, &args.Lua, false, config.StartUp())
+
+		return processor, err
+	}
+
+	return res, nil
+}
+
+func launchDapCase(config *emuconfig.Config, args *dapLaunchArgs, trapAddress uint, res *dap.Launch) (*dap.Launch, error) {
+	repo, err := config.GetCaseRepo()
+	if err != nil {
+		return nil, err
+	}
+
+	caseFileName := args.TestCase
+	if !strings.HasSuffix(caseFileName, verifier.TestCaseExtension) {
+		caseFileName += verifier.TestCaseExtension
+	}
+
+	testCase, err := repo.Get(caseFileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load test case file: %v", err)
+	}
+
+	// The test driver is assembled when the test case is executed
+	if len(res.Listings) == 0 {
+		res.Listings = []string{assembler.ListingFileName(path.Join(config.AcmeBinDir, testCase.TestDriverSource+".bin"))}
+	}
+
+	res.Run = func(dbg *debugger.Debugger) (cpu.Processor, error) {
+		caseExec := caseexec.NewCaseExec(config, config, repo, true)
+
+		if trapAddress != emuconfig.IllegalTrapAddress {
+			caseExec.SetTrapAddress(uint16(trapAddress))
+		}
+
+		caseExec.SetTracer(dbg)
+		err := caseExec.LoadAndExecuteCase(args.TestCase)
+
+		return caseExec.CurrentCpu, err
+	}
+
+	return res, nil
+}
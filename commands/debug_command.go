@@ -0,0 +1,102 @@
+package commands
+
+import (
+	"6502profiler/caseexec"
+	"6502profiler/debugger"
+	"6502profiler/emuconfig"
+	"6502profiler/util"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func DebugCommand(arguments []string) error {
+	var config *emuconfig.Config = emuconfig.DefaultConfig()
+	var err error = nil
+
+	debugFlags := flag.NewFlagSet("6502profiler debug", flag.ContinueOnError)
+	binaryFileName := debugFlags.String("prg", "", "Path to the program to debug")
+	testCasePath := debugFlags.String("t", "", "Test case file to debug instead of a program")
+	configName := debugFlags.String("c", "", "Config file name")
+	labelFileName := debugFlags.String("label", "", "Path to the label file used to resolve and show labels")
+	trapAddress := debugFlags.Uint("trapaddr", emuconfig.IllegalTrapAddress, "Address to use for triggering a trap")
+	trapScript := debugFlags.String("lua", "", "Lua script to call when trap is triggered")
+	historyLength := debugFlags.Int("history", debugger.DefaultHistoryLength, "Number of instructions which can be undone one by one, 0 disables reverse execution")
+
+	if err = debugFlags.Parse(arguments); err != nil {
+		os.Exit(util.ExitErrorSyntax)
+	}
+
+	if *configName != "" {
+		config, err = emuconfig.NewConfigFromFile(*configName)
+		if err != nil {
+			return fmt.Errorf("error loading config: %v", err)
+		}
+	}
+
+	if (*binaryFileName == "") == (*testCasePath == "") {
+		return fmt.Errorf("either a program or a test case has to be specified")
+	}
+
+	labels, err := loadLabels(config.GetAssembler(), *labelFileName)
+	if err != nil {
+		return err
+	}
+
+	dbg := debugger.New(os.Stdin, os.Stdout, labels)
+	if *historyLength > 0 {
+		dbg.EnableHistory(*historyLength)
+	}
+
+	fmt.Println("Type help to list the available commands")
+
+	if *testCasePath != "" {
+		repo, err := config.GetCaseRepo()
+		if err != nil {
+			return err
+		}
+
+		caseExec := caseexec.NewCaseExec(config, config, repo, true)
+
+		if *trapAddress != emuconfig.IllegalTrapAddress {
+			caseExec.SetTrapAddress((uint16)(*trapAddress))
+		}
+
+		caseExec.SetTracer(dbg)
+
+		err = caseExec.LoadAndExecuteCase(*testCasePath)
+		if dbg.Quit() {
+			return nil
+		}
+
+		if err != nil {
+			fmt.Println(err)
+		}
+
+		if caseExec.CurrentCpu != nil {
+			dbg.PostMortem(caseExec.CurrentCpu)
+		}
+
+		return nil
+	}
+
+	processor, err := config.NewCpu()
+	if err != nil {
+		return fmt.Errorf("error processing config: %v", err)
+	}
+
+	processor.SetTracer(dbg)
+
+	_, _, err = LoadAndRunBinary(processor, binaryFileName, trapAddress, trapScript, false, config.StartUp())
+	if dbg.Quit() {
+		return nil
+	}
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	dbg.PostMortem(processor)
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package commands
+
+import (
+	"6502profiler/disasm"
+	"6502profiler/emuconfig"
+	"6502profiler/util"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func DisasmCommand(arguments []string) error {
+	var config *emuconfig.Config = emuconfig.DefaultConfig()
+	var err error = nil
+
+	disasmFlags := flag.NewFlagSet("6502profiler disasm", flag.ContinueOnError)
+	binaryFileName := disasmFlags.String("prg", "", "Path to the program to disassemble")
+	configName := disasmFlags.String("c", "", "Config file name")
+	labelFileName := disasmFlags.String("label", "", "Path to the label file used to replace addresses by labels")
+	rangeFlag := disasmFlags.String("range", "", "Memory range to disassemble instead of the whole program. Format 'startaddr:len'")
+
+	if err = disasmFlags.Parse(arguments); err != nil {
+		os.Exit(util.ExitErrorSyntax)
+	}
+
+	if *configName != "" {
+		config, err = emuconfig.NewConfigFromFile(*configName)
+		if err != nil {
+			return fmt.Errorf("error loading config: %v", err)
+		}
+	}
+
+	if (*binaryFileName == "") && (*rangeFlag == "") {
+		return fmt.Errorf("neither a program nor a memory range specified")
+	}
+
+	start, length, err := parseDumpParams(*rangeFlag)
+	if err != nil {
+		return err
+	}
+
+	labels, err := loadLabels(config.GetAssembler(), *labelFileName)
+	if err != nil {
+		return err
+	}
+
+	processor, err := config.NewCpu()
+	if err != nil {
+		return fmt.Errorf("error processing config: %v", err)
+	}
+
+	if *binaryFileName != "" {
+		loadAddress, progLen, err := processor.Load(*binaryFileName)
+		if err != nil {
+			return fmt.Errorf("%v", err)
+		}
+
+		if *rangeFlag == "" {
+			start, length = loadAddress, progLen
+		}
+	}
+
+	code, err := processor.CopyFromMem(start, length)
+	if err != nil {
+		return fmt.Errorf("unable to read memory: %v", err)
+	}
+
+	dis := disasm.New(processor.Model())
+	dis.SetLabels(labels)
+
+	for _, j := range dis.Range(code, uint32(start)) {
+		for _, label := range labels[uint16(j.Address)] {
+			fmt.Printf("%s:\n", label)
+		}
+
+		fmt.Println(j.String())
+	}
+
+	return nil
+}
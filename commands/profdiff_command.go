@@ -0,0 +1,111 @@
+package commands
+
+import (
+	"6502profiler/emuconfig"
+	"6502profiler/profiler"
+	"6502profiler/util"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// profileBinary runs the named program and collects its profile
+func profileBinary(config *emuconfig.Config, binaryFileName string, labelFileName string) (*profiler.Profile, error) {
+	labels, err := loadLabels(config.GetAssembler(), labelFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	processor, err := config.NewCpu()
+	if err != nil {
+		return nil, fmt.Errorf("error processing config: %v", err)
+	}
+
+	callGraph := profiler.NewCallGraph(labels)
+	processor.SetTracer(callGraph)
+
+	trapAddress := uint(emuconfig.IllegalTrapAddress)
+	trapScript := ""
+
+	loadAddress, progLen, err := LoadAndRunBinary(processor, &binaryFileName, &trapAddress, &trapScript, true, config.StartUp())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", binaryFileName, err)
+	}
+
+	callGraph.Finish(processor)
+
+	return profiler.NewProfile(binaryFileName, processor.GetMem(), labels, loadAddress, (loadAddress + progLen - 1), callGraph, nil), nil
+}
+
+func ProfDiffCommand(arguments []string) error {
+	var config *emuconfig.Config = emuconfig.DefaultConfig()
+	var err error = nil
+	var before, after *profiler.Profile
+
+	diffFlags := flag.NewFlagSet("6502profiler profdiff", flag.ContinueOnError)
+	run := diffFlags.Bool("run", false, "Run the two programs given as arguments instead of reading two profiles")
+	configName := diffFlags.String("c", "", "Config file name used to run the programs")
+	labelFileName := diffFlags.String("label", "", "Path to the label file of the first program. It is also used for the second one if -label2 is not given")
+	labelFileName2 := diffFlags.String("label2", "", "Path to the label file of the second program")
+	cycleLimit := diffFlags.Uint64("cyclelimit", 0, "Maximum number of clock cycles a program may use")
+	instrLimit := diffFlags.Uint64("instrlimit", 0, "Maximum number of instructions a program may execute")
+	stopOnReturn := diffFlags.Bool("stoponrts", false, "Stop when a program returns from its start routine via RTS")
+	exitPort := diffFlags.Uint("exitport", 0, "Address which stops a program when written to")
+	top := diffFlags.Uint("top", 10, "Number of labels, subroutines and addresses with the largest changes which are shown")
+	threshold := diffFlags.Float64("threshold", -1.0, "Fail if the total clock cycles (accesses if a profile has no clock cycles) increase by more than this percentage")
+
+	diffFlags.Usage = func() {
+		fmt.Fprintf(diffFlags.Output(), "Usage: 6502profiler profdiff [options] <before> <after>\n")
+		diffFlags.PrintDefaults()
+	}
+
+	if err = diffFlags.Parse(arguments); err != nil {
+		os.Exit(util.ExitErrorSyntax)
+	}
+
+	if diffFlags.NArg() != 2 {
+		return fmt.Errorf("two profiles or programs have to be specified")
+	}
+
+	if *run {
+		if *configName != "" {
+			config, err = emuconfig.NewConfigFromFile(*configName)
+			if err != nil {
+				return fmt.Errorf("error loading config: %v", err)
+			}
+		}
+
+		overrideExecutionLimits(config, *cycleLimit, *instrLimit)
+
+		if err = overrideTermination(config, *stopOnReturn, "", flagValue(diffFlags, "exitport", exitPort), ""); err != nil {
+			return err
+		}
+
+		if *labelFileName2 == "" {
+			*labelFileName2 = *labelFileName
+		}
+
+		if before, err = profileBinary(config, diffFlags.Arg(0), *labelFileName); err != nil {
+			return err
+		}
+
+		if after, err = profileBinary(config, diffFlags.Arg(1), *labelFileName2); err != nil {
+			return err
+		}
+	} else {
+		if before, err = profiler.LoadProfile(diffFlags.Arg(0)); err != nil {
+			return err
+		}
+
+		if after, err = profiler.LoadProfile(diffFlags.Arg(1)); err != nil {
+			return err
+		}
+	}
+
+	diff := profiler.DiffProfiles(before, after)
+	if err = diff.Write(os.Stdout, int(*top)); err != nil {
+		return err
+	}
+
+	return diff.CheckThreshold(*threshold)
+}
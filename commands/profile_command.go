@@ -1,17 +1,21 @@
 package commands
 
 import (
+	"6502profiler/assembler"
 	"6502profiler/cpu"
+	"6502profiler/disasm"
 	"6502profiler/emuconfig"
 	"6502profiler/luabridge"
 	"6502profiler/memory"
 	"6502profiler/profiler"
+	"6502profiler/srcmap"
 	"6502profiler/util"
 	"flag"
 	"fmt"
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
 
 	lua "github.com/yuin/gopher-lua"
 )
@@ -34,6 +38,20 @@ func determineCutOffCalc(strategy *string, p float64) profiler.CutOffCalc {
 	return ctOff
 }
 
+func determineValueCutOffCalc(strategy *string, percentage uint) profiler.ValueCutOffCalc {
+	p := float64(percentage) / 100.0
+
+	if *strategy != strategyMedian {
+		return func(values []uint64) uint64 {
+			return profiler.CutOffAbsoluteValueOf(values, p)
+		}
+	}
+
+	return func(values []uint64) uint64 {
+		return profiler.CutOffMedianOf(values, p)
+	}
+}
+
 func parseDumpParams(param string) (uint16, uint16, error) {
 	if param == "" {
 		return 0, 0, nil
@@ -69,7 +87,7 @@ func parseDumpParams(param string) (uint16, uint16, error) {
 	return dumpAddress16, dumpLen16, nil
 }
 
-func DumpMemory(param string, cpu *cpu.CPU6502) error {
+func DumpMemory(param string, cpu cpu.Processor) error {
 	if param == "" {
 		return nil
 	}
@@ -79,12 +97,156 @@ func DumpMemory(param string, cpu *cpu.CPU6502) error {
 		return err
 	}
 
-	memory.Dump(cpu.Mem, dumpAddress, dumpAddress+dumpLen-1)
+	memory.Dump(cpu.GetMem(), dumpAddress, dumpAddress+dumpLen-1)
 
 	return nil
 }
 
-func LoadAndRunBinary(processor *cpu.CPU6502, binaryFileName *string, trapAddress *uint, trapScript *string, silent bool) (uint16, uint16, error) {
+// overrideExecutionLimits replaces the execution limits of the config with the values given on the command line
+func overrideExecutionLimits(config *emuconfig.Config, cycleLimit uint64, instrLimit uint64) {
+	if cycleLimit != 0 {
+		config.CycleLimit = cycleLimit
+	}
+
+	if instrLimit != 0 {
+		config.InstructionLimit = instrLimit
+	}
+}
+
+// parseStopAddresses converts a comma separated list of addresses and labels into addresses.
+// Addresses can be given in decimal or in hex using $ or 0x as a prefix.
+func parseStopAddresses(spec string, labels map[uint16][]string) ([]uint32, error) {
+	res := []uint32{}
+
+	if spec == "" {
+		return res, nil
+	}
+
+	labelAddresses := map[string]uint16{}
+	for addr, names := range labels {
+		for _, j := range names {
+			labelAddresses[j] = addr
+		}
+	}
+
+	for _, j := range strings.Split(spec, ",") {
+		j = strings.TrimSpace(j)
+
+		if strings.HasPrefix(j, "$") {
+			j = "0x" + j[1:]
+		}
+
+		addr, err := strconv.ParseUint(j, 0, 24)
+		if err == nil {
+			res = append(res, uint32(addr))
+			continue
+		}
+
+		labelAddr, ok := labelAddresses[j]
+		if !ok {
+			return nil, fmt.Errorf("'%s' is neither an address nor a known label", j)
+		}
+
+		res = append(res, uint32(labelAddr))
+	}
+
+	return res, nil
+}
+
+// flagValue returns value if the named option has been given on the command line and nil otherwise
+func flagValue[T any](flags *flag.FlagSet, name string, value *T) *T {
+	var res *T
+
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			res = value
+		}
+	})
+
+	return res
+}
+
+// overrideTermination adds the termination conditions given on the command line to the config. exitPort
+// is nil if no exit port has been given.
+func overrideTermination(config *emuconfig.Config, stopOnReturn bool, stopAt string, exitPort *uint, labelFileName string) error {
+	var labels map[uint16][]string = map[uint16][]string{}
+	var err error
+
+	if (stopAt != "") && (labelFileName != "") {
+		labels, err = loadLabels(config.GetAssembler(), labelFileName)
+		if err != nil {
+			return err
+		}
+	}
+
+	stopAddresses, err := parseStopAddresses(stopAt, labels)
+	if err != nil {
+		return err
+	}
+
+	if (exitPort != nil) && (*exitPort > 0xFFFF) {
+		return fmt.Errorf("$%x is not a valid exit port", *exitPort)
+	}
+
+	config.StopOnReturn = config.StopOnReturn || stopOnReturn
+	config.StopAddresses = append(config.StopAddresses, stopAddresses...)
+
+	if exitPort != nil {
+		port := uint16(*exitPort)
+		config.ExitPort = &port
+	}
+
+	return nil
+}
+
+// programResult returns an ExitCodeError holding the value written to the exit port, if any. The
+// process then ends with this value as its exit code.
+func programResult(processor cpu.Processor, silent bool) error {
+	exitCode, ok := processor.ExitCode()
+	if !ok {
+		return nil
+	}
+
+	if !silent {
+		fmt.Printf("Program exited with code %d\n", exitCode)
+	}
+
+	return &util.ExitCodeError{Code: int(exitCode)}
+}
+
+// overrideStartUp replaces the start-up sequence of the config with the values given on the command line
+func overrideStartUp(config *emuconfig.Config, boot bool, bootCycles uint64, bootReady uint) error {
+	if bootReady > 0xFFFF {
+		return fmt.Errorf("$%x is not a valid ready address", bootReady)
+	}
+
+	config.BootFromReset = config.BootFromReset || boot
+
+	if bootCycles != 0 {
+		config.BootCycles = bootCycles
+	}
+
+	if bootReady != 0 {
+		config.BootReadyAddress = uint16(bootReady)
+	}
+
+	return nil
+}
+
+func LoadAndRunBinary(processor cpu.Processor, binaryFileName *string, trapAddress *uint, trapScript *string, silent bool, startUp cpu.StartUp) (uint16, uint16, error) {
+	if startUp.InjectsProgram() {
+		if err := cpu.Boot(processor, startUp); err != nil {
+			return 0, 0, fmt.Errorf("unable to start machine: %v", err)
+		}
+
+		if !silent {
+			fmt.Printf("Machine started in %d clock cycles\n", processor.NumCycles())
+		}
+
+		// Only the accesses of the injected program are of interest
+		processor.GetMem().ClearStatistics()
+	}
+
 	loadAddress, progLen, err := processor.Load(*binaryFileName)
 	if err != nil {
 		return 0, 0, fmt.Errorf("%v", err)
@@ -110,7 +272,7 @@ func LoadAndRunBinary(processor *cpu.CPU6502, binaryFileName *string, trapAddres
 			fmt.Printf("Using trap address $%x\n", trapAddr)
 		}
 
-		baseMem := processor.Mem
+		baseMem := processor.GetMem()
 
 		wrapperMem := memory.NewMemWrapper(baseMem, 0xFF00&trapAddr)
 		trapProc, err := luabridge.NewTrapProcessor(L, *trapScript, processor, loadAddress, progLen, *binaryFileName+".ident")
@@ -119,16 +281,22 @@ func LoadAndRunBinary(processor *cpu.CPU6502, binaryFileName *string, trapAddres
 		}
 
 		wrapperMem.AddSpecialWriteAddress(trapAddr, trapProc.Write)
-		processor.Mem = wrapperMem
+		processor.SetMem(wrapperMem)
 		defer func() {
 			_ = trapProc.Ctx.CallCleanup()
 			// Remove memory wrapper, because the trap adddress will not work after the Lua
 			// state has been Closed.
-			processor.Mem = baseMem
+			processor.SetMem(baseMem)
 		}()
 	}
 
-	err = processor.Run(loadAddress)
+	if startUp.FromReset && !startUp.InjectsProgram() {
+		err = cpu.Boot(processor, startUp)
+	} else {
+		err = processor.Run(loadAddress)
+	}
+
+	err = disasm.AnnotateError(processor, err)
 	if err != nil {
 		return 0, 0, fmt.Errorf("a problem occurred: %v", err)
 	}
@@ -138,7 +306,7 @@ func LoadAndRunBinary(processor *cpu.CPU6502, binaryFileName *string, trapAddres
 
 func RunCommand(arguments []string) error {
 	var config *emuconfig.Config = emuconfig.DefaultConfig()
-	var processor *cpu.CPU6502
+	var processor cpu.Processor
 	var err error = nil
 
 	runFlags := flag.NewFlagSet("6502profiler run", flag.ContinueOnError)
@@ -147,7 +315,17 @@ func RunCommand(arguments []string) error {
 	dumpFlag := runFlags.String("dump", "", "Dump memory after program has stopped. Format 'startaddr:len'")
 	trapAddress := runFlags.Uint("trapaddr", emuconfig.IllegalTrapAddress, "Address to use for triggering a trap")
 	trapScript := runFlags.String("lua", "", "Lua script to call when trap is triggered")
+	labelFileName := runFlags.String("label", "", "Path to the label file used to resolve labels given in -stopat and -tracefilter")
 	silent := runFlags.Bool("silent", false, "Do not print additional info")
+	cycleLimit := runFlags.Uint64("cyclelimit", 0, "Maximum number of clock cycles the program may use")
+	instrLimit := runFlags.Uint64("instrlimit", 0, "Maximum number of instructions the program may execute")
+	stopOnReturn := runFlags.Bool("stoponrts", false, "Stop when the program returns from its start routine via RTS")
+	stopAt := runFlags.String("stopat", "", "Comma separated list of addresses or labels which stop the program when reached")
+	exitPort := runFlags.Uint("exitport", 0, "Address which stops the program when written to. The value becomes the exit code")
+	boot := runFlags.Bool("boot", false, "Start the machine at the address stored in the reset vector")
+	bootCycles := runFlags.Uint64("bootcycles", 0, "Number of clock cycles the start-up code may use before the program is started")
+	bootReady := runFlags.Uint("bootready", 0, "Address which signals that the start-up code has initialized the machine")
+	traceOpts := addTraceFlags(runFlags)
 
 	if err = runFlags.Parse(arguments); err != nil {
 		os.Exit(util.ExitErrorSyntax)
@@ -160,6 +338,16 @@ func RunCommand(arguments []string) error {
 		}
 	}
 
+	overrideExecutionLimits(config, *cycleLimit, *instrLimit)
+
+	if err = overrideTermination(config, *stopOnReturn, *stopAt, flagValue(runFlags, "exitport", exitPort), *labelFileName); err != nil {
+		return err
+	}
+
+	if err = overrideStartUp(config, *boot, *bootCycles, *bootReady); err != nil {
+		return err
+	}
+
 	processor, err = config.NewCpu()
 	if err != nil {
 		return fmt.Errorf("error processing config: %v", err)
@@ -173,7 +361,18 @@ func RunCommand(arguments []string) error {
 		return err
 	}
 
-	_, _, err = LoadAndRunBinary(processor, binaryFileName, trapAddress, trapScript, *silent)
+	tracer, closeTrace, err := traceOpts.open(config.GetAssembler(), *labelFileName)
+	if err != nil {
+		return err
+	}
+
+	processor.SetTracer(tracer)
+
+	_, _, err = LoadAndRunBinary(processor, binaryFileName, trapAddress, trapScript, *silent, config.StartUp())
+	if closeErr := closeTrace(); err == nil {
+		err = closeErr
+	}
+
 	if err != nil {
 		return err
 	}
@@ -187,7 +386,7 @@ func RunCommand(arguments []string) error {
 		return err
 	}
 
-	return nil
+	return programResult(processor, *silent)
 }
 
 func ProfileCommand(arguments []string) error {
@@ -195,11 +394,11 @@ func ProfileCommand(arguments []string) error {
 	var labels map[uint16][]string
 	var err error = nil
 	var config *emuconfig.Config = emuconfig.DefaultConfig()
-	var processor *cpu.CPU6502
+	var processor cpu.Processor
 
 	profileFlags := flag.NewFlagSet("6502profiler profile", flag.ContinueOnError)
 	binaryFileName := profileFlags.String("prg", "", "Path to the program to run")
-	labelFileName := profileFlags.String("label", "", "Path to the label file generated by the ACME assembler")
+	labelFileName := profileFlags.String("label", "", "Path to the label file generated by the assembler, a VICE label file or an ld65 debug info file")
 	outputFileName := profileFlags.String("out", "", "Path to the out file that holds the generated data")
 	percentageCutOff := profileFlags.Uint("prcnt", 10, "Percentage used to determine cut off value")
 	strategy := profileFlags.String("strategy", strategyMedian, "Strategy to determine cutoff value")
@@ -208,6 +407,21 @@ func ProfileCommand(arguments []string) error {
 	trapAddress := profileFlags.Uint("trapaddr", emuconfig.IllegalTrapAddress, "Address to use for triggering a trap")
 	trapScript := profileFlags.String("lua", "", "Lua script to call when trap is triggered")
 	silent := profileFlags.Bool("silent", false, "Do not print additional info")
+	cycleLimit := profileFlags.Uint64("cyclelimit", 0, "Maximum number of clock cycles the program may use")
+	instrLimit := profileFlags.Uint64("instrlimit", 0, "Maximum number of instructions the program may execute")
+	stopOnReturn := profileFlags.Bool("stoponrts", false, "Stop when the program returns from its start routine via RTS")
+	stopAt := profileFlags.String("stopat", "", "Comma separated list of addresses or labels which stop the program when reached")
+	exitPort := profileFlags.Uint("exitport", 0, "Address which stops the program when written to. The value becomes the exit code")
+	boot := profileFlags.Bool("boot", false, "Start the machine at the address stored in the reset vector")
+	bootCycles := profileFlags.Uint64("bootcycles", 0, "Number of clock cycles the start-up code may use before the program is started")
+	bootReady := profileFlags.Uint("bootready", 0, "Address which signals that the start-up code has initialized the machine")
+	noDisasm := profileFlags.Bool("nodisasm", false, "Do not add disassembled instructions to the generated data")
+	topRoutines := profileFlags.Uint("top", 10, "Number of subroutines with the most clock cycles which are shown after the program has stopped")
+	callGraphFileName := profileFlags.String("callgraph", "", "Path to a file which receives the clock cycles used by all subroutines and their calls")
+	format := profileFlags.String("format", profiler.FormatText, "Format of the out file: "+strings.Join(profiler.Formats, ", "))
+	listingFileName := profileFlags.String("listing", "", "Listing, report or ld65 debug info file used to assign the clock cycles to source lines")
+	sourceFileName := profileFlags.String("source", "", "Path to a file which receives the source code annotated with the clock cycles of each line")
+	traceOpts := addTraceFlags(profileFlags)
 
 	if err = profileFlags.Parse(arguments); err != nil {
 		os.Exit(util.ExitErrorSyntax)
@@ -220,6 +434,16 @@ func ProfileCommand(arguments []string) error {
 		}
 	}
 
+	overrideExecutionLimits(config, *cycleLimit, *instrLimit)
+
+	if err = overrideTermination(config, *stopOnReturn, *stopAt, flagValue(profileFlags, "exitport", exitPort), *labelFileName); err != nil {
+		return err
+	}
+
+	if err = overrideStartUp(config, *boot, *bootCycles, *bootReady); err != nil {
+		return err
+	}
+
 	assembler := config.GetAssembler()
 
 	processor, err = config.NewCpu()
@@ -237,16 +461,16 @@ func ProfileCommand(arguments []string) error {
 		return err
 	}
 
-	if statisticRequested {
-		labels = map[uint16][]string{}
+	labels, err = loadLabels(assembler, *labelFileName)
+	if err != nil {
+		return err
+	}
 
-		if *labelFileName != "" {
-			labels, err = assembler.ParseLabelFile(*labelFileName)
-			if err != nil {
-				return fmt.Errorf("a problem occurred: %v", err)
-			}
-		}
+	if !isProfileFormat(*format) {
+		return fmt.Errorf("unknown format '%s'", *format)
+	}
 
+	if statisticRequested || (*sourceFileName != "") {
 		if *percentageCutOff > 100 {
 			return fmt.Errorf("%d is not a valid value for cutoff percentage", *percentageCutOff)
 		}
@@ -254,7 +478,25 @@ func ProfileCommand(arguments []string) error {
 		p = float64(*percentageCutOff) / 100.0
 	}
 
-	loadAddress, progLen, err := LoadAndRunBinary(processor, binaryFileName, trapAddress, trapScript, *silent)
+	tracer, closeTrace, err := traceOpts.open(assembler, *labelFileName)
+	if err != nil {
+		return err
+	}
+
+	var callGraph *profiler.CallGraph
+	needsCallGraph := (*callGraphFileName != "") || (*sourceFileName != "") || (statisticRequested && profiler.NeedsCallGraph(*format))
+	if ((*topRoutines != 0) && !*silent) || needsCallGraph {
+		callGraph = profiler.NewCallGraph(labels)
+		processor.SetTracer(cpu.CombineTracers(tracer, callGraph))
+	} else {
+		processor.SetTracer(tracer)
+	}
+
+	loadAddress, progLen, err := LoadAndRunBinary(processor, binaryFileName, trapAddress, trapScript, *silent, config.StartUp())
+	if closeErr := closeTrace(); err == nil {
+		err = closeErr
+	}
+
 	if err != nil {
 		return err
 	}
@@ -263,10 +505,36 @@ func ProfileCommand(arguments []string) error {
 		fmt.Printf("Program ran for %d clock cycles\n", processor.NumCycles())
 	}
 
+	if callGraph != nil {
+		callGraph.Finish(processor)
+
+		if err = writeCallGraph(callGraph, int(*topRoutines), *silent, *callGraphFileName); err != nil {
+			return err
+		}
+	}
+
+	if *sourceFileName != "" {
+		if err = annotateSource(processor, callGraph, *sourceFileName, *binaryFileName, *listingFileName, config, loadAddress, (loadAddress + progLen - 1), determineValueCutOffCalc(strategy, *percentageCutOff)); err != nil {
+			return fmt.Errorf("problem generating annotated source: %v", err)
+		}
+	}
+
 	if statisticRequested {
-		var ctOff = determineCutOffCalc(strategy, p)
+		var dis *disasm.Disassembler
+
+		if !*noDisasm {
+			dis = disasm.New(processor.Model())
+			dis.SetLabels(labels)
+		}
+
+		if *format == profiler.FormatText {
+			var ctOff = determineCutOffCalc(strategy, p)
+			err = profiler.DumpStatistics(processor.GetMem(), *outputFileName, labels, loadAddress, (loadAddress + progLen - 1), ctOff, dis)
+		} else {
+			err = exportProfile(processor, callGraph, *format, *outputFileName, *binaryFileName, *listingFileName, config, labels, loadAddress, (loadAddress + progLen - 1), dis)
+		}
 
-		if err = profiler.DumpStatistics(processor.Mem, *outputFileName, labels, loadAddress, (loadAddress + progLen - 1), ctOff); err != nil {
+		if err != nil {
 			return fmt.Errorf("problem generating output file: %v", err)
 		}
 	}
@@ -276,5 +544,111 @@ func ProfileCommand(arguments []string) error {
 		return err
 	}
 
+	return programResult(processor, *silent)
+}
+
+// writeCallGraph shows the subroutines with the most clock cycles and writes the whole call graph
+// to the named file if requested
+func writeCallGraph(callGraph *profiler.CallGraph, top int, silent bool, fileName string) error {
+	if (top != 0) && !silent {
+		fmt.Println()
+		callGraph.WriteTop(os.Stdout, top)
+		fmt.Println()
+	}
+
+	if fileName == "" {
+		return nil
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("problem generating call graph: %v", err)
+	}
+	defer func() { f.Close() }()
+
+	callGraph.WriteReport(f)
+
 	return nil
 }
+
+func isProfileFormat(format string) bool {
+	for _, j := range profiler.Formats {
+		if j == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+// exportProfile writes the data of the program run stored between start and end in one of the
+// formats which can be read by other tools
+func exportProfile(processor cpu.Processor, callGraph *profiler.CallGraph, format string, fileName string, program string, listing string, config *emuconfig.Config, labels map[uint16][]string, start uint16, end uint16, dis *disasm.Disassembler) error {
+	var src *srcmap.Map
+
+	if format == profiler.FormatCallgrind {
+		var err error
+
+		if src, err = loadSourceMap(listing, program, config); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer func() { f.Close() }()
+
+	switch format {
+	case profiler.FormatCallgrind:
+		err = callGraph.WriteCallgrind(f, program, src)
+	case profiler.FormatFolded:
+		err = callGraph.WriteFolded(f)
+	case profiler.FormatJSON:
+		err = profiler.NewProfile(program, processor.GetMem(), labels, start, end, callGraph, dis).WriteJSON(f)
+	case profiler.FormatCSV:
+		err = profiler.NewProfile(program, processor.GetMem(), labels, start, end, callGraph, dis).WriteCSV(f)
+	}
+
+	return err
+}
+
+// loadSourceMap reads the named listing. If no listing is given the listing which has been created
+// together with the program is used if it exists. nil is returned if there is no listing.
+func loadSourceMap(listing string, program string, config *emuconfig.Config) (*srcmap.Map, error) {
+	if listing == "" {
+		if _, err := os.Stat(assembler.ListingFileName(program)); err != nil {
+			return nil, nil
+		}
+
+		listing = assembler.ListingFileName(program)
+	}
+
+	format := srcmap.FormatForFile(listing, srcmap.FormatForAssembler(config.AsmType))
+
+	return srcmap.LoadListing(listing, format, uint32(config.Ca65StartAddress))
+}
+
+// annotateSource writes the source code of the program stored between start and end to the named
+// file. Each line is annotated with its clock cycles, executions and data accesses.
+func annotateSource(processor cpu.Processor, callGraph *profiler.CallGraph, fileName string, program string, listing string, config *emuconfig.Config, start uint16, end uint16, cutOff profiler.ValueCutOffCalc) error {
+	src, err := loadSourceMap(listing, program, config)
+	if err != nil {
+		return err
+	}
+
+	if src == nil {
+		return fmt.Errorf("no listing found, use -listing to specify it")
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer func() { f.Close() }()
+
+	lines := profiler.SourceLines(src, callGraph, processor.GetMem(), start, end)
+
+	return profiler.WriteAnnotatedSource(f, lines, callGraph.TotalCycles(), cutOff)
+}
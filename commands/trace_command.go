@@ -0,0 +1,173 @@
+package commands
+
+import (
+	"6502profiler/assembler"
+	"6502profiler/cpu"
+	"6502profiler/emuconfig"
+	"6502profiler/trace"
+	"6502profiler/util"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+)
+
+const traceFormatText = "text"
+const traceFormatBinary = "bin"
+
+// traceFlags holds the command line options which control the instruction trace
+type traceFlags struct {
+	fileName *string
+	format   *string
+	filter   *string
+}
+
+func addTraceFlags(f *flag.FlagSet) *traceFlags {
+	return &traceFlags{
+		fileName: f.String("trace", "", "Write a trace of the executed instructions to this file"),
+		format:   f.String("tracefmt", traceFormatText, "Format of the trace: 'text' or 'bin'"),
+		filter:   f.String("tracefilter", "", "Comma separated conditions selecting the traced instructions: range=start-end, sub=addr, from=cycle, to=cycle"),
+	}
+}
+
+func loadLabels(asm assembler.Assembler, labelFileName string) (map[uint16][]string, error) {
+	if labelFileName == "" {
+		return map[uint16][]string{}, nil
+	}
+
+	labels, err := assembler.LoadLabels(asm, labelFileName)
+	if err != nil {
+		return nil, fmt.Errorf("a problem occurred: %v", err)
+	}
+
+	return labels, nil
+}
+
+// open creates the tracer requested on the command line. The returned tracer is nil if no trace
+// has been requested. The returned function has to be called after the program has stopped.
+func (t *traceFlags) open(asm assembler.Assembler, labelFileName string) (cpu.Tracer, func() error, error) {
+	if *t.fileName == "" {
+		return nil, func() error { return nil }, nil
+	}
+
+	if (*t.format != traceFormatText) && (*t.format != traceFormatBinary) {
+		return nil, nil, fmt.Errorf("unknown trace format '%s'", *t.format)
+	}
+
+	labels, err := loadLabels(asm, labelFileName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filter, err := trace.ParseFilter(*t.filter, labels)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Create(*t.fileName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create trace file: %v", err)
+	}
+
+	w := trace.NewWriter(f, *t.format == traceFormatBinary, filter, labels)
+
+	closer := func() error {
+		err := w.Flush()
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+
+		if err != nil {
+			return fmt.Errorf("unable to write trace file: %v", err)
+		}
+
+		return nil
+	}
+
+	return w, closer, nil
+}
+
+func TraceCommand(arguments []string) error {
+	var config *emuconfig.Config = emuconfig.DefaultConfig()
+	var err error = nil
+
+	traceFlags := flag.NewFlagSet("6502profiler trace", flag.ContinueOnError)
+	inFileName := traceFlags.String("in", "", "Path to a trace written in the binary format")
+	configName := traceFlags.String("c", "", "Config file name")
+	labelFileName := traceFlags.String("label", "", "Path to the label file used to replace addresses by labels")
+	grepFlag := traceFlags.String("grep", "", "Only print lines which match this regular expression")
+	filterFlag := traceFlags.String("filter", "", "Comma separated conditions selecting the printed instructions: range=start-end, from=cycle, to=cycle")
+
+	if err = traceFlags.Parse(arguments); err != nil {
+		os.Exit(util.ExitErrorSyntax)
+	}
+
+	if *configName != "" {
+		config, err = emuconfig.NewConfigFromFile(*configName)
+		if err != nil {
+			return fmt.Errorf("error loading config: %v", err)
+		}
+	}
+
+	if *inFileName == "" {
+		return fmt.Errorf("no trace file specified")
+	}
+
+	var re *regexp.Regexp
+	if *grepFlag != "" {
+		re, err = regexp.Compile(*grepFlag)
+		if err != nil {
+			return fmt.Errorf("illegal regular expression: %v", err)
+		}
+	}
+
+	labels, err := loadLabels(config.GetAssembler(), *labelFileName)
+	if err != nil {
+		return err
+	}
+
+	filter, err := trace.ParseFilter(*filterFlag, labels)
+	if err != nil {
+		return err
+	}
+
+	// The call stack is not part of a trace
+	if len(filter.Subroutines) != 0 {
+		return fmt.Errorf("sub can only be used while the program is traced")
+	}
+
+	f, err := os.Open(*inFileName)
+	if err != nil {
+		return fmt.Errorf("unable to open trace file: %v", err)
+	}
+	defer func() { f.Close() }()
+
+	reader, err := trace.NewReader(f)
+	if err != nil {
+		return err
+	}
+
+	formatter := trace.NewFormatter(reader.Header, labels)
+
+	for {
+		r, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if !filter.Matches(nil, r) {
+			continue
+		}
+
+		line := formatter.Format(r)
+		if (re == nil) || re.MatchString(line) {
+			fmt.Println(line)
+		}
+	}
+}
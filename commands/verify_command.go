@@ -2,11 +2,15 @@ package commands
 
 import (
 	"6502profiler/caseexec"
+	"6502profiler/cpu"
+	"6502profiler/debugger"
 	"6502profiler/emuconfig"
 	"6502profiler/util"
+	"6502profiler/verifier"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 )
 
 func VerifyAllCommand(arguments []string) error {
@@ -17,6 +21,12 @@ func VerifyAllCommand(arguments []string) error {
 	preExecName := verifierFlags.String("prexec", "", "Program to run before first test")
 	verboseFlag := verifierFlags.Bool("verbose", false, "Give more information")
 	trapFlag := verifierFlags.Uint("trapaddr", emuconfig.IllegalTrapAddress, "Set trap address")
+	labelFileName := verifierFlags.String("label", "", "Path to the label file used to resolve labels given in -tracefilter")
+	baselineFileName := verifierFlags.String("baseline", "", "Path to a file containing the clock cycles of all test cases. Test cases which exceed them fail")
+	recordBaseline := verifierFlags.Bool("record-baseline", false, "Write the clock cycles of all test cases to the file given in -baseline")
+	tolerance := verifierFlags.Float64("tolerance", 0.0, "Percentage by which a test case may exceed its baseline")
+	coverageFileName := verifierFlags.String("coverage", "", "Path to an lcov tracefile which receives the source lines, routines and branch directions executed by the test cases")
+	traceOpts := addTraceFlags(verifierFlags)
 
 	if err = verifierFlags.Parse(arguments); err != nil {
 		os.Exit(util.ExitErrorSyntax)
@@ -40,6 +50,28 @@ func VerifyAllCommand(arguments []string) error {
 		caseExec.SetTrapAddress((uint16)(*trapFlag))
 	}
 
+	tracer, closeTrace, err := traceOpts.open(config.GetAssembler(), *labelFileName)
+	if err != nil {
+		return err
+	}
+
+	var cov *coverageCollector
+
+	if *coverageFileName != "" {
+		cov = newCoverageCollector(config)
+		tracer = cpu.CombineTracers(tracer, cov.recorder)
+		caseExec.CaseFinished = func(_ string, testCase *verifier.TestCase) {
+			cov.add(caseExec.CurrentCpu, testCase)
+		}
+	}
+
+	caseExec.SetTracer(tracer)
+
+	baseline, err := openBaseline(caseExec, *baselineFileName, *recordBaseline, *tolerance)
+	if err != nil {
+		return err
+	}
+
 	if *preExecName != "" {
 		err = caseExec.ExecuteSetupProgram(*preExecName)
 		if err != nil {
@@ -48,19 +80,65 @@ func VerifyAllCommand(arguments []string) error {
 	}
 
 	testCount, err := repo.IterateTestCases(caseExec.ExecuteCase)
+	if closeErr := closeTrace(); closeErr != nil {
+		return closeErr
+	}
+
 	if err != nil {
 		return fmt.Errorf("unable to iterate test cases: %v", err)
 	}
 
+	if *recordBaseline {
+		if err = baseline.Save(*baselineFileName); err != nil {
+			return err
+		}
+	}
+
 	if *verboseFlag {
 		fmt.Println("--------------------------------------------")
 	}
 	fmt.Println()
 	fmt.Printf("%d tests successfully executed\n", testCount)
 
+	if cov != nil {
+		return cov.write(os.Stdout, *coverageFileName)
+	}
+
 	return nil
 }
 
+// openBaseline reads the baseline against which the test cases are checked or creates an empty one
+// if it is to be recorded
+func openBaseline(caseExec *caseexec.CaseExec, fileName string, record bool, tolerance float64) (verifier.Baseline, error) {
+	if fileName == "" {
+		if record {
+			return nil, fmt.Errorf("the baseline file has to be specified with -baseline")
+		}
+
+		return nil, nil
+	}
+
+	if tolerance < 0.0 {
+		return nil, fmt.Errorf("%.2f is not a valid tolerance", tolerance)
+	}
+
+	if record {
+		res := verifier.NewBaseline()
+		caseExec.RecordBaseline(res)
+
+		return res, nil
+	}
+
+	res, err := verifier.LoadBaseline(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	caseExec.CheckBaseline(res, tolerance)
+
+	return res, nil
+}
+
 func VerifyCommand(arguments []string) error {
 	var config *emuconfig.Config = emuconfig.DefaultConfig()
 	var err error
@@ -70,6 +148,11 @@ func VerifyCommand(arguments []string) error {
 	preExecName := verifierFlags.String("prexec", "", "Program to run before test")
 	verboseFlag := verifierFlags.Bool("verbose", false, "Give more information")
 	trapFlag := verifierFlags.Uint("trapaddr", emuconfig.IllegalTrapAddress, "Set trap address")
+	labelFileName := verifierFlags.String("label", "", "Path to the label file used to resolve labels given in -tracefilter and -lastwrite")
+	traceOpts := addTraceFlags(verifierFlags)
+	postMortemFlag := verifierFlags.Bool("postmortem", false, "Start the debugger on the final state if the test fails")
+	lastWriteSpec := verifierFlags.String("lastwrite", "", "Comma separated addresses for which the last write is shown if the test fails")
+	historyLength := verifierFlags.Int("history", debugger.DefaultHistoryLength, "Number of instructions recorded for -postmortem and -lastwrite")
 
 	if err = verifierFlags.Parse(arguments); err != nil {
 		os.Exit(util.ExitErrorSyntax)
@@ -97,6 +180,30 @@ func VerifyCommand(arguments []string) error {
 		caseExec.SetTrapAddress((uint16)(*trapFlag))
 	}
 
+	tracer, closeTrace, err := traceOpts.open(config.GetAssembler(), *labelFileName)
+	if err != nil {
+		return err
+	}
+
+	var dbg *debugger.Debugger
+
+	if *postMortemFlag || (*lastWriteSpec != "") {
+		labels, err := loadLabels(config.GetAssembler(), *labelFileName)
+		if err != nil {
+			return err
+		}
+
+		// The debugger only records the executed instructions. It is not needed before the test has
+		// failed.
+		dbg = debugger.New(os.Stdin, os.Stdout, labels)
+		dbg.EnableHistory(*historyLength)
+		dbg.SetTracer(tracer)
+		dbg.Continue()
+		caseExec.SetTracer(dbg)
+	} else {
+		caseExec.SetTracer(tracer)
+	}
+
 	if *preExecName != "" {
 		err = caseExec.ExecuteSetupProgram(*preExecName)
 		if err != nil {
@@ -105,9 +212,40 @@ func VerifyCommand(arguments []string) error {
 	}
 
 	res := caseExec.LoadAndExecuteCase(*testCasePath)
+	if closeErr := closeTrace(); (res == nil) && (closeErr != nil) {
+		res = closeErr
+	}
+
+	if (res != nil) && (dbg != nil) && (caseExec.CurrentCpu != nil) {
+		postMortem(dbg, caseExec.CurrentCpu, res, *lastWriteSpec, *postMortemFlag)
+	}
+
 	if *verboseFlag {
 		fmt.Println("--------------------------------------------")
 	}
 
 	return res
 }
+
+// postMortem shows the last writes to the addresses in lastWriteSpec and starts the debugger on the
+// final state of a failed test if requested
+func postMortem(dbg *debugger.Debugger, p cpu.Processor, failure error, lastWriteSpec string, startDebugger bool) {
+	fmt.Println()
+
+	if lastWriteSpec != "" {
+		for _, j := range strings.Split(lastWriteSpec, ",") {
+			msg, err := dbg.DescribeLastWrite(strings.TrimSpace(j))
+			if err != nil {
+				msg = err.Error()
+			}
+
+			fmt.Println(msg)
+		}
+	}
+
+	if startDebugger {
+		fmt.Println(failure)
+		fmt.Println("Type help to list the available commands")
+		dbg.PostMortem(p)
+	}
+}
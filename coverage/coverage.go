@@ -0,0 +1,601 @@
+package coverage
+
+import (
+	"6502profiler/cpu"
+	"6502profiler/disasm"
+	"6502profiler/srcmap"
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// BranchCount counts how often a conditional branch has been taken and not taken
+type BranchCount struct {
+	Taken    uint64
+	NotTaken uint64
+}
+
+// Executed returns true if the branch has been executed at all
+func (b BranchCount) Executed() bool {
+	return (b.Taken != 0) || (b.NotTaken != 0)
+}
+
+// OneWay returns true if the branch has been executed but has either always or never been taken
+func (b BranchCount) OneWay() bool {
+	return (b.Taken == 0) != (b.NotTaken == 0)
+}
+
+// Recorder is a cpu.Tracer which counts how often the instruction at each address has been executed
+// and how often each conditional branch has been taken
+type Recorder struct {
+	counts   map[uint32]uint64
+	branches map[uint32]BranchCount
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{counts: map[uint32]uint64{}, branches: map[uint32]BranchCount{}}
+}
+
+func (r *Recorder) Trace(p cpu.Processor, rec *cpu.TraceRecord) {
+	r.counts[rec.PC]++
+
+	switch rec.Branch {
+	case cpu.BranchTaken:
+		b := r.branches[rec.PC]
+		b.Taken++
+		r.branches[rec.PC] = b
+	case cpu.BranchNotTaken:
+		b := r.branches[rec.PC]
+		b.NotTaken++
+		r.branches[rec.PC] = b
+	}
+}
+
+// Counts returns the number of executions of each address at which an instruction has been executed
+func (r *Recorder) Counts() map[uint32]uint64 {
+	return r.counts
+}
+
+// Branches returns the outcomes of the conditional branches which have been executed
+func (r *Recorder) Branches() map[uint32]BranchCount {
+	return r.branches
+}
+
+// Reset forgets all executed instructions
+func (r *Recorder) Reset() {
+	r.counts = map[uint32]uint64{}
+	r.branches = map[uint32]BranchCount{}
+}
+
+// Routine describes the coverage of the code from a label up to the next one
+type Routine struct {
+	Name string
+	// Location is the line which has generated the first byte of the routine. Its line number is zero
+	// if it is not known.
+	Location srcmap.Location
+	// Executions counts the executed instructions of the routine
+	Executions uint64
+}
+
+// LineCoverage describes how often the instructions generated by a source line have been executed
+type LineCoverage struct {
+	srcmap.Location
+	Executions uint64
+}
+
+// BranchCoverage describes the outcomes of the conditional branches generated by a source line
+type BranchCoverage struct {
+	srcmap.Location
+	BranchCount
+}
+
+// Report merges the coverage of several programs, e.g. the test drivers of all test cases, at the
+// level of source lines and routines. A line or routine is covered if it has been executed by any
+// of the programs.
+type Report struct {
+	mnemonics map[string]bool
+	// conditional contains the mnemonics of the conditional branches
+	conditional map[string]bool
+	include     func(file string) bool
+	lines       map[srcmap.Location]uint64
+	branches    map[srcmap.Location]*BranchCount
+	routines    map[string]*Routine
+	sources     map[string][]string
+}
+
+// NewReport creates an empty report for programs which run on the given CPU. Only source files for
+// which include returns true are taken into account. include may be nil.
+func NewReport(model cpu.CpuModel, include func(file string) bool) *Report {
+	res := &Report{
+		mnemonics:   map[string]bool{},
+		conditional: map[string]bool{},
+		include:     include,
+		lines:       map[srcmap.Location]uint64{},
+		branches:    map[srcmap.Location]*BranchCount{},
+		routines:    map[string]*Routine{},
+		sources:     map[string][]string{},
+	}
+
+	for mnemonic, modes := range disasm.Opcodes(model) {
+		res.mnemonics[strings.ToUpper(mnemonic)] = true
+
+		// Relative addressing is only used by branches. BRA and BSR are the unconditional ones.
+		_, rel := modes["rel"]
+		_, rlw := modes["rlw"]
+		_, zpr := modes["zpr"]
+		if (rel || rlw || zpr) && (mnemonic != "BRA") && (mnemonic != "BSR") {
+			res.conditional[strings.ToUpper(mnemonic)] = true
+		}
+	}
+
+	return res
+}
+
+func (r *Report) included(file string) bool {
+	return (r.include == nil) || r.include(file)
+}
+
+// source returns the lines of a source file or nil if it can not be read
+func (r *Report) source(fileName string) []string {
+	if text, ok := r.sources[fileName]; ok {
+		return text
+	}
+
+	var res []string
+
+	if f, err := os.Open(fileName); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			res = append(res, scanner.Text())
+		}
+
+		f.Close()
+	}
+
+	r.sources[fileName] = res
+
+	return res
+}
+
+// toMnemonic returns the mnemonic contained in word, which may be followed by a suffix like .w, or
+// an empty string
+func (r *Report) toMnemonic(word string) string {
+	if strings.HasPrefix(word, ".") {
+		return ""
+	}
+
+	word, _, _ = strings.Cut(word, ".")
+	word = strings.ToUpper(word)
+
+	if !r.mnemonics[word] {
+		return ""
+	}
+
+	return word
+}
+
+// mnemonic returns the mnemonic of the instruction contained in the given source line or an empty
+// string if the line contains no instruction. Listings do not tell whether a line has generated
+// code or data, so the text of the line is examined. An instruction may be preceded by a label.
+func (r *Report) mnemonic(loc srcmap.Location) string {
+	text := r.source(loc.File)
+	if (loc.Line < 1) || (loc.Line > len(text)) {
+		return ""
+	}
+
+	line, _, _ := strings.Cut(text[loc.Line-1], ";")
+	words := strings.Fields(line)
+
+	if len(words) == 0 {
+		return ""
+	}
+
+	if res := r.toMnemonic(words[0]); (res != "") || (len(words) == 1) {
+		return res
+	}
+
+	return r.toMnemonic(words[1])
+}
+
+// Add merges the coverage of a program which is stored between start and end. executed contains the
+// number of executions of each instruction and branches the outcomes of the conditional branches.
+// src is used to assign the instructions to source lines. If it is nil only the coverage of the
+// routines can be determined. Routines are named by the labels which are located inside the program.
+func (r *Report) Add(src *srcmap.Map, labels map[uint16][]string, start uint16, end uint16, executed map[uint32]uint64, branches map[uint32]BranchCount) {
+	if src != nil {
+		hits := map[srcmap.Location]uint64{}
+		for addr, count := range executed {
+			if loc, ok := src.Lookup(addr); ok {
+				hits[loc] += count
+			}
+		}
+
+		outcomes := map[srcmap.Location]BranchCount{}
+		for addr, count := range branches {
+			if loc, ok := src.Lookup(addr); ok {
+				b := outcomes[loc]
+				b.Taken += count.Taken
+				b.NotTaken += count.NotTaken
+				outcomes[loc] = b
+			}
+		}
+
+		for _, loc := range src.Lines() {
+			if !r.included(loc.File) {
+				continue
+			}
+
+			mnemonic := r.mnemonic(loc)
+			if (hits[loc] != 0) || (mnemonic != "") {
+				r.lines[loc] += hits[loc]
+			}
+
+			// Branches which have not been executed are recognized by their mnemonic
+			b, ok := outcomes[loc]
+			if ok || r.conditional[mnemonic] {
+				if _, ok := r.branches[loc]; !ok {
+					r.branches[loc] = &BranchCount{}
+				}
+
+				r.branches[loc].Taken += b.Taken
+				r.branches[loc].NotTaken += b.NotTaken
+			}
+		}
+	}
+
+	addresses := []uint16{}
+	for addr := range labels {
+		if (addr >= start) && (addr <= end) {
+			addresses = append(addresses, addr)
+		}
+	}
+
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+
+	for i, addr := range addresses {
+		next := uint32(end) + 1
+		if i+1 < len(addresses) {
+			next = uint32(addresses[i+1])
+		}
+
+		r.addRoutine(src, labels[addr], uint32(addr), next, executed)
+	}
+}
+
+// addRoutine merges the coverage of the code between start and end (exclusive) which is named by
+// the given labels
+func (r *Report) addRoutine(src *srcmap.Map, names []string, start uint32, end uint32, executed map[uint32]uint64) {
+	loc := srcmap.Location{}
+	isCode := true
+
+	if src != nil {
+		found := false
+		isCode = false
+
+		for addr := start; addr < end; addr++ {
+			l, ok := src.Lookup(addr)
+			if !ok {
+				continue
+			}
+
+			if !found {
+				loc, found = l, true
+			}
+
+			if _, ok := r.lines[l]; ok && (l.File == loc.File) {
+				isCode = true
+			}
+		}
+
+		// Data and routines outside of the included files are ignored
+		if !found || !r.included(loc.File) {
+			return
+		}
+	}
+
+	executions := uint64(0)
+	for addr := start; addr < end; addr++ {
+		executions += executed[addr]
+	}
+
+	if !isCode && (executions == 0) {
+		return
+	}
+
+	for _, name := range names {
+		routine, ok := r.routines[name]
+		if !ok {
+			routine = &Routine{Name: name, Location: loc}
+			r.routines[name] = routine
+		}
+
+		routine.Executions += executions
+	}
+}
+
+// Lines returns the coverage of all source lines which contain instructions sorted by file and line
+func (r *Report) Lines() []LineCoverage {
+	res := []LineCoverage{}
+
+	for loc, count := range r.lines {
+		res = append(res, LineCoverage{Location: loc, Executions: count})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].File != res[j].File {
+			return res[i].File < res[j].File
+		}
+
+		return res[i].Line < res[j].Line
+	})
+
+	return res
+}
+
+// Branches returns the outcomes of all source lines which contain conditional branches sorted by
+// file and line
+func (r *Report) Branches() []BranchCoverage {
+	res := []BranchCoverage{}
+
+	for loc, count := range r.branches {
+		res = append(res, BranchCoverage{Location: loc, BranchCount: *count})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].File != res[j].File {
+			return res[i].File < res[j].File
+		}
+
+		return res[i].Line < res[j].Line
+	})
+
+	return res
+}
+
+// Routines returns the coverage of all routines sorted by their location and name
+func (r *Report) Routines() []*Routine {
+	res := []*Routine{}
+
+	for _, j := range r.routines {
+		res = append(res, j)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.Location.File != b.Location.File {
+			return a.Location.File < b.Location.File
+		}
+
+		if a.Location.Line != b.Location.Line {
+			return a.Location.Line < b.Location.Line
+		}
+
+		return a.Name < b.Name
+	})
+
+	return res
+}
+
+// formatLineRanges joins consecutive line numbers to ranges, e.g. 3-5, 9
+func formatLineRanges(lines []int) string {
+	res := []string{}
+
+	for i := 0; i < len(lines); {
+		j := i
+		for (j+1 < len(lines)) && (lines[j+1] == lines[j]+1) {
+			j++
+		}
+
+		if i == j {
+			res = append(res, fmt.Sprint(lines[i]))
+		} else {
+			res = append(res, fmt.Sprintf("%d-%d", lines[i], lines[j]))
+		}
+
+		i = j + 1
+	}
+
+	return strings.Join(res, ", ")
+}
+
+// countDirections returns the number of outcomes of a branch which have occurred
+func countDirections(b BranchCount) int {
+	res := 0
+
+	if b.Taken != 0 {
+		res++
+	}
+
+	if b.NotTaken != 0 {
+		res++
+	}
+
+	return res
+}
+
+func percentage(part int, total int) float64 {
+	if total == 0 {
+		return 0.0
+	}
+
+	return 100.0 * float64(part) / float64(total)
+}
+
+// WriteSummary writes the percentage of covered lines, routines and branch directions followed by
+// the routines and lines which have not been executed and the branches which only go one way
+func (r *Report) WriteSummary(w io.Writer) error {
+	b := bufio.NewWriter(w)
+
+	lines := r.Lines()
+	uncoveredLines := map[string][]int{}
+	files := []string{}
+	numCovered := 0
+
+	for _, j := range lines {
+		if j.Executions != 0 {
+			numCovered++
+			continue
+		}
+
+		if _, ok := uncoveredLines[j.File]; !ok {
+			files = append(files, j.File)
+		}
+
+		uncoveredLines[j.File] = append(uncoveredLines[j.File], j.Line)
+	}
+
+	routines := r.Routines()
+	uncoveredRoutines := []*Routine{}
+
+	for _, j := range routines {
+		if j.Executions == 0 {
+			uncoveredRoutines = append(uncoveredRoutines, j)
+		}
+	}
+
+	fmt.Fprintf(b, "Lines covered:    %d of %d (%.2f%%)\n", numCovered, len(lines), percentage(numCovered, len(lines)))
+	fmt.Fprintf(b, "Routines covered: %d of %d (%.2f%%)\n", len(routines)-len(uncoveredRoutines), len(routines), percentage(len(routines)-len(uncoveredRoutines), len(routines)))
+
+	branches := r.Branches()
+	oneWay := []BranchCoverage{}
+	numDirections := 0
+
+	for _, j := range branches {
+		if j.OneWay() {
+			oneWay = append(oneWay, j)
+		}
+
+		numDirections += countDirections(j.BranchCount)
+	}
+
+	fmt.Fprintf(b, "Branches covered: %d of %d (%.2f%%)\n", numDirections, 2*len(branches), percentage(numDirections, 2*len(branches)))
+
+	if len(uncoveredRoutines) != 0 {
+		fmt.Fprintf(b, "\nRoutines which have not been executed\n")
+
+		for _, j := range uncoveredRoutines {
+			if j.Location.Line == 0 {
+				fmt.Fprintf(b, "    %s\n", j.Name)
+			} else {
+				fmt.Fprintf(b, "    %s (%s:%d)\n", j.Name, j.Location.File, j.Location.Line)
+			}
+		}
+	}
+
+	if len(files) != 0 {
+		fmt.Fprintf(b, "\nLines which have not been executed\n")
+
+		for _, j := range files {
+			fmt.Fprintf(b, "    %s: %s\n", j, formatLineRanges(uncoveredLines[j]))
+		}
+	}
+
+	if len(oneWay) != 0 {
+		fmt.Fprintf(b, "\nBranches which only go one way\n")
+
+		for _, j := range oneWay {
+			if j.Taken != 0 {
+				fmt.Fprintf(b, "    %s:%d: always taken (%d times)\n", j.File, j.Line, j.Taken)
+			} else {
+				fmt.Fprintf(b, "    %s:%d: never taken (%d times)\n", j.File, j.Line, j.NotTaken)
+			}
+		}
+	}
+
+	return b.Flush()
+}
+
+// WriteLcov writes the coverage in the tracefile format of lcov, which is read by genhtml and many
+// editors. Routines whose location is not known are left out.
+func (r *Report) WriteLcov(w io.Writer, testName string) error {
+	b := bufio.NewWriter(w)
+
+	files := map[string][]LineCoverage{}
+	names := []string{}
+
+	for _, j := range r.Lines() {
+		if _, ok := files[j.File]; !ok {
+			names = append(names, j.File)
+		}
+
+		files[j.File] = append(files[j.File], j)
+	}
+
+	branches := map[string][]BranchCoverage{}
+
+	for _, j := range r.Branches() {
+		branches[j.File] = append(branches[j.File], j)
+	}
+
+	routines := map[string][]*Routine{}
+
+	for _, j := range r.Routines() {
+		if j.Location.Line == 0 {
+			continue
+		}
+
+		if _, ok := files[j.Location.File]; !ok {
+			files[j.Location.File] = []LineCoverage{}
+			names = append(names, j.Location.File)
+		}
+
+		routines[j.Location.File] = append(routines[j.Location.File], j)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(b, "TN:%s\n", testName)
+		fmt.Fprintf(b, "SF:%s\n", name)
+
+		hit := 0
+		for _, j := range routines[name] {
+			fmt.Fprintf(b, "FN:%d,%s\n", j.Location.Line, j.Name)
+		}
+
+		for _, j := range routines[name] {
+			fmt.Fprintf(b, "FNDA:%d,%s\n", j.Executions, j.Name)
+
+			if j.Executions != 0 {
+				hit++
+			}
+		}
+
+		fmt.Fprintf(b, "FNF:%d\n", len(routines[name]))
+		fmt.Fprintf(b, "FNH:%d\n", hit)
+
+		// Block 0 of each line is the branch, branch 0 is taken and branch 1 not taken. Outcomes of
+		// branches which have not been executed are written as -.
+		hit = 0
+		for _, j := range branches[name] {
+			if j.Executed() {
+				fmt.Fprintf(b, "BRDA:%d,0,0,%d\nBRDA:%d,0,1,%d\n", j.Line, j.Taken, j.Line, j.NotTaken)
+			} else {
+				fmt.Fprintf(b, "BRDA:%d,0,0,-\nBRDA:%d,0,1,-\n", j.Line, j.Line)
+			}
+
+			hit += countDirections(j.BranchCount)
+		}
+
+		fmt.Fprintf(b, "BRF:%d\n", 2*len(branches[name]))
+		fmt.Fprintf(b, "BRH:%d\n", hit)
+
+		hit = 0
+		for _, j := range files[name] {
+			fmt.Fprintf(b, "DA:%d,%d\n", j.Line, j.Executions)
+
+			if j.Executions != 0 {
+				hit++
+			}
+		}
+
+		fmt.Fprintf(b, "LF:%d\n", len(files[name]))
+		fmt.Fprintf(b, "LH:%d\n", hit)
+		fmt.Fprintln(b, "end_of_record")
+	}
+
+	return b.Flush()
+}
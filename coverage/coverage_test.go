@@ -0,0 +1,209 @@
+package coverage
+
+import (
+	"6502profiler/cpu"
+	"6502profiler/srcmap"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSource = `; library under test
+double
+    asl         ; times two
+    bcs overflow
+    rts
+overflow lda #$ff
+    rts
+unused
+    lda #0
+    rts
+msg !byte 1, 2, 3
+`
+
+// newTestMap assigns the addresses of the program assembled from testSource at $0800 to its lines
+func newTestMap(t *testing.T) (*srcmap.Map, string) {
+	t.Helper()
+
+	fileName := filepath.Join(t.TempDir(), "lib.a")
+	if err := os.WriteFile(fileName, []byte(testSource), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := srcmap.New()
+	m.Add(0x0800, 1, srcmap.Location{File: fileName, Line: 3})
+	m.Add(0x0801, 2, srcmap.Location{File: fileName, Line: 4})
+	m.Add(0x0803, 1, srcmap.Location{File: fileName, Line: 5})
+	m.Add(0x0804, 2, srcmap.Location{File: fileName, Line: 6})
+	m.Add(0x0806, 1, srcmap.Location{File: fileName, Line: 7})
+	m.Add(0x0807, 2, srcmap.Location{File: fileName, Line: 9})
+	m.Add(0x0809, 1, srcmap.Location{File: fileName, Line: 10})
+	m.Add(0x080A, 3, srcmap.Location{File: fileName, Line: 11})
+
+	return m, fileName
+}
+
+var testLabels = map[uint16][]string{
+	0x0800: {"double"},
+	0x0804: {"overflow"},
+	0x0807: {"unused"},
+	0x080A: {"msg"},
+}
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder()
+	r.Trace(nil, &cpu.TraceRecord{PC: 0x0800})
+	r.Trace(nil, &cpu.TraceRecord{PC: 0x0800})
+	r.Trace(nil, &cpu.TraceRecord{PC: 0x0801, Branch: cpu.BranchTaken})
+	r.Trace(nil, &cpu.TraceRecord{PC: 0x0801, Branch: cpu.BranchNotTaken})
+	r.Trace(nil, &cpu.TraceRecord{PC: 0x0801, Branch: cpu.BranchNotTaken})
+
+	if (len(r.Counts()) != 2) || (r.Counts()[0x0800] != 2) || (r.Counts()[0x0801] != 3) {
+		t.Fatalf("unexpected counts %v", r.Counts())
+	}
+
+	if (len(r.Branches()) != 1) || (r.Branches()[0x0801] != BranchCount{Taken: 1, NotTaken: 2}) {
+		t.Fatalf("unexpected branches %v", r.Branches())
+	}
+
+	r.Reset()
+	if (len(r.Counts()) != 0) || (len(r.Branches()) != 0) {
+		t.Fatalf("counts not reset: %v %v", r.Counts(), r.Branches())
+	}
+}
+
+func TestReport(t *testing.T) {
+	m, fileName := newTestMap(t)
+	r := NewReport(cpu.Model6502, nil)
+
+	// The first program does not overflow, the second one does. unused is never called.
+	r.Add(m, testLabels, 0x0800, 0x080C, map[uint32]uint64{0x0800: 1, 0x0801: 1, 0x0803: 1}, map[uint32]BranchCount{0x0801: {NotTaken: 1}})
+	r.Add(m, testLabels, 0x0800, 0x080C, map[uint32]uint64{0x0800: 1, 0x0801: 1, 0x0804: 1, 0x0806: 1}, map[uint32]BranchCount{0x0801: {Taken: 1}})
+
+	expectedLines := map[int]uint64{3: 2, 4: 2, 5: 1, 6: 1, 7: 1, 9: 0, 10: 0}
+	lines := r.Lines()
+
+	if len(lines) != len(expectedLines) {
+		t.Fatalf("expected %d lines, got %v", len(expectedLines), lines)
+	}
+
+	for _, j := range lines {
+		if count, ok := expectedLines[j.Line]; !ok || (count != j.Executions) || (j.File != fileName) {
+			t.Fatalf("unexpected coverage of line %d: %d", j.Line, j.Executions)
+		}
+	}
+
+	routines := r.Routines()
+	if len(routines) != 3 {
+		t.Fatalf("expected 3 routines, got %d", len(routines))
+	}
+
+	expectedRoutines := []Routine{
+		{Name: "double", Location: srcmap.Location{File: fileName, Line: 3}, Executions: 5},
+		{Name: "overflow", Location: srcmap.Location{File: fileName, Line: 6}, Executions: 2},
+		{Name: "unused", Location: srcmap.Location{File: fileName, Line: 9}, Executions: 0},
+	}
+
+	for i, j := range expectedRoutines {
+		if *routines[i] != j {
+			t.Fatalf("expected %v, got %v", j, *routines[i])
+		}
+	}
+
+	summary := &bytes.Buffer{}
+	if err := r.WriteSummary(summary); err != nil {
+		t.Fatal(err)
+	}
+
+	if branches := r.Branches(); (len(branches) != 1) || (branches[0].Line != 4) || (branches[0].BranchCount != BranchCount{Taken: 1, NotTaken: 1}) {
+		t.Fatalf("unexpected branches %v", branches)
+	}
+
+	for _, j := range []string{"Lines covered:    5 of 7", "Routines covered: 2 of 3", "Branches covered: 2 of 2", "unused (" + fileName + ":9)", fileName + ": 9-10\n"} {
+		if !strings.Contains(summary.String(), j) {
+			t.Fatalf("'%s' not found in summary:\n%s", j, summary.String())
+		}
+	}
+
+	lcov := &bytes.Buffer{}
+	if err := r.WriteLcov(lcov, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, j := range []string{"TN:test\nSF:" + fileName + "\n", "FN:6,overflow\n", "FNDA:0,unused\n", "FNF:3\nFNH:2\n", "BRDA:4,0,0,1\nBRDA:4,0,1,1\nBRF:2\nBRH:2\n", "DA:4,2\n", "DA:10,0\n", "LF:7\nLH:5\nend_of_record\n"} {
+		if !strings.Contains(lcov.String(), j) {
+			t.Fatalf("'%s' not found in tracefile:\n%s", j, lcov.String())
+		}
+	}
+}
+
+func TestOneWayBranches(t *testing.T) {
+	m, fileName := newTestMap(t)
+	r := NewReport(cpu.Model6502, nil)
+
+	r.Add(m, testLabels, 0x0800, 0x080C, map[uint32]uint64{0x0800: 2, 0x0801: 2, 0x0803: 2}, map[uint32]BranchCount{0x0801: {NotTaken: 2}})
+
+	branches := r.Branches()
+	if (len(branches) != 1) || !branches[0].OneWay() || (branches[0].NotTaken != 2) {
+		t.Fatalf("unexpected branches %v", branches)
+	}
+
+	summary := &bytes.Buffer{}
+	if err := r.WriteSummary(summary); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, j := range []string{"Branches covered: 1 of 2", "Branches which only go one way\n    " + fileName + ":4: never taken (2 times)\n"} {
+		if !strings.Contains(summary.String(), j) {
+			t.Fatalf("'%s' not found in summary:\n%s", j, summary.String())
+		}
+	}
+
+	// Branches which have not been executed are found through their mnemonic
+	r = NewReport(cpu.Model6502, nil)
+	r.Add(m, testLabels, 0x0800, 0x080C, map[uint32]uint64{}, nil)
+
+	lcov := &bytes.Buffer{}
+	if err := r.WriteLcov(lcov, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(lcov.String(), "BRDA:4,0,0,-\nBRDA:4,0,1,-\nBRF:2\nBRH:0\n") {
+		t.Fatalf("branch which has not been executed is missing:\n%s", lcov.String())
+	}
+}
+
+func TestReportFilter(t *testing.T) {
+	m, _ := newTestMap(t)
+	r := NewReport(cpu.Model6502, func(file string) bool { return false })
+
+	r.Add(m, testLabels, 0x0800, 0x080C, map[uint32]uint64{0x0800: 1}, nil)
+
+	if (len(r.Lines()) != 0) || (len(r.Routines()) != 0) || (len(r.Branches()) != 0) {
+		t.Fatalf("excluded file has been reported")
+	}
+}
+
+func TestReportWithoutSource(t *testing.T) {
+	r := NewReport(cpu.Model6502, nil)
+
+	// Without source lines data can not be told apart from code
+	r.Add(nil, testLabels, 0x0800, 0x0808, map[uint32]uint64{0x0800: 1, 0x0801: 1}, map[uint32]BranchCount{0x0801: {NotTaken: 1}})
+
+	routines := r.Routines()
+	if (len(routines) != 3) || (len(r.Lines()) != 0) || (len(r.Branches()) != 0) {
+		t.Fatalf("expected 3 routines and no lines or branches, got %d, %d and %d", len(routines), len(r.Lines()), len(r.Branches()))
+	}
+
+	if (routines[0].Name != "double") || (routines[0].Executions != 2) || (routines[2].Name != "unused") || (routines[2].Executions != 0) {
+		t.Fatalf("unexpected routines %v %v %v", *routines[0], *routines[1], *routines[2])
+	}
+}
+
+func TestFormatLineRanges(t *testing.T) {
+	if res := formatLineRanges([]int{1, 3, 4, 5, 9, 10}); res != "1, 3-5, 9-10" {
+		t.Fatalf("unexpected ranges '%s'", res)
+	}
+}
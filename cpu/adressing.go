@@ -1,5 +1,7 @@
 package cpu
 
+import "6502profiler/memory"
+
 func (c *CPU6502) pageCrossCycles(addr1, addr2 uint16) uint64 {
 	var additionalCycles uint64 = 0
 	if (addr1 & 0xFF00) != (addr2 & 0xFF00) {
@@ -9,77 +11,203 @@ func (c *CPU6502) pageCrossCycles(addr1, addr2 uint16) uint64 {
 	return additionalCycles
 }
 
+// -------- Dummy bus cycles --------
+
+// dummyIndexRead performs the additional read which happens while an index is added to a base
+// address. The NMOS 6502 reads from the address before the carry has been added to the high byte. The
+// 65C02 instead rereads the last byte of the instruction. If always is false the read only happens
+// when a page boundary is crossed, which is the case for instructions which only read their operand.
+func (c *CPU6502) dummyIndexRead(base uint16, addr uint16, always bool) {
+	if !c.busAccurate {
+		return
+	}
+
+	if !always && ((base & 0xFF00) == (addr & 0xFF00)) {
+		return
+	}
+
+	if c.model.IsCmos() {
+		c.dummyRead(c.PC, memory.AccessOperandFetch)
+		return
+	}
+
+	c.dummyRead((base&0xFF00)|(addr&0x00FF), memory.AccessDataRead)
+}
+
+// dummyZeroPageRead performs the read of the unindexed zero page address
+func (c *CPU6502) dummyZeroPageRead(zpAddr uint8) {
+	if c.busAccurate {
+		c.dummyRead(uint16(zpAddr), memory.AccessDataRead)
+	}
+}
+
+// storeModified writes the result of a read-modify-write instruction. The NMOS 6502 writes the
+// unmodified value before the result, the 65C02 reads the operand a second time instead.
+func (c *CPU6502) storeModified(addr uint16, oldVal uint8, newVal uint8) {
+	if c.busAccurate {
+		if c.model.IsCmos() {
+			c.dummyRead(addr, memory.AccessDataRead)
+		} else {
+			c.Mem.Store(addr, oldVal)
+		}
+	}
+
+	c.Mem.Store(addr, newVal)
+}
+
+// dummyRead performs a read whose value is discarded. The read is not added to the trace record of
+// the instruction, where it could be mistaken for an instruction byte or for the effective address.
+func (c *CPU6502) dummyRead(addr uint16, kind memory.AccessKind) {
+	m := c.Mem
+	if (c.tracer.mem != nil) && (m == memory.Memory(c.tracer.mem)) {
+		m = c.tracer.mem.Memory
+	}
+
+	_ = m.LoadAs(addr, kind)
+}
+
+// dummyInstructionRead reads the byte following the opcode of an instruction which consists of
+// only one byte. The PC is not changed.
+func (c *CPU6502) dummyInstructionRead() {
+	if c.busAccurate {
+		c.dummyRead(c.PC, memory.AccessOperandFetch)
+	}
+}
+
+// dummyStackRead reads the top of the stack while the stack pointer is incremented by a pull or
+// while JSR stores the low byte of the target address
+func (c *CPU6502) dummyStackRead() {
+	if c.busAccurate {
+		c.dummyRead(0x100+uint16(c.SP), memory.AccessDataRead)
+	}
+}
+
+// dummyBranchReads performs the reads of a taken branch while the target address is calculated.
+// next is the address of the instruction following the branch. The first read fetches this
+// instruction. If a page boundary is crossed the NMOS 6502 reads from the target address before the
+// carry has been added to the high byte, the 65C02 rereads the next instruction.
+func (c *CPU6502) dummyBranchReads(next uint16, target uint16) {
+	if !c.busAccurate {
+		return
+	}
+
+	c.dummyRead(next, memory.AccessOperandFetch)
+
+	if (next & 0xFF00) == (target & 0xFF00) {
+		return
+	}
+
+	if c.model.IsCmos() {
+		c.dummyRead(next, memory.AccessOperandFetch)
+		return
+	}
+
+	c.dummyRead((next&0xFF00)|(target&0x00FF), memory.AccessDataRead)
+}
+
 // -------- Addressing modes --------
 
 func (c *CPU6502) getAddrAbsolute() uint16 {
-	loByte := c.Mem.Load(c.PC)
+	loByte := c.fetchOperand()
 	c.PC++
-	var addr uint16 = uint16(c.Mem.Load(c.PC))*256 + uint16(loByte)
+	var addr uint16 = uint16(c.fetchOperand())*256 + uint16(loByte)
 
 	return addr
 }
 
 func (c *CPU6502) getAddrZeroPage() uint16 {
-	loByte := c.Mem.Load(c.PC)
+	loByte := c.fetchOperand()
 
 	return uint16(loByte)
 }
 
 func (c *CPU6502) getAddrAbsoluteY() (uint16, uint64) {
-	loByte := c.Mem.Load(c.PC)
+	loByte := c.fetchOperand()
 	c.PC++
-	var addr uint16 = uint16(c.Mem.Load(c.PC))*256 + uint16(loByte)
+	var addr uint16 = uint16(c.fetchOperand())*256 + uint16(loByte)
 	var res = addr + uint16(c.Y)
+	c.dummyIndexRead(addr, res, false)
 
 	return res, c.pageCrossCycles(addr, res)
 }
 
+// getAddrAbsoluteYWrite is used by instructions which write to their operand. These always perform
+// the dummy read.
+func (c *CPU6502) getAddrAbsoluteYWrite() uint16 {
+	loByte := c.fetchOperand()
+	c.PC++
+	var addr uint16 = uint16(c.fetchOperand())*256 + uint16(loByte)
+	var res = addr + uint16(c.Y)
+	c.dummyIndexRead(addr, res, true)
+
+	return res
+}
+
 func (c *CPU6502) getAddrAbsoluteX() (uint16, uint64) {
-	loByte := c.Mem.Load(c.PC)
+	loByte := c.fetchOperand()
 	c.PC++
-	var addr uint16 = uint16(c.Mem.Load(c.PC))*256 + uint16(loByte)
+	var addr uint16 = uint16(c.fetchOperand())*256 + uint16(loByte)
 	res := addr + uint16(c.X)
+	c.dummyIndexRead(addr, res, false)
 
 	return res, c.pageCrossCycles(res, addr)
 }
 
+// getAddrAbsoluteXWrite is used by instructions which write to their operand. These always perform
+// the dummy read.
+func (c *CPU6502) getAddrAbsoluteXWrite() uint16 {
+	loByte := c.fetchOperand()
+	c.PC++
+	var addr uint16 = uint16(c.fetchOperand())*256 + uint16(loByte)
+	res := addr + uint16(c.X)
+	c.dummyIndexRead(addr, res, true)
+
+	return res
+}
+
 func (c *CPU6502) getAddrZeroPageY() uint16 {
-	loByte := c.Mem.Load(c.PC)
+	loByte := c.fetchOperand()
+	c.dummyZeroPageRead(loByte)
 	var zpAddr uint8 = loByte + c.Y // Allow possible overflow
 
 	return uint16(zpAddr)
 }
 
 func (c *CPU6502) getAddrZeroPageX() uint16 {
-	loByte := c.Mem.Load(c.PC)
+	loByte := c.fetchOperand()
+	c.dummyZeroPageRead(loByte)
 	var zpAddr uint8 = loByte + c.X // Allow possible overflow
 
 	return uint16(zpAddr)
 }
 
 func (c *CPU6502) getAddrIndirect() uint16 {
-	loByte := c.Mem.Load(c.PC)
+	loByte := c.fetchOperand()
 	c.PC++
-	var addr uint16 = uint16(c.Mem.Load(c.PC))*256 + uint16(loByte)
+	var addr uint16 = uint16(c.fetchOperand())*256 + uint16(loByte)
+
+	ptrLo := c.Mem.Load(addr)
 
-	return uint16(c.Mem.Load(addr+1))*256 + uint16(c.Mem.Load(addr))
+	return uint16(c.Mem.Load(addr+1))*256 + uint16(ptrLo)
 }
 
 // This was a bug of the original 6502 JMP(addr) implementation. When the address of an
 // indirect JMP is the last byte on a page, i.e. 0xXYFF then the second byte is taken
 // from 0xXY00.
 func (c *CPU6502) getAddrIndirectJmp6502() uint16 {
-	loByte := c.Mem.Load(c.PC)
+	loByte := c.fetchOperand()
 	c.PC++
-	var addr uint16 = uint16(c.Mem.Load(c.PC))*256 + uint16(loByte)
+	var addr uint16 = uint16(c.fetchOperand())*256 + uint16(loByte)
 	loByte++
-	var addr2 uint16 = uint16(c.Mem.Load(c.PC))*256 + uint16(loByte)
+	var addr2 uint16 = uint16(c.fetchOperand())*256 + uint16(loByte)
 
-	return uint16(c.Mem.Load(addr2))*256 + uint16(c.Mem.Load(addr))
+	ptrLo := c.Mem.Load(addr)
+
+	return uint16(c.Mem.Load(addr2))*256 + uint16(ptrLo)
 }
 
 func (c *CPU6502) getAddrRelative() (uint16, uint64) {
-	offset := int16(int8(c.Mem.Load(c.PC)))
+	offset := int16(int8(c.fetchOperand()))
 
 	// Offsets are calculated relative to the byte following the instruction.
 	// That's the reason for the plus one
@@ -89,45 +217,72 @@ func (c *CPU6502) getAddrRelative() (uint16, uint64) {
 }
 
 func (c *CPU6502) getAddrIndirectIdxY() (uint16, uint64) {
-	zpAddrLo := c.Mem.Load(c.PC)
+	zpAddrLo := c.fetchOperand()
 	zpAddrHi := zpAddrLo + 1 // Overflow is allowed
 
-	var addr uint16 = uint16(c.Mem.Load(uint16(zpAddrHi)))*256 + uint16(c.Mem.Load(uint16(zpAddrLo)))
+	ptrLo := c.Mem.Load(uint16(zpAddrLo))
+	var addr uint16 = uint16(c.Mem.Load(uint16(zpAddrHi)))*256 + uint16(ptrLo)
 	var res = addr + uint16(c.Y)
+	c.dummyIndexRead(addr, res, false)
 
 	return res, c.pageCrossCycles(addr, res)
 }
 
+// getAddrIndirectIdxYWrite is used by instructions which write to their operand. These always
+// perform the dummy read.
+func (c *CPU6502) getAddrIndirectIdxYWrite() uint16 {
+	zpAddrLo := c.fetchOperand()
+	zpAddrHi := zpAddrLo + 1 // Overflow is allowed
+
+	ptrLo := c.Mem.Load(uint16(zpAddrLo))
+	var addr uint16 = uint16(c.Mem.Load(uint16(zpAddrHi)))*256 + uint16(ptrLo)
+	var res = addr + uint16(c.Y)
+	c.dummyIndexRead(addr, res, true)
+
+	return res
+}
+
 func (c *CPU6502) getAddrIdxIndirectX() uint16 {
-	zpAddrLo := c.Mem.Load(c.PC) + c.X // Overflow is allowed
-	zpAddrHi := zpAddrLo + 1           // Overflow is allowed
+	zpBase := c.fetchOperand()
+	c.dummyZeroPageRead(zpBase)
+	zpAddrLo := zpBase + c.X // Overflow is allowed
+	zpAddrHi := zpAddrLo + 1 // Overflow is allowed
+
+	ptrLo := c.Mem.Load(uint16(zpAddrLo))
 
-	return uint16(c.Mem.Load(uint16(zpAddrHi)))*256 + uint16(c.Mem.Load(uint16(zpAddrLo)))
+	return uint16(c.Mem.Load(uint16(zpAddrHi)))*256 + uint16(ptrLo)
 }
 
 func (c *CPU6502) getAddrZp65C02() uint16 {
-	zpAddrLo := c.Mem.Load(c.PC)
+	zpAddrLo := c.fetchOperand()
 	zpAddrHi := zpAddrLo + 1 // Overflow is allowed
 
-	var addr uint16 = uint16(c.Mem.Load(uint16(zpAddrHi)))*256 + uint16(c.Mem.Load(uint16(zpAddrLo)))
+	ptrLo := c.Mem.Load(uint16(zpAddrLo))
+	var addr uint16 = uint16(c.Mem.Load(uint16(zpAddrHi)))*256 + uint16(ptrLo)
 
 	return addr
 }
 
 func (c *CPU6502) getAddrIdxIndirect65C02() uint16 {
-	baseAddrLo := c.Mem.Load(c.PC)
+	baseAddrLo := c.fetchOperand()
 	c.PC++
 
-	baseAddr := uint16(c.Mem.Load(c.PC))*256 + uint16(baseAddrLo)
+	baseAddr := uint16(c.fetchOperand())*256 + uint16(baseAddrLo)
 	baseAddr += uint16(c.X)
 
-	return uint16(c.Mem.Load(baseAddr+1))*256 + uint16(c.Mem.Load(baseAddr))
+	ptrLo := c.Mem.Load(baseAddr)
+
+	return uint16(c.Mem.Load(baseAddr+1))*256 + uint16(ptrLo)
 }
 
-func (c *CPU6502) getAddressesBitBranchRelative() (uint16, uint16, uint64) {
+// getAddressesBitBranchRelative returns the zero page address tested by BBR and BBS, its value and
+// the branch target. The zero page location is read before the offset is fetched.
+func (c *CPU6502) getAddressesBitBranchRelative() (uint16, uint8, uint16, uint64) {
 	zpAddr := c.getAddrZeroPage()
 	c.PC++
+	value := c.Mem.Load(zpAddr)
+	c.dummyZeroPageRead(uint8(zpAddr))
 	branchAddress, additionalCycle := c.getAddrRelative()
 
-	return zpAddr, branchAddress, additionalCycle
+	return zpAddr, value, branchAddress, additionalCycle
 }
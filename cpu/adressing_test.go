@@ -160,7 +160,7 @@ func TestGetAddrBitBranchRelativePositive(t *testing.T) {
 	cpu.CopyToMem([]byte{0xa9, 0xfe, 0x85, 0x40, 0x0f, 0x40, 0x01, 0x00, 0x00}, 0x0000)
 	cpu.PC = 0x0005
 
-	zpAddr, branchAddress, _ := cpu.getAddressesBitBranchRelative()
+	zpAddr, _, branchAddress, _ := cpu.getAddressesBitBranchRelative()
 
 	if branchAddress != 0x0008 {
 		t.Fatal("Relative bit branch addressing for a positive	offset does not work. Branch address wrong")
@@ -177,7 +177,7 @@ func TestGetAddrBitBranchRelativeNegative(t *testing.T) {
 	cpu.CopyToMem([]byte{0x00, 0xa9, 0xfe, 0x85, 0x40, 0x0f, 0x40, 0xf8, 0x00}, 0x0000)
 	cpu.PC = 0x0006
 
-	zpAddr, branchAddress, _ := cpu.getAddressesBitBranchRelative()
+	zpAddr, _, branchAddress, _ := cpu.getAddressesBitBranchRelative()
 
 	if branchAddress != 0x0000 {
 		t.Fatal("Relative bit branch addressing for a negative	offset does not work. Branch address wrong")
@@ -282,3 +282,350 @@ func TestGetAddrZp65C02(t *testing.T) {
 		t.Fatal("65C02 Zero-page addressing does not work")
 	}
 }
+
+// -------- Bus accurate mode --------
+
+type busAccess struct {
+	write bool
+	addr  uint16
+	val   uint8
+}
+
+// busRecorder records all accesses made through the 16 bit interface of the memory
+type busRecorder struct {
+	*memory.LinearMemory
+	accesses []busAccess
+}
+
+func (b *busRecorder) Load(address uint16) uint8 {
+	res := b.LinearMemory.Load(address)
+	b.accesses = append(b.accesses, busAccess{false, address, res})
+
+	return res
+}
+
+func (b *busRecorder) LoadAs(address uint16, kind memory.AccessKind) uint8 {
+	res := b.LinearMemory.LoadAs(address, kind)
+	b.accesses = append(b.accesses, busAccess{false, address, res})
+
+	return res
+}
+
+func (b *busRecorder) Store(address uint16, val uint8) {
+	b.accesses = append(b.accesses, busAccess{true, address, val})
+	b.LinearMemory.Store(address, val)
+}
+
+// checkBusAccesses executes the instruction at $0800 and compares the bus accesses with the expected ones
+func checkBusAccesses(t *testing.T, model CpuModel, prog []byte, prepare func(c *CPU6502), expected []busAccess) {
+	cpu := New6502(model)
+	rec := &busRecorder{LinearMemory: memory.NewLinearMemory(65536)}
+	cpu.Init(rec)
+	cpu.SetBusAccurate(true)
+	cpu.CopyToMem(prog, 0x0800)
+	prepare(cpu)
+	rec.accesses = nil
+
+	cpu.PC = 0x0800
+	cpu.executeInstruction()
+
+	if len(rec.accesses) != len(expected) {
+		t.Fatalf("Wrong number of bus accesses. Expected %v, got %v", expected, rec.accesses)
+	}
+
+	for i, j := range expected {
+		if rec.accesses[i] != j {
+			t.Fatalf("Wrong bus access %d. Expected %v, got %v", i, expected, rec.accesses)
+		}
+	}
+}
+
+func rd(addr uint16, val uint8) busAccess {
+	return busAccess{false, addr, val}
+}
+
+func wr(addr uint16, val uint8) busAccess {
+	return busAccess{true, addr, val}
+}
+
+func TestBusRmwAbsoluteNmos(t *testing.T) {
+	// inc $2000
+	checkBusAccesses(t, Model6502, []byte{0xEE, 0x00, 0x20}, func(c *CPU6502) {
+		c.Mem.Store(0x2000, 0x41)
+	}, []busAccess{rd(0x0800, 0xEE), rd(0x0801, 0x00), rd(0x0802, 0x20), rd(0x2000, 0x41), wr(0x2000, 0x41), wr(0x2000, 0x42)})
+}
+
+func TestBusRmwAbsoluteCmos(t *testing.T) {
+	// inc $2000
+	checkBusAccesses(t, Model65C02, []byte{0xEE, 0x00, 0x20}, func(c *CPU6502) {
+		c.Mem.Store(0x2000, 0x41)
+	}, []busAccess{rd(0x0800, 0xEE), rd(0x0801, 0x00), rd(0x0802, 0x20), rd(0x2000, 0x41), rd(0x2000, 0x41), wr(0x2000, 0x42)})
+}
+
+func TestBusRmwZeroPageX(t *testing.T) {
+	// asl $10,x
+	checkBusAccesses(t, Model6502, []byte{0x16, 0x10}, func(c *CPU6502) {
+		c.X = 0x02
+		c.Mem.Store(0x0012, 0x01)
+	}, []busAccess{rd(0x0800, 0x16), rd(0x0801, 0x10), rd(0x0010, 0x00), rd(0x0012, 0x01), wr(0x0012, 0x01), wr(0x0012, 0x02)})
+}
+
+func TestBusRmwAbsoluteX(t *testing.T) {
+	// dec $2000,x
+	checkBusAccesses(t, Model6502, []byte{0xDE, 0x00, 0x20}, func(c *CPU6502) {
+		c.X = 0x05
+		c.Mem.Store(0x2005, 0x10)
+	}, []busAccess{rd(0x0800, 0xDE), rd(0x0801, 0x00), rd(0x0802, 0x20), rd(0x2005, 0x10), rd(0x2005, 0x10), wr(0x2005, 0x10), wr(0x2005, 0x0F)})
+}
+
+func TestBusReadAbsoluteXNoPageCross(t *testing.T) {
+	// lda $2000,x
+	checkBusAccesses(t, Model6502, []byte{0xBD, 0x00, 0x20}, func(c *CPU6502) {
+		c.X = 0x05
+	}, []busAccess{rd(0x0800, 0xBD), rd(0x0801, 0x00), rd(0x0802, 0x20), rd(0x2005, 0x00)})
+}
+
+func TestBusReadAbsoluteXPageCrossNmos(t *testing.T) {
+	// lda $20ff,x
+	checkBusAccesses(t, Model6502, []byte{0xBD, 0xFF, 0x20}, func(c *CPU6502) {
+		c.X = 0x02
+		c.Mem.Store(0x2001, 0x11)
+		c.Mem.Store(0x2101, 0x22)
+	}, []busAccess{rd(0x0800, 0xBD), rd(0x0801, 0xFF), rd(0x0802, 0x20), rd(0x2001, 0x11), rd(0x2101, 0x22)})
+}
+
+func TestBusReadAbsoluteXPageCrossCmos(t *testing.T) {
+	// lda $20ff,x
+	checkBusAccesses(t, Model65C02, []byte{0xBD, 0xFF, 0x20}, func(c *CPU6502) {
+		c.X = 0x02
+		c.Mem.Store(0x2101, 0x22)
+	}, []busAccess{rd(0x0800, 0xBD), rd(0x0801, 0xFF), rd(0x0802, 0x20), rd(0x0802, 0x20), rd(0x2101, 0x22)})
+}
+
+func TestBusReadAbsoluteYPageCross(t *testing.T) {
+	// ldx $20ff,y
+	checkBusAccesses(t, Model6502, []byte{0xBE, 0xFF, 0x20}, func(c *CPU6502) {
+		c.Y = 0x01
+	}, []busAccess{rd(0x0800, 0xBE), rd(0x0801, 0xFF), rd(0x0802, 0x20), rd(0x2000, 0x00), rd(0x2100, 0x00)})
+}
+
+func TestBusWriteAbsoluteY(t *testing.T) {
+	// sta $2000,y
+	checkBusAccesses(t, Model6502, []byte{0x99, 0x00, 0x20}, func(c *CPU6502) {
+		c.Y = 0x01
+		c.A = 0x33
+	}, []busAccess{rd(0x0800, 0x99), rd(0x0801, 0x00), rd(0x0802, 0x20), rd(0x2001, 0x00), wr(0x2001, 0x33)})
+}
+
+func TestBusReadIndirectIdxYPageCross(t *testing.T) {
+	// lda ($10),y
+	checkBusAccesses(t, Model6502, []byte{0xB1, 0x10}, func(c *CPU6502) {
+		c.Y = 0x10
+		c.Mem.Store(0x0010, 0xF8)
+		c.Mem.Store(0x0011, 0x20)
+	}, []busAccess{rd(0x0800, 0xB1), rd(0x0801, 0x10), rd(0x0010, 0xF8), rd(0x0011, 0x20), rd(0x2008, 0x00), rd(0x2108, 0x00)})
+}
+
+func TestBusWriteIndirectIdxY(t *testing.T) {
+	// sta ($10),y
+	checkBusAccesses(t, Model6502, []byte{0x91, 0x10}, func(c *CPU6502) {
+		c.Y = 0x01
+		c.A = 0x44
+		c.Mem.Store(0x0010, 0x00)
+		c.Mem.Store(0x0011, 0x20)
+	}, []busAccess{rd(0x0800, 0x91), rd(0x0801, 0x10), rd(0x0010, 0x00), rd(0x0011, 0x20), rd(0x2001, 0x00), wr(0x2001, 0x44)})
+}
+
+func TestBusReadZeroPageY(t *testing.T) {
+	// ldx $10,y
+	checkBusAccesses(t, Model6502, []byte{0xB6, 0x10}, func(c *CPU6502) {
+		c.Y = 0x01
+	}, []busAccess{rd(0x0800, 0xB6), rd(0x0801, 0x10), rd(0x0010, 0x00), rd(0x0011, 0x00)})
+}
+
+func TestBusReadIdxIndirectX(t *testing.T) {
+	// lda ($10,x)
+	checkBusAccesses(t, Model6502, []byte{0xA1, 0x10}, func(c *CPU6502) {
+		c.X = 0x02
+		c.Mem.Store(0x0012, 0x00)
+		c.Mem.Store(0x0013, 0x20)
+	}, []busAccess{rd(0x0800, 0xA1), rd(0x0801, 0x10), rd(0x0010, 0x00), rd(0x0012, 0x00), rd(0x0013, 0x20), rd(0x2000, 0x00)})
+}
+
+func TestBusImplied(t *testing.T) {
+	// inx
+	checkBusAccesses(t, Model6502, []byte{0xE8, 0x55}, func(c *CPU6502) {}, []busAccess{rd(0x0800, 0xE8), rd(0x0801, 0x55)})
+}
+
+func TestBusPush(t *testing.T) {
+	// pha
+	checkBusAccesses(t, Model6502, []byte{0x48}, func(c *CPU6502) {
+		c.SP = 0xFD
+		c.A = 0x33
+	}, []busAccess{rd(0x0800, 0x48), rd(0x0801, 0x00), wr(0x01FD, 0x33)})
+}
+
+func TestBusPull(t *testing.T) {
+	// ply
+	checkBusAccesses(t, Model65C02, []byte{0x7A}, func(c *CPU6502) {
+		c.SP = 0xFC
+		c.Mem.Store(0x01FD, 0x42)
+	}, []busAccess{rd(0x0800, 0x7A), rd(0x0801, 0x00), rd(0x01FC, 0x00), rd(0x01FD, 0x42)})
+}
+
+func TestBusJsr(t *testing.T) {
+	// jsr $2010
+	checkBusAccesses(t, Model6502, []byte{0x20, 0x10, 0x20}, func(c *CPU6502) {
+		c.SP = 0xFF
+	}, []busAccess{rd(0x0800, 0x20), rd(0x0801, 0x10), rd(0x01FF, 0x00), wr(0x01FF, 0x08), wr(0x01FE, 0x02), rd(0x0802, 0x20)})
+}
+
+func TestBusRts(t *testing.T) {
+	// rts
+	checkBusAccesses(t, Model6502, []byte{0x60}, func(c *CPU6502) {
+		c.SP = 0xFD
+		c.Mem.Store(0x01FE, 0x02)
+		c.Mem.Store(0x01FF, 0x09)
+		c.Mem.Store(0x0902, 0x12)
+	}, []busAccess{rd(0x0800, 0x60), rd(0x0801, 0x00), rd(0x01FD, 0x00), rd(0x01FE, 0x02), rd(0x01FF, 0x09), rd(0x0902, 0x12)})
+}
+
+func TestBusRti(t *testing.T) {
+	// rti
+	checkBusAccesses(t, Model6502, []byte{0x40}, func(c *CPU6502) {
+		c.SP = 0xFC
+		c.Mem.Store(0x01FD, 0x01)
+		c.Mem.Store(0x01FE, 0x00)
+		c.Mem.Store(0x01FF, 0x09)
+	}, []busAccess{rd(0x0800, 0x40), rd(0x0801, 0x00), rd(0x01FC, 0x00), rd(0x01FD, 0x01), rd(0x01FE, 0x00), rd(0x01FF, 0x09)})
+}
+
+func TestBusBrk(t *testing.T) {
+	// brk
+	checkBusAccesses(t, Model6502, []byte{0x00, 0xEA}, func(c *CPU6502) {
+		c.SetBrkIsInterrupt(true)
+		c.SP = 0xFF
+		c.Flags = 0
+		c.Mem.Store(IrqVector, 0x00)
+		c.Mem.Store(IrqVector+1, 0x30)
+	}, []busAccess{rd(0x0800, 0x00), rd(0x0801, 0xEA), wr(0x01FF, 0x08), wr(0x01FE, 0x02), wr(0x01FD, Flag_B|flagUnused), rd(IrqVector, 0x00), rd(IrqVector+1, 0x30)})
+}
+
+func TestBusIrq(t *testing.T) {
+	cpu := New6502(Model6502)
+	rec := &busRecorder{LinearMemory: memory.NewLinearMemory(65536)}
+	cpu.Init(rec)
+	cpu.SetBusAccurate(true)
+	cpu.CopyToMem([]byte{0xE8}, 0x0800)
+	cpu.Mem.Store(IrqVector+1, 0x30)
+	rec.accesses = nil
+
+	cpu.PC = 0x0800
+	cpu.SP = 0xFF
+	cpu.Flags = 0
+	cpu.Interrupts.RaiseIrq()
+	cpu.pollInterrupts()
+
+	expected := []busAccess{rd(0x0800, 0xE8), rd(0x0800, 0xE8), wr(0x01FF, 0x08), wr(0x01FE, 0x00), wr(0x01FD, flagUnused), rd(IrqVector, 0x00), rd(IrqVector+1, 0x30)}
+	if (len(rec.accesses) != len(expected)) || (cpu.PC != 0x3000) {
+		t.Fatalf("Wrong bus accesses. Expected %v, got %v", expected, rec.accesses)
+	}
+
+	for i, j := range expected {
+		if rec.accesses[i] != j {
+			t.Fatalf("Wrong bus access %d. Expected %v, got %v", i, expected, rec.accesses)
+		}
+	}
+}
+
+func TestBusBranchNotTaken(t *testing.T) {
+	// bne *+4
+	checkBusAccesses(t, Model6502, []byte{0xD0, 0x02}, func(c *CPU6502) {
+		c.Flags = Flag_Z
+	}, []busAccess{rd(0x0800, 0xD0), rd(0x0801, 0x02)})
+}
+
+func TestBusBranchTaken(t *testing.T) {
+	// bne *+4
+	checkBusAccesses(t, Model6502, []byte{0xD0, 0x02, 0xEA}, func(c *CPU6502) {
+		c.Flags = 0
+	}, []busAccess{rd(0x0800, 0xD0), rd(0x0801, 0x02), rd(0x0802, 0xEA)})
+}
+
+func TestBusBranchPageCrossNmos(t *testing.T) {
+	// bne *-2
+	checkBusAccesses(t, Model6502, []byte{0xD0, 0xFC, 0xEA}, func(c *CPU6502) {
+		c.Flags = 0
+	}, []busAccess{rd(0x0800, 0xD0), rd(0x0801, 0xFC), rd(0x0802, 0xEA), rd(0x08FE, 0x00)})
+}
+
+func TestBusBranchPageCrossCmos(t *testing.T) {
+	// bra *-2
+	checkBusAccesses(t, Model65C02, []byte{0x80, 0xFC, 0xEA}, func(c *CPU6502) {}, []busAccess{rd(0x0800, 0x80), rd(0x0801, 0xFC), rd(0x0802, 0xEA), rd(0x0802, 0xEA)})
+}
+
+func TestBusBitBranch(t *testing.T) {
+	// bbr0 $10, *+5
+	checkBusAccesses(t, ModelR65C02, []byte{0x0F, 0x10, 0x02, 0xEA}, func(c *CPU6502) {
+		c.Mem.Store(0x0010, 0x80)
+	}, []busAccess{rd(0x0800, 0x0F), rd(0x0801, 0x10), rd(0x0010, 0x80), rd(0x0010, 0x80), rd(0x0802, 0x02), rd(0x0803, 0xEA)})
+}
+
+func TestBusAccurateTrace(t *testing.T) {
+	// inx
+	// rts
+	cpu := New6502(Model6502)
+	cpu.Init(memory.NewLinearMemory(65536))
+	cpu.SetBusAccurate(true)
+	cpu.CopyToMem([]byte{0xE8, 0x60}, 0x0800)
+	cpu.SP = 0xFD
+	cpu.Mem.Store(0x01FE, 0xFF)
+	cpu.Mem.Store(0x01FF, 0xFF)
+
+	tracer := &recordingTracer{}
+	cpu.SetTracer(tracer)
+
+	if err := cpu.Run(0x0800); err != nil {
+		t.Fatalf("program failed: %v", err)
+	}
+
+	if len(tracer.records) < 2 {
+		t.Fatalf("expected at least 2 trace records, got %d", len(tracer.records))
+	}
+
+	// The dummy reads are neither instruction bytes nor effective addresses
+	inx, rts := tracer.records[0], tracer.records[1]
+	if (inx.NumBytes != 1) || inx.HasEffectiveAddress {
+		t.Fatalf("dummy read recorded for inx: %+v", inx)
+	}
+
+	if (rts.NumBytes != 1) || (rts.EffectiveAddress != 0x01FF) {
+		t.Fatalf("dummy read recorded for rts: %+v", rts)
+	}
+}
+
+func TestBusAccurateDisabled(t *testing.T) {
+	cpu := New6502(Model6502)
+	rec := &busRecorder{LinearMemory: memory.NewLinearMemory(65536)}
+	cpu.Init(rec)
+	// inc $2000
+	cpu.CopyToMem([]byte{0xEE, 0x00, 0x20}, 0x0800)
+	rec.accesses = nil
+
+	cpu.PC = 0x0800
+	cpu.executeInstruction()
+
+	if len(rec.accesses) != 5 {
+		t.Fatalf("Dummy accesses performed although bus accurate mode is disabled: %v", rec.accesses)
+	}
+}
+
+func TestBusAccurateUnsupported(t *testing.T) {
+	if New65816().SetBusAccurate(true) == nil {
+		t.Fatal("65816 accepts bus accurate mode")
+	}
+
+	if New65CE02(Model45GS02).SetBusAccurate(false) != nil {
+		t.Fatal("65CE02 rejects disabling bus accurate mode")
+	}
+}
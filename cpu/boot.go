@@ -0,0 +1,86 @@
+package cpu
+
+import (
+	"errors"
+	"fmt"
+)
+
+// StartUp describes how a machine is started through its reset vector before a program is run
+type StartUp struct {
+	// FromReset selects whether the machine is started at the address stored in the reset vector
+	FromReset bool
+	// MaxCycles is the number of clock cycles the start-up code may use. Zero means that there is no limit.
+	MaxCycles uint64
+	// ReadyAddress is reached by the start-up code when the machine has been initialized. Zero means
+	// that there is no such address.
+	ReadyAddress uint16
+}
+
+// InjectsProgram returns true if the program under test is loaded and started after the start-up
+// code has been executed. Otherwise the start-up code is the program which is run.
+func (s StartUp) InjectsProgram() bool {
+	return s.FromReset && ((s.MaxCycles != 0) || (s.ReadyAddress != 0))
+}
+
+// Boot resets p and starts execution at the address stored in the reset vector. If s injects a
+// program Boot returns as soon as the ready address is reached or the start-up cycle budget is used
+// up. It is an error if the start-up code ends or uses up its budget before the ready address
+// is reached. The execution limits and termination conditions of p are restored before Boot returns.
+func Boot(p Processor, s StartUp) error {
+	limits := p.GetExecutionLimits()
+	termination := p.GetTermination()
+	defer func() {
+		p.SetExecutionLimits(limits)
+		p.SetTermination(termination)
+	}()
+
+	if s.InjectsProgram() {
+		bootLimits := limits
+		if s.MaxCycles != 0 {
+			bootLimits.MaxCycles = s.MaxCycles
+		}
+
+		bootTermination := TerminationConditions{}
+		if s.ReadyAddress != 0 {
+			bootTermination.StopAddresses = []uint32{uint32(s.ReadyAddress)}
+		}
+
+		p.SetExecutionLimits(bootLimits)
+		p.SetTermination(bootTermination)
+	}
+
+	p.Reset()
+	flags, _ := p.GetRegister(RegFlags)
+	p.SetRegister(RegFlags, flags|uint16(Flag_I))
+
+	vector, err := p.CopyFromMem(ResetVector, 2)
+	if err != nil {
+		return fmt.Errorf("unable to read reset vector: %v", err)
+	}
+
+	err = p.RunExt((uint16(vector[1])<<8)|uint16(vector[0]), true)
+
+	if !s.InjectsProgram() {
+		return err
+	}
+
+	if s.ReadyAddress == 0 {
+		// The cycle budget has been used up or the start-up code has ended
+		var limitErr *LimitError
+		if (err != nil) && errors.As(err, &limitErr) && (p.NumCycles() >= s.MaxCycles) {
+			return nil
+		}
+
+		return err
+	}
+
+	if err != nil {
+		return fmt.Errorf("ready address $%04X not reached: %v", s.ReadyAddress, err)
+	}
+
+	if p.GetPC() != s.ReadyAddress {
+		return fmt.Errorf("start-up code ended at $%04X before the ready address $%04X was reached", p.GetPC(), s.ReadyAddress)
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package cpu
+
+import (
+	"6502profiler/memory"
+	"strings"
+	"testing"
+)
+
+// newBootTest6502 creates a 6502 whose reset vector points to the given ROM at $F000
+func newBootTest6502(rom []byte) *CPU6502 {
+	cpu := New6502(Model6502)
+	cpu.Init(memory.NewLinearMemory(65536))
+	cpu.CopyToMem(rom, 0xF000)
+	cpu.CopyToMem([]byte{0x00, 0xF0}, ResetVector)
+
+	return cpu
+}
+
+func TestBootUntilReadyAddress(t *testing.T) {
+	// ldx #$FF
+	// txs
+	// inc $10
+	// ready jmp ready
+	cpu := newBootTest6502([]byte{0xA2, 0xFF, 0x9A, 0xE6, 0x10, 0x4C, 0x05, 0xF0})
+	cpu.SetTermination(TerminationConditions{StopOnReturn: true})
+
+	err := Boot(cpu, StartUp{FromReset: true, ReadyAddress: 0xF005})
+	if err != nil {
+		t.Fatalf("boot failed: %v", err)
+	}
+
+	if (cpu.PC != 0xF005) || (cpu.Mem.Load(0x0010) != 1) {
+		t.Fatalf("ready address not reached: PC=%04X", cpu.PC)
+	}
+
+	if (cpu.Flags & Flag_I) == 0 {
+		t.Fatal("I flag not set by reset")
+	}
+
+	// ldx # (2) + txs (2) + inc zp (5)
+	if cpu.NumCycles() != 9 {
+		t.Fatalf("Wrong number of cycles: %d", cpu.NumCycles())
+	}
+
+	if !cpu.GetTermination().StopOnReturn || (len(cpu.GetTermination().StopAddresses) != 0) {
+		t.Fatal("termination conditions not restored")
+	}
+}
+
+func TestBootWithCycleBudget(t *testing.T) {
+	// loop inc $10
+	//      jmp loop
+	cpu := newBootTest6502([]byte{0xE6, 0x10, 0x4C, 0x00, 0xF0})
+
+	err := Boot(cpu, StartUp{FromReset: true, MaxCycles: 80})
+	if err != nil {
+		t.Fatalf("boot failed: %v", err)
+	}
+
+	// Each iteration takes 8 clock cycles
+	if cpu.Mem.Load(0x0010) != 10 {
+		t.Fatalf("wrong number of iterations: %d", cpu.Mem.Load(0x0010))
+	}
+
+	if cpu.GetExecutionLimits().MaxCycles != 0 {
+		t.Fatal("execution limits not restored")
+	}
+}
+
+func TestBootReadyAddressNotReached(t *testing.T) {
+	// loop inc $10
+	//      jmp loop
+	cpu := newBootTest6502([]byte{0xE6, 0x10, 0x4C, 0x00, 0xF0})
+
+	err := Boot(cpu, StartUp{FromReset: true, MaxCycles: 80, ReadyAddress: 0xF100})
+	if (err == nil) || !strings.Contains(err.Error(), "ready address $F100 not reached") {
+		t.Fatalf("wrong error: %v", err)
+	}
+}
+
+func TestBootEndsBeforeReadyAddress(t *testing.T) {
+	// inc $10
+	// brk
+	cpu := newBootTest6502([]byte{0xE6, 0x10, 0x00})
+
+	err := Boot(cpu, StartUp{FromReset: true, ReadyAddress: 0xF100})
+	if (err == nil) || !strings.Contains(err.Error(), "before the ready address") {
+		t.Fatalf("wrong error: %v", err)
+	}
+}
+
+func TestBootWithoutInjectedProgram(t *testing.T) {
+	// inc $10
+	// brk
+	cpu := newBootTest6502([]byte{0xE6, 0x10, 0x00})
+
+	if err := Boot(cpu, StartUp{FromReset: true}); err != nil {
+		t.Fatalf("boot failed: %v", err)
+	}
+
+	if cpu.Mem.Load(0x0010) != 1 {
+		t.Fatal("start-up code not executed")
+	}
+}
@@ -3,7 +3,6 @@ package cpu
 import (
 	"6502profiler/memory"
 	"fmt"
-	"os"
 )
 
 const Flag_N uint8 = 0x80
@@ -18,6 +17,35 @@ type CpuModel uint8
 
 const Model6502 CpuModel = 0x00
 const Model65C02 CpuModel = 0x01
+const Model6510 CpuModel = 0x02
+const ModelW65C02S CpuModel = 0x03
+const ModelR65C02 CpuModel = 0x04
+const Model65SC02 CpuModel = 0x05
+const Model2A03 CpuModel = 0x06
+const Model65816 CpuModel = 0x07
+const Model65CE02 CpuModel = 0x08
+const Model45GS02 CpuModel = 0x09
+
+// IsCmos returns true if the model belongs to the 65C02 family
+func (m CpuModel) IsCmos() bool {
+	return (m == Model65C02) || (m == ModelW65C02S) || (m == ModelR65C02) || (m == Model65SC02)
+}
+
+// HasBitInstructions returns true if the model implements the Rockwell instructions BBR, BBS, RMB and SMB
+func (m CpuModel) HasBitInstructions() bool {
+	return (m == Model65C02) || (m == ModelW65C02S) || (m == ModelR65C02)
+}
+
+// HasUndocumentedInstructions returns true if the model implements the stable undocumented instructions
+// of the NMOS 6502
+func (m CpuModel) HasUndocumentedInstructions() bool {
+	return (m == Model6510) || (m == Model2A03)
+}
+
+// HasDecimalMode returns false if the D flag has no effect on ADC and SBC
+func (m CpuModel) HasDecimalMode() bool {
+	return m != Model2A03
+}
 
 type execFunc func(c *CPU6502) (uint64, bool)
 
@@ -31,7 +59,12 @@ type CPU6502 struct {
 	model      CpuModel
 	cycleCount uint64
 	Mem        memory.Memory
+	Interrupts *InterruptController
 	opCodes    map[byte]execFunc
+	// If busAccurate is set the dummy reads and writes of the real hardware are performed
+	busAccurate bool
+	monitor     executionMonitor
+	tracer      instructionTracer
 }
 
 func New6502(m CpuModel) *CPU6502 {
@@ -44,6 +77,7 @@ func New6502(m CpuModel) *CPU6502 {
 		Flags:      0x00,
 		model:      m,
 		cycleCount: 0,
+		Interrupts: NewInterruptController(),
 		opCodes:    make(map[uint8]execFunc),
 	}
 
@@ -237,9 +271,12 @@ func New6502(m CpuModel) *CPU6502 {
 	// RTS
 	res.opCodes[0x60] = (*CPU6502).rts
 
+	// RTI
+	res.opCodes[0x40] = (*CPU6502).rti
+
 	// JMP
 	res.opCodes[0x4C] = (*CPU6502).jmp
-	if m == Model6502 {
+	if !m.IsCmos() {
 		res.opCodes[0x6c] = (*CPU6502).jmpIndirect6502
 	}
 
@@ -280,16 +317,108 @@ func New6502(m CpuModel) *CPU6502 {
 	res.opCodes[0x78] = (*CPU6502).sei
 
 	// BRK
-	res.opCodes[0x00] = func(c *CPU6502) (uint64, bool) {
-		return 7, true
-	}
+	res.opCodes[0x00] = (*CPU6502).brkHalt
 
 	// NOP
 	res.opCodes[0xEA] = func(c *CPU6502) (uint64, bool) {
 		return 2, false
 	}
 
-	if m == Model65C02 {
+	if m.HasUndocumentedInstructions() {
+		// Stable undocumented instructions of the NMOS 6502/6510
+		res.opCodes[0x07] = (*CPU6502).sloZeroPage
+		res.opCodes[0x17] = (*CPU6502).sloZeroPageX
+		res.opCodes[0x0F] = (*CPU6502).sloAbsolute
+		res.opCodes[0x1F] = (*CPU6502).sloAbsoluteX
+		res.opCodes[0x1B] = (*CPU6502).sloAbsoluteY
+		res.opCodes[0x03] = (*CPU6502).sloIdxXIndirect
+		res.opCodes[0x13] = (*CPU6502).sloIndirectIdxY
+
+		res.opCodes[0x27] = (*CPU6502).rlaZeroPage
+		res.opCodes[0x37] = (*CPU6502).rlaZeroPageX
+		res.opCodes[0x2F] = (*CPU6502).rlaAbsolute
+		res.opCodes[0x3F] = (*CPU6502).rlaAbsoluteX
+		res.opCodes[0x3B] = (*CPU6502).rlaAbsoluteY
+		res.opCodes[0x23] = (*CPU6502).rlaIdxXIndirect
+		res.opCodes[0x33] = (*CPU6502).rlaIndirectIdxY
+
+		res.opCodes[0x47] = (*CPU6502).sreZeroPage
+		res.opCodes[0x57] = (*CPU6502).sreZeroPageX
+		res.opCodes[0x4F] = (*CPU6502).sreAbsolute
+		res.opCodes[0x5F] = (*CPU6502).sreAbsoluteX
+		res.opCodes[0x5B] = (*CPU6502).sreAbsoluteY
+		res.opCodes[0x43] = (*CPU6502).sreIdxXIndirect
+		res.opCodes[0x53] = (*CPU6502).sreIndirectIdxY
+
+		res.opCodes[0x67] = (*CPU6502).rraZeroPage
+		res.opCodes[0x77] = (*CPU6502).rraZeroPageX
+		res.opCodes[0x6F] = (*CPU6502).rraAbsolute
+		res.opCodes[0x7F] = (*CPU6502).rraAbsoluteX
+		res.opCodes[0x7B] = (*CPU6502).rraAbsoluteY
+		res.opCodes[0x63] = (*CPU6502).rraIdxXIndirect
+		res.opCodes[0x73] = (*CPU6502).rraIndirectIdxY
+
+		res.opCodes[0xC7] = (*CPU6502).dcpZeroPage
+		res.opCodes[0xD7] = (*CPU6502).dcpZeroPageX
+		res.opCodes[0xCF] = (*CPU6502).dcpAbsolute
+		res.opCodes[0xDF] = (*CPU6502).dcpAbsoluteX
+		res.opCodes[0xDB] = (*CPU6502).dcpAbsoluteY
+		res.opCodes[0xC3] = (*CPU6502).dcpIdxXIndirect
+		res.opCodes[0xD3] = (*CPU6502).dcpIndirectIdxY
+
+		res.opCodes[0xE7] = (*CPU6502).iscZeroPage
+		res.opCodes[0xF7] = (*CPU6502).iscZeroPageX
+		res.opCodes[0xEF] = (*CPU6502).iscAbsolute
+		res.opCodes[0xFF] = (*CPU6502).iscAbsoluteX
+		res.opCodes[0xFB] = (*CPU6502).iscAbsoluteY
+		res.opCodes[0xE3] = (*CPU6502).iscIdxXIndirect
+		res.opCodes[0xF3] = (*CPU6502).iscIndirectIdxY
+
+		res.opCodes[0x87] = (*CPU6502).saxZeroPage
+		res.opCodes[0x97] = (*CPU6502).saxZeroPageY
+		res.opCodes[0x8F] = (*CPU6502).saxAbsolute
+		res.opCodes[0x83] = (*CPU6502).saxIdxXIndirect
+
+		res.opCodes[0xA7] = (*CPU6502).laxZeroPage
+		res.opCodes[0xB7] = (*CPU6502).laxZeroPageY
+		res.opCodes[0xAF] = (*CPU6502).laxAbsolute
+		res.opCodes[0xBF] = (*CPU6502).laxAbsoluteY
+		res.opCodes[0xA3] = (*CPU6502).laxIdxXIndirect
+		res.opCodes[0xB3] = (*CPU6502).laxIndirectIdxY
+
+		res.opCodes[0xBB] = (*CPU6502).lasAbsoluteY
+		res.opCodes[0x0B] = (*CPU6502).ancImmediate
+		res.opCodes[0x2B] = (*CPU6502).ancImmediate
+		res.opCodes[0x4B] = (*CPU6502).alrImmediate
+		res.opCodes[0x6B] = (*CPU6502).arrImmediate
+		res.opCodes[0xCB] = (*CPU6502).sbxImmediate
+		res.opCodes[0xEB] = (*CPU6502).subImmediate
+
+		// Multi byte NOPs
+		for _, j := range []byte{0x1A, 0x3A, 0x5A, 0x7A, 0xDA, 0xFA} {
+			res.opCodes[j] = (*CPU6502).nopImplied
+		}
+
+		for _, j := range []byte{0x80, 0x82, 0x89, 0xC2, 0xE2} {
+			res.opCodes[j] = (*CPU6502).nopImmediate
+		}
+
+		for _, j := range []byte{0x04, 0x44, 0x64} {
+			res.opCodes[j] = (*CPU6502).nopZeroPage
+		}
+
+		for _, j := range []byte{0x14, 0x34, 0x54, 0x74, 0xD4, 0xF4} {
+			res.opCodes[j] = (*CPU6502).nopZeroPageX
+		}
+
+		res.opCodes[0x0C] = (*CPU6502).nopAbsolute
+
+		for _, j := range []byte{0x1C, 0x3C, 0x5C, 0x7C, 0xDC, 0xFC} {
+			res.opCodes[j] = (*CPU6502).nopAbsoluteX
+		}
+	}
+
+	if m.IsCmos() {
 		// New instructions
 		res.opCodes[0x80] = (*CPU6502).bra
 		res.opCodes[0x64] = (*CPU6502).stzZeroPage
@@ -305,6 +434,35 @@ func New6502(m CpuModel) *CPU6502 {
 		res.opCodes[0x04] = (*CPU6502).tsbZeroPage
 		res.opCodes[0x0c] = (*CPU6502).tsbAbsolute
 
+		// New addressing modes for exisiting instructions
+		res.opCodes[0x6c] = (*CPU6502).jmpIndirect65C02
+		res.opCodes[0x7c] = (*CPU6502).jmpIndexXIndirect
+		res.opCodes[0x1a] = (*CPU6502).inc65C02
+		res.opCodes[0x3a] = (*CPU6502).dec65C02
+
+		res.opCodes[0x89] = (*CPU6502).bitImmediate
+		res.opCodes[0x34] = (*CPU6502).bitZeroPageX
+		res.opCodes[0x3C] = (*CPU6502).bitAbsoluteX
+
+		res.opCodes[0x72] = (*CPU6502).addIndirect
+		res.opCodes[0xF2] = (*CPU6502).subIndirect
+		res.opCodes[0x32] = (*CPU6502).andIndirect
+		res.opCodes[0x52] = (*CPU6502).eorIndirect
+		res.opCodes[0x12] = (*CPU6502).oraIndirect
+		res.opCodes[0xd2] = (*CPU6502).cmpIndirect
+		res.opCodes[0xb2] = (*CPU6502).ldaIndirect
+		res.opCodes[0x92] = (*CPU6502).staIndirect
+
+		// Different cycle count when compared with a 6502. ADD and SBC are missing here
+		// because the differences in cycle count for these instructions are implemented in
+		// the ADD and SBC routines directly.
+		res.opCodes[0x1e] = (*CPU6502).aslAbsoluteX65C02
+		res.opCodes[0x5e] = (*CPU6502).lsrAbsoluteX65C02
+		res.opCodes[0x3e] = (*CPU6502).rolAbsoluteX65C02
+		res.opCodes[0x7e] = (*CPU6502).rorAbsoluteX65C02
+	}
+
+	if m.HasBitInstructions() {
 		res.opCodes[0x0f] = (*CPU6502).bbr0
 		res.opCodes[0x1f] = (*CPU6502).bbr1
 		res.opCodes[0x2f] = (*CPU6502).bbr2
@@ -340,33 +498,11 @@ func New6502(m CpuModel) *CPU6502 {
 		res.opCodes[0xd7] = (*CPU6502).smb5
 		res.opCodes[0xe7] = (*CPU6502).smb6
 		res.opCodes[0xf7] = (*CPU6502).smb7
+	}
 
-		// New addressing modes for exisiting instructions
-		res.opCodes[0x6c] = (*CPU6502).jmpIndirect65C02
-		res.opCodes[0x7c] = (*CPU6502).jmpIndexXIndirect
-		res.opCodes[0x1a] = (*CPU6502).inc65C02
-		res.opCodes[0x3a] = (*CPU6502).dec65C02
-
-		res.opCodes[0x89] = (*CPU6502).bitImmediate
-		res.opCodes[0x34] = (*CPU6502).bitZeroPageX
-		res.opCodes[0x3C] = (*CPU6502).bitAbsoluteX
-
-		res.opCodes[0x72] = (*CPU6502).addIndirect
-		res.opCodes[0xF2] = (*CPU6502).subIndirect
-		res.opCodes[0x32] = (*CPU6502).andIndirect
-		res.opCodes[0x52] = (*CPU6502).eorIndirect
-		res.opCodes[0x12] = (*CPU6502).oraIndirect
-		res.opCodes[0xd2] = (*CPU6502).cmpIndirect
-		res.opCodes[0xb2] = (*CPU6502).ldaIndirect
-		res.opCodes[0x92] = (*CPU6502).staIndirect
-
-		// Different cycle count when compared with a 6502. ADD and SBC are missing here
-		// because the differences in cycle count for these instructions are implemented in
-		// the ADD and SBC routines directly.
-		res.opCodes[0x1e] = (*CPU6502).aslAbsoluteX65C02
-		res.opCodes[0x5e] = (*CPU6502).lsrAbsoluteX65C02
-		res.opCodes[0x3e] = (*CPU6502).rolAbsoluteX65C02
-		res.opCodes[0x7e] = (*CPU6502).rorAbsoluteX65C02
+	if m == ModelW65C02S {
+		res.opCodes[0xdb] = (*CPU6502).stp
+		res.opCodes[0xcb] = (*CPU6502).wai
 	}
 
 	return res
@@ -380,9 +516,14 @@ func (c *CPU6502) Reset() {
 	c.Y = 0
 	c.PC = 0
 	c.SP = 0xFF
+	c.Interrupts.Reset()
 	c.Mem.ClearStatistics()
 }
 
+func (c *CPU6502) Model() CpuModel {
+	return c.model
+}
+
 func (c *CPU6502) NumCycles() uint64 {
 	return c.cycleCount
 }
@@ -392,69 +533,133 @@ func (c *CPU6502) Init(m memory.Memory) {
 	c.SP = 0xFF
 }
 
-func (c *CPU6502) LoadAndRun(fileName string) (loadAddress uint16, progLen uint16, err error) {
-	loadAddress, progLen, err = c.Load(fileName)
-	if err != nil {
-		return 0, 0, fmt.Errorf("unable to run program: %v", err)
-	}
+func (c *CPU6502) GetMem() memory.Memory {
+	return c.Mem
+}
 
-	return loadAddress, progLen, c.Run(loadAddress)
+func (c *CPU6502) SetMem(m memory.Memory) {
+	c.Mem = m
 }
 
-func (c *CPU6502) Load(fileName string) (loadAddress uint16, progLen uint16, err error) {
-	data, err := os.ReadFile(fileName)
-	if err != nil {
-		return 0, 0, fmt.Errorf("unable to load binary: %v", err)
-	}
+func (c *CPU6502) GetPC() uint16 {
+	return c.PC
+}
 
-	if len(data) < 3 {
-		return 0, 0, fmt.Errorf("no program data found")
-	}
+func (c *CPU6502) SetPC(pc uint16) {
+	c.PC = pc
+}
 
-	loadAddress = uint16(data[1])*256 + uint16(data[0])
-	c.CopyToMem(data[2:], loadAddress)
+func (c *CPU6502) SetTracer(t Tracer) {
+	c.tracer.set(t)
+}
 
-	return loadAddress, uint16(len(data) - 2), nil
+// SetBusAccurate enables or disables the dummy bus cycles of read-modify-write and indexed instructions
+func (c *CPU6502) SetBusAccurate(busAccurate bool) error {
+	c.busAccurate = busAccurate
+	return nil
 }
 
-func (c *CPU6502) CopyToMem(binary []byte, startAddress uint16) (err error) {
-	// Recover from panic created by memory access
-	defer func() {
-		if res := recover(); res != nil {
-			// Use named return value to return a value after handling the panic
-			err = fmt.Errorf("error copying to memory: %v", res)
-		}
-	}()
+func (c *CPU6502) SetExecutionLimits(l ExecutionLimits) {
+	c.monitor.limits = l
+}
 
-	copyAddress := startAddress
+func (c *CPU6502) GetExecutionLimits() ExecutionLimits {
+	return c.monitor.limits
+}
 
-	for _, j := range binary {
-		c.Mem.Store(copyAddress, j)
-		copyAddress++ // This can overflow
-	}
+func (c *CPU6502) CallStack() []CallFrame {
+	return c.monitor.frames()
+}
 
-	return err
+func (c *CPU6502) GetExecState() ExecState {
+	return c.monitor.execState(c.cycleCount)
 }
 
-func (c *CPU6502) CopyFromMem(startAddress uint16, length uint16) (data []byte, err error) {
-	// Recover from panic created by memory access
-	defer func() {
-		if res := recover(); res != nil {
-			// Use named return value to return a value after handling the panic
-			err = fmt.Errorf("error copying from memory: %v", res)
-		}
-	}()
+func (c *CPU6502) SetExecState(s ExecState) {
+	c.cycleCount = s.Cycles
+	c.monitor.restore(s)
+	c.tracer.restart = true
+}
+
+func (c *CPU6502) SetTermination(t TerminationConditions) {
+	c.monitor.termination = t
+}
+
+func (c *CPU6502) GetTermination() TerminationConditions {
+	return c.monitor.termination
+}
+
+func (c *CPU6502) RequestExit(exitCode uint8) {
+	c.monitor.exit(exitCode)
+}
+
+func (c *CPU6502) ExitCode() (uint8, bool) {
+	return c.monitor.exitCode, c.monitor.exited
+}
+
+func (c *CPU6502) GetInterrupts() *InterruptController {
+	return c.Interrupts
+}
+
+func (c *CPU6502) FlagNames() string {
+	return "NV-BDIZC"
+}
+
+func (c *CPU6502) GetRegister(r Register) (uint16, bool) {
+	switch r {
+	case RegA:
+		return uint16(c.A), true
+	case RegX:
+		return uint16(c.X), true
+	case RegY:
+		return uint16(c.Y), true
+	case RegSP:
+		return uint16(c.SP), true
+	case RegFlags:
+		return uint16(c.Flags), true
+	default:
+		return 0, false
+	}
+}
+
+func (c *CPU6502) SetRegister(r Register, val uint16) bool {
+	switch r {
+	case RegA:
+		c.A = uint8(val)
+	case RegX:
+		c.X = uint8(val)
+	case RegY:
+		c.Y = uint8(val)
+	case RegSP:
+		c.SP = uint8(val)
+	case RegFlags:
+		c.Flags = uint8(val)
+	default:
+		return false
+	}
 
-	data = []byte{}
-	copyAddress := startAddress
-	var count uint16
+	return true
+}
 
-	for count = 0; count < length; count++ {
-		data = append(data, c.Mem.Load(copyAddress))
-		copyAddress++
+func (c *CPU6502) LoadAndRun(fileName string) (loadAddress uint16, progLen uint16, err error) {
+	loadAddress, progLen, err = c.Load(fileName)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to run program: %v", err)
 	}
 
-	return data, err
+	return loadAddress, progLen, c.Run(loadAddress)
+}
+
+func (c *CPU6502) Load(fileName string) (loadAddress uint16, progLen uint16, err error) {
+	return loadBinary(c.Mem, fileName)
+}
+
+func (c *CPU6502) CopyToMem(binary []byte, startAddress uint16) error {
+	return copyToMem(c.Mem, binary, startAddress)
+}
+
+func (c *CPU6502) CopyFromMem(startAddress uint16, length uint16) ([]byte, error) {
+	return copyFromMem(c.Mem, startAddress, length)
 }
 
 func (c *CPU6502) CopyAndRun(program []byte, startAddress uint16) (err error) {
@@ -489,11 +694,50 @@ func (c *CPU6502) RunExt(startAddress uint16, resetCycleCount bool) (err error)
 		c.cycleCount = 0
 	}
 
+	c.monitor.start(c.cycleCount, uint16(c.SP))
+
+	if c.tracer.tracer != nil {
+		c.Mem = c.tracer.attach(c.Mem)
+		defer func() { c.Mem = c.tracer.detach() }()
+	}
+
 	for halt := false; !halt; {
-		cyclesUsed, halt = c.executeInstruction()
+		c.cycleCount += c.pollInterrupts()
+
+		if c.monitor.atStopAddress(uint32(c.PC)) {
+			break
+		}
+
+		if reason := c.monitor.beforeInstruction(uint32(c.PC), c.cycleCount); reason != "" {
+			return c.monitor.limitError(reason, c)
+		}
+
+		pc := c.PC
+		sp := c.SP
+		if c.tracer.tracer == nil {
+			cyclesUsed, halt = c.executeInstruction()
+		} else {
+			cyclesUsed, halt = c.tracer.execute(c, uint32(pc), c.cycleCount, c.executeInstruction)
+		}
+
+		if c.tracer.restart {
+			c.tracer.restart = false
+			continue
+		}
+
 		if !halt {
 			c.cycleCount += cyclesUsed
 		}
+
+		if c.monitor.stopped {
+			break
+		}
+
+		if !halt {
+			if reason := c.monitor.selfLoop(uint32(pc), uint16(sp), uint32(c.PC), uint16(c.SP), c.Interrupts.interruptPossible(c.Flags), false); reason != "" {
+				return c.monitor.limitError(reason, c)
+			}
+		}
 	}
 
 	return err
@@ -515,6 +759,14 @@ func (c *CPU6502) nzFlags(v uint8) {
 	}
 }
 
+func (c *CPU6502) setFlag(flag uint8, set bool) {
+	if set {
+		c.Flags |= flag
+	} else {
+		c.Flags &= (^flag)
+	}
+}
+
 // Stack functions. Stack is always in the area 0x100 - 0x1FF. The first address is
 // 0x1FF. The stack grows downwards.
 func (c *CPU6502) push(val uint8) {
@@ -529,8 +781,29 @@ func (c *CPU6502) pop() uint8 {
 
 // ---------------------
 
+// oneByteOpcodes contains the instructions which read the byte following the opcode although they
+// consist of only one byte. BRK reads its padding byte in this way. The opcodes $1A, $3A, $5A, $7A,
+// $DA and $FA are implied instructions on the 65C02 and undocumented implied NOPs on the 6510.
+var oneByteOpcodes = func() [256]bool {
+	var res [256]bool
+	for _, j := range []uint8{
+		0x00, 0x08, 0x0A, 0x18, 0x1A, 0x28, 0x2A, 0x38, 0x3A, 0x40, 0x48, 0x4A, 0x58, 0x5A, 0x60, 0x68,
+		0x6A, 0x78, 0x7A, 0x88, 0x8A, 0x98, 0x9A, 0xA8, 0xAA, 0xB8, 0xBA, 0xC8, 0xCA, 0xD8, 0xDA, 0xE8,
+		0xEA, 0xF8, 0xFA,
+	} {
+		res[j] = true
+	}
+
+	return res
+}()
+
+// fetchOperand reads the operand byte at the current PC
+func (c *CPU6502) fetchOperand() uint8 {
+	return c.Mem.LoadAs(c.PC, memory.AccessOperandFetch)
+}
+
 func (c *CPU6502) executeInstruction() (uint64, bool) {
-	opCode := c.Mem.Load(c.PC)
+	opCode := c.Mem.LoadAs(c.PC, memory.AccessOpcodeFetch)
 	instruction, ok := c.opCodes[opCode]
 	if !ok {
 		panic(fmt.Sprintf("Illegal opcode $%x at $%x", opCode, c.PC))
@@ -538,5 +811,9 @@ func (c *CPU6502) executeInstruction() (uint64, bool) {
 
 	c.PC++
 
+	if oneByteOpcodes[opCode] {
+		c.dummyInstructionRead()
+	}
+
 	return instruction(c)
 }
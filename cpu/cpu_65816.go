@@ -0,0 +1,1003 @@
+package cpu
+
+import (
+	"6502profiler/memory"
+	"fmt"
+)
+
+// In native mode bit 5 and 4 of the flag register select the width of the accumulator and the
+// index registers. If a bit is set the corresponding registers are 8 bit wide.
+const Flag_M uint8 = 0x20
+const Flag_X uint8 = 0x10
+
+// Interrupt vectors used by the 65816 in native mode. In emulation mode the 6502 vectors are used.
+const CopVectorNative uint16 = 0xFFE4
+const BrkVectorNative uint16 = 0xFFE6
+const NmiVectorNative uint16 = 0xFFEA
+const IrqVectorNative uint16 = 0xFFEE
+const CopVector uint16 = 0xFFF4
+
+type execFunc816 func(c *CPU65816) (uint64, bool)
+
+// CPU65816 simulates the WDC 65816. Memory accesses to bank 0 are made through the 16 bit interface
+// of the memory model, i.e. they see memory wrappers, banking and MMU mappings in the same way as
+// the 6502. All other banks are accessed through LargeMemory.
+type CPU65816 struct {
+	PC uint16
+	// In emulation mode the high byte of the stack pointer is always $01
+	SP uint16
+	// C is the 16 bit accumulator. The low byte is A and the high byte is B.
+	C uint16
+	// The high bytes of X and Y are always zero if the index registers are 8 bit wide
+	X              uint16
+	Y              uint16
+	D              uint16
+	DBR            uint8
+	PBR            uint8
+	Flags          uint8
+	Emulation      bool
+	cycleCount     uint64
+	brkIsInterrupt bool
+	Mem            memory.Memory
+	Interrupts     *InterruptController
+	opCodes        [256]execFunc816
+	monitor        executionMonitor
+	tracer         instructionTracer
+	// Set while MVN or MVP repeat themselves
+	blockMoveActive bool
+}
+
+func New65816() *CPU65816 {
+	res := &CPU65816{
+		PC:             0x0000,
+		SP:             0x01FF,
+		C:              0,
+		X:              0,
+		Y:              0,
+		D:              0,
+		DBR:            0,
+		PBR:            0,
+		Flags:          Flag_M | Flag_X,
+		Emulation:      true,
+		cycleCount:     0,
+		brkIsInterrupt: false,
+		Interrupts:     NewInterruptController(),
+	}
+
+	res.initOpCodes()
+
+	return res
+}
+
+func (c *CPU65816) Reset() {
+	c.cycleCount = 0
+	c.Flags = Flag_M | Flag_X
+	c.Emulation = true
+	c.C = 0
+	c.X = 0
+	c.Y = 0
+	c.D = 0
+	c.DBR = 0
+	c.PBR = 0
+	c.PC = 0
+	c.SP = 0x01FF
+	c.Interrupts.Reset()
+	c.Mem.ClearStatistics()
+}
+
+func (c *CPU65816) Model() CpuModel {
+	return Model65816
+}
+
+func (c *CPU65816) NumCycles() uint64 {
+	return c.cycleCount
+}
+
+func (c *CPU65816) Init(m memory.Memory) {
+	c.Mem = m
+	c.SP = 0x01FF
+}
+
+func (c *CPU65816) GetMem() memory.Memory {
+	return c.Mem
+}
+
+func (c *CPU65816) SetMem(m memory.Memory) {
+	c.Mem = m
+}
+
+func (c *CPU65816) GetPC() uint16 {
+	return c.PC
+}
+
+func (c *CPU65816) SetPC(pc uint16) {
+	c.PC = pc
+}
+
+func (c *CPU65816) SetExecutionLimits(l ExecutionLimits) {
+	c.monitor.limits = l
+}
+
+func (c *CPU65816) GetExecutionLimits() ExecutionLimits {
+	return c.monitor.limits
+}
+
+func (c *CPU65816) CallStack() []CallFrame {
+	return c.monitor.frames()
+}
+
+func (c *CPU65816) GetExecState() ExecState {
+	return c.monitor.execState(c.cycleCount)
+}
+
+func (c *CPU65816) SetExecState(s ExecState) {
+	c.cycleCount = s.Cycles
+	c.monitor.restore(s)
+	c.tracer.restart = true
+}
+
+func (c *CPU65816) SetTermination(t TerminationConditions) {
+	c.monitor.termination = t
+}
+
+func (c *CPU65816) GetTermination() TerminationConditions {
+	return c.monitor.termination
+}
+
+func (c *CPU65816) RequestExit(exitCode uint8) {
+	c.monitor.exit(exitCode)
+}
+
+func (c *CPU65816) ExitCode() (uint8, bool) {
+	return c.monitor.exitCode, c.monitor.exited
+}
+
+func (c *CPU65816) GetInterrupts() *InterruptController {
+	return c.Interrupts
+}
+
+func (c *CPU65816) SetBrkIsInterrupt(brkIsInterrupt bool) {
+	c.brkIsInterrupt = brkIsInterrupt
+}
+
+func (c *CPU65816) SetTracer(t Tracer) {
+	c.tracer.set(t)
+}
+
+// SetBusAccurate returns an error as dummy bus cycles are not simulated for the 65816
+func (c *CPU65816) SetBusAccurate(busAccurate bool) error {
+	if busAccurate {
+		return fmt.Errorf("bus accurate mode is not supported by the 65816")
+	}
+
+	return nil
+}
+
+func (c *CPU65816) FlagNames() string {
+	if c.Emulation {
+		return "NV--DIZC"
+	}
+
+	return "NVMXDIZC"
+}
+
+// GetRegister returns A, X and Y in their current width. RegC always returns the full 16 bit
+// accumulator.
+func (c *CPU65816) GetRegister(r Register) (uint16, bool) {
+	switch r {
+	case RegA:
+		return c.getA(c.accu16()), true
+	case RegC:
+		return c.C, true
+	case RegX:
+		return c.X, true
+	case RegY:
+		return c.Y, true
+	case RegSP:
+		return c.SP, true
+	case RegFlags:
+		return uint16(c.Flags), true
+	case RegD:
+		return c.D, true
+	case RegDBR:
+		return uint16(c.DBR), true
+	case RegPBR:
+		return uint16(c.PBR), true
+	case RegE:
+		if c.Emulation {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+// SetRegister respects the current register width, i.e. setting A in 8 bit mode leaves B unchanged
+func (c *CPU65816) SetRegister(r Register, val uint16) bool {
+	switch r {
+	case RegA:
+		c.setA(val, c.accu16())
+	case RegC:
+		c.C = val
+	case RegX:
+		c.X = c.indexValue(val)
+	case RegY:
+		c.Y = c.indexValue(val)
+	case RegSP:
+		c.setSP(val)
+	case RegFlags:
+		c.setP(uint8(val))
+	case RegD:
+		c.D = val
+	case RegDBR:
+		c.DBR = uint8(val)
+	case RegPBR:
+		c.PBR = uint8(val)
+	case RegE:
+		c.setEmulation(val != 0)
+	default:
+		return false
+	}
+
+	return true
+}
+
+func (c *CPU65816) LoadAndRun(fileName string) (loadAddress uint16, progLen uint16, err error) {
+	loadAddress, progLen, err = c.Load(fileName)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to run program: %v", err)
+	}
+
+	return loadAddress, progLen, c.Run(loadAddress)
+}
+
+func (c *CPU65816) Load(fileName string) (loadAddress uint16, progLen uint16, err error) {
+	return loadBinary(c.Mem, fileName)
+}
+
+func (c *CPU65816) CopyToMem(binary []byte, startAddress uint16) error {
+	return copyToMem(c.Mem, binary, startAddress)
+}
+
+func (c *CPU65816) CopyFromMem(startAddress uint16, length uint16) ([]byte, error) {
+	return copyFromMem(c.Mem, startAddress, length)
+}
+
+func (c *CPU65816) CopyAndRun(program []byte, startAddress uint16) (err error) {
+	err = c.CopyToMem(program, startAddress)
+	if err != nil {
+		return err
+	}
+
+	err = c.Run(startAddress)
+
+	return err
+}
+
+func (c *CPU65816) Run(startAddress uint16) (err error) {
+	return c.RunExt(startAddress, true)
+}
+
+// RunExt starts execution at the given address in the current program bank
+func (c *CPU65816) RunExt(startAddress uint16, resetCycleCount bool) (err error) {
+	var cyclesUsed uint64
+	err = nil
+
+	// Recover from panic created by an instruction
+	defer func() {
+		if res := recover(); res != nil {
+			// Use named return value to return a value after handling the panic
+			err = fmt.Errorf("error running 65816 program: %v", res)
+		}
+	}()
+
+	c.PC = startAddress
+	if resetCycleCount {
+		c.cycleCount = 0
+	}
+
+	c.monitor.start(c.cycleCount, c.SP)
+
+	if c.tracer.tracer != nil {
+		c.Mem = c.tracer.attach(c.Mem)
+		defer func() { c.Mem = c.tracer.detach() }()
+	}
+
+	for halt := false; !halt; {
+		c.cycleCount += c.pollInterrupts()
+
+		if c.monitor.atStopAddress(c.programAddress()) {
+			break
+		}
+
+		if reason := c.monitor.beforeInstruction(c.programAddress(), c.cycleCount); reason != "" {
+			return c.monitor.limitError(reason, c)
+		}
+
+		pc := c.programAddress()
+		sp := c.SP
+		if c.tracer.tracer == nil {
+			cyclesUsed, halt = c.executeInstruction()
+		} else {
+			cyclesUsed, halt = c.tracer.execute(c, pc, c.cycleCount, c.executeInstruction)
+		}
+
+		if c.tracer.restart {
+			c.tracer.restart = false
+			continue
+		}
+
+		if !halt {
+			c.cycleCount += cyclesUsed
+		}
+
+		if c.monitor.stopped {
+			break
+		}
+
+		if !halt {
+			if reason := c.monitor.selfLoop(pc, sp, c.programAddress(), c.SP, c.Interrupts.interruptPossible(c.Flags), c.blockMoveActive); reason != "" {
+				return c.monitor.limitError(reason, c)
+			}
+		}
+	}
+
+	return err
+}
+
+func (c *CPU65816) executeInstruction() (uint64, bool) {
+	c.blockMoveActive = false
+	opCode := c.loadAs(c.programAddress(), memory.AccessOpcodeFetch)
+	c.PC++
+
+	return c.opCodes[opCode](c)
+}
+
+// -------- Register helpers --------
+
+func (c *CPU65816) accu16() bool {
+	return (c.Flags & Flag_M) == 0
+}
+
+func (c *CPU65816) index16() bool {
+	return (c.Flags & Flag_X) == 0
+}
+
+func (c *CPU65816) getA(wide bool) uint16 {
+	if wide {
+		return c.C
+	}
+
+	return c.C & 0x00FF
+}
+
+// setA only changes the low byte of the accumulator if it is 8 bit wide
+func (c *CPU65816) setA(v uint16, wide bool) {
+	if wide {
+		c.C = v
+	} else {
+		c.C = (c.C & 0xFF00) | (v & 0x00FF)
+	}
+}
+
+func (c *CPU65816) indexValue(v uint16) uint16 {
+	if c.index16() {
+		return v
+	}
+
+	return v & 0x00FF
+}
+
+func (c *CPU65816) setSP(v uint16) {
+	if c.Emulation {
+		c.SP = 0x0100 | (v & 0x00FF)
+	} else {
+		c.SP = v
+	}
+}
+
+// stackMask returns the part of the stack pointer which can change
+func (c *CPU65816) stackMask() uint16 {
+	if c.Emulation {
+		return 0x00FF
+	}
+
+	return 0xFFFF
+}
+
+// setP has to be used whenever the flag register is changed as a whole. In emulation mode M and
+// X are always set and 8 bit index registers lose their high byte.
+func (c *CPU65816) setP(v uint8) {
+	if c.Emulation {
+		v |= Flag_M | Flag_X
+	}
+
+	c.Flags = v
+
+	if !c.index16() {
+		c.X &= 0x00FF
+		c.Y &= 0x00FF
+	}
+}
+
+func (c *CPU65816) setEmulation(emulation bool) {
+	c.Emulation = emulation
+
+	if emulation {
+		c.setP(c.Flags)
+		c.setSP(c.SP)
+	}
+}
+
+func (c *CPU65816) setFlag(flag uint8, set bool) {
+	if set {
+		c.Flags |= flag
+	} else {
+		c.Flags &= (^flag)
+	}
+}
+
+func (c *CPU65816) nzFlags(v uint16, wide bool) {
+	if wide {
+		c.setFlag(Flag_Z, v == 0)
+		c.setFlag(Flag_N, (v&0x8000) != 0)
+	} else {
+		c.setFlag(Flag_Z, (v&0x00FF) == 0)
+		c.setFlag(Flag_N, (v&0x0080) != 0)
+	}
+}
+
+// -------- Memory access --------
+
+func (c *CPU65816) load(addr uint32) uint8 {
+	return c.loadAs(addr, memory.AccessDataRead)
+}
+
+func (c *CPU65816) loadAs(addr uint32, kind memory.AccessKind) uint8 {
+	if addr <= 0xFFFF {
+		return c.Mem.LoadAs(uint16(addr), kind)
+	}
+
+	return c.Mem.ToLargeMemory().LoadLargeAs(addr, kind)
+}
+
+func (c *CPU65816) store(addr uint32, b uint8) {
+	if addr <= 0xFFFF {
+		c.Mem.Store(uint16(addr), b)
+		return
+	}
+
+	c.Mem.ToLargeMemory().StoreLarge(addr, b)
+}
+
+// load16Bank0 reads a word from bank 0. The address of the high byte wraps around at $FFFF.
+func (c *CPU65816) load16Bank0(addr uint16) uint16 {
+	return uint16(c.load(uint32(addr))) | (uint16(c.load(uint32(addr+1))) << 8)
+}
+
+// programAddress returns the 24 bit address of the next instruction
+func (c *CPU65816) programAddress() uint32 {
+	return (uint32(c.PBR) << 16) | uint32(c.PC)
+}
+
+func (c *CPU65816) fetch8() uint8 {
+	res := c.loadAs(c.programAddress(), memory.AccessOperandFetch)
+	c.PC++
+
+	return res
+}
+
+func (c *CPU65816) fetch16() uint16 {
+	lo := uint16(c.fetch8())
+	hi := uint16(c.fetch8())
+
+	return (hi << 8) | lo
+}
+
+func (c *CPU65816) fetch24() uint32 {
+	lo := uint32(c.fetch16())
+	bank := uint32(c.fetch8())
+
+	return (bank << 16) | lo
+}
+
+// operand816 is the effective address of an operand. The high byte of a 16 bit operand is
+// read from the next address. For direct page and stack relative operands this address wraps
+// around in bank 0. For all other operands it may cross into the next bank.
+type operand816 struct {
+	addr  uint32
+	bank0 bool
+	// kind is used when the operand is read. Immediate operands are part of the instruction.
+	kind memory.AccessKind
+}
+
+func (o operand816) next() uint32 {
+	if o.bank0 {
+		return (o.addr + 1) & 0xFFFF
+	}
+
+	return (o.addr + 1) & 0xFFFFFF
+}
+
+func (c *CPU65816) loadOperand(o operand816, wide bool) uint16 {
+	res := uint16(c.loadAs(o.addr, o.kind))
+
+	if wide {
+		res |= uint16(c.loadAs(o.next(), o.kind)) << 8
+	}
+
+	return res
+}
+
+func (c *CPU65816) storeOperand(o operand816, v uint16, wide bool) {
+	c.store(o.addr, uint8(v))
+
+	if wide {
+		c.store(o.next(), uint8(v>>8))
+	}
+}
+
+// -------- Stack --------
+
+// In emulation mode the stack is confined to page 1
+func (c *CPU65816) push8(v uint8) {
+	c.store(uint32(c.SP), v)
+	c.setSP(c.SP - 1)
+}
+
+func (c *CPU65816) pop8() uint8 {
+	c.setSP(c.SP + 1)
+	return c.load(uint32(c.SP))
+}
+
+func (c *CPU65816) push16(v uint16) {
+	c.push8(uint8(v >> 8))
+	c.push8(uint8(v))
+}
+
+func (c *CPU65816) pop16() uint16 {
+	lo := uint16(c.pop8())
+	hi := uint16(c.pop8())
+
+	return (hi << 8) | lo
+}
+
+// -------- Addressing modes --------
+
+// An addrMode816 fetches the operand bytes of an instruction and calculates the effective address.
+// The first additional cycle value is caused by a direct page register whose low byte is not zero.
+// The second one is caused by indexing across a page boundary or by using 16 bit index registers. It
+// is only relevant for instructions which read from memory. wide is the width of the operand.
+type addrMode816 func(c *CPU65816, wide bool) (operand816, uint64, uint64)
+
+func (c *CPU65816) dataAddr(a uint16) uint32 {
+	return (uint32(c.DBR) << 16) | uint32(a)
+}
+
+func (c *CPU65816) indexed(base uint32, index uint16) (uint32, uint64) {
+	res := (base + uint32(index)) & 0xFFFFFF
+
+	if c.index16() || ((base & 0xFFFF00) != (res & 0xFFFF00)) {
+		return res, 1
+	}
+
+	return res, 0
+}
+
+func (c *CPU65816) directPenalty() uint64 {
+	if (c.D & 0x00FF) != 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// directAddr calculates an address in the direct page. In emulation mode a direct page that starts
+// at a page boundary behaves like the zero page of the 6502, i.e. the address wraps around in the page.
+func (c *CPU65816) directAddr(offset uint16) uint32 {
+	if c.Emulation && ((c.D & 0x00FF) == 0) {
+		return uint32(c.D | (offset & 0x00FF))
+	}
+
+	return uint32(c.D + offset)
+}
+
+func (c *CPU65816) directPointer(offset uint16) uint16 {
+	lo := uint16(c.load(c.directAddr(offset)))
+	hi := uint16(c.load(c.directAddr(offset + 1)))
+
+	return (hi << 8) | lo
+}
+
+func (c *CPU65816) directLongPointer(offset uint16) uint32 {
+	lo := uint32(c.load(uint32(c.D + offset)))
+	hi := uint32(c.load(uint32(c.D + offset + 1)))
+	bank := uint32(c.load(uint32(c.D + offset + 2)))
+
+	return (bank << 16) | (hi << 8) | lo
+}
+
+// #
+func immediate816(c *CPU65816, wide bool) (operand816, uint64, uint64) {
+	res := operand816{addr: c.programAddress(), bank0: false, kind: memory.AccessOperandFetch}
+
+	c.PC++
+	if wide {
+		c.PC++
+	}
+
+	return res, 0, 0
+}
+
+// a
+func absolute816(c *CPU65816, wide bool) (operand816, uint64, uint64) {
+	return operand816{addr: c.dataAddr(c.fetch16()), bank0: false}, 0, 0
+}
+
+// a,x
+func absoluteX816(c *CPU65816, wide bool) (operand816, uint64, uint64) {
+	addr, penalty := c.indexed(c.dataAddr(c.fetch16()), c.X)
+	return operand816{addr: addr, bank0: false}, 0, penalty
+}
+
+// a,y
+func absoluteY816(c *CPU65816, wide bool) (operand816, uint64, uint64) {
+	addr, penalty := c.indexed(c.dataAddr(c.fetch16()), c.Y)
+	return operand816{addr: addr, bank0: false}, 0, penalty
+}
+
+// al
+func absoluteLong816(c *CPU65816, wide bool) (operand816, uint64, uint64) {
+	return operand816{addr: c.fetch24(), bank0: false}, 0, 0
+}
+
+// al,x
+func absoluteLongX816(c *CPU65816, wide bool) (operand816, uint64, uint64) {
+	return operand816{addr: (c.fetch24() + uint32(c.X)) & 0xFFFFFF, bank0: false}, 0, 0
+}
+
+// d
+func direct816(c *CPU65816, wide bool) (operand816, uint64, uint64) {
+	return operand816{addr: c.directAddr(uint16(c.fetch8())), bank0: true}, c.directPenalty(), 0
+}
+
+// d,x
+func directX816(c *CPU65816, wide bool) (operand816, uint64, uint64) {
+	return operand816{addr: c.directAddr(uint16(c.fetch8()) + c.X), bank0: true}, c.directPenalty(), 0
+}
+
+// d,y
+func directY816(c *CPU65816, wide bool) (operand816, uint64, uint64) {
+	return operand816{addr: c.directAddr(uint16(c.fetch8()) + c.Y), bank0: true}, c.directPenalty(), 0
+}
+
+// (d)
+func directIndirect816(c *CPU65816, wide bool) (operand816, uint64, uint64) {
+	ptr := c.directPointer(uint16(c.fetch8()))
+	return operand816{addr: c.dataAddr(ptr), bank0: false}, c.directPenalty(), 0
+}
+
+// [d]
+func directIndirectLong816(c *CPU65816, wide bool) (operand816, uint64, uint64) {
+	ptr := c.directLongPointer(uint16(c.fetch8()))
+	return operand816{addr: ptr, bank0: false}, c.directPenalty(), 0
+}
+
+// (d,x)
+func directIdxIndirectX816(c *CPU65816, wide bool) (operand816, uint64, uint64) {
+	ptr := c.directPointer(uint16(c.fetch8()) + c.X)
+	return operand816{addr: c.dataAddr(ptr), bank0: false}, c.directPenalty(), 0
+}
+
+// (d),y
+func directIndirectIdxY816(c *CPU65816, wide bool) (operand816, uint64, uint64) {
+	ptr := c.directPointer(uint16(c.fetch8()))
+	addr, penalty := c.indexed(c.dataAddr(ptr), c.Y)
+	return operand816{addr: addr, bank0: false}, c.directPenalty(), penalty
+}
+
+// [d],y
+func directIndirectLongIdxY816(c *CPU65816, wide bool) (operand816, uint64, uint64) {
+	ptr := c.directLongPointer(uint16(c.fetch8()))
+	return operand816{addr: (ptr + uint32(c.Y)) & 0xFFFFFF, bank0: false}, c.directPenalty(), 0
+}
+
+// d,s
+func stackRelative816(c *CPU65816, wide bool) (operand816, uint64, uint64) {
+	return operand816{addr: uint32(c.SP + uint16(c.fetch8())), bank0: true}, 0, 0
+}
+
+// (d,s),y
+func stackRelIndirectIdxY816(c *CPU65816, wide bool) (operand816, uint64, uint64) {
+	ptr := c.load16Bank0(c.SP + uint16(c.fetch8()))
+	return operand816{addr: (c.dataAddr(ptr) + uint32(c.Y)) & 0xFFFFFF, bank0: false}, 0, 0
+}
+
+// -------- Instruction builders --------
+
+// An operation816 performs the actual work of an instruction on an operand of the given width
+type operation816 func(c *CPU65816, o operand816, wide bool)
+
+// readInstr creates an instruction which reads its operand from memory. The operand width is
+// determined by the M flag or, if index is true, by the X flag. 16 bit operands need one more cycle.
+func readInstr(op operation816, mode addrMode816, cycles uint64, index bool) execFunc816 {
+	return func(c *CPU65816) (uint64, bool) {
+		wide := c.accu16()
+		if index {
+			wide = c.index16()
+		}
+
+		o, directPenalty, indexPenalty := mode(c, wide)
+		op(c, o, wide)
+
+		res := cycles + directPenalty + indexPenalty
+		if wide {
+			res++
+		}
+
+		return res, false
+	}
+}
+
+// writeInstr creates an instruction which writes to memory. For stores the indexing penalty is
+// always contained in the cycles value.
+func writeInstr(op operation816, mode addrMode816, cycles uint64, index bool) execFunc816 {
+	return func(c *CPU65816) (uint64, bool) {
+		wide := c.accu16()
+		if index {
+			wide = c.index16()
+		}
+
+		o, directPenalty, _ := mode(c, wide)
+		op(c, o, wide)
+
+		res := cycles + directPenalty
+		if wide {
+			res++
+		}
+
+		return res, false
+	}
+}
+
+// modifyInstr creates a read-modify-write instruction. These need two more cycles for 16 bit operands.
+func modifyInstr(modifier func(c *CPU65816, v uint16, wide bool) uint16, mode addrMode816, cycles uint64) execFunc816 {
+	return func(c *CPU65816) (uint64, bool) {
+		wide := c.accu16()
+
+		o, directPenalty, _ := mode(c, wide)
+		c.storeOperand(o, modifier(c, c.loadOperand(o, wide), wide), wide)
+
+		res := cycles + directPenalty
+		if wide {
+			res += 2
+		}
+
+		return res, false
+	}
+}
+
+// accuInstr creates an instruction which modifies the accumulator, like ASL A
+func accuInstr(modifier func(c *CPU65816, v uint16, wide bool) uint16) execFunc816 {
+	return func(c *CPU65816) (uint64, bool) {
+		wide := c.accu16()
+		c.setA(modifier(c, c.getA(wide), wide), wide)
+
+		return 2, false
+	}
+}
+
+// -------- Opcode table --------
+
+type modeEntry816 struct {
+	offset byte
+	mode   addrMode816
+	cycles uint64
+}
+
+func (c *CPU65816) initOpCodes() {
+	// The addressing modes of ORA, AND, EOR, ADC, LDA, CMP and SBC, relative to the opcode of (d,x)
+	aluModes := []modeEntry816{
+		{0x00, directIdxIndirectX816, 6},
+		{0x02, stackRelative816, 4},
+		{0x04, direct816, 3},
+		{0x06, directIndirectLong816, 6},
+		{0x08, immediate816, 2},
+		{0x0C, absolute816, 4},
+		{0x0E, absoluteLong816, 5},
+		{0x10, directIndirectIdxY816, 5},
+		{0x11, directIndirect816, 5},
+		{0x12, stackRelIndirectIdxY816, 7},
+		{0x14, directX816, 4},
+		{0x16, directIndirectLongIdxY816, 6},
+		{0x18, absoluteY816, 4},
+		{0x1C, absoluteX816, 4},
+		{0x1E, absoluteLongX816, 5},
+	}
+
+	aluOps := map[byte]operation816{
+		0x01: (*CPU65816).ora,
+		0x21: (*CPU65816).and,
+		0x41: (*CPU65816).eor,
+		0x61: (*CPU65816).adc,
+		0xA1: (*CPU65816).lda,
+		0xC1: (*CPU65816).cmp,
+		0xE1: (*CPU65816).sbc,
+	}
+
+	for base, op := range aluOps {
+		for _, m := range aluModes {
+			c.opCodes[base+m.offset] = readInstr(op, m.mode, m.cycles, false)
+		}
+	}
+
+	// STA. Indexed stores always need the additional cycle.
+	for _, m := range aluModes {
+		cycles := m.cycles
+		if (m.offset == 0x10) || (m.offset == 0x18) || (m.offset == 0x1C) {
+			cycles++
+		}
+
+		if m.offset != 0x08 {
+			c.opCodes[0x81+m.offset] = writeInstr((*CPU65816).sta, m.mode, cycles, false)
+		}
+	}
+
+	// LDX, LDY
+	c.opCodes[0xA2] = readInstr((*CPU65816).ldx, immediate816, 2, true)
+	c.opCodes[0xA6] = readInstr((*CPU65816).ldx, direct816, 3, true)
+	c.opCodes[0xAE] = readInstr((*CPU65816).ldx, absolute816, 4, true)
+	c.opCodes[0xB6] = readInstr((*CPU65816).ldx, directY816, 4, true)
+	c.opCodes[0xBE] = readInstr((*CPU65816).ldx, absoluteY816, 4, true)
+	c.opCodes[0xA0] = readInstr((*CPU65816).ldy, immediate816, 2, true)
+	c.opCodes[0xA4] = readInstr((*CPU65816).ldy, direct816, 3, true)
+	c.opCodes[0xAC] = readInstr((*CPU65816).ldy, absolute816, 4, true)
+	c.opCodes[0xB4] = readInstr((*CPU65816).ldy, directX816, 4, true)
+	c.opCodes[0xBC] = readInstr((*CPU65816).ldy, absoluteX816, 4, true)
+
+	// STX, STY, STZ
+	c.opCodes[0x86] = writeInstr((*CPU65816).stx, direct816, 3, true)
+	c.opCodes[0x8E] = writeInstr((*CPU65816).stx, absolute816, 4, true)
+	c.opCodes[0x96] = writeInstr((*CPU65816).stx, directY816, 4, true)
+	c.opCodes[0x84] = writeInstr((*CPU65816).sty, direct816, 3, true)
+	c.opCodes[0x8C] = writeInstr((*CPU65816).sty, absolute816, 4, true)
+	c.opCodes[0x94] = writeInstr((*CPU65816).sty, directX816, 4, true)
+	c.opCodes[0x64] = writeInstr((*CPU65816).stz, direct816, 3, false)
+	c.opCodes[0x74] = writeInstr((*CPU65816).stz, directX816, 4, false)
+	c.opCodes[0x9C] = writeInstr((*CPU65816).stz, absolute816, 4, false)
+	c.opCodes[0x9E] = writeInstr((*CPU65816).stz, absoluteX816, 5, false)
+
+	// CPX, CPY
+	c.opCodes[0xE0] = readInstr((*CPU65816).cpx, immediate816, 2, true)
+	c.opCodes[0xE4] = readInstr((*CPU65816).cpx, direct816, 3, true)
+	c.opCodes[0xEC] = readInstr((*CPU65816).cpx, absolute816, 4, true)
+	c.opCodes[0xC0] = readInstr((*CPU65816).cpy, immediate816, 2, true)
+	c.opCodes[0xC4] = readInstr((*CPU65816).cpy, direct816, 3, true)
+	c.opCodes[0xCC] = readInstr((*CPU65816).cpy, absolute816, 4, true)
+
+	// BIT
+	c.opCodes[0x89] = readInstr((*CPU65816).bitImmediate, immediate816, 2, false)
+	c.opCodes[0x24] = readInstr((*CPU65816).bit, direct816, 3, false)
+	c.opCodes[0x2C] = readInstr((*CPU65816).bit, absolute816, 4, false)
+	c.opCodes[0x34] = readInstr((*CPU65816).bit, directX816, 4, false)
+	c.opCodes[0x3C] = readInstr((*CPU65816).bit, absoluteX816, 4, false)
+
+	// ASL, ROL, LSR, ROR, DEC, INC
+	modifiers := map[byte]func(c *CPU65816, v uint16, wide bool) uint16{
+		0x06: (*CPU65816).asl,
+		0x26: (*CPU65816).rol,
+		0x46: (*CPU65816).lsr,
+		0x66: (*CPU65816).ror,
+		0xC6: (*CPU65816).dec,
+		0xE6: (*CPU65816).inc,
+	}
+
+	for base, modifier := range modifiers {
+		c.opCodes[base] = modifyInstr(modifier, direct816, 5)
+		c.opCodes[base+0x08] = modifyInstr(modifier, absolute816, 6)
+		c.opCodes[base+0x10] = modifyInstr(modifier, directX816, 6)
+		c.opCodes[base+0x18] = modifyInstr(modifier, absoluteX816, 7)
+	}
+
+	c.opCodes[0x0A] = accuInstr((*CPU65816).asl)
+	c.opCodes[0x2A] = accuInstr((*CPU65816).rol)
+	c.opCodes[0x4A] = accuInstr((*CPU65816).lsr)
+	c.opCodes[0x6A] = accuInstr((*CPU65816).ror)
+	c.opCodes[0x3A] = accuInstr((*CPU65816).dec)
+	c.opCodes[0x1A] = accuInstr((*CPU65816).inc)
+
+	// TSB, TRB
+	c.opCodes[0x04] = modifyInstr((*CPU65816).tsb, direct816, 5)
+	c.opCodes[0x0C] = modifyInstr((*CPU65816).tsb, absolute816, 6)
+	c.opCodes[0x14] = modifyInstr((*CPU65816).trb, direct816, 5)
+	c.opCodes[0x1C] = modifyInstr((*CPU65816).trb, absolute816, 6)
+
+	// Branches
+	c.opCodes[0x10] = branchInstr(Flag_N, false)
+	c.opCodes[0x30] = branchInstr(Flag_N, true)
+	c.opCodes[0x50] = branchInstr(Flag_V, false)
+	c.opCodes[0x70] = branchInstr(Flag_V, true)
+	c.opCodes[0x90] = branchInstr(Flag_C, false)
+	c.opCodes[0xB0] = branchInstr(Flag_C, true)
+	c.opCodes[0xD0] = branchInstr(Flag_Z, false)
+	c.opCodes[0xF0] = branchInstr(Flag_Z, true)
+	c.opCodes[0x80] = (*CPU65816).bra
+	c.opCodes[0x82] = (*CPU65816).brl
+
+	// Jumps and subroutines
+	c.opCodes[0x4C] = (*CPU65816).jmpAbsolute
+	c.opCodes[0x5C] = (*CPU65816).jmlLong
+	c.opCodes[0x6C] = (*CPU65816).jmpIndirect
+	c.opCodes[0x7C] = (*CPU65816).jmpIdxIndirectX
+	c.opCodes[0xDC] = (*CPU65816).jmlIndirectLong
+	c.opCodes[0x20] = (*CPU65816).jsrAbsolute
+	c.opCodes[0xFC] = (*CPU65816).jsrIdxIndirectX
+	c.opCodes[0x22] = (*CPU65816).jsl
+	c.opCodes[0x60] = (*CPU65816).rts
+	c.opCodes[0x6B] = (*CPU65816).rtl
+	c.opCodes[0x40] = (*CPU65816).rti
+
+	// Stack
+	c.opCodes[0x48] = (*CPU65816).pha
+	c.opCodes[0x68] = (*CPU65816).pla
+	c.opCodes[0xDA] = (*CPU65816).phx
+	c.opCodes[0xFA] = (*CPU65816).plx
+	c.opCodes[0x5A] = (*CPU65816).phy
+	c.opCodes[0x7A] = (*CPU65816).ply
+	c.opCodes[0x08] = (*CPU65816).php
+	c.opCodes[0x28] = (*CPU65816).plp
+	c.opCodes[0x8B] = (*CPU65816).phb
+	c.opCodes[0xAB] = (*CPU65816).plb
+	c.opCodes[0x0B] = (*CPU65816).phd
+	c.opCodes[0x2B] = (*CPU65816).pld
+	c.opCodes[0x4B] = (*CPU65816).phk
+	c.opCodes[0xF4] = (*CPU65816).pea
+	c.opCodes[0xD4] = (*CPU65816).pei
+	c.opCodes[0x62] = (*CPU65816).per
+
+	// Transfers
+	c.opCodes[0xAA] = (*CPU65816).tax
+	c.opCodes[0xA8] = (*CPU65816).tay
+	c.opCodes[0x8A] = (*CPU65816).txa
+	c.opCodes[0x98] = (*CPU65816).tya
+	c.opCodes[0xBA] = (*CPU65816).tsx
+	c.opCodes[0x9A] = (*CPU65816).txs
+	c.opCodes[0x9B] = (*CPU65816).txy
+	c.opCodes[0xBB] = (*CPU65816).tyx
+	c.opCodes[0x5B] = (*CPU65816).tcd
+	c.opCodes[0x7B] = (*CPU65816).tdc
+	c.opCodes[0x1B] = (*CPU65816).tcs
+	c.opCodes[0x3B] = (*CPU65816).tsc
+	c.opCodes[0xEB] = (*CPU65816).xba
+	c.opCodes[0xFB] = (*CPU65816).xce
+
+	// Increment and decrement of index registers
+	c.opCodes[0xE8] = (*CPU65816).inx
+	c.opCodes[0xC8] = (*CPU65816).iny
+	c.opCodes[0xCA] = (*CPU65816).dex
+	c.opCodes[0x88] = (*CPU65816).dey
+
+	// Flags
+	c.opCodes[0x18] = flagInstr(Flag_C, false)
+	c.opCodes[0x38] = flagInstr(Flag_C, true)
+	c.opCodes[0x58] = flagInstr(Flag_I, false)
+	c.opCodes[0x78] = flagInstr(Flag_I, true)
+	c.opCodes[0xB8] = flagInstr(Flag_V, false)
+	c.opCodes[0xD8] = flagInstr(Flag_D, false)
+	c.opCodes[0xF8] = flagInstr(Flag_D, true)
+	c.opCodes[0xC2] = (*CPU65816).rep
+	c.opCodes[0xE2] = (*CPU65816).sep
+
+	// Block moves
+	c.opCodes[0x54] = (*CPU65816).mvn
+	c.opCodes[0x44] = (*CPU65816).mvp
+
+	// Miscellaneous
+	c.opCodes[0xEA] = (*CPU65816).nop
+	c.opCodes[0x42] = (*CPU65816).wdm
+	c.opCodes[0x00] = (*CPU65816).brk
+	c.opCodes[0x02] = (*CPU65816).cop
+	c.opCodes[0xDB] = (*CPU65816).stp
+	c.opCodes[0xCB] = (*CPU65816).wai
+}
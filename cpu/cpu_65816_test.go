@@ -0,0 +1,344 @@
+package cpu
+
+import (
+	"6502profiler/memory"
+	"testing"
+)
+
+func newTest65816(testProg []byte) *CPU65816 {
+	cpu := New65816()
+	// The F256 memory model provides 1 MB of memory, i.e. the banks $00-$0F
+	cpu.Init(memory.NewF56JrMemory(false))
+	cpu.CopyToMem(testProg, UnitProgStart)
+
+	return cpu
+}
+
+func run65816(t *testing.T, cpu *CPU65816) {
+	err := cpu.Run(UnitProgStart)
+	if err != nil {
+		t.Fatalf("65816 program failed: %v", err)
+	}
+}
+
+func TestOpCodeTable65816Complete(t *testing.T) {
+	cpu := New65816()
+
+	for i, j := range cpu.opCodes {
+		if j == nil {
+			t.Fatalf("Opcode %02X not implemented", i)
+		}
+	}
+}
+
+func TestEmulationModeLoad(t *testing.T) {
+	// lda #$42
+	// brk
+	cpu := newTest65816([]byte{0xA9, 0x42, 0x00})
+	run65816(t, cpu)
+
+	if (cpu.C != 0x0042) || (cpu.NumCycles() != 2) || !cpu.Emulation {
+		t.Fatalf("LDA # in emulation mode failed: C=%04X cycles=%d", cpu.C, cpu.NumCycles())
+	}
+}
+
+func TestNativeMode16BitRegisters(t *testing.T) {
+	// clc
+	// xce
+	// rep #$30
+	// lda #$1234
+	// ldx #$8000
+	// brk
+	cpu := newTest65816([]byte{0x18, 0xFB, 0xC2, 0x30, 0xA9, 0x34, 0x12, 0xA2, 0x00, 0x80, 0x00})
+	run65816(t, cpu)
+
+	if cpu.Emulation {
+		t.Fatal("XCE did not switch to native mode")
+	}
+
+	if (cpu.C != 0x1234) || (cpu.X != 0x8000) {
+		t.Fatalf("16 bit loads failed: C=%04X X=%04X", cpu.C, cpu.X)
+	}
+
+	if (cpu.Flags & Flag_N) == 0 {
+		t.Fatal("N flag not set by 16 bit value")
+	}
+
+	// clc (2) + xce (2) + rep (3) + lda (3) + ldx (3)
+	if cpu.NumCycles() != 13 {
+		t.Fatalf("Wrong number of cycles: %d", cpu.NumCycles())
+	}
+}
+
+func TestSepClearsIndexHighByte(t *testing.T) {
+	// clc
+	// xce
+	// rep #$10
+	// ldx #$1234
+	// sep #$10
+	// brk
+	cpu := newTest65816([]byte{0x18, 0xFB, 0xC2, 0x10, 0xA2, 0x34, 0x12, 0xE2, 0x10, 0x00})
+	run65816(t, cpu)
+
+	if cpu.X != 0x0034 {
+		t.Fatalf("High byte of X not cleared: %04X", cpu.X)
+	}
+}
+
+func TestBackToEmulationMode(t *testing.T) {
+	// clc
+	// xce
+	// rep #$30
+	// ldx #$1234
+	// tcs
+	// sec
+	// xce
+	// brk
+	cpu := newTest65816([]byte{0x18, 0xFB, 0xC2, 0x30, 0xA2, 0x34, 0x12, 0x1B, 0x38, 0xFB, 0x00})
+	cpu.C = 0x2345
+	run65816(t, cpu)
+
+	if !cpu.Emulation || (cpu.X != 0x0034) || (cpu.SP != 0x0145) {
+		t.Fatalf("Switch to emulation mode failed: X=%04X SP=%04X", cpu.X, cpu.SP)
+	}
+
+	if (cpu.Flags & (Flag_M | Flag_X)) != (Flag_M | Flag_X) {
+		t.Fatal("M and X not set in emulation mode")
+	}
+
+	// B is preserved
+	if cpu.C != 0x2345 {
+		t.Fatalf("Accumulator changed: %04X", cpu.C)
+	}
+}
+
+func TestAdc16BitDecimal(t *testing.T) {
+	// clc
+	// xce
+	// rep #$20
+	// sed
+	// clc
+	// lda #$1999
+	// adc #$0001
+	// sta $10
+	// lda #$9999
+	// adc #$0001
+	// brk
+	cpu := newTest65816([]byte{0x18, 0xFB, 0xC2, 0x20, 0xF8, 0x18, 0xA9, 0x99, 0x19, 0x69, 0x01, 0x00, 0x85, 0x10,
+		0xA9, 0x99, 0x99, 0x69, 0x01, 0x00, 0x00})
+	run65816(t, cpu)
+
+	if (cpu.Mem.Load(0x10) != 0x00) || (cpu.Mem.Load(0x11) != 0x20) {
+		t.Fatal("$1999 + 1 is not $2000 in decimal mode")
+	}
+
+	if (cpu.C != 0x0000) || ((cpu.Flags & Flag_C) == 0) || ((cpu.Flags & Flag_Z) == 0) {
+		t.Fatalf("$9999 + 1 did not result in $0000 with carry: %04X", cpu.C)
+	}
+}
+
+func TestSbc16BitBinary(t *testing.T) {
+	// clc
+	// xce
+	// rep #$20
+	// sec
+	// lda #$1000
+	// sbc #$0001
+	// brk
+	cpu := newTest65816([]byte{0x18, 0xFB, 0xC2, 0x20, 0x38, 0xA9, 0x00, 0x10, 0xE9, 0x01, 0x00, 0x00})
+	run65816(t, cpu)
+
+	if (cpu.C != 0x0FFF) || ((cpu.Flags & Flag_C) == 0) {
+		t.Fatalf("16 bit SBC failed: %04X", cpu.C)
+	}
+}
+
+func TestSbc8BitDecimal(t *testing.T) {
+	// sed
+	// sec
+	// lda #$10
+	// sbc #$01
+	// brk
+	cpu := newTest65816([]byte{0xF8, 0x38, 0xA9, 0x10, 0xE9, 0x01, 0x00})
+	run65816(t, cpu)
+
+	if (cpu.C != 0x0009) || ((cpu.Flags & Flag_C) == 0) {
+		t.Fatalf("8 bit decimal SBC failed: %04X", cpu.C)
+	}
+}
+
+func TestLongAddressing(t *testing.T) {
+	// lda #$42
+	// sta $020000
+	// lda #$02
+	// pha
+	// plb
+	// lda #$55
+	// sta $1234
+	// brk
+	cpu := newTest65816([]byte{0xA9, 0x42, 0x8F, 0x00, 0x00, 0x02, 0xA9, 0x02, 0x48, 0xAB, 0xA9, 0x55, 0x8D, 0x34, 0x12, 0x00})
+	run65816(t, cpu)
+
+	large := cpu.Mem.ToLargeMemory()
+
+	if large.LoadLarge(0x020000) != 0x42 {
+		t.Fatal("STA long did not write to bank 2")
+	}
+
+	if (large.LoadLarge(0x021234) != 0x55) || (cpu.Mem.Load(0x1234) != 0x00) {
+		t.Fatal("STA absolute did not use data bank register")
+	}
+}
+
+func TestDirectPagePenalty(t *testing.T) {
+	// lda $10
+	// brk
+	cpu := newTest65816([]byte{0xA5, 0x10, 0x00})
+	cpu.D = 0x0001
+	cpu.Mem.Store(0x0011, 0x42)
+	run65816(t, cpu)
+
+	if (cpu.C != 0x0042) || (cpu.NumCycles() != 4) {
+		t.Fatalf("Direct page access failed: C=%04X cycles=%d", cpu.C, cpu.NumCycles())
+	}
+}
+
+func TestIndexPenalty(t *testing.T) {
+	// lda $10ff,x
+	// brk
+	cpu := newTest65816([]byte{0xBD, 0xFF, 0x10, 0x00})
+	cpu.X = 1
+	cpu.Mem.Store(0x1100, 0x42)
+	run65816(t, cpu)
+
+	if (cpu.C != 0x0042) || (cpu.NumCycles() != 5) {
+		t.Fatalf("Page crossing not detected: C=%04X cycles=%d", cpu.C, cpu.NumCycles())
+	}
+}
+
+func TestJslRtl(t *testing.T) {
+	// jsl $010000
+	// brk
+	cpu := newTest65816([]byte{0x22, 0x00, 0x00, 0x01, 0x00})
+	// inx
+	// rtl
+	cpu.Mem.ToLargeMemory().StoreLarge(0x010000, 0xE8)
+	cpu.Mem.ToLargeMemory().StoreLarge(0x010001, 0x6B)
+	run65816(t, cpu)
+
+	if (cpu.X != 1) || (cpu.PBR != 0) || (cpu.PC != UnitProgStart+5) || (cpu.SP != 0x01FF) {
+		t.Fatalf("JSL/RTL failed: X=%d PBR=%02X PC=%04X", cpu.X, cpu.PBR, cpu.PC)
+	}
+
+	// jsl (8) + inx (2) + rtl (6)
+	if cpu.NumCycles() != 16 {
+		t.Fatalf("Wrong number of cycles: %d", cpu.NumCycles())
+	}
+}
+
+func TestMvn(t *testing.T) {
+	// clc
+	// xce
+	// rep #$30
+	// lda #$0003
+	// ldx #$1000
+	// ldy #$2000
+	// mvn $00,$00
+	// brk
+	cpu := newTest65816([]byte{0x18, 0xFB, 0xC2, 0x30, 0xA9, 0x03, 0x00, 0xA2, 0x00, 0x10, 0xA0, 0x00, 0x20, 0x54, 0x00, 0x00, 0x00})
+	cpu.CopyToMem([]byte{1, 2, 3, 4, 5}, 0x1000)
+	run65816(t, cpu)
+
+	data, _ := cpu.CopyFromMem(0x2000, 5)
+	if (data[0] != 1) || (data[3] != 4) || (data[4] != 0) {
+		t.Fatalf("MVN did not copy data: %v", data)
+	}
+
+	if (cpu.C != 0xFFFF) || (cpu.X != 0x1004) || (cpu.Y != 0x2004) {
+		t.Fatalf("Registers wrong after MVN: C=%04X X=%04X Y=%04X", cpu.C, cpu.X, cpu.Y)
+	}
+
+	// 2 + 2 + 3 + 3 + 3 + 3 + 4 * 7
+	if cpu.NumCycles() != 44 {
+		t.Fatalf("Wrong number of cycles: %d", cpu.NumCycles())
+	}
+}
+
+func TestXba(t *testing.T) {
+	// xba
+	// brk
+	cpu := newTest65816([]byte{0xEB, 0x00})
+	cpu.C = 0x8012
+	run65816(t, cpu)
+
+	if (cpu.C != 0x1280) || ((cpu.Flags & Flag_N) == 0) {
+		t.Fatalf("XBA failed: %04X", cpu.C)
+	}
+}
+
+func TestNativeIrq(t *testing.T) {
+	// clc
+	// xce
+	// cli
+	// nop
+	// brk
+	cpu := newTest65816([]byte{0x18, 0xFB, 0x58, 0xEA, 0x00})
+	cpu.CopyToMem([]byte{0x00, 0x09}, IrqVectorNative)
+	cpu.Flags |= Flag_I
+	// inx
+	// stz $d019
+	// rti
+	cpu.CopyToMem([]byte{0xE8, 0x9C, 0x19, 0xD0, 0x40}, 0x0900)
+
+	wrapper := memory.NewMemWrapper(cpu.Mem, 0xD000)
+	wrapper.AddSpecialWriteAddress(0xD019, func(data uint8) {
+		cpu.Interrupts.ClearIrq()
+	})
+	cpu.Mem = wrapper
+
+	cpu.Interrupts.RaiseIrq()
+	run65816(t, cpu)
+
+	if cpu.X != 1 {
+		t.Fatal("IRQ handler not called exactly once")
+	}
+
+	// clc (2) + xce (2) + cli (2) + irq entry (8) + inx (2) + stz (4) + rti (7) + nop (2)
+	if cpu.NumCycles() != 29 {
+		t.Fatalf("Wrong number of cycles: %d", cpu.NumCycles())
+	}
+}
+
+func TestBranchPageCrossEmulation(t *testing.T) {
+	// bne *+$7f
+	cpu := newTest65816([]byte{0xD0, 0x7D})
+	cpu.Mem.Store(0x087F, 0x00)
+	run65816(t, cpu)
+
+	if (cpu.PC != 0x0880) || (cpu.NumCycles() != 3) {
+		t.Fatalf("Branch failed: PC=%04X cycles=%d", cpu.PC, cpu.NumCycles())
+	}
+
+	// bne *-$7e
+	cpu = newTest65816([]byte{0xD0, 0x80})
+	cpu.Mem.Store(0x0782, 0x00)
+	run65816(t, cpu)
+
+	if (cpu.PC != 0x0783) || (cpu.NumCycles() != 4) {
+		t.Fatalf("Branch across page failed: PC=%04X cycles=%d", cpu.PC, cpu.NumCycles())
+	}
+}
+
+func TestRegisterAccess65816(t *testing.T) {
+	var p Processor = New65816()
+	p.SetRegister(RegC, 0x1234)
+	p.SetRegister(RegA, 0xFF)
+
+	if val, _ := p.GetRegister(RegC); val != 0x12FF {
+		t.Fatalf("Setting A in 8 bit mode changed B: %04X", val)
+	}
+
+	if _, ok := New6502(Model6502).GetRegister(RegDBR); ok {
+		t.Fatal("6502 has a data bank register")
+	}
+}
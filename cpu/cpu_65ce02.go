@@ -0,0 +1,932 @@
+package cpu
+
+import (
+	"6502profiler/memory"
+	"fmt"
+)
+
+// If the E flag is set the stack is 8 bit wide and confined to the page stored in the high byte of
+// the stack pointer. If it is clear the stack pointer is a 16 bit register.
+const Flag_E uint8 = 0x20
+
+// Flat addresses of the 45GS02 have 28 bits
+const flatAddrMask uint32 = 0x0FFFFFFF
+
+type execFuncCE func(c *CPU65CE02) (uint64, bool)
+
+// CPU65CE02 simulates the CSG 65CE02 and the 45GS02 of the MEGA65. In addition to the 65CE02 the
+// 45GS02 implements the MAP instruction, 32 bit flat addressing via the ([bp]),Z mode (prefix NOP) and
+// the 32 bit quad instructions (prefix NEG NEG) which use A, X, Y and Z as one 32 bit register Q.
+// Addresses which are not mapped by MAP are accessed through the 16 bit interface of the memory model.
+// Mapped and flat addresses are accessed through LargeMemory.
+type CPU65CE02 struct {
+	PC uint16
+	// The high byte of the stack pointer selects the stack page if the E flag is set
+	SP    uint16
+	A     uint8
+	X     uint8
+	Y     uint8
+	Z     uint8
+	B     uint8
+	Flags uint8
+	model CpuModel
+	// Set while executing an instruction which is prefixed by NEG NEG or NOP
+	quad bool
+	flat bool
+	// Memory mapping established by MAP. The offsets have a granularity of 256 bytes.
+	mapLoOffset    uint32
+	mapHiOffset    uint32
+	mapLoMB        uint32
+	mapHiMB        uint32
+	mapLoMask      uint8
+	mapHiMask      uint8
+	mapActive      bool
+	cycleCount     uint64
+	brkIsInterrupt bool
+	Mem            memory.Memory
+	Interrupts     *InterruptController
+	opCodes        [256]execFuncCE
+	monitor        executionMonitor
+	tracer         instructionTracer
+}
+
+func New65CE02(m CpuModel) *CPU65CE02 {
+	res := &CPU65CE02{
+		SP:         0x01FF,
+		Flags:      Flag_E,
+		model:      m,
+		Interrupts: NewInterruptController(),
+	}
+
+	res.initOpCodes()
+
+	return res
+}
+
+func (c *CPU65CE02) Reset() {
+	c.cycleCount = 0
+	c.Flags = Flag_E
+	c.A = 0
+	c.X = 0
+	c.Y = 0
+	c.Z = 0
+	c.B = 0
+	c.PC = 0
+	c.SP = 0x01FF
+	c.quad = false
+	c.flat = false
+	c.mapLoOffset = 0
+	c.mapHiOffset = 0
+	c.mapLoMB = 0
+	c.mapHiMB = 0
+	c.mapLoMask = 0
+	c.mapHiMask = 0
+	c.mapActive = false
+	c.Interrupts.Reset()
+	c.Mem.ClearStatistics()
+}
+
+func (c *CPU65CE02) Model() CpuModel {
+	return c.model
+}
+
+func (c *CPU65CE02) NumCycles() uint64 {
+	return c.cycleCount
+}
+
+func (c *CPU65CE02) Init(m memory.Memory) {
+	c.Mem = m
+	c.SP = 0x01FF
+}
+
+func (c *CPU65CE02) GetMem() memory.Memory {
+	return c.Mem
+}
+
+func (c *CPU65CE02) SetMem(m memory.Memory) {
+	c.Mem = m
+}
+
+func (c *CPU65CE02) GetPC() uint16 {
+	return c.PC
+}
+
+func (c *CPU65CE02) SetPC(pc uint16) {
+	c.PC = pc
+}
+
+func (c *CPU65CE02) SetExecutionLimits(l ExecutionLimits) {
+	c.monitor.limits = l
+}
+
+func (c *CPU65CE02) GetExecutionLimits() ExecutionLimits {
+	return c.monitor.limits
+}
+
+func (c *CPU65CE02) CallStack() []CallFrame {
+	return c.monitor.frames()
+}
+
+func (c *CPU65CE02) GetExecState() ExecState {
+	return c.monitor.execState(c.cycleCount)
+}
+
+func (c *CPU65CE02) SetExecState(s ExecState) {
+	c.cycleCount = s.Cycles
+	c.monitor.restore(s)
+	c.tracer.restart = true
+}
+
+func (c *CPU65CE02) SetTermination(t TerminationConditions) {
+	c.monitor.termination = t
+}
+
+func (c *CPU65CE02) GetTermination() TerminationConditions {
+	return c.monitor.termination
+}
+
+func (c *CPU65CE02) RequestExit(exitCode uint8) {
+	c.monitor.exit(exitCode)
+}
+
+func (c *CPU65CE02) ExitCode() (uint8, bool) {
+	return c.monitor.exitCode, c.monitor.exited
+}
+
+func (c *CPU65CE02) GetInterrupts() *InterruptController {
+	return c.Interrupts
+}
+
+func (c *CPU65CE02) SetBrkIsInterrupt(brkIsInterrupt bool) {
+	c.brkIsInterrupt = brkIsInterrupt
+}
+
+func (c *CPU65CE02) SetTracer(t Tracer) {
+	c.tracer.set(t)
+}
+
+// SetBusAccurate returns an error as dummy bus cycles are not simulated for the 65CE02
+func (c *CPU65CE02) SetBusAccurate(busAccurate bool) error {
+	if busAccurate {
+		return fmt.Errorf("bus accurate mode is not supported by the 65CE02")
+	}
+
+	return nil
+}
+
+func (c *CPU65CE02) FlagNames() string {
+	return "NVEBDIZC"
+}
+
+func (c *CPU65CE02) GetRegister(r Register) (uint16, bool) {
+	switch r {
+	case RegA:
+		return uint16(c.A), true
+	case RegX:
+		return uint16(c.X), true
+	case RegY:
+		return uint16(c.Y), true
+	case RegZ:
+		return uint16(c.Z), true
+	case RegB:
+		return uint16(c.B), true
+	case RegSP:
+		return c.SP, true
+	case RegFlags:
+		return uint16(c.Flags), true
+	default:
+		return 0, false
+	}
+}
+
+func (c *CPU65CE02) SetRegister(r Register, val uint16) bool {
+	switch r {
+	case RegA:
+		c.A = uint8(val)
+	case RegX:
+		c.X = uint8(val)
+	case RegY:
+		c.Y = uint8(val)
+	case RegZ:
+		c.Z = uint8(val)
+	case RegB:
+		c.B = uint8(val)
+	case RegSP:
+		c.SP = val
+	case RegFlags:
+		c.Flags = uint8(val)
+	default:
+		return false
+	}
+
+	return true
+}
+
+func (c *CPU65CE02) LoadAndRun(fileName string) (loadAddress uint16, progLen uint16, err error) {
+	loadAddress, progLen, err = c.Load(fileName)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to run program: %v", err)
+	}
+
+	return loadAddress, progLen, c.Run(loadAddress)
+}
+
+func (c *CPU65CE02) Load(fileName string) (loadAddress uint16, progLen uint16, err error) {
+	return loadBinary(c.Mem, fileName)
+}
+
+func (c *CPU65CE02) CopyToMem(binary []byte, startAddress uint16) error {
+	return copyToMem(c.Mem, binary, startAddress)
+}
+
+func (c *CPU65CE02) CopyFromMem(startAddress uint16, length uint16) ([]byte, error) {
+	return copyFromMem(c.Mem, startAddress, length)
+}
+
+func (c *CPU65CE02) CopyAndRun(program []byte, startAddress uint16) (err error) {
+	err = c.CopyToMem(program, startAddress)
+	if err != nil {
+		return err
+	}
+
+	err = c.Run(startAddress)
+
+	return err
+}
+
+func (c *CPU65CE02) Run(startAddress uint16) (err error) {
+	return c.RunExt(startAddress, true)
+}
+
+func (c *CPU65CE02) RunExt(startAddress uint16, resetCycleCount bool) (err error) {
+	var cyclesUsed uint64
+	err = nil
+
+	// Recover from panic created by an instruction
+	defer func() {
+		if res := recover(); res != nil {
+			// Use named return value to return a value after handling the panic
+			err = fmt.Errorf("error running 65CE02 program: %v", res)
+		}
+	}()
+
+	c.PC = startAddress
+	if resetCycleCount {
+		c.cycleCount = 0
+	}
+
+	c.monitor.start(c.cycleCount, c.SP)
+
+	if c.tracer.tracer != nil {
+		c.Mem = c.tracer.attach(c.Mem)
+		defer func() { c.Mem = c.tracer.detach() }()
+	}
+
+	for halt := false; !halt; {
+		c.cycleCount += c.pollInterrupts()
+
+		if c.monitor.atStopAddress(uint32(c.PC)) {
+			break
+		}
+
+		if reason := c.monitor.beforeInstruction(uint32(c.PC), c.cycleCount); reason != "" {
+			return c.monitor.limitError(reason, c)
+		}
+
+		pc := c.PC
+		sp := c.SP
+		if c.tracer.tracer == nil {
+			cyclesUsed, halt = c.executeInstruction()
+		} else {
+			cyclesUsed, halt = c.tracer.execute(c, uint32(pc), c.cycleCount, c.executeInstruction)
+		}
+
+		if c.tracer.restart {
+			c.tracer.restart = false
+			continue
+		}
+
+		if !halt {
+			c.cycleCount += cyclesUsed
+		}
+
+		if c.monitor.stopped {
+			break
+		}
+
+		if !halt {
+			if reason := c.monitor.selfLoop(uint32(pc), sp, uint32(c.PC), c.SP, c.Interrupts.interruptPossible(c.Flags), false); reason != "" {
+				return c.monitor.limitError(reason, c)
+			}
+		}
+	}
+
+	return err
+}
+
+func (c *CPU65CE02) executeInstruction() (uint64, bool) {
+	opCode := c.loadAs(c.PC, memory.AccessOpcodeFetch)
+	c.PC++
+
+	return c.opCodes[opCode](c)
+}
+
+// executePrefixed executes the instruction following a prefix. The prefix state is reset afterwards.
+func (c *CPU65CE02) executePrefixed(quad bool, flat bool) (uint64, bool) {
+	c.quad = c.quad || quad
+	c.flat = c.flat || flat
+
+	defer func() {
+		c.quad = false
+		c.flat = false
+	}()
+
+	return c.executeInstruction()
+}
+
+// -------- Register helpers --------
+
+func (c *CPU65CE02) setFlag(flag uint8, set bool) {
+	if set {
+		c.Flags |= flag
+	} else {
+		c.Flags &= (^flag)
+	}
+}
+
+func (c *CPU65CE02) nzFlags(v uint8) {
+	c.setFlag(Flag_Z, v == 0)
+	c.setFlag(Flag_N, (v&0x80) != 0)
+}
+
+func (c *CPU65CE02) nzFlags16(v uint16) {
+	c.setFlag(Flag_Z, v == 0)
+	c.setFlag(Flag_N, (v&0x8000) != 0)
+}
+
+func (c *CPU65CE02) nzFlags32(v uint32) {
+	c.setFlag(Flag_Z, v == 0)
+	c.setFlag(Flag_N, (v&0x80000000) != 0)
+}
+
+// Q consists of A (bits 0-7), X, Y and Z (bits 24-31)
+func (c *CPU65CE02) getQ() uint32 {
+	return (uint32(c.Z) << 24) | (uint32(c.Y) << 16) | (uint32(c.X) << 8) | uint32(c.A)
+}
+
+func (c *CPU65CE02) setQ(v uint32) {
+	c.A = uint8(v)
+	c.X = uint8(v >> 8)
+	c.Y = uint8(v >> 16)
+	c.Z = uint8(v >> 24)
+}
+
+// -------- Memory access --------
+
+// translate returns the flat address of a 16 bit address which has been mapped by MAP. Each of the
+// eight 8K blocks can be mapped individually.
+func (c *CPU65CE02) translate(addr uint16) (uint32, bool) {
+	block := addr >> 13
+
+	if block < 4 {
+		if (c.mapLoMask & (1 << block)) != 0 {
+			return ((c.mapLoMB << 20) + c.mapLoOffset + uint32(addr)) & flatAddrMask, true
+		}
+	} else {
+		if (c.mapHiMask & (1 << (block - 4))) != 0 {
+			return ((c.mapHiMB << 20) + c.mapHiOffset + uint32(addr)) & flatAddrMask, true
+		}
+	}
+
+	return 0, false
+}
+
+func (c *CPU65CE02) load(addr uint16) uint8 {
+	return c.loadAs(addr, memory.AccessDataRead)
+}
+
+func (c *CPU65CE02) loadAs(addr uint16, kind memory.AccessKind) uint8 {
+	if flatAddr, ok := c.translate(addr); ok {
+		return c.Mem.ToLargeMemory().LoadLargeAs(flatAddr, kind)
+	}
+
+	return c.Mem.LoadAs(addr, kind)
+}
+
+func (c *CPU65CE02) store(addr uint16, b uint8) {
+	if flatAddr, ok := c.translate(addr); ok {
+		c.Mem.ToLargeMemory().StoreLarge(flatAddr, b)
+		return
+	}
+
+	c.Mem.Store(addr, b)
+}
+
+func (c *CPU65CE02) load16(addr uint16) uint16 {
+	return uint16(c.load(addr)) | (uint16(c.load(addr+1)) << 8)
+}
+
+func (c *CPU65CE02) fetch8() uint8 {
+	res := c.loadAs(c.PC, memory.AccessOperandFetch)
+	c.PC++
+
+	return res
+}
+
+func (c *CPU65CE02) fetch16() uint16 {
+	lo := uint16(c.fetch8())
+	hi := uint16(c.fetch8())
+
+	return (hi << 8) | lo
+}
+
+func (c *CPU65CE02) peek() uint8 {
+	return c.loadAs(c.PC, memory.AccessOperandFetch)
+}
+
+// operandCE is the effective address of an operand. Flat operands are 28 bit addresses which are
+// accessed through LargeMemory. All other operands are 16 bit addresses.
+type operandCE struct {
+	addr uint32
+	flat bool
+	// kind is used when the operand is read. Immediate operands are part of the instruction.
+	kind memory.AccessKind
+}
+
+func (o operandCE) offset(i uint32) operandCE {
+	if o.flat {
+		return operandCE{addr: (o.addr + i) & flatAddrMask, flat: true, kind: o.kind}
+	}
+
+	return operandCE{addr: (o.addr + i) & 0xFFFF, flat: false, kind: o.kind}
+}
+
+func (c *CPU65CE02) loadOperand(o operandCE) uint8 {
+	if o.flat {
+		return c.Mem.ToLargeMemory().LoadLargeAs(o.addr, o.kind)
+	}
+
+	return c.loadAs(uint16(o.addr), o.kind)
+}
+
+func (c *CPU65CE02) storeOperand(o operandCE, b uint8) {
+	if o.flat {
+		c.Mem.ToLargeMemory().StoreLarge(o.addr, b)
+		return
+	}
+
+	c.store(uint16(o.addr), b)
+}
+
+// loadOperandN reads a little endian value of the given number of bytes
+func (c *CPU65CE02) loadOperandN(o operandCE, numBytes uint32) uint32 {
+	var res uint32 = 0
+	var i uint32
+
+	for i = 0; i < numBytes; i++ {
+		res |= uint32(c.loadOperand(o.offset(i))) << (8 * i)
+	}
+
+	return res
+}
+
+func (c *CPU65CE02) storeOperandN(o operandCE, v uint32, numBytes uint32) {
+	var i uint32
+
+	for i = 0; i < numBytes; i++ {
+		c.storeOperand(o.offset(i), uint8(v>>(8*i)))
+	}
+}
+
+// operandWidth returns the number of bytes an accumulator instruction operates on
+func (c *CPU65CE02) operandWidth() uint32 {
+	if c.quad {
+		return 4
+	}
+
+	return 1
+}
+
+// -------- Stack --------
+
+// stackMask returns the part of the stack pointer which can change
+func (c *CPU65CE02) stackMask() uint16 {
+	if (c.Flags & Flag_E) != 0 {
+		return 0x00FF
+	}
+
+	return 0xFFFF
+}
+
+func (c *CPU65CE02) push8(v uint8) {
+	c.store(c.SP, v)
+
+	if (c.Flags & Flag_E) != 0 {
+		c.SP = (c.SP & 0xFF00) | ((c.SP - 1) & 0x00FF)
+	} else {
+		c.SP--
+	}
+}
+
+func (c *CPU65CE02) pop8() uint8 {
+	if (c.Flags & Flag_E) != 0 {
+		c.SP = (c.SP & 0xFF00) | ((c.SP + 1) & 0x00FF)
+	} else {
+		c.SP++
+	}
+
+	return c.load(c.SP)
+}
+
+func (c *CPU65CE02) push16(v uint16) {
+	c.push8(uint8(v >> 8))
+	c.push8(uint8(v))
+}
+
+func (c *CPU65CE02) pop16() uint16 {
+	lo := uint16(c.pop8())
+	hi := uint16(c.pop8())
+
+	return (hi << 8) | lo
+}
+
+// -------- Addressing modes --------
+
+// An addrModeCE fetches the operand bytes of an instruction and calculates the effective address.
+// The second return value contains additional cycles, which are caused by reading a 32 bit pointer.
+type addrModeCE func(c *CPU65CE02) (operandCE, uint64)
+
+// Base page addresses wrap around in the page selected by the B register
+func (c *CPU65CE02) baseAddr(offset uint8) uint16 {
+	return (uint16(c.B) << 8) | uint16(offset)
+}
+
+func (c *CPU65CE02) basePointer(offset uint8) uint16 {
+	lo := uint16(c.load(c.baseAddr(offset)))
+	hi := uint16(c.load(c.baseAddr(offset + 1)))
+
+	return (hi << 8) | lo
+}
+
+func logical(addr uint16) operandCE {
+	return operandCE{addr: uint32(addr), flat: false}
+}
+
+// #
+func immediateCE(c *CPU65CE02) (operandCE, uint64) {
+	res := logical(c.PC)
+	res.kind = memory.AccessOperandFetch
+	c.PC++
+
+	return res, 0
+}
+
+// bp
+func baseCE(c *CPU65CE02) (operandCE, uint64) {
+	return logical(c.baseAddr(c.fetch8())), 0
+}
+
+// bp,x
+func baseXCE(c *CPU65CE02) (operandCE, uint64) {
+	return logical(c.baseAddr(c.fetch8() + c.X)), 0
+}
+
+// bp,y
+func baseYCE(c *CPU65CE02) (operandCE, uint64) {
+	return logical(c.baseAddr(c.fetch8() + c.Y)), 0
+}
+
+// abs
+func absoluteCE(c *CPU65CE02) (operandCE, uint64) {
+	return logical(c.fetch16()), 0
+}
+
+// abs,x
+func absoluteXCE(c *CPU65CE02) (operandCE, uint64) {
+	return logical(c.fetch16() + uint16(c.X)), 0
+}
+
+// abs,y
+func absoluteYCE(c *CPU65CE02) (operandCE, uint64) {
+	return logical(c.fetch16() + uint16(c.Y)), 0
+}
+
+// (bp,x)
+func baseIdxIndirectXCE(c *CPU65CE02) (operandCE, uint64) {
+	return logical(c.basePointer(c.fetch8() + c.X)), 0
+}
+
+// (bp),y
+func baseIndirectIdxYCE(c *CPU65CE02) (operandCE, uint64) {
+	return logical(c.basePointer(c.fetch8()) + uint16(c.Y)), 0
+}
+
+// (bp),z. After the prefix NOP the base page contains a 32 bit pointer to a flat address. Quad
+// instructions do not use Z as an index as it is part of Q.
+func baseIndirectIdxZCE(c *CPU65CE02) (operandCE, uint64) {
+	offset := c.fetch8()
+	var index uint32 = uint32(c.Z)
+
+	if c.quad {
+		index = 0
+	}
+
+	if c.flat {
+		var ptr uint32 = 0
+		var i uint8
+
+		for i = 0; i < 4; i++ {
+			ptr |= uint32(c.load(c.baseAddr(offset+i))) << (8 * i)
+		}
+
+		return operandCE{addr: (ptr + index) & flatAddrMask, flat: true}, 2
+	}
+
+	return logical(c.basePointer(offset) + uint16(index)), 0
+}
+
+// (d,sp),y
+func stackIndirectIdxYCE(c *CPU65CE02) (operandCE, uint64) {
+	ptr := c.load16(c.SP + uint16(c.fetch8()))
+	return logical(ptr + uint16(c.Y)), 0
+}
+
+// -------- Instruction builders --------
+
+type operationCE func(c *CPU65CE02, o operandCE)
+
+// The three additional bytes of a quad operand take one cycle each
+func (c *CPU65CE02) quadCycles() uint64 {
+	if c.quad {
+		return 3
+	}
+
+	return 0
+}
+
+func memInstrCE(op operationCE, mode addrModeCE, cycles uint64) execFuncCE {
+	return func(c *CPU65CE02) (uint64, bool) {
+		o, additional := mode(c)
+		op(c, o)
+
+		return cycles + additional + c.quadCycles(), false
+	}
+}
+
+// modifyInstrCE creates a read-modify-write instruction. Quad operands have to be read and written.
+func modifyInstrCE(modifier func(c *CPU65CE02, v uint32, numBytes uint32) uint32, mode addrModeCE, cycles uint64) execFuncCE {
+	return func(c *CPU65CE02) (uint64, bool) {
+		o, additional := mode(c)
+		numBytes := c.operandWidth()
+		c.storeOperandN(o, modifier(c, c.loadOperandN(o, numBytes), numBytes), numBytes)
+
+		return cycles + additional + 2*c.quadCycles(), false
+	}
+}
+
+// accuInstrCE creates an instruction which modifies the accumulator or Q
+func accuInstrCE(modifier func(c *CPU65CE02, v uint32, numBytes uint32) uint32) execFuncCE {
+	return func(c *CPU65CE02) (uint64, bool) {
+		if c.quad {
+			c.setQ(modifier(c, c.getQ(), 4))
+		} else {
+			c.A = uint8(modifier(c, uint32(c.A), 1))
+		}
+
+		return 1, false
+	}
+}
+
+// -------- Opcode table --------
+
+type modeEntryCE struct {
+	offset byte
+	mode   addrModeCE
+	cycles uint64
+}
+
+func (c *CPU65CE02) initOpCodes() {
+	// The addressing modes of ORA, AND, EOR, ADC, STA, LDA, CMP and SBC, relative to the opcode of (bp,x)
+	aluModes := []modeEntryCE{
+		{0x00, baseIdxIndirectXCE, 5},
+		{0x04, baseCE, 3},
+		{0x08, immediateCE, 2},
+		{0x0C, absoluteCE, 4},
+		{0x10, baseIndirectIdxYCE, 5},
+		{0x11, baseIndirectIdxZCE, 5},
+		{0x14, baseXCE, 3},
+		{0x18, absoluteYCE, 4},
+		{0x1C, absoluteXCE, 4},
+	}
+
+	aluOps := map[byte]operationCE{
+		0x01: (*CPU65CE02).ora,
+		0x21: (*CPU65CE02).and,
+		0x41: (*CPU65CE02).eor,
+		0x61: (*CPU65CE02).adc,
+		0x81: (*CPU65CE02).sta,
+		0xA1: (*CPU65CE02).lda,
+		0xC1: (*CPU65CE02).cmp,
+		0xE1: (*CPU65CE02).sbc,
+	}
+
+	for base, op := range aluOps {
+		for _, m := range aluModes {
+			// $89 is BIT #
+			if (base == 0x81) && (m.offset == 0x08) {
+				continue
+			}
+
+			c.opCodes[base+m.offset] = memInstrCE(op, m.mode, m.cycles)
+		}
+	}
+
+	c.opCodes[0x82] = memInstrCE((*CPU65CE02).sta, stackIndirectIdxYCE, 6)
+	c.opCodes[0xE2] = memInstrCE((*CPU65CE02).lda, stackIndirectIdxYCE, 6)
+
+	// LDX, LDY, LDZ
+	c.opCodes[0xA2] = memInstrCE((*CPU65CE02).ldx, immediateCE, 2)
+	c.opCodes[0xA6] = memInstrCE((*CPU65CE02).ldx, baseCE, 3)
+	c.opCodes[0xAE] = memInstrCE((*CPU65CE02).ldx, absoluteCE, 4)
+	c.opCodes[0xB6] = memInstrCE((*CPU65CE02).ldx, baseYCE, 3)
+	c.opCodes[0xBE] = memInstrCE((*CPU65CE02).ldx, absoluteYCE, 4)
+	c.opCodes[0xA0] = memInstrCE((*CPU65CE02).ldy, immediateCE, 2)
+	c.opCodes[0xA4] = memInstrCE((*CPU65CE02).ldy, baseCE, 3)
+	c.opCodes[0xAC] = memInstrCE((*CPU65CE02).ldy, absoluteCE, 4)
+	c.opCodes[0xB4] = memInstrCE((*CPU65CE02).ldy, baseXCE, 3)
+	c.opCodes[0xBC] = memInstrCE((*CPU65CE02).ldy, absoluteXCE, 4)
+	c.opCodes[0xA3] = memInstrCE((*CPU65CE02).ldz, immediateCE, 2)
+	c.opCodes[0xAB] = memInstrCE((*CPU65CE02).ldz, absoluteCE, 4)
+	c.opCodes[0xBB] = memInstrCE((*CPU65CE02).ldz, absoluteXCE, 4)
+
+	// STX, STY, STZ. STZ stores the Z register.
+	c.opCodes[0x86] = memInstrCE((*CPU65CE02).stx, baseCE, 3)
+	c.opCodes[0x8E] = memInstrCE((*CPU65CE02).stx, absoluteCE, 4)
+	c.opCodes[0x96] = memInstrCE((*CPU65CE02).stx, baseYCE, 3)
+	c.opCodes[0x9B] = memInstrCE((*CPU65CE02).stx, absoluteYCE, 4)
+	c.opCodes[0x84] = memInstrCE((*CPU65CE02).sty, baseCE, 3)
+	c.opCodes[0x8C] = memInstrCE((*CPU65CE02).sty, absoluteCE, 4)
+	c.opCodes[0x94] = memInstrCE((*CPU65CE02).sty, baseXCE, 3)
+	c.opCodes[0x8B] = memInstrCE((*CPU65CE02).sty, absoluteXCE, 4)
+	c.opCodes[0x64] = memInstrCE((*CPU65CE02).stz, baseCE, 3)
+	c.opCodes[0x74] = memInstrCE((*CPU65CE02).stz, baseXCE, 3)
+	c.opCodes[0x9C] = memInstrCE((*CPU65CE02).stz, absoluteCE, 4)
+	c.opCodes[0x9E] = memInstrCE((*CPU65CE02).stz, absoluteXCE, 4)
+
+	// CPX, CPY, CPZ
+	c.opCodes[0xE0] = memInstrCE((*CPU65CE02).cpx, immediateCE, 2)
+	c.opCodes[0xE4] = memInstrCE((*CPU65CE02).cpx, baseCE, 3)
+	c.opCodes[0xEC] = memInstrCE((*CPU65CE02).cpx, absoluteCE, 4)
+	c.opCodes[0xC0] = memInstrCE((*CPU65CE02).cpy, immediateCE, 2)
+	c.opCodes[0xC4] = memInstrCE((*CPU65CE02).cpy, baseCE, 3)
+	c.opCodes[0xCC] = memInstrCE((*CPU65CE02).cpy, absoluteCE, 4)
+	c.opCodes[0xC2] = memInstrCE((*CPU65CE02).cpz, immediateCE, 2)
+	c.opCodes[0xD4] = memInstrCE((*CPU65CE02).cpz, baseCE, 3)
+	c.opCodes[0xDC] = memInstrCE((*CPU65CE02).cpz, absoluteCE, 4)
+
+	// BIT
+	c.opCodes[0x89] = memInstrCE((*CPU65CE02).bitImmediate, immediateCE, 2)
+	c.opCodes[0x24] = memInstrCE((*CPU65CE02).bit, baseCE, 3)
+	c.opCodes[0x2C] = memInstrCE((*CPU65CE02).bit, absoluteCE, 4)
+	c.opCodes[0x34] = memInstrCE((*CPU65CE02).bit, baseXCE, 3)
+	c.opCodes[0x3C] = memInstrCE((*CPU65CE02).bit, absoluteXCE, 4)
+
+	// ASL, ROL, LSR, ROR, DEC, INC
+	modifiers := map[byte]func(c *CPU65CE02, v uint32, numBytes uint32) uint32{
+		0x06: (*CPU65CE02).asl,
+		0x26: (*CPU65CE02).rol,
+		0x46: (*CPU65CE02).lsr,
+		0x66: (*CPU65CE02).ror,
+		0xC6: (*CPU65CE02).dec,
+		0xE6: (*CPU65CE02).inc,
+	}
+
+	for base, modifier := range modifiers {
+		c.opCodes[base] = modifyInstrCE(modifier, baseCE, 4)
+		c.opCodes[base+0x08] = modifyInstrCE(modifier, absoluteCE, 5)
+		c.opCodes[base+0x10] = modifyInstrCE(modifier, baseXCE, 4)
+		c.opCodes[base+0x18] = modifyInstrCE(modifier, absoluteXCE, 5)
+	}
+
+	c.opCodes[0x0A] = accuInstrCE((*CPU65CE02).asl)
+	c.opCodes[0x2A] = accuInstrCE((*CPU65CE02).rol)
+	c.opCodes[0x4A] = accuInstrCE((*CPU65CE02).lsr)
+	c.opCodes[0x6A] = accuInstrCE((*CPU65CE02).ror)
+	c.opCodes[0x3A] = accuInstrCE((*CPU65CE02).dec)
+	c.opCodes[0x1A] = accuInstrCE((*CPU65CE02).inc)
+
+	// ASR
+	c.opCodes[0x43] = accuInstrCE((*CPU65CE02).asr)
+	c.opCodes[0x44] = modifyInstrCE((*CPU65CE02).asr, baseCE, 4)
+	c.opCodes[0x54] = modifyInstrCE((*CPU65CE02).asr, baseXCE, 4)
+
+	// TSB, TRB
+	c.opCodes[0x04] = modifyInstrCE((*CPU65CE02).tsb, baseCE, 4)
+	c.opCodes[0x0C] = modifyInstrCE((*CPU65CE02).tsb, absoluteCE, 5)
+	c.opCodes[0x14] = modifyInstrCE((*CPU65CE02).trb, baseCE, 4)
+	c.opCodes[0x1C] = modifyInstrCE((*CPU65CE02).trb, absoluteCE, 5)
+
+	// Word instructions
+	c.opCodes[0xE3] = (*CPU65CE02).inw
+	c.opCodes[0xC3] = (*CPU65CE02).dew
+	c.opCodes[0xCB] = (*CPU65CE02).asw
+	c.opCodes[0xEB] = (*CPU65CE02).row
+
+	// RMB, SMB, BBR, BBS
+	var bit uint8
+	for bit = 0; bit < 8; bit++ {
+		c.opCodes[0x07+(bit<<4)] = rmbCE(bit)
+		c.opCodes[0x87+(bit<<4)] = smbCE(bit)
+		c.opCodes[0x0F+(bit<<4)] = bbCE(bit, false)
+		c.opCodes[0x8F+(bit<<4)] = bbCE(bit, true)
+	}
+
+	// Branches with 8 and 16 bit offsets
+	branches := []struct {
+		opCode byte
+		flag   uint8
+		set    bool
+	}{
+		{0x10, Flag_N, false},
+		{0x30, Flag_N, true},
+		{0x50, Flag_V, false},
+		{0x70, Flag_V, true},
+		{0x90, Flag_C, false},
+		{0xB0, Flag_C, true},
+		{0xD0, Flag_Z, false},
+		{0xF0, Flag_Z, true},
+	}
+
+	for _, b := range branches {
+		c.opCodes[b.opCode] = branchInstrCE(b.flag, b.set, false)
+		c.opCodes[b.opCode+3] = branchInstrCE(b.flag, b.set, true)
+	}
+
+	c.opCodes[0x80] = braInstrCE(false)
+	c.opCodes[0x83] = braInstrCE(true)
+	c.opCodes[0x63] = (*CPU65CE02).bsr
+
+	// Jumps and subroutines
+	c.opCodes[0x4C] = (*CPU65CE02).jmpAbsolute
+	c.opCodes[0x6C] = (*CPU65CE02).jmpIndirect
+	c.opCodes[0x7C] = (*CPU65CE02).jmpIdxIndirectX
+	c.opCodes[0x20] = (*CPU65CE02).jsrAbsolute
+	c.opCodes[0x22] = (*CPU65CE02).jsrIndirect
+	c.opCodes[0x23] = (*CPU65CE02).jsrIdxIndirectX
+	c.opCodes[0x60] = (*CPU65CE02).rts
+	c.opCodes[0x62] = (*CPU65CE02).rtsImmediate
+	c.opCodes[0x40] = (*CPU65CE02).rti
+
+	// Stack
+	c.opCodes[0x48] = (*CPU65CE02).pha
+	c.opCodes[0x68] = (*CPU65CE02).pla
+	c.opCodes[0xDA] = (*CPU65CE02).phx
+	c.opCodes[0xFA] = (*CPU65CE02).plx
+	c.opCodes[0x5A] = (*CPU65CE02).phy
+	c.opCodes[0x7A] = (*CPU65CE02).ply
+	c.opCodes[0xDB] = (*CPU65CE02).phz
+	c.opCodes[0xFB] = (*CPU65CE02).plz
+	c.opCodes[0x08] = (*CPU65CE02).php
+	c.opCodes[0x28] = (*CPU65CE02).plp
+	c.opCodes[0xF4] = (*CPU65CE02).phwImmediate
+	c.opCodes[0xFC] = (*CPU65CE02).phwAbsolute
+
+	// Transfers
+	c.opCodes[0xAA] = (*CPU65CE02).tax
+	c.opCodes[0xA8] = (*CPU65CE02).tay
+	c.opCodes[0x4B] = (*CPU65CE02).taz
+	c.opCodes[0x5B] = (*CPU65CE02).tab
+	c.opCodes[0x8A] = (*CPU65CE02).txa
+	c.opCodes[0x98] = (*CPU65CE02).tya
+	c.opCodes[0x6B] = (*CPU65CE02).tza
+	c.opCodes[0x7B] = (*CPU65CE02).tba
+	c.opCodes[0xBA] = (*CPU65CE02).tsx
+	c.opCodes[0x9A] = (*CPU65CE02).txs
+	c.opCodes[0x0B] = (*CPU65CE02).tsy
+	c.opCodes[0x2B] = (*CPU65CE02).tys
+
+	// Increment and decrement of index registers
+	c.opCodes[0xE8] = (*CPU65CE02).inx
+	c.opCodes[0xC8] = (*CPU65CE02).iny
+	c.opCodes[0x1B] = (*CPU65CE02).inz
+	c.opCodes[0xCA] = (*CPU65CE02).dex
+	c.opCodes[0x88] = (*CPU65CE02).dey
+	c.opCodes[0x3B] = (*CPU65CE02).dez
+
+	// Flags
+	c.opCodes[0x18] = flagInstrCE(Flag_C, false)
+	c.opCodes[0x38] = flagInstrCE(Flag_C, true)
+	c.opCodes[0x58] = flagInstrCE(Flag_I, false)
+	c.opCodes[0x78] = flagInstrCE(Flag_I, true)
+	c.opCodes[0xB8] = flagInstrCE(Flag_V, false)
+	c.opCodes[0xD8] = flagInstrCE(Flag_D, false)
+	c.opCodes[0xF8] = flagInstrCE(Flag_D, true)
+	c.opCodes[0x02] = flagInstrCE(Flag_E, false)
+	c.opCodes[0x03] = flagInstrCE(Flag_E, true)
+
+	// Miscellaneous
+	c.opCodes[0x42] = (*CPU65CE02).neg
+	c.opCodes[0xEA] = (*CPU65CE02).nop
+	c.opCodes[0x5C] = (*CPU65CE02).mapInstr
+	c.opCodes[0x00] = (*CPU65CE02).brk
+}
@@ -0,0 +1,247 @@
+package cpu
+
+import (
+	"6502profiler/memory"
+	"testing"
+)
+
+func newTest65CE02(model CpuModel, testProg []byte) *CPU65CE02 {
+	cpu := New65CE02(model)
+	// The F256 memory model provides 1 MB of memory which can be accessed via flat addresses
+	cpu.Init(memory.NewF56JrMemory(false))
+	cpu.CopyToMem(testProg, UnitProgStart)
+
+	return cpu
+}
+
+func run65CE02(t *testing.T, cpu *CPU65CE02) {
+	err := cpu.Run(UnitProgStart)
+	if err != nil {
+		t.Fatalf("65CE02 program failed: %v", err)
+	}
+}
+
+func TestOpCodeTable65CE02Complete(t *testing.T) {
+	cpu := New65CE02(Model45GS02)
+
+	for i, j := range cpu.opCodes {
+		if j == nil {
+			t.Fatalf("Opcode %02X not implemented", i)
+		}
+	}
+}
+
+func TestLdzStz(t *testing.T) {
+	// ldz #$42
+	// stz $1000
+	// brk
+	cpu := newTest65CE02(Model65CE02, []byte{0xA3, 0x42, 0x9C, 0x00, 0x10, 0x00})
+	run65CE02(t, cpu)
+
+	if cpu.Mem.Load(0x1000) != 0x42 {
+		t.Fatalf("STZ did not store Z: %02X", cpu.Mem.Load(0x1000))
+	}
+
+	// ldz # (2) + stz abs (4)
+	if cpu.NumCycles() != 6 {
+		t.Fatalf("Wrong number of cycles: %d", cpu.NumCycles())
+	}
+}
+
+func TestInwDew(t *testing.T) {
+	// inw $10
+	// dew $12
+	// brk
+	cpu := newTest65CE02(Model65CE02, []byte{0xE3, 0x10, 0xC3, 0x12, 0x00})
+	cpu.Mem.Store(0x0010, 0xFF)
+	cpu.Mem.Store(0x0011, 0x12)
+	cpu.Mem.Store(0x0012, 0x00)
+	cpu.Mem.Store(0x0013, 0x80)
+	run65CE02(t, cpu)
+
+	if (cpu.Mem.Load(0x0010) != 0x00) || (cpu.Mem.Load(0x0011) != 0x13) {
+		t.Fatal("INW failed")
+	}
+
+	if (cpu.Mem.Load(0x0012) != 0xFF) || (cpu.Mem.Load(0x0013) != 0x7F) {
+		t.Fatal("DEW failed")
+	}
+
+	if (cpu.Flags & Flag_N) != 0 {
+		t.Fatal("N flag not determined by 16 bit result")
+	}
+}
+
+func TestBasePageRegister(t *testing.T) {
+	// lda #$20
+	// tab
+	// lda #$33
+	// sta $05
+	// brk
+	cpu := newTest65CE02(Model65CE02, []byte{0xA9, 0x20, 0x5B, 0xA9, 0x33, 0x85, 0x05, 0x00})
+	run65CE02(t, cpu)
+
+	if cpu.Mem.Load(0x2005) != 0x33 {
+		t.Fatal("base page not selected by B")
+	}
+}
+
+func TestExtendedStack(t *testing.T) {
+	// cle
+	// ldy #$20
+	// tys
+	// ldx #$00
+	// txs
+	// lda #$55
+	// pha
+	// brk
+	cpu := newTest65CE02(Model65CE02, []byte{0x02, 0xA0, 0x20, 0x2B, 0xA2, 0x00, 0x9A, 0xA9, 0x55, 0x48, 0x00})
+	run65CE02(t, cpu)
+
+	if (cpu.SP != 0x1FFF) || (cpu.Mem.Load(0x2000) != 0x55) {
+		t.Fatalf("16 bit stack pointer did not cross page: SP=%04X", cpu.SP)
+	}
+}
+
+func TestBranchLong(t *testing.T) {
+	// sec
+	// bcs +$0100 (relative to the last byte of the instruction)
+	cpu := newTest65CE02(Model65CE02, []byte{0x38, 0xB3, 0x00, 0x01})
+	// lda #$01
+	// brk
+	cpu.CopyToMem([]byte{0xA9, 0x01, 0x00}, UnitProgStart+3+0x0100)
+	run65CE02(t, cpu)
+
+	if cpu.A != 0x01 {
+		t.Fatal("16 bit branch did not reach its target")
+	}
+
+	// sec (1) + bcs taken (4) + lda # (2)
+	if cpu.NumCycles() != 7 {
+		t.Fatalf("Wrong number of cycles: %d", cpu.NumCycles())
+	}
+}
+
+func TestBsrRts(t *testing.T) {
+	// bsr +$0010
+	// inx
+	// brk
+	cpu := newTest65CE02(Model65CE02, []byte{0x63, 0x10, 0x00, 0xE8, 0x00})
+	// ldx #$41
+	// rts
+	cpu.CopyToMem([]byte{0xA2, 0x41, 0x60}, UnitProgStart+2+0x0010)
+	run65CE02(t, cpu)
+
+	if cpu.X != 0x42 {
+		t.Fatalf("BSR/RTS failed: X=%02X", cpu.X)
+	}
+}
+
+func TestQuadLoadStore(t *testing.T) {
+	// neg
+	// neg
+	// lda $1000
+	// neg
+	// neg
+	// sta $2000
+	// brk
+	cpu := newTest65CE02(Model45GS02, []byte{0x42, 0x42, 0xAD, 0x00, 0x10, 0x42, 0x42, 0x8D, 0x00, 0x20, 0x00})
+	cpu.CopyToMem([]byte{0x78, 0x56, 0x34, 0x92}, 0x1000)
+	run65CE02(t, cpu)
+
+	if cpu.getQ() != 0x92345678 {
+		t.Fatalf("LDQ failed: Q=%08X", cpu.getQ())
+	}
+
+	data, _ := cpu.CopyFromMem(0x2000, 4)
+	if (data[0] != 0x78) || (data[1] != 0x56) || (data[2] != 0x34) || (data[3] != 0x92) {
+		t.Fatalf("STQ failed: %v", data)
+	}
+
+	if (cpu.Flags & Flag_N) == 0 {
+		t.Fatal("N flag not determined by bit 31")
+	}
+
+	// ldq abs (2 + 4 + 3) + stq abs (2 + 4 + 3)
+	if cpu.NumCycles() != 18 {
+		t.Fatalf("Wrong number of cycles: %d", cpu.NumCycles())
+	}
+}
+
+func TestQuadAdcCarry(t *testing.T) {
+	// clc
+	// neg
+	// neg
+	// adc $1000
+	// brk
+	cpu := newTest65CE02(Model45GS02, []byte{0x18, 0x42, 0x42, 0x6D, 0x00, 0x10, 0x00})
+	cpu.CopyToMem([]byte{0x01, 0x00, 0x00, 0x00}, 0x1000)
+	cpu.setQ(0xFFFFFFFF)
+	run65CE02(t, cpu)
+
+	if (cpu.getQ() != 0) || ((cpu.Flags & Flag_C) == 0) || ((cpu.Flags & Flag_Z) == 0) {
+		t.Fatalf("ADCQ failed: Q=%08X flags=%02X", cpu.getQ(), cpu.Flags)
+	}
+}
+
+func TestNegIsNotPrefixOn65CE02(t *testing.T) {
+	// lda #$01
+	// neg
+	// neg
+	// brk
+	cpu := newTest65CE02(Model65CE02, []byte{0xA9, 0x01, 0x42, 0x42, 0x00})
+	run65CE02(t, cpu)
+
+	if cpu.A != 0x01 {
+		t.Fatalf("NEG NEG failed: A=%02X", cpu.A)
+	}
+}
+
+func TestFlatAddressing(t *testing.T) {
+	// ldz #$01
+	// nop
+	// lda ($10),z
+	// brk
+	cpu := newTest65CE02(Model45GS02, []byte{0xA3, 0x01, 0xEA, 0xB2, 0x10, 0x00})
+	cpu.CopyToMem([]byte{0x00, 0x40, 0x05, 0x00}, 0x0010)
+	cpu.Mem.ToLargeMemory().StoreLarge(0x054001, 0x99)
+	run65CE02(t, cpu)
+
+	if cpu.A != 0x99 {
+		t.Fatalf("flat addressing failed: A=%02X", cpu.A)
+	}
+}
+
+func TestMap(t *testing.T) {
+	// lda #$00
+	// ldx #$21 (map block 1, i.e. $2000-$3FFF, offset $1xx00)
+	// ldy #$00
+	// ldz #$00
+	// map
+	// eom
+	// lda $2000
+	// brk
+	cpu := newTest65CE02(Model45GS02, []byte{0xA9, 0x00, 0xA2, 0x21, 0xA0, 0x00, 0xA3, 0x00, 0x5C, 0xEA, 0xAD, 0x00, 0x20, 0x00})
+	cpu.Mem.ToLargeMemory().StoreLarge(0x012000, 0x77)
+	run65CE02(t, cpu)
+
+	if cpu.A != 0x77 {
+		t.Fatalf("MAP failed: A=%02X", cpu.A)
+	}
+}
+
+func TestRegisterAccess65CE02(t *testing.T) {
+	cpu := New65CE02(Model65CE02)
+
+	if !cpu.SetRegister(RegZ, 0x12) || !cpu.SetRegister(RegB, 0x34) {
+		t.Fatal("unable to set Z or B")
+	}
+
+	if (cpu.Z != 0x12) || (cpu.B != 0x34) {
+		t.Fatal("wrong registers set")
+	}
+
+	if _, ok := cpu.GetRegister(RegDBR); ok {
+		t.Fatal("65816 register exists in 65CE02")
+	}
+}
@@ -0,0 +1,171 @@
+package cpu
+
+import (
+	"6502profiler/memory"
+	"testing"
+)
+
+func hasOpCode(model CpuModel, opCode byte) bool {
+	_, ok := New6502(model).opCodes[opCode]
+	return ok
+}
+
+func TestOpCodeTables(t *testing.T) {
+	if !hasOpCode(ModelW65C02S, 0xDB) || !hasOpCode(ModelW65C02S, 0xCB) {
+		t.Fatal("STP or WAI missing in W65C02S")
+	}
+
+	if !hasOpCode(ModelW65C02S, 0x0F) {
+		t.Fatal("BBR0 missing in W65C02S")
+	}
+
+	for _, m := range []CpuModel{Model65C02, ModelR65C02} {
+		if !hasOpCode(m, 0x0F) || !hasOpCode(m, 0x87) {
+			t.Fatalf("Rockwell bit instructions missing in model %d", m)
+		}
+
+		if hasOpCode(m, 0xDB) || hasOpCode(m, 0xCB) {
+			t.Fatalf("STP or WAI present in model %d", m)
+		}
+	}
+
+	if !hasOpCode(Model65SC02, 0x80) {
+		t.Fatal("BRA missing in 65SC02")
+	}
+
+	for _, o := range []byte{0x0F, 0x8F, 0x07, 0x87, 0xDB, 0xCB} {
+		if hasOpCode(Model65SC02, o) {
+			t.Fatalf("Opcode %02X present in 65SC02", o)
+		}
+	}
+
+	if !hasOpCode(Model2A03, 0x80) || !hasOpCode(Model2A03, 0xA7) {
+		t.Fatal("Undocumented opcodes missing in 2A03")
+	}
+}
+
+func TestADC2A03IgnoresDecimal(t *testing.T) {
+	arranger := func(c *CPU6502) {
+		c.A = 0x09
+		c.Flags = Flag_D
+	}
+
+	verifier := func(c *CPU6502) bool {
+		return c.A == 0x0A
+	}
+
+	// adc #$01
+	// brk
+	c := InstructionTestCase{
+		model:           Model2A03,
+		testProg:        []byte{0x69, 0x01, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "ADC 2A03",
+	}
+
+	testSingleInstructionWithCase(t, c)
+
+	// The decimal flag itself still works
+	verifier = func(c *CPU6502) bool {
+		return (c.A == 0x10) && ((c.Flags & Flag_D) != 0)
+	}
+
+	c.model = Model6502
+	c.verifier = verifier
+	c.instructionName = "ADC 6502 decimal"
+
+	testSingleInstructionWithCase(t, c)
+}
+
+func TestARR2A03IgnoresDecimal(t *testing.T) {
+	arranger := func(c *CPU6502) {
+		c.A = 0xFF
+		c.Flags = Flag_D
+	}
+
+	verifier := func(c *CPU6502) bool {
+		return c.A == 0x07
+	}
+
+	// arr #$0F
+	// brk
+	c := InstructionTestCase{
+		model:           Model2A03,
+		testProg:        []byte{0x6B, 0x0F, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "ARR 2A03",
+	}
+
+	testSingleInstructionWithCase(t, c)
+
+	// The NMOS 6510 corrects the low nibble in decimal mode
+	verifier = func(c *CPU6502) bool {
+		return c.A == 0x0D
+	}
+
+	c.model = Model6510
+	c.verifier = verifier
+	c.instructionName = "ARR 6510 decimal"
+
+	testSingleInstructionWithCase(t, c)
+}
+
+func TestSTP(t *testing.T) {
+	verifier := func(c *CPU6502) bool {
+		return (c.PC == UnitProgStart+1) && (c.X == 0)
+	}
+
+	// stp
+	// inx
+	// brk
+	c := InstructionTestCase{
+		model:           ModelW65C02S,
+		testProg:        []byte{0xDB, 0xE8, 0x00},
+		arranger:        nil,
+		verifier:        verifier,
+		instructionName: "STP",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
+
+func TestWAIMaskedIrq(t *testing.T) {
+	arranger := func(c *CPU6502) {
+		c.Flags = Flag_I
+		c.Interrupts.RaiseIrq()
+	}
+
+	// A masked IRQ ends the wait but is not serviced
+	verifier := func(c *CPU6502) bool {
+		return (c.X == 1) && (c.PC == UnitProgStart+3)
+	}
+
+	// wai
+	// inx
+	// brk
+	c := InstructionTestCase{
+		model:           ModelW65C02S,
+		testProg:        []byte{0xCB, 0xE8, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "WAI",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
+
+func TestWAIWithoutInterrupt(t *testing.T) {
+	cpu := New6502(ModelW65C02S)
+	cpu.Init(memory.NewLinearMemory(8192))
+
+	// wai
+	// brk
+	cpu.CopyToMem([]byte{0xCB, 0x00}, UnitProgStart)
+
+	err := cpu.Run(UnitProgStart)
+	if err == nil {
+		t.Fatal("WAI without pending interrupt did not fail")
+	}
+}
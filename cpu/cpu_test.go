@@ -84,3 +84,78 @@ func testSingleInstructionWithCase(t *testing.T, c InstructionTestCase) {
 		t.Fatalf("%s does not work: %v", c.instructionName, err)
 	}
 }
+
+func TestAccessKinds(t *testing.T) {
+	// lda #$05
+	// sta $0900
+	// lda $0900
+	// brk
+	prog := []byte{0xA9, 0x05, 0x8D, 0x00, 0x09, 0xAD, 0x00, 0x09, 0x00}
+
+	for _, model := range []CpuModel{Model6502, Model65C02} {
+		cpu := New6502(model)
+		cpu.Init(memory.NewLinearMemory(8192))
+
+		if err := cpu.CopyToMem(prog, UnitProgStart); err != nil {
+			t.Fatal(err)
+		}
+
+		cpu.Mem.ClearStatistics()
+
+		if err := cpu.Run(UnitProgStart); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := map[uint16]memory.AccessStats{
+			0x0800: {memory.AccessOpcodeFetch: 1},
+			0x0801: {memory.AccessOperandFetch: 1},
+			0x0802: {memory.AccessOpcodeFetch: 1},
+			0x0803: {memory.AccessOperandFetch: 1},
+			0x0805: {memory.AccessOpcodeFetch: 1},
+			0x0806: {memory.AccessOperandFetch: 1},
+			0x0808: {memory.AccessOpcodeFetch: 1},
+			0x0900: {memory.AccessDataRead: 1, memory.AccessDataWrite: 1},
+		}
+
+		for addr, stats := range expected {
+			if res := cpu.Mem.GetAccessStatistics(addr); res != stats {
+				t.Fatalf("model %d: wrong access statistics for $%04X: %v", model, addr, res)
+			}
+		}
+	}
+}
+
+func TestBranchOperandFetch(t *testing.T) {
+	//     ldx #10
+	// loop
+	//     dex
+	//     bne loop
+	//     brk
+	prog := []byte{0xA2, 0x0A, 0xCA, 0xD0, 0xFD, 0x00}
+
+	cpu := New6502(Model6502)
+	cpu.Init(memory.NewLinearMemory(8192))
+
+	if err := cpu.CopyToMem(prog, UnitProgStart); err != nil {
+		t.Fatal(err)
+	}
+
+	cpu.Mem.ClearStatistics()
+
+	if err := cpu.Run(UnitProgStart); err != nil {
+		t.Fatal(err)
+	}
+
+	// The operand is also fetched when the branch is not taken
+	expected := map[uint16]memory.AccessStats{
+		0x0803: {memory.AccessOpcodeFetch: 10},
+		0x0804: {memory.AccessOperandFetch: 10},
+		0x0805: {memory.AccessOpcodeFetch: 1},
+	}
+
+	for addr, stats := range expected {
+		if res := cpu.Mem.GetAccessStatistics(addr); res != stats {
+			t.Fatalf("wrong access statistics for $%04X: %v", addr, res)
+		}
+	}
+}
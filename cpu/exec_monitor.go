@@ -0,0 +1,279 @@
+package cpu
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NumTracedPCs is the number of executed instructions whose addresses are reported when an
+// execution limit is reached
+const NumTracedPCs = 16
+
+// ExecutionLimits restricts the number of clock cycles and instructions a single call of RunExt may use.
+// A value of zero means that there is no limit.
+type ExecutionLimits struct {
+	MaxCycles       uint64
+	MaxInstructions uint64
+}
+
+// CallFrame describes an active subroutine call. Addresses of the 65816 include the program bank.
+type CallFrame struct {
+	CallSite uint32
+	Target   uint32
+	// Value of the stack pointer after the return address has been pushed
+	sp uint16
+}
+
+// LimitError is returned by RunExt if the program exceeds its execution limits or if it is caught
+// in a loop which can not be left
+type LimitError struct {
+	Reason    string
+	Registers string
+	LastPCs   []uint32
+	CallStack []CallFrame
+	// If Disassemble is set the last executed instructions are listed with their disassembly
+	Disassemble func(addr uint32) string
+}
+
+func (e *LimitError) Error() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "execution stopped: %s\n", e.Reason)
+	fmt.Fprintf(&b, "Registers: %s\n", e.Registers)
+
+	b.WriteString("Last executed instructions:")
+	for _, j := range e.LastPCs {
+		if e.Disassemble != nil {
+			fmt.Fprintf(&b, "\n    %s", e.Disassemble(j))
+		} else {
+			fmt.Fprintf(&b, " %s", formatAddress(j))
+		}
+	}
+	b.WriteString("\n")
+
+	b.WriteString("Call stack:")
+	if len(e.CallStack) == 0 {
+		b.WriteString(" empty")
+	}
+
+	for i := len(e.CallStack) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "\n    %s called from %s", formatAddress(e.CallStack[i].Target), formatAddress(e.CallStack[i].CallSite))
+	}
+
+	return b.String()
+}
+
+func formatAddress(addr uint32) string {
+	if addr > 0xFFFF {
+		return fmt.Sprintf("$%02X:%04X", addr>>16, addr&0xFFFF)
+	}
+
+	return fmt.Sprintf("$%04X", addr)
+}
+
+// formatRegisters returns a description of all registers which exist in the given CPU
+func formatRegisters(p Processor) string {
+	regs := []struct {
+		r    Register
+		name string
+	}{
+		{RegA, "A"}, {RegC, "C"}, {RegX, "X"}, {RegY, "Y"}, {RegZ, "Z"}, {RegB, "B"}, {RegSP, "SP"},
+		{RegD, "D"}, {RegDBR, "DBR"}, {RegPBR, "PBR"},
+	}
+
+	res := []string{}
+
+	for _, j := range regs {
+		if v, ok := p.GetRegister(j.r); ok {
+			res = append(res, fmt.Sprintf("%s=$%02X", j.name, v))
+		}
+	}
+
+	res = append(res, fmt.Sprintf("PC=$%04X", p.GetPC()))
+
+	flags, _ := p.GetRegister(RegFlags)
+	names := p.FlagNames()
+	flagStr := ""
+	var i uint
+
+	for i = 0; i < 8; i++ {
+		if (flags & (0x80 >> i)) != 0 {
+			flagStr += string(names[i])
+		} else {
+			flagStr += "."
+		}
+	}
+
+	res = append(res, "Flags="+flagStr)
+
+	return strings.Join(res, " ")
+}
+
+// ExecState is the part of the state of a CPU which is neither stored in its registers nor in its
+// memory. Together with these it allows to return to an earlier point of a program run.
+type ExecState struct {
+	Cycles uint64
+	// Number of instructions executed in the current program run including the current one
+	Instructions uint64
+	CallStack    []CallFrame
+}
+
+// executionMonitor is used by all CPU cores to enforce the execution limits. It records the addresses
+// of the most recently executed instructions and the active subroutine calls.
+type executionMonitor struct {
+	limits       ExecutionLimits
+	instructions uint64
+	startCycles  uint64
+	currentPC    uint32
+	pcs          [NumTracedPCs]uint32
+	pcPos        int
+	pcCount      int
+	callStack    []CallFrame
+	termination  TerminationConditions
+	entrySP      uint16
+	stopped      bool
+	exited       bool
+	exitCode     uint8
+}
+
+// start is called at the beginning of RunExt. The limits apply to each call of RunExt. sp is
+// the value of the stack pointer when the routine at the start address is entered.
+func (m *executionMonitor) start(cycleCount uint64, sp uint16) {
+	m.instructions = 0
+	m.entrySP = sp
+	m.stopped = false
+	m.exited = false
+	m.exitCode = 0
+	m.startCycles = cycleCount
+	m.pcPos = 0
+	m.pcCount = 0
+	m.callStack = m.callStack[:0]
+}
+
+// beforeInstruction is called before an instruction at the given address is executed. It returns
+// a non empty reason if a limit has been reached.
+func (m *executionMonitor) beforeInstruction(pc uint32, cycleCount uint64) string {
+	if (m.limits.MaxCycles != 0) && ((cycleCount - m.startCycles) >= m.limits.MaxCycles) {
+		return fmt.Sprintf("cycle limit of %d clock cycles reached", m.limits.MaxCycles)
+	}
+
+	if (m.limits.MaxInstructions != 0) && (m.instructions >= m.limits.MaxInstructions) {
+		return fmt.Sprintf("instruction limit of %d instructions reached", m.limits.MaxInstructions)
+	}
+
+	m.instructions++
+	m.currentPC = pc
+	m.pcs[m.pcPos] = pc
+	m.pcPos = (m.pcPos + 1) % NumTracedPCs
+	if m.pcCount < NumTracedPCs {
+		m.pcCount++
+	}
+
+	return ""
+}
+
+// call records a subroutine call to target. sp is the value of the stack pointer after the return
+// address has been pushed. Frames which have been abandoned by resetting the stack are removed.
+func (m *executionMonitor) call(target uint32, sp uint16) {
+	for (len(m.callStack) > 0) && (m.callStack[len(m.callStack)-1].sp <= sp) {
+		m.callStack = m.callStack[:len(m.callStack)-1]
+	}
+
+	m.callStack = append(m.callStack, CallFrame{CallSite: m.currentPC, Target: target, sp: sp})
+}
+
+// ret removes all frames whose return address has been pulled from the stack. sp is the value of
+// the stack pointer after the return address has been pulled. stackMask is $FF if the stack is
+// restricted to one page and $FFFF otherwise.
+func (m *executionMonitor) ret(sp uint16, stackMask uint16) {
+	for (len(m.callStack) > 0) && (m.callStack[len(m.callStack)-1].sp < sp) {
+		m.callStack = m.callStack[:len(m.callStack)-1]
+	}
+
+	// The routine at the start address has returned if its caller's return address has been pulled,
+	// i.e. if the stack pointer has moved above its value at the start of the program. The stack
+	// pointer may wrap around.
+	above := (sp - m.entrySP) & stackMask
+	if m.termination.StopOnReturn && (above != 0) && (above <= (stackMask >> 1)) {
+		m.stopped = true
+	}
+}
+
+// atStopAddress returns true if the program has reached one of the stop addresses. The
+// instruction at the start address is always executed.
+func (m *executionMonitor) atStopAddress(pc uint32) bool {
+	if m.instructions == 0 {
+		return false
+	}
+
+	for _, j := range m.termination.StopAddresses {
+		if j == pc {
+			return true
+		}
+	}
+
+	return false
+}
+
+// exit ends the program after the current instruction and records the exit code
+func (m *executionMonitor) exit(exitCode uint8) {
+	m.stopped = true
+	m.exited = true
+	m.exitCode = exitCode
+}
+
+// lastPCs returns the addresses of the most recently executed instructions, the oldest one first
+func (m *executionMonitor) lastPCs() []uint32 {
+	res := make([]uint32, 0, m.pcCount)
+
+	for i := 0; i < m.pcCount; i++ {
+		res = append(res, m.pcs[(m.pcPos-m.pcCount+i+NumTracedPCs)%NumTracedPCs])
+	}
+
+	return res
+}
+
+// frames returns a copy of the currently active subroutine calls. The innermost call is the last element.
+func (m *executionMonitor) frames() []CallFrame {
+	res := make([]CallFrame, len(m.callStack))
+	copy(res, m.callStack)
+
+	return res
+}
+
+func (m *executionMonitor) execState(cycles uint64) ExecState {
+	return ExecState{Cycles: cycles, Instructions: m.instructions, CallStack: m.frames()}
+}
+
+// restore returns to a state created by execState. The current instruction is counted once more
+// when it is started over.
+func (m *executionMonitor) restore(s ExecState) {
+	m.instructions = s.Instructions
+	if m.instructions > 0 {
+		m.instructions--
+	}
+
+	m.callStack = append(m.callStack[:0], s.CallStack...)
+}
+
+func (m *executionMonitor) limitError(reason string, p Processor) *LimitError {
+	return &LimitError{
+		Reason:    reason,
+		Registers: formatRegisters(p),
+		LastPCs:   m.lastPCs(),
+		CallStack: m.frames(),
+	}
+}
+
+// selfLoop is called after the instruction at pc has been executed. sp is the stack pointer before
+// and newPC and newSP are the values after the instruction. It returns a non empty reason if the
+// instruction has jumped to itself, as such a loop can only be left through an interrupt. A return to
+// the same instruction, e.g. by a recursive call followed by RTS, changes the stack pointer and an
+// unfinished block move of the 65816 repeats its instruction on purpose.
+func (m *executionMonitor) selfLoop(pc uint32, sp uint16, newPC uint32, newSP uint16, interruptPossible bool, blockMoveActive bool) string {
+	if (newPC != pc) || (newSP != sp) || interruptPossible || blockMoveActive {
+		return ""
+	}
+
+	return fmt.Sprintf("instruction at %s jumps to itself and no interrupt can end the loop", formatAddress(pc))
+}
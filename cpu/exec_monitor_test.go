@@ -0,0 +1,170 @@
+package cpu
+
+import (
+	"6502profiler/memory"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func runWithLimits(t *testing.T, p Processor, prog []byte, limits ExecutionLimits) *LimitError {
+	p.Init(memory.NewLinearMemory(65536))
+	p.CopyToMem(prog, UnitProgStart)
+	p.SetExecutionLimits(limits)
+
+	err := p.Run(UnitProgStart)
+	if err == nil {
+		t.Fatal("program was not stopped")
+	}
+
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return limitErr
+}
+
+func TestInstructionLimit(t *testing.T) {
+	// loop inx
+	//      jmp loop
+	err := runWithLimits(t, New6502(Model6502), []byte{0xE8, 0x4C, 0x00, 0x08}, ExecutionLimits{MaxInstructions: 100})
+
+	if !strings.Contains(err.Reason, "instruction limit") {
+		t.Fatalf("wrong reason: %s", err.Reason)
+	}
+
+	if len(err.LastPCs) != NumTracedPCs {
+		t.Fatalf("wrong number of traced instructions: %d", len(err.LastPCs))
+	}
+
+	// 100 instructions have been executed. The last one was INX at $0800.
+	if (err.LastPCs[NumTracedPCs-1] != 0x0801) || (err.LastPCs[NumTracedPCs-2] != 0x0800) {
+		t.Fatalf("wrong instruction trace: %v", err.LastPCs)
+	}
+
+	if !strings.Contains(err.Registers, "X=$32") {
+		t.Fatalf("wrong registers: %s", err.Registers)
+	}
+}
+
+func TestCycleLimit(t *testing.T) {
+	// loop inx
+	//      jmp loop
+	cpu := New6502(Model6502)
+	err := runWithLimits(t, cpu, []byte{0xE8, 0x4C, 0x00, 0x08}, ExecutionLimits{MaxCycles: 50})
+
+	if !strings.Contains(err.Reason, "cycle limit") {
+		t.Fatalf("wrong reason: %s", err.Reason)
+	}
+
+	if (cpu.NumCycles() < 50) || (cpu.NumCycles() > 55) {
+		t.Fatalf("wrong number of cycles: %d", cpu.NumCycles())
+	}
+}
+
+func TestLimitsApplyToEachRun(t *testing.T) {
+	// ldx #$10
+	// loop dex
+	//      bne loop
+	//      brk
+	cpu := New6502(Model6502)
+	cpu.Init(memory.NewLinearMemory(65536))
+	cpu.CopyToMem([]byte{0xA2, 0x10, 0xCA, 0xD0, 0xFD, 0x00}, UnitProgStart)
+	cpu.SetExecutionLimits(ExecutionLimits{MaxInstructions: 40})
+
+	for i := 0; i < 3; i++ {
+		if err := cpu.RunExt(UnitProgStart, false); err != nil {
+			t.Fatalf("run %d failed: %v", i, err)
+		}
+	}
+}
+
+func TestSelfLoopWithCallStack(t *testing.T) {
+	// jsr sub
+	// brk
+	// sub jsr sub2
+	// sub2 jmp sub2
+	err := runWithLimits(t, New6502(Model6502), []byte{0x20, 0x04, 0x08, 0x00, 0x20, 0x07, 0x08, 0x4C, 0x07, 0x08}, ExecutionLimits{})
+
+	if !strings.Contains(err.Reason, "$0807 jumps to itself") {
+		t.Fatalf("wrong reason: %s", err.Reason)
+	}
+
+	if len(err.CallStack) != 2 {
+		t.Fatalf("wrong call stack: %v", err.CallStack)
+	}
+
+	if (err.CallStack[0].Target != 0x0804) || (err.CallStack[0].CallSite != 0x0800) || (err.CallStack[1].Target != 0x0807) || (err.CallStack[1].CallSite != 0x0804) {
+		t.Fatalf("wrong call stack: %v", err.CallStack)
+	}
+
+	if !strings.Contains(err.Error(), "$0807 called from $0804") {
+		t.Fatalf("call stack not reported: %s", err.Error())
+	}
+}
+
+func TestReturnToSameInstruction(t *testing.T) {
+	// ldx #2
+	// jsr rec
+	// brk
+	// rec dex
+	//     beq done
+	//     jsr rec
+	// done rts
+	cpu := New6502(Model6502)
+	cpu.Init(memory.NewLinearMemory(65536))
+	cpu.CopyToMem([]byte{0xA2, 0x02, 0x20, 0x06, 0x08, 0x00, 0xCA, 0xF0, 0x03, 0x20, 0x06, 0x08, 0x60}, UnitProgStart)
+
+	// The inner call returns to the RTS which ends it
+	if err := cpu.Run(UnitProgStart); err != nil {
+		t.Fatalf("recursive call regarded as endless loop: %v", err)
+	}
+}
+
+func TestCallStackAfterReturn(t *testing.T) {
+	// jsr sub
+	// jmp *
+	// sub rts
+	err := runWithLimits(t, New6502(Model65C02), []byte{0x20, 0x06, 0x08, 0x4C, 0x03, 0x08, 0x60}, ExecutionLimits{})
+
+	if len(err.CallStack) != 0 {
+		t.Fatalf("call stack not empty: %v", err.CallStack)
+	}
+}
+
+func TestSelfLoopLeftByInterrupt(t *testing.T) {
+	// cli
+	// jmp *
+	cpu := New6502(Model6502)
+	cpu.Init(memory.NewLinearMemory(65536))
+	cpu.CopyToMem([]byte{0x58, 0x4C, 0x01, 0x08}, UnitProgStart)
+	// The interrupt routine stops the simulation
+	cpu.CopyToMem([]byte{0x00, 0x00}, 0x0900)
+	cpu.CopyToMem([]byte{0x00, 0x09}, IrqVector)
+	cpu.Interrupts.RaiseIrq()
+
+	if err := cpu.Run(UnitProgStart); err != nil {
+		t.Fatalf("self loop detected although an interrupt is pending: %v", err)
+	}
+}
+
+func TestSelfLoop65CE02(t *testing.T) {
+	// bra *
+	err := runWithLimits(t, New65CE02(Model65CE02), []byte{0x80, 0xFE}, ExecutionLimits{})
+
+	if !strings.Contains(err.Reason, "jumps to itself") || !strings.Contains(err.Registers, "Z=$00") {
+		t.Fatalf("wrong error: %v", err)
+	}
+}
+
+func TestSelfLoop65816(t *testing.T) {
+	// jsl $000805
+	// brk
+	// bra *
+	err := runWithLimits(t, New65816(), []byte{0x22, 0x05, 0x08, 0x00, 0x00, 0x80, 0xFE}, ExecutionLimits{})
+
+	if (len(err.CallStack) != 1) || (err.CallStack[0].Target != 0x0805) || !strings.Contains(err.Registers, "PBR=$00") {
+		t.Fatalf("wrong error: %v", err)
+	}
+}
@@ -0,0 +1,754 @@
+package cpu
+
+import "fmt"
+
+// -------- Loads and stores --------
+
+func (c *CPU65816) lda(o operand816, wide bool) {
+	v := c.loadOperand(o, wide)
+	c.setA(v, wide)
+	c.nzFlags(v, wide)
+}
+
+func (c *CPU65816) ldx(o operand816, wide bool) {
+	c.X = c.loadOperand(o, wide)
+	c.nzFlags(c.X, wide)
+}
+
+func (c *CPU65816) ldy(o operand816, wide bool) {
+	c.Y = c.loadOperand(o, wide)
+	c.nzFlags(c.Y, wide)
+}
+
+func (c *CPU65816) sta(o operand816, wide bool) {
+	c.storeOperand(o, c.C, wide)
+}
+
+func (c *CPU65816) stx(o operand816, wide bool) {
+	c.storeOperand(o, c.X, wide)
+}
+
+func (c *CPU65816) sty(o operand816, wide bool) {
+	c.storeOperand(o, c.Y, wide)
+}
+
+func (c *CPU65816) stz(o operand816, wide bool) {
+	c.storeOperand(o, 0, wide)
+}
+
+// -------- Logical operations --------
+
+func (c *CPU65816) ora(o operand816, wide bool) {
+	v := c.getA(wide) | c.loadOperand(o, wide)
+	c.setA(v, wide)
+	c.nzFlags(v, wide)
+}
+
+func (c *CPU65816) and(o operand816, wide bool) {
+	v := c.getA(wide) & c.loadOperand(o, wide)
+	c.setA(v, wide)
+	c.nzFlags(v, wide)
+}
+
+func (c *CPU65816) eor(o operand816, wide bool) {
+	v := c.getA(wide) ^ c.loadOperand(o, wide)
+	c.setA(v, wide)
+	c.nzFlags(v, wide)
+}
+
+func signBit(wide bool) uint16 {
+	if wide {
+		return 0x8000
+	}
+
+	return 0x0080
+}
+
+func (c *CPU65816) bit(o operand816, wide bool) {
+	v := c.loadOperand(o, wide)
+	sign := signBit(wide)
+
+	c.setFlag(Flag_Z, (c.getA(wide)&v) == 0)
+	c.setFlag(Flag_N, (v&sign) != 0)
+	c.setFlag(Flag_V, (v&(sign>>1)) != 0)
+}
+
+// BIT # only changes the Z flag
+func (c *CPU65816) bitImmediate(o operand816, wide bool) {
+	c.setFlag(Flag_Z, (c.getA(wide)&c.loadOperand(o, wide)) == 0)
+}
+
+func (c *CPU65816) tsb(v uint16, wide bool) uint16 {
+	a := c.getA(wide)
+	c.setFlag(Flag_Z, (a&v) == 0)
+
+	return v | a
+}
+
+func (c *CPU65816) trb(v uint16, wide bool) uint16 {
+	a := c.getA(wide)
+	c.setFlag(Flag_Z, (a&v) == 0)
+
+	return v & (^a)
+}
+
+// -------- Arithmetic --------
+
+// addWithCarry implements ADC and SBC for 8 and 16 bit operands
+func (c *CPU65816) addWithCarry(v uint16, wide bool, subtract bool) {
+	var digits uint = 2
+	if wide {
+		digits = 4
+	}
+
+	res, carry, overflow := addWithCarry(uint32(c.getA(wide)), uint32(v), (c.Flags&Flag_C) != 0, digits, (c.Flags&Flag_D) != 0, subtract)
+
+	c.setFlag(Flag_C, carry)
+	c.setFlag(Flag_V, overflow)
+	c.setA(uint16(res), wide)
+	c.nzFlags(uint16(res), wide)
+}
+
+func (c *CPU65816) adc(o operand816, wide bool) {
+	c.addWithCarry(c.loadOperand(o, wide), wide, false)
+}
+
+func (c *CPU65816) sbc(o operand816, wide bool) {
+	c.addWithCarry(c.loadOperand(o, wide), wide, true)
+}
+
+func (c *CPU65816) compare(reg uint16, v uint16, wide bool) {
+	c.setFlag(Flag_C, reg >= v)
+	c.nzFlags(reg-v, wide)
+}
+
+func (c *CPU65816) cmp(o operand816, wide bool) {
+	c.compare(c.getA(wide), c.loadOperand(o, wide), wide)
+}
+
+func (c *CPU65816) cpx(o operand816, wide bool) {
+	c.compare(c.X, c.loadOperand(o, wide), wide)
+}
+
+func (c *CPU65816) cpy(o operand816, wide bool) {
+	c.compare(c.Y, c.loadOperand(o, wide), wide)
+}
+
+// -------- Shifts, rotations, increment and decrement --------
+
+func (c *CPU65816) asl(v uint16, wide bool) uint16 {
+	c.setFlag(Flag_C, (v&signBit(wide)) != 0)
+	v <<= 1
+	c.nzFlags(v, wide)
+
+	return v
+}
+
+func (c *CPU65816) lsr(v uint16, wide bool) uint16 {
+	c.setFlag(Flag_C, (v&1) != 0)
+	v >>= 1
+	c.nzFlags(v, wide)
+
+	return v
+}
+
+func (c *CPU65816) rol(v uint16, wide bool) uint16 {
+	carry := uint16(c.Flags & Flag_C)
+	c.setFlag(Flag_C, (v&signBit(wide)) != 0)
+	v = (v << 1) | carry
+	c.nzFlags(v, wide)
+
+	return v
+}
+
+func (c *CPU65816) ror(v uint16, wide bool) uint16 {
+	var carry uint16 = 0
+	if (c.Flags & Flag_C) != 0 {
+		carry = signBit(wide)
+	}
+
+	c.setFlag(Flag_C, (v&1) != 0)
+	v = (v >> 1) | carry
+	c.nzFlags(v, wide)
+
+	return v
+}
+
+func (c *CPU65816) inc(v uint16, wide bool) uint16 {
+	v++
+	c.nzFlags(v, wide)
+
+	return v
+}
+
+func (c *CPU65816) dec(v uint16, wide bool) uint16 {
+	v--
+	c.nzFlags(v, wide)
+
+	return v
+}
+
+func (c *CPU65816) inx() (uint64, bool) {
+	c.X = c.indexValue(c.X + 1)
+	c.nzFlags(c.X, c.index16())
+
+	return 2, false
+}
+
+func (c *CPU65816) iny() (uint64, bool) {
+	c.Y = c.indexValue(c.Y + 1)
+	c.nzFlags(c.Y, c.index16())
+
+	return 2, false
+}
+
+func (c *CPU65816) dex() (uint64, bool) {
+	c.X = c.indexValue(c.X - 1)
+	c.nzFlags(c.X, c.index16())
+
+	return 2, false
+}
+
+func (c *CPU65816) dey() (uint64, bool) {
+	c.Y = c.indexValue(c.Y - 1)
+	c.nzFlags(c.Y, c.index16())
+
+	return 2, false
+}
+
+// -------- Branches --------
+
+// branchTo adds the cycles for a taken branch. In emulation mode crossing a page costs one more cycle.
+func (c *CPU65816) branchTo(target uint16) uint64 {
+	var res uint64 = 1
+
+	if c.Emulation && ((target & 0xFF00) != (c.PC & 0xFF00)) {
+		res++
+	}
+
+	c.PC = target
+
+	return res
+}
+
+func branchInstr(flag uint8, set bool) execFunc816 {
+	return func(c *CPU65816) (uint64, bool) {
+		offset := int8(c.fetch8())
+		taken := ((c.Flags & flag) != 0) == set
+		c.tracer.branch(taken)
+
+		if taken {
+			return 2 + c.branchTo(c.PC+uint16(offset)), false
+		}
+
+		return 2, false
+	}
+}
+
+func (c *CPU65816) bra() (uint64, bool) {
+	offset := int8(c.fetch8())
+
+	return 2 + c.branchTo(c.PC+uint16(offset)), false
+}
+
+func (c *CPU65816) brl() (uint64, bool) {
+	offset := c.fetch16()
+	c.PC += offset
+
+	return 4, false
+}
+
+// -------- Jumps and subroutines --------
+
+func (c *CPU65816) jmpAbsolute() (uint64, bool) {
+	c.PC = c.fetch16()
+
+	return 3, false
+}
+
+func (c *CPU65816) jmlLong() (uint64, bool) {
+	addr := c.fetch24()
+	c.PC = uint16(addr)
+	c.PBR = uint8(addr >> 16)
+
+	return 4, false
+}
+
+// The pointer of JMP (a) is always read from bank 0
+func (c *CPU65816) jmpIndirect() (uint64, bool) {
+	c.PC = c.load16Bank0(c.fetch16())
+
+	return 5, false
+}
+
+// The pointer of JMP (a,x) is read from the program bank
+func (c *CPU65816) jmpIdxIndirectX() (uint64, bool) {
+	c.PC = c.programPointer(c.fetch16() + c.X)
+
+	return 6, false
+}
+
+func (c *CPU65816) jmlIndirectLong() (uint64, bool) {
+	ptr := c.fetch16()
+	c.PC = c.load16Bank0(ptr)
+	c.PBR = c.load(uint32(ptr + 2))
+
+	return 6, false
+}
+
+func (c *CPU65816) programPointer(addr uint16) uint16 {
+	bank := uint32(c.PBR) << 16
+	lo := uint16(c.load(bank | uint32(addr)))
+	hi := uint16(c.load(bank | uint32(addr+1)))
+
+	return (hi << 8) | lo
+}
+
+// JSR and JSL push the address of the last byte of the instruction
+func (c *CPU65816) jsrAbsolute() (uint64, bool) {
+	target := c.fetch16()
+	c.push16(c.PC - 1)
+	c.PC = target
+	c.monitor.call(c.programAddress(), c.SP)
+
+	return 6, false
+}
+
+func (c *CPU65816) jsrIdxIndirectX() (uint64, bool) {
+	ptr := c.fetch16()
+	c.push16(c.PC - 1)
+	c.PC = c.programPointer(ptr + c.X)
+	c.monitor.call(c.programAddress(), c.SP)
+
+	return 8, false
+}
+
+func (c *CPU65816) jsl() (uint64, bool) {
+	target := c.fetch24()
+	c.push8(c.PBR)
+	c.push16(c.PC - 1)
+	c.PC = uint16(target)
+	c.PBR = uint8(target >> 16)
+	c.monitor.call(target, c.SP)
+
+	return 8, false
+}
+
+func (c *CPU65816) rts() (uint64, bool) {
+	c.PC = c.pop16() + 1
+	c.monitor.ret(c.SP, c.stackMask())
+
+	return 6, false
+}
+
+func (c *CPU65816) rtl() (uint64, bool) {
+	c.PC = c.pop16() + 1
+	c.PBR = c.pop8()
+	c.monitor.ret(c.SP, c.stackMask())
+
+	return 6, false
+}
+
+// -------- Stack --------
+
+func (c *CPU65816) pha() (uint64, bool) {
+	if c.accu16() {
+		c.push16(c.C)
+		return 4, false
+	}
+
+	c.push8(uint8(c.C))
+
+	return 3, false
+}
+
+func (c *CPU65816) pla() (uint64, bool) {
+	wide := c.accu16()
+
+	if wide {
+		c.C = c.pop16()
+	} else {
+		c.setA(uint16(c.pop8()), false)
+	}
+
+	c.nzFlags(c.C, wide)
+
+	if wide {
+		return 5, false
+	}
+
+	return 4, false
+}
+
+func (c *CPU65816) pushIndex(v uint16) (uint64, bool) {
+	if c.index16() {
+		c.push16(v)
+		return 4, false
+	}
+
+	c.push8(uint8(v))
+
+	return 3, false
+}
+
+func (c *CPU65816) pullIndex(reg *uint16) (uint64, bool) {
+	wide := c.index16()
+
+	if wide {
+		*reg = c.pop16()
+	} else {
+		*reg = uint16(c.pop8())
+	}
+
+	c.nzFlags(*reg, wide)
+
+	if wide {
+		return 5, false
+	}
+
+	return 4, false
+}
+
+func (c *CPU65816) phx() (uint64, bool) {
+	return c.pushIndex(c.X)
+}
+
+func (c *CPU65816) phy() (uint64, bool) {
+	return c.pushIndex(c.Y)
+}
+
+func (c *CPU65816) plx() (uint64, bool) {
+	return c.pullIndex(&c.X)
+}
+
+func (c *CPU65816) ply() (uint64, bool) {
+	return c.pullIndex(&c.Y)
+}
+
+func (c *CPU65816) php() (uint64, bool) {
+	c.push8(c.Flags)
+
+	return 3, false
+}
+
+func (c *CPU65816) plp() (uint64, bool) {
+	c.setP(c.pop8())
+
+	return 4, false
+}
+
+func (c *CPU65816) phb() (uint64, bool) {
+	c.push8(c.DBR)
+
+	return 3, false
+}
+
+func (c *CPU65816) plb() (uint64, bool) {
+	c.DBR = c.pop8()
+	c.nzFlags(uint16(c.DBR), false)
+
+	return 4, false
+}
+
+func (c *CPU65816) phd() (uint64, bool) {
+	c.push16(c.D)
+
+	return 4, false
+}
+
+func (c *CPU65816) pld() (uint64, bool) {
+	c.D = c.pop16()
+	c.nzFlags(c.D, true)
+
+	return 5, false
+}
+
+func (c *CPU65816) phk() (uint64, bool) {
+	c.push8(c.PBR)
+
+	return 3, false
+}
+
+func (c *CPU65816) pea() (uint64, bool) {
+	c.push16(c.fetch16())
+
+	return 5, false
+}
+
+func (c *CPU65816) pei() (uint64, bool) {
+	c.push16(c.directPointer(uint16(c.fetch8())))
+
+	return 6 + c.directPenalty(), false
+}
+
+func (c *CPU65816) per() (uint64, bool) {
+	offset := c.fetch16()
+	c.push16(c.PC + offset)
+
+	return 6, false
+}
+
+// -------- Transfers --------
+
+func (c *CPU65816) transferToIndex(v uint16) uint16 {
+	res := c.indexValue(v)
+	c.nzFlags(res, c.index16())
+
+	return res
+}
+
+func (c *CPU65816) transferToAccu(v uint16) {
+	wide := c.accu16()
+	c.setA(v, wide)
+	c.nzFlags(v, wide)
+}
+
+func (c *CPU65816) tax() (uint64, bool) {
+	c.X = c.transferToIndex(c.C)
+
+	return 2, false
+}
+
+func (c *CPU65816) tay() (uint64, bool) {
+	c.Y = c.transferToIndex(c.C)
+
+	return 2, false
+}
+
+func (c *CPU65816) tsx() (uint64, bool) {
+	c.X = c.transferToIndex(c.SP)
+
+	return 2, false
+}
+
+func (c *CPU65816) txy() (uint64, bool) {
+	c.Y = c.transferToIndex(c.X)
+
+	return 2, false
+}
+
+func (c *CPU65816) tyx() (uint64, bool) {
+	c.X = c.transferToIndex(c.Y)
+
+	return 2, false
+}
+
+func (c *CPU65816) txa() (uint64, bool) {
+	c.transferToAccu(c.X)
+
+	return 2, false
+}
+
+func (c *CPU65816) tya() (uint64, bool) {
+	c.transferToAccu(c.Y)
+
+	return 2, false
+}
+
+func (c *CPU65816) txs() (uint64, bool) {
+	c.setSP(c.X)
+
+	return 2, false
+}
+
+func (c *CPU65816) tcd() (uint64, bool) {
+	c.D = c.C
+	c.nzFlags(c.D, true)
+
+	return 2, false
+}
+
+func (c *CPU65816) tdc() (uint64, bool) {
+	c.C = c.D
+	c.nzFlags(c.C, true)
+
+	return 2, false
+}
+
+func (c *CPU65816) tcs() (uint64, bool) {
+	c.setSP(c.C)
+
+	return 2, false
+}
+
+func (c *CPU65816) tsc() (uint64, bool) {
+	c.C = c.SP
+	c.nzFlags(c.C, true)
+
+	return 2, false
+}
+
+// XBA swaps A and B. The flags are set according to the new value of A.
+func (c *CPU65816) xba() (uint64, bool) {
+	c.C = (c.C << 8) | (c.C >> 8)
+	c.nzFlags(c.C, false)
+
+	return 3, false
+}
+
+// XCE exchanges the carry and the emulation flag
+func (c *CPU65816) xce() (uint64, bool) {
+	carry := (c.Flags & Flag_C) != 0
+	c.setFlag(Flag_C, c.Emulation)
+	c.setEmulation(carry)
+
+	return 2, false
+}
+
+// -------- Flags --------
+
+func flagInstr(flag uint8, set bool) execFunc816 {
+	return func(c *CPU65816) (uint64, bool) {
+		c.setFlag(flag, set)
+
+		return 2, false
+	}
+}
+
+func (c *CPU65816) rep() (uint64, bool) {
+	c.setP(c.Flags & (^c.fetch8()))
+
+	return 3, false
+}
+
+func (c *CPU65816) sep() (uint64, bool) {
+	c.setP(c.Flags | c.fetch8())
+
+	return 3, false
+}
+
+// -------- Block moves --------
+
+// MVN and MVP move one byte per execution. As long as the accumulator has not wrapped around to
+// $FFFF the instruction is executed again.
+func (c *CPU65816) blockMove(step uint16) (uint64, bool) {
+	destBank := c.fetch8()
+	srcBank := c.fetch8()
+
+	c.DBR = destBank
+	c.store((uint32(destBank)<<16)|uint32(c.Y), c.load((uint32(srcBank)<<16)|uint32(c.X)))
+
+	c.X = c.indexValue(c.X + step)
+	c.Y = c.indexValue(c.Y + step)
+	c.C--
+
+	if c.C != 0xFFFF {
+		c.PC -= 3
+		c.blockMoveActive = true
+	}
+
+	return 7, false
+}
+
+func (c *CPU65816) mvn() (uint64, bool) {
+	return c.blockMove(1)
+}
+
+func (c *CPU65816) mvp() (uint64, bool) {
+	return c.blockMove(0xFFFF)
+}
+
+// -------- Interrupts --------
+
+func (c *CPU65816) readVector(vector uint16) uint16 {
+	return c.load16Bank0(vector)
+}
+
+// enterInterrupt pushes the program bank (only in native mode), the return address and the flags
+// and jumps to the routine referenced by the vector. In emulation mode the B flag is pushed for BRK.
+func (c *CPU65816) enterInterrupt(nativeVector uint16, emuVector uint16, returnAddr uint16, brk bool) uint64 {
+	var res uint64 = 7
+	vector := emuVector
+	flags := c.Flags
+
+	if c.Emulation {
+		flags = (flags | flagUnused) & (^Flag_B)
+		if brk {
+			flags |= Flag_B
+		}
+	} else {
+		c.push8(c.PBR)
+		vector = nativeVector
+		res++
+	}
+
+	c.push16(returnAddr)
+	c.push8(flags)
+
+	c.Flags |= Flag_I
+	c.Flags &= (^Flag_D)
+	c.PBR = 0
+	c.PC = c.readVector(vector)
+
+	return res
+}
+
+func (c *CPU65816) pollInterrupts() uint64 {
+	if c.Interrupts.nmiPending {
+		c.Interrupts.nmiPending = false
+		return c.enterInterrupt(NmiVectorNative, NmiVector, c.PC, false)
+	}
+
+	if c.Interrupts.irqLine && ((c.Flags & Flag_I) == 0) {
+		return c.enterInterrupt(IrqVectorNative, IrqVector, c.PC, false)
+	}
+
+	return 0
+}
+
+// BRK halts the simulator unless BrkIsInterrupt is set and a routine has been installed
+func (c *CPU65816) brk() (uint64, bool) {
+	vector := IrqVector
+	if !c.Emulation {
+		vector = BrkVectorNative
+	}
+
+	if !c.brkIsInterrupt || (c.readVector(vector) == 0x0000) {
+		return 7, true
+	}
+
+	return c.enterInterrupt(BrkVectorNative, IrqVector, c.PC+1, true), false
+}
+
+// COP is always executed as a software interrupt
+func (c *CPU65816) cop() (uint64, bool) {
+	return c.enterInterrupt(CopVectorNative, CopVector, c.PC+1, false), false
+}
+
+func (c *CPU65816) rti() (uint64, bool) {
+	c.setP(c.pop8())
+	c.PC = c.pop16()
+
+	if c.Emulation {
+		return 6, false
+	}
+
+	c.PBR = c.pop8()
+
+	return 7, false
+}
+
+func (c *CPU65816) stp() (uint64, bool) {
+	return 3, true
+}
+
+func (c *CPU65816) wai() (uint64, bool) {
+	if !c.Interrupts.nmiPending && !c.Interrupts.irqLine {
+		panic(fmt.Sprintf("WAI at $%02x:%04x would wait forever as no interrupt is pending", c.PBR, c.PC-1))
+	}
+
+	return 3, false
+}
+
+// -------- Miscellaneous --------
+
+func (c *CPU65816) nop() (uint64, bool) {
+	return 2, false
+}
+
+// WDM is reserved for future extensions. It is a two byte NOP.
+func (c *CPU65816) wdm() (uint64, bool) {
+	c.PC++
+
+	return 2, false
+}
@@ -0,0 +1,808 @@
+package cpu
+
+// -------- Accumulator helpers --------
+
+func (c *CPU65CE02) getAccu(numBytes uint32) uint32 {
+	if numBytes == 4 {
+		return c.getQ()
+	}
+
+	return uint32(c.A)
+}
+
+func (c *CPU65CE02) setAccu(v uint32, numBytes uint32) {
+	if numBytes == 4 {
+		c.setQ(v)
+	} else {
+		c.A = uint8(v)
+	}
+}
+
+func (c *CPU65CE02) nzFlagsN(v uint32, numBytes uint32) {
+	if numBytes == 4 {
+		c.nzFlags32(v)
+	} else {
+		c.nzFlags(uint8(v))
+	}
+}
+
+func signBitN(numBytes uint32) uint32 {
+	return uint32(1) << (8*numBytes - 1)
+}
+
+// -------- Loads and stores --------
+
+func (c *CPU65CE02) lda(o operandCE) {
+	n := c.operandWidth()
+	v := c.loadOperandN(o, n)
+	c.setAccu(v, n)
+	c.nzFlagsN(v, n)
+}
+
+func (c *CPU65CE02) ldx(o operandCE) {
+	c.X = c.loadOperand(o)
+	c.nzFlags(c.X)
+}
+
+func (c *CPU65CE02) ldy(o operandCE) {
+	c.Y = c.loadOperand(o)
+	c.nzFlags(c.Y)
+}
+
+func (c *CPU65CE02) ldz(o operandCE) {
+	c.Z = c.loadOperand(o)
+	c.nzFlags(c.Z)
+}
+
+func (c *CPU65CE02) sta(o operandCE) {
+	n := c.operandWidth()
+	c.storeOperandN(o, c.getAccu(n), n)
+}
+
+func (c *CPU65CE02) stx(o operandCE) {
+	c.storeOperand(o, c.X)
+}
+
+func (c *CPU65CE02) sty(o operandCE) {
+	c.storeOperand(o, c.Y)
+}
+
+// In contrast to the 65C02 STZ stores the Z register and not the value zero
+func (c *CPU65CE02) stz(o operandCE) {
+	c.storeOperand(o, c.Z)
+}
+
+// -------- Logical operations --------
+
+func (c *CPU65CE02) ora(o operandCE) {
+	n := c.operandWidth()
+	v := c.getAccu(n) | c.loadOperandN(o, n)
+	c.setAccu(v, n)
+	c.nzFlagsN(v, n)
+}
+
+func (c *CPU65CE02) and(o operandCE) {
+	n := c.operandWidth()
+	v := c.getAccu(n) & c.loadOperandN(o, n)
+	c.setAccu(v, n)
+	c.nzFlagsN(v, n)
+}
+
+func (c *CPU65CE02) eor(o operandCE) {
+	n := c.operandWidth()
+	v := c.getAccu(n) ^ c.loadOperandN(o, n)
+	c.setAccu(v, n)
+	c.nzFlagsN(v, n)
+}
+
+func (c *CPU65CE02) bit(o operandCE) {
+	n := c.operandWidth()
+	v := c.loadOperandN(o, n)
+	sign := signBitN(n)
+
+	c.setFlag(Flag_Z, (c.getAccu(n)&v) == 0)
+	c.setFlag(Flag_N, (v&sign) != 0)
+	c.setFlag(Flag_V, (v&(sign>>1)) != 0)
+}
+
+// BIT # only changes the Z flag
+func (c *CPU65CE02) bitImmediate(o operandCE) {
+	c.setFlag(Flag_Z, (c.A&c.loadOperand(o)) == 0)
+}
+
+func (c *CPU65CE02) tsb(v uint32, numBytes uint32) uint32 {
+	a := c.getAccu(numBytes)
+	c.setFlag(Flag_Z, (a&v) == 0)
+
+	return v | a
+}
+
+func (c *CPU65CE02) trb(v uint32, numBytes uint32) uint32 {
+	a := c.getAccu(numBytes)
+	c.setFlag(Flag_Z, (a&v) == 0)
+
+	return v & (^a)
+}
+
+// -------- Arithmetic --------
+
+// addWithCarry implements ADC and SBC. Quad arithmetic is always binary.
+func (c *CPU65CE02) addWithCarry(v uint32, numBytes uint32, subtract bool) {
+	decimal := ((c.Flags & Flag_D) != 0) && (numBytes == 1)
+
+	res, carry, overflow := addWithCarry(c.getAccu(numBytes), v, (c.Flags&Flag_C) != 0, uint(2*numBytes), decimal, subtract)
+
+	c.setFlag(Flag_C, carry)
+	c.setFlag(Flag_V, overflow)
+	c.setAccu(res, numBytes)
+	c.nzFlagsN(res, numBytes)
+}
+
+func (c *CPU65CE02) adc(o operandCE) {
+	n := c.operandWidth()
+	c.addWithCarry(c.loadOperandN(o, n), n, false)
+}
+
+func (c *CPU65CE02) sbc(o operandCE) {
+	n := c.operandWidth()
+	c.addWithCarry(c.loadOperandN(o, n), n, true)
+}
+
+func (c *CPU65CE02) compare(reg uint8, v uint8) {
+	c.setFlag(Flag_C, reg >= v)
+	c.nzFlags(reg - v)
+}
+
+func (c *CPU65CE02) cmp(o operandCE) {
+	n := c.operandWidth()
+	if n == 1 {
+		c.compare(c.A, c.loadOperand(o))
+		return
+	}
+
+	q := c.getQ()
+	v := c.loadOperandN(o, n)
+	c.setFlag(Flag_C, q >= v)
+	c.nzFlags32(q - v)
+}
+
+func (c *CPU65CE02) cpx(o operandCE) {
+	c.compare(c.X, c.loadOperand(o))
+}
+
+func (c *CPU65CE02) cpy(o operandCE) {
+	c.compare(c.Y, c.loadOperand(o))
+}
+
+func (c *CPU65CE02) cpz(o operandCE) {
+	c.compare(c.Z, c.loadOperand(o))
+}
+
+// -------- Shifts, rotations, increment and decrement --------
+
+func (c *CPU65CE02) asl(v uint32, numBytes uint32) uint32 {
+	c.setFlag(Flag_C, (v&signBitN(numBytes)) != 0)
+	v <<= 1
+	c.nzFlagsN(v, numBytes)
+
+	return v
+}
+
+func (c *CPU65CE02) lsr(v uint32, numBytes uint32) uint32 {
+	c.setFlag(Flag_C, (v&1) != 0)
+	v >>= 1
+	c.nzFlagsN(v, numBytes)
+
+	return v
+}
+
+// ASR shifts to the right and keeps the sign bit
+func (c *CPU65CE02) asr(v uint32, numBytes uint32) uint32 {
+	sign := v & signBitN(numBytes)
+	c.setFlag(Flag_C, (v&1) != 0)
+	v = (v >> 1) | sign
+	c.nzFlagsN(v, numBytes)
+
+	return v
+}
+
+func (c *CPU65CE02) rol(v uint32, numBytes uint32) uint32 {
+	carry := uint32(c.Flags & Flag_C)
+	c.setFlag(Flag_C, (v&signBitN(numBytes)) != 0)
+	v = (v << 1) | carry
+	c.nzFlagsN(v, numBytes)
+
+	return v
+}
+
+func (c *CPU65CE02) ror(v uint32, numBytes uint32) uint32 {
+	var carry uint32 = 0
+	if (c.Flags & Flag_C) != 0 {
+		carry = signBitN(numBytes)
+	}
+
+	c.setFlag(Flag_C, (v&1) != 0)
+	v = (v >> 1) | carry
+	c.nzFlagsN(v, numBytes)
+
+	return v
+}
+
+func (c *CPU65CE02) inc(v uint32, numBytes uint32) uint32 {
+	v++
+	c.nzFlagsN(v, numBytes)
+
+	return v
+}
+
+func (c *CPU65CE02) dec(v uint32, numBytes uint32) uint32 {
+	v--
+	c.nzFlagsN(v, numBytes)
+
+	return v
+}
+
+func (c *CPU65CE02) inx() (uint64, bool) {
+	c.X++
+	c.nzFlags(c.X)
+
+	return 1, false
+}
+
+func (c *CPU65CE02) iny() (uint64, bool) {
+	c.Y++
+	c.nzFlags(c.Y)
+
+	return 1, false
+}
+
+func (c *CPU65CE02) inz() (uint64, bool) {
+	c.Z++
+	c.nzFlags(c.Z)
+
+	return 1, false
+}
+
+func (c *CPU65CE02) dex() (uint64, bool) {
+	c.X--
+	c.nzFlags(c.X)
+
+	return 1, false
+}
+
+func (c *CPU65CE02) dey() (uint64, bool) {
+	c.Y--
+	c.nzFlags(c.Y)
+
+	return 1, false
+}
+
+func (c *CPU65CE02) dez() (uint64, bool) {
+	c.Z--
+	c.nzFlags(c.Z)
+
+	return 1, false
+}
+
+// -------- Word instructions --------
+
+// modifyBaseWord applies modifier to the 16 bit word stored in the base page
+func (c *CPU65CE02) modifyBaseWord(modifier func(v uint16) uint16) {
+	offset := c.fetch8()
+	lo := c.baseAddr(offset)
+	hi := c.baseAddr(offset + 1)
+
+	v := modifier(uint16(c.load(lo)) | (uint16(c.load(hi)) << 8))
+	c.nzFlags16(v)
+
+	c.store(lo, uint8(v))
+	c.store(hi, uint8(v>>8))
+}
+
+func (c *CPU65CE02) inw() (uint64, bool) {
+	c.modifyBaseWord(func(v uint16) uint16 { return v + 1 })
+
+	return 5, false
+}
+
+func (c *CPU65CE02) dew() (uint64, bool) {
+	c.modifyBaseWord(func(v uint16) uint16 { return v - 1 })
+
+	return 5, false
+}
+
+// modifyAbsoluteWord applies modifier to the 16 bit word stored at an absolute address
+func (c *CPU65CE02) modifyAbsoluteWord(modifier func(v uint16) uint16) {
+	addr := c.fetch16()
+
+	v := modifier(c.load16(addr))
+	c.nzFlags16(v)
+
+	c.store(addr, uint8(v))
+	c.store(addr+1, uint8(v>>8))
+}
+
+func (c *CPU65CE02) asw() (uint64, bool) {
+	c.modifyAbsoluteWord(func(v uint16) uint16 {
+		c.setFlag(Flag_C, (v&0x8000) != 0)
+		return v << 1
+	})
+
+	return 6, false
+}
+
+func (c *CPU65CE02) row() (uint64, bool) {
+	c.modifyAbsoluteWord(func(v uint16) uint16 {
+		carry := uint16(c.Flags & Flag_C)
+		c.setFlag(Flag_C, (v&0x8000) != 0)
+		return (v << 1) | carry
+	})
+
+	return 6, false
+}
+
+// -------- Bit instructions --------
+
+func rmbCE(bit uint8) execFuncCE {
+	return func(c *CPU65CE02) (uint64, bool) {
+		addr := c.baseAddr(c.fetch8())
+		c.store(addr, c.load(addr)&(^(1 << bit)))
+
+		return 4, false
+	}
+}
+
+func smbCE(bit uint8) execFuncCE {
+	return func(c *CPU65CE02) (uint64, bool) {
+		addr := c.baseAddr(c.fetch8())
+		c.store(addr, c.load(addr)|(1<<bit))
+
+		return 4, false
+	}
+}
+
+// bbCE creates BBR (set = false) and BBS (set = true)
+func bbCE(bit uint8, set bool) execFuncCE {
+	return func(c *CPU65CE02) (uint64, bool) {
+		v := c.load(c.baseAddr(c.fetch8()))
+		offset := int8(c.fetch8())
+		taken := ((v & (1 << bit)) != 0) == set
+		c.tracer.branch(taken)
+
+		if taken {
+			c.PC += uint16(offset)
+			return 5, false
+		}
+
+		return 4, false
+	}
+}
+
+// -------- Branches --------
+
+// fetchBranchTarget reads the offset of a relative branch. 16 bit offsets are relative to the
+// address of the last byte of the instruction, 8 bit offsets to the address of the next instruction.
+func (c *CPU65CE02) fetchBranchTarget(long bool) uint16 {
+	if long {
+		offset := c.fetch16()
+		return c.PC - 1 + offset
+	}
+
+	offset := int8(c.fetch8())
+
+	return c.PC + uint16(offset)
+}
+
+func branchInstrCE(flag uint8, set bool, long bool) execFuncCE {
+	var cycles uint64 = 2
+	if long {
+		cycles = 3
+	}
+
+	return func(c *CPU65CE02) (uint64, bool) {
+		target := c.fetchBranchTarget(long)
+		taken := ((c.Flags & flag) != 0) == set
+		c.tracer.branch(taken)
+
+		if taken {
+			c.PC = target
+			return cycles + 1, false
+		}
+
+		return cycles, false
+	}
+}
+
+func braInstrCE(long bool) execFuncCE {
+	var cycles uint64 = 3
+	if long {
+		cycles = 4
+	}
+
+	return func(c *CPU65CE02) (uint64, bool) {
+		c.PC = c.fetchBranchTarget(long)
+
+		return cycles, false
+	}
+}
+
+// BSR calls a subroutine at a 16 bit relative offset
+func (c *CPU65CE02) bsr() (uint64, bool) {
+	target := c.fetchBranchTarget(true)
+	c.push16(c.PC - 1)
+	c.PC = target
+	c.monitor.call(uint32(target), c.SP)
+
+	return 5, false
+}
+
+// -------- Jumps and subroutines --------
+
+func (c *CPU65CE02) jmpAbsolute() (uint64, bool) {
+	c.PC = c.fetch16()
+
+	return 3, false
+}
+
+func (c *CPU65CE02) jmpIndirect() (uint64, bool) {
+	c.PC = c.load16(c.fetch16())
+
+	return 5, false
+}
+
+func (c *CPU65CE02) jmpIdxIndirectX() (uint64, bool) {
+	c.PC = c.load16(c.fetch16() + uint16(c.X))
+
+	return 5, false
+}
+
+// jsr pushes the address of the last byte of the instruction and jumps to target
+func (c *CPU65CE02) jsr(target uint16) (uint64, bool) {
+	c.push16(c.PC - 1)
+	c.PC = target
+	c.monitor.call(uint32(target), c.SP)
+
+	return 5, false
+}
+
+func (c *CPU65CE02) jsrAbsolute() (uint64, bool) {
+	return c.jsr(c.fetch16())
+}
+
+func (c *CPU65CE02) jsrIndirect() (uint64, bool) {
+	return c.jsr(c.load16(c.fetch16()))
+}
+
+func (c *CPU65CE02) jsrIdxIndirectX() (uint64, bool) {
+	return c.jsr(c.load16(c.fetch16() + uint16(c.X)))
+}
+
+func (c *CPU65CE02) rts() (uint64, bool) {
+	c.PC = c.pop16() + 1
+	c.monitor.ret(c.SP, c.stackMask())
+
+	return 4, false
+}
+
+// RTS # additionally removes the given number of bytes from the stack
+func (c *CPU65CE02) rtsImmediate() (uint64, bool) {
+	n := c.fetch8()
+	c.PC = c.pop16() + 1
+
+	if (c.Flags & Flag_E) != 0 {
+		c.SP = (c.SP & 0xFF00) | ((c.SP + uint16(n)) & 0x00FF)
+	} else {
+		c.SP += uint16(n)
+	}
+
+	c.monitor.ret(c.SP, c.stackMask())
+
+	return 4, false
+}
+
+// -------- Stack --------
+
+func (c *CPU65CE02) pha() (uint64, bool) {
+	c.push8(c.A)
+
+	return 3, false
+}
+
+func (c *CPU65CE02) pla() (uint64, bool) {
+	c.A = c.pop8()
+	c.nzFlags(c.A)
+
+	return 3, false
+}
+
+func (c *CPU65CE02) phx() (uint64, bool) {
+	c.push8(c.X)
+
+	return 3, false
+}
+
+func (c *CPU65CE02) plx() (uint64, bool) {
+	c.X = c.pop8()
+	c.nzFlags(c.X)
+
+	return 3, false
+}
+
+func (c *CPU65CE02) phy() (uint64, bool) {
+	c.push8(c.Y)
+
+	return 3, false
+}
+
+func (c *CPU65CE02) ply() (uint64, bool) {
+	c.Y = c.pop8()
+	c.nzFlags(c.Y)
+
+	return 3, false
+}
+
+func (c *CPU65CE02) phz() (uint64, bool) {
+	c.push8(c.Z)
+
+	return 3, false
+}
+
+func (c *CPU65CE02) plz() (uint64, bool) {
+	c.Z = c.pop8()
+	c.nzFlags(c.Z)
+
+	return 3, false
+}
+
+// PHP always pushes the B flag
+func (c *CPU65CE02) php() (uint64, bool) {
+	c.push8(c.Flags | Flag_B)
+
+	return 3, false
+}
+
+// setP sets the flags from a value pulled from the stack. The E flag can only be changed by CLE and SEE.
+func (c *CPU65CE02) setP(v uint8) {
+	c.Flags = (v & (^(Flag_B | Flag_E))) | (c.Flags & Flag_E)
+}
+
+func (c *CPU65CE02) plp() (uint64, bool) {
+	c.setP(c.pop8())
+
+	return 3, false
+}
+
+func (c *CPU65CE02) phwImmediate() (uint64, bool) {
+	c.push16(c.fetch16())
+
+	return 5, false
+}
+
+func (c *CPU65CE02) phwAbsolute() (uint64, bool) {
+	c.push16(c.load16(c.fetch16()))
+
+	return 7, false
+}
+
+// -------- Transfers --------
+
+func (c *CPU65CE02) tax() (uint64, bool) {
+	c.X = c.A
+	c.nzFlags(c.X)
+
+	return 1, false
+}
+
+func (c *CPU65CE02) tay() (uint64, bool) {
+	c.Y = c.A
+	c.nzFlags(c.Y)
+
+	return 1, false
+}
+
+func (c *CPU65CE02) taz() (uint64, bool) {
+	c.Z = c.A
+	c.nzFlags(c.Z)
+
+	return 1, false
+}
+
+// TAB does not change any flags
+func (c *CPU65CE02) tab() (uint64, bool) {
+	c.B = c.A
+
+	return 1, false
+}
+
+func (c *CPU65CE02) txa() (uint64, bool) {
+	c.A = c.X
+	c.nzFlags(c.A)
+
+	return 1, false
+}
+
+func (c *CPU65CE02) tya() (uint64, bool) {
+	c.A = c.Y
+	c.nzFlags(c.A)
+
+	return 1, false
+}
+
+func (c *CPU65CE02) tza() (uint64, bool) {
+	c.A = c.Z
+	c.nzFlags(c.A)
+
+	return 1, false
+}
+
+func (c *CPU65CE02) tba() (uint64, bool) {
+	c.A = c.B
+	c.nzFlags(c.A)
+
+	return 1, false
+}
+
+func (c *CPU65CE02) tsx() (uint64, bool) {
+	c.X = uint8(c.SP)
+	c.nzFlags(c.X)
+
+	return 1, false
+}
+
+// TXS does not change any flags
+func (c *CPU65CE02) txs() (uint64, bool) {
+	c.SP = (c.SP & 0xFF00) | uint16(c.X)
+
+	return 1, false
+}
+
+// TSY transfers the high byte of the stack pointer to Y
+func (c *CPU65CE02) tsy() (uint64, bool) {
+	c.Y = uint8(c.SP >> 8)
+	c.nzFlags(c.Y)
+
+	return 1, false
+}
+
+// TYS sets the high byte of the stack pointer. It does not change any flags.
+func (c *CPU65CE02) tys() (uint64, bool) {
+	c.SP = (uint16(c.Y) << 8) | (c.SP & 0x00FF)
+
+	return 1, false
+}
+
+// -------- Flags --------
+
+func flagInstrCE(flag uint8, set bool) execFuncCE {
+	return func(c *CPU65CE02) (uint64, bool) {
+		c.setFlag(flag, set)
+
+		return 1, false
+	}
+}
+
+// -------- Miscellaneous --------
+
+// NEG negates the accumulator. On the 45GS02 two consecutive NEG instructions are the prefix of
+// a quad instruction.
+func (c *CPU65CE02) neg() (uint64, bool) {
+	if (c.model == Model45GS02) && !c.quad && (c.peek() == 0x42) {
+		c.PC++
+		cycles, halt := c.executePrefixed(true, false)
+
+		return cycles + 2, halt
+	}
+
+	c.A = -c.A
+	c.nzFlags(c.A)
+
+	return 1, false
+}
+
+// On the 45GS02 NOP is EOM which ends the interrupt inhibition caused by MAP. If it precedes an
+// instruction using the (bp),z addressing mode this mode uses a 32 bit flat pointer.
+func (c *CPU65CE02) nop() (uint64, bool) {
+	if c.model != Model45GS02 {
+		return 1, false
+	}
+
+	c.mapActive = false
+
+	if (c.peek() & 0x1F) == 0x12 {
+		cycles, halt := c.executePrefixed(false, true)
+
+		return cycles + 1, halt
+	}
+
+	return 1, false
+}
+
+// mapInstr implements MAP on the 45GS02. On the 65CE02 the opcode is AUG, a four byte NOP.
+//
+// The offset for the lower 32K is taken from A (bits 8-15) and the low nibble of X (bits 16-19).
+// The high nibble of X selects the 8K blocks which are mapped. Y and Z do the same for the upper
+// 32K. If X (or Z) is $0F, A (or Y) selects the megabyte of the respective offset instead.
+// Interrupts are inhibited until the next EOM.
+func (c *CPU65CE02) mapInstr() (uint64, bool) {
+	if c.model != Model45GS02 {
+		c.PC += 3
+		return 4, false
+	}
+
+	if c.X == 0x0F {
+		c.mapLoMB = uint32(c.A)
+	} else {
+		c.mapLoOffset = (uint32(c.X&0x0F) << 16) | (uint32(c.A) << 8)
+		c.mapLoMask = c.X >> 4
+	}
+
+	if c.Z == 0x0F {
+		c.mapHiMB = uint32(c.Y)
+	} else {
+		c.mapHiOffset = (uint32(c.Z&0x0F) << 16) | (uint32(c.Y) << 8)
+		c.mapHiMask = c.Z >> 4
+	}
+
+	c.mapActive = true
+
+	return 1, false
+}
+
+// -------- Interrupts --------
+
+func (c *CPU65CE02) readVector(vector uint16) uint16 {
+	return c.load16(vector)
+}
+
+// enterInterrupt pushes the return address and the flags and jumps to the routine referenced by
+// the given vector. The B flag is only set in the pushed value, never in the flag register itself.
+func (c *CPU65CE02) enterInterrupt(vector uint16, returnAddr uint16, brk bool) uint64 {
+	c.push16(returnAddr)
+
+	flags := c.Flags & (^Flag_B)
+	if brk {
+		flags |= Flag_B
+	}
+
+	c.push8(flags)
+	c.Flags |= Flag_I
+	c.Flags &= (^Flag_D)
+	c.PC = c.readVector(vector)
+
+	return 7
+}
+
+// pollInterrupts is called before each instruction is executed. After MAP interrupts are inhibited
+// until EOM is executed.
+func (c *CPU65CE02) pollInterrupts() uint64 {
+	if c.mapActive {
+		return 0
+	}
+
+	if c.Interrupts.nmiPending {
+		c.Interrupts.nmiPending = false
+		return c.enterInterrupt(NmiVector, c.PC, false)
+	}
+
+	if c.Interrupts.irqLine && ((c.Flags & Flag_I) == 0) {
+		return c.enterInterrupt(IrqVector, c.PC, false)
+	}
+
+	return 0
+}
+
+// BRK halts the simulator unless BrkIsInterrupt is set and a routine has been installed
+func (c *CPU65CE02) brk() (uint64, bool) {
+	if !c.brkIsInterrupt || (c.readVector(IrqVector) == 0x0000) {
+		return 7, true
+	}
+
+	return c.enterInterrupt(IrqVector, c.PC+1, true), false
+}
+
+func (c *CPU65CE02) rti() (uint64, bool) {
+	c.setP(c.pop8())
+	c.PC = c.pop16()
+
+	return 5, false
+}
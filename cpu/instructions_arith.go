@@ -163,11 +163,11 @@ func (c *CPU6502) addBase(val1, val2 uint8) (uint8, uint64) {
 	var res uint8
 	var additionalCycles uint64 = 0
 
-	if (c.model == Model65C02) && ((c.Flags & Flag_D) != 0) {
+	if c.model.IsCmos() && ((c.Flags & Flag_D) != 0) {
 		additionalCycles++
 	}
 
-	if (c.Flags & Flag_D) == 0 {
+	if ((c.Flags & Flag_D) == 0) || !c.model.HasDecimalMode() {
 		res = c.addBaseBin(val1, val2)
 	} else {
 		res = c.addBaseBcd6502(val1, val2)
@@ -180,11 +180,11 @@ func (c *CPU6502) subBase(val1, val2 uint8) (uint8, uint64) {
 	var res uint8
 	var additionalCycles uint64 = 0
 
-	if (c.model == Model65C02) && ((c.Flags & Flag_D) != 0) {
+	if c.model.IsCmos() && ((c.Flags & Flag_D) != 0) {
 		additionalCycles++
 	}
 
-	if (c.Flags & Flag_D) == 0 {
+	if ((c.Flags & Flag_D) == 0) || !c.model.HasDecimalMode() {
 		res = c.subBaseBin(val1, val2)
 	} else {
 		res = c.subBaseBcd(val1, val2)
@@ -194,7 +194,7 @@ func (c *CPU6502) subBase(val1, val2 uint8) (uint8, uint64) {
 }
 
 func (c *CPU6502) addImmediate() (uint64, bool) {
-	operand := c.Mem.Load(c.PC)
+	operand := c.fetchOperand()
 	res, additionalCycles := c.addBase(c.A, operand)
 	c.A = res
 	c.PC++
@@ -203,7 +203,7 @@ func (c *CPU6502) addImmediate() (uint64, bool) {
 }
 
 func (c *CPU6502) subImmediate() (uint64, bool) {
-	operand := c.Mem.Load(c.PC)
+	operand := c.fetchOperand()
 	res, additionalCycles := c.subBase(c.A, operand)
 	c.A = res
 	c.PC++
@@ -380,7 +380,7 @@ func Or(a, b uint8) uint8 {
 }
 
 func logicalImmediate(c *CPU6502, op LogicalOp) (uint64, bool) {
-	operand := c.Mem.Load(c.PC)
+	operand := c.fetchOperand()
 	c.A = op(c.A, operand)
 	c.nzFlags(c.A)
 	c.PC++
@@ -657,7 +657,7 @@ func (c *CPU6502) modZeroPage(modifier ModifierOp) (uint64, bool) {
 	operAddr := c.getAddrZeroPage()
 	oper := c.Mem.Load(operAddr)
 	res := modifier(c, oper)
-	c.Mem.Store(operAddr, res)
+	c.storeModified(operAddr, oper, res)
 	c.nzFlags(res)
 	c.PC++
 
@@ -668,7 +668,7 @@ func (c *CPU6502) modZeroPageX(modifier ModifierOp) (uint64, bool) {
 	operAddr := c.getAddrZeroPageX()
 	oper := c.Mem.Load(operAddr)
 	res := modifier(c, oper)
-	c.Mem.Store(operAddr, res)
+	c.storeModified(operAddr, oper, res)
 	c.nzFlags(res)
 	c.PC++
 
@@ -679,7 +679,7 @@ func (c *CPU6502) modAbsolute(modifier ModifierOp) (uint64, bool) {
 	operAddr := c.getAddrAbsolute()
 	oper := c.Mem.Load(operAddr)
 	res := modifier(c, oper)
-	c.Mem.Store(operAddr, res)
+	c.storeModified(operAddr, oper, res)
 	c.nzFlags(res)
 	c.PC++
 
@@ -687,10 +687,10 @@ func (c *CPU6502) modAbsolute(modifier ModifierOp) (uint64, bool) {
 }
 
 func (c *CPU6502) modAbsoluteX(modifier ModifierOp) (uint64, bool) {
-	operAddr, _ := c.getAddrAbsoluteX()
+	operAddr := c.getAddrAbsoluteXWrite()
 	oper := c.Mem.Load(operAddr)
 	res := modifier(c, oper)
-	c.Mem.Store(operAddr, res)
+	c.storeModified(operAddr, oper, res)
 	c.nzFlags(res)
 	c.PC++
 
@@ -701,7 +701,7 @@ func (c *CPU6502) modAbsoluteX65C02(modifier ModifierOp) (uint64, bool) {
 	operAddr, _ := c.getAddrAbsoluteX()
 	oper := c.Mem.Load(operAddr)
 	res := modifier(c, oper)
-	c.Mem.Store(operAddr, res)
+	c.storeModified(operAddr, oper, res)
 	c.nzFlags(res)
 	c.PC++
 
@@ -895,7 +895,7 @@ func (c *CPU6502) bitZeroPageX() (uint64, bool) {
 }
 
 func (c *CPU6502) bitImmediate() (uint64, bool) {
-	oper := c.Mem.Load(c.PC)
+	oper := c.fetchOperand()
 	c.bitBase(oper)
 	c.PC++
 
@@ -937,7 +937,7 @@ func (c *CPU6502) trbZeroPage() (uint64, bool) {
 	oper := c.Mem.Load(addr)
 	res := c.trbBase(oper)
 
-	c.Mem.Store(addr, res)
+	c.storeModified(addr, oper, res)
 	c.PC++
 
 	return 5, false
@@ -949,7 +949,7 @@ func (c *CPU6502) trbAbsolute() (uint64, bool) {
 	oper := c.Mem.Load(addr)
 	res := c.trbBase(oper)
 
-	c.Mem.Store(addr, res)
+	c.storeModified(addr, oper, res)
 	c.PC++
 
 	return 6, false
@@ -973,7 +973,7 @@ func (c *CPU6502) tsbZeroPage() (uint64, bool) {
 	oper := c.Mem.Load(addr)
 	res := c.tsbBase(oper)
 
-	c.Mem.Store(addr, res)
+	c.storeModified(addr, oper, res)
 	c.PC++
 
 	return 5, false
@@ -985,7 +985,7 @@ func (c *CPU6502) tsbAbsolute() (uint64, bool) {
 	oper := c.Mem.Load(addr)
 	res := c.tsbBase(oper)
 
-	c.Mem.Store(addr, res)
+	c.storeModified(addr, oper, res)
 	c.PC++
 
 	return 6, false
@@ -999,7 +999,7 @@ func (c *CPU6502) rmbBase(bit uint8) (uint64, bool) {
 	oper := c.Mem.Load(addr)
 	res := oper & (bit ^ 0xFF)
 
-	c.Mem.Store(addr, res)
+	c.storeModified(addr, oper, res)
 	c.PC++
 
 	return 5, false
@@ -1045,7 +1045,7 @@ func (c *CPU6502) smbBase(bit uint8) (uint64, bool) {
 	oper := c.Mem.Load(addr)
 	res := oper | bit
 
-	c.Mem.Store(addr, res)
+	c.storeModified(addr, oper, res)
 	c.PC++
 
 	return 5, false
@@ -1,12 +1,19 @@
 package cpu
 
+import "6502profiler/memory"
+
 func (c *CPU6502) branchOnFlagClear(flag uint8) (uint64, bool) {
 	if (c.Flags & flag) != 0 {
-		c.PC++ // Skip when flag is set
+		// The operand is read even if the branch is not taken
+		_ = c.fetchOperand()
+		c.PC++
+		c.tracer.branch(false)
 		return 2, false
 	}
 
+	c.tracer.branch(true)
 	branchAddress, additionalCycle := c.getAddrRelative()
+	c.dummyBranchReads(c.PC+1, branchAddress)
 	c.PC = branchAddress
 
 	return 3 + additionalCycle, false
@@ -14,34 +21,45 @@ func (c *CPU6502) branchOnFlagClear(flag uint8) (uint64, bool) {
 
 func (c *CPU6502) branchOnFlagSet(flag uint8) (uint64, bool) {
 	if (c.Flags & flag) == 0 {
-		c.PC++ // Skip when flag is clear
+		// The operand is read even if the branch is not taken
+		_ = c.fetchOperand()
+		c.PC++
+		c.tracer.branch(false)
 		return 2, false
 	}
 
+	c.tracer.branch(true)
 	branchAddress, additionalCycle := c.getAddrRelative()
+	c.dummyBranchReads(c.PC+1, branchAddress)
 	c.PC = branchAddress
 
 	return 3 + additionalCycle, false
 }
 
 func (c *CPU6502) branchOnBitClear(bit uint8) (uint64, bool) {
-	zpAddr, branchAddr, additionalCycle := c.getAddressesBitBranchRelative()
-	if (c.Mem.Load(zpAddr) & bit) != 0 {
+	_, value, branchAddr, additionalCycle := c.getAddressesBitBranchRelative()
+	if (value & bit) != 0 {
 		c.PC++
+		c.tracer.branch(false)
 		return 5, false
 	}
 
+	c.tracer.branch(true)
+	c.dummyBranchReads(c.PC+1, branchAddr)
 	c.PC = branchAddr
 	return 6 + additionalCycle, false
 }
 
 func (c *CPU6502) branchOnBitSet(bit uint8) (uint64, bool) {
-	zpAddr, branchAddr, additionalCycle := c.getAddressesBitBranchRelative()
-	if (c.Mem.Load(zpAddr) & bit) == 0 {
+	_, value, branchAddr, additionalCycle := c.getAddressesBitBranchRelative()
+	if (value & bit) == 0 {
 		c.PC++
+		c.tracer.branch(false)
 		return 5, false
 	}
 
+	c.tracer.branch(true)
+	c.dummyBranchReads(c.PC+1, branchAddr)
 	c.PC = branchAddr
 	return 6 + additionalCycle, false
 }
@@ -98,6 +116,7 @@ func (c *CPU6502) bvs() (uint64, bool) {
 
 func (c *CPU6502) bra() (uint64, bool) {
 	branchAddress, additionalCycle := c.getAddrRelative()
+	c.dummyBranchReads(c.PC+1, branchAddress)
 	c.PC = branchAddress
 
 	return 3 + additionalCycle, false
@@ -173,13 +192,18 @@ func (c *CPU6502) bbs7() (uint64, bool) {
 
 // -------- JSR--------
 
+// JSR pushes the return address before it fetches the high byte of the target address
 func (c *CPU6502) jsr() (uint64, bool) {
-	addr := c.getAddrAbsolute()
+	targetLo := c.fetchOperand()
+	c.PC++
+	c.dummyStackRead()
 	hiByte := uint8((c.PC & 0xFF00) >> 8)
 	c.push(hiByte)
 	loByte := uint8(c.PC & 0x00FF)
 	c.push(loByte)
+	addr := uint16(c.fetchOperand())*256 + uint16(targetLo)
 	c.PC = addr
+	c.monitor.call(uint32(addr), uint16(c.SP))
 
 	return 6, false
 }
@@ -187,10 +211,18 @@ func (c *CPU6502) jsr() (uint64, bool) {
 // -------- RTS--------
 
 func (c *CPU6502) rts() (uint64, bool) {
+	c.dummyStackRead()
 	loByte := uint16(c.pop())
 	hiByte := uint16(c.pop())
+
+	// The last byte of the JSR instruction is read while the return address is incremented
+	if c.busAccurate {
+		c.dummyRead(hiByte*256+loByte, memory.AccessOperandFetch)
+	}
+
 	addr := hiByte*256 + loByte + 1
 	c.PC = addr
+	c.monitor.ret(uint16(c.SP), 0x00FF)
 
 	return 6, false
 }
@@ -29,7 +29,7 @@ func (c *CPU6502) cmpBase(val1, val2 uint8) {
 // -------- CPY --------
 
 func (c *CPU6502) cpyImmediate() (uint64, bool) {
-	c.cmpBase(c.Y, c.Mem.Load(c.PC))
+	c.cmpBase(c.Y, c.fetchOperand())
 	c.PC++
 
 	return 2, false
@@ -54,7 +54,7 @@ func (c *CPU6502) cpyAbsolute() (uint64, bool) {
 // -------- CPX --------
 
 func (c *CPU6502) cpxImmediate() (uint64, bool) {
-	c.cmpBase(c.X, c.Mem.Load(c.PC))
+	c.cmpBase(c.X, c.fetchOperand())
 	c.PC++
 
 	return 2, false
@@ -79,7 +79,7 @@ func (c *CPU6502) cpxAbsolute() (uint64, bool) {
 // -------- CMP --------
 
 func (c *CPU6502) cmpImmediate() (uint64, bool) {
-	c.cmpBase(c.A, c.Mem.Load(c.PC))
+	c.cmpBase(c.A, c.fetchOperand())
 	c.PC++
 
 	return 2, false
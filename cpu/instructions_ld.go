@@ -10,7 +10,7 @@ func (c *CPU6502) ldxBase(value uint8) bool {
 }
 
 func (c *CPU6502) ldxImmediate() (uint64, bool) {
-	stop := c.ldxBase(c.Mem.Load(c.PC))
+	stop := c.ldxBase(c.fetchOperand())
 	c.PC++
 
 	return 2, stop
@@ -56,7 +56,7 @@ func (c *CPU6502) ldyBase(value uint8) bool {
 }
 
 func (c *CPU6502) ldyImmediate() (uint64, bool) {
-	stop := c.ldyBase(c.Mem.Load(c.PC))
+	stop := c.ldyBase(c.fetchOperand())
 	c.PC++
 
 	return 2, stop
@@ -102,7 +102,7 @@ func (c *CPU6502) ldaBase(value uint8) bool {
 }
 
 func (c *CPU6502) ldaImmediate() (uint64, bool) {
-	stop := c.ldaBase(c.Mem.Load(c.PC))
+	stop := c.ldaBase(c.fetchOperand())
 	c.PC++
 
 	return 2, stop
@@ -17,16 +17,14 @@ func (c *CPU6502) staZeroPage() (uint64, bool) {
 }
 
 func (c *CPU6502) staAbsoluteY() (uint64, bool) {
-	addr, _ := c.getAddrAbsoluteY()
-	c.Mem.Store(addr, c.A)
+	c.Mem.Store(c.getAddrAbsoluteYWrite(), c.A)
 	c.PC++
 
 	return 5, false
 }
 
 func (c *CPU6502) staAbsoluteX() (uint64, bool) {
-	addr, _ := c.getAddrAbsoluteX()
-	c.Mem.Store(addr, c.A)
+	c.Mem.Store(c.getAddrAbsoluteXWrite(), c.A)
 	c.PC++
 
 	return 5, false
@@ -40,8 +38,7 @@ func (c *CPU6502) staZeroPageX() (uint64, bool) {
 }
 
 func (c *CPU6502) staIndirectY() (uint64, bool) {
-	addr, _ := c.getAddrIndirectIdxY()
-	c.Mem.Store(addr, c.A)
+	c.Mem.Store(c.getAddrIndirectIdxYWrite(), c.A)
 	c.PC++
 
 	return 6, false
@@ -87,8 +84,7 @@ func (c *CPU6502) stzAbsolute() (uint64, bool) {
 }
 
 func (c *CPU6502) stzAbsoluteX() (uint64, bool) {
-	addr, _ := c.getAddrAbsoluteX()
-	c.Mem.Store(addr, 0)
+	c.Mem.Store(c.getAddrAbsoluteXWrite(), 0)
 	c.PC++
 
 	return 6, false
@@ -150,6 +146,7 @@ func (c *CPU6502) pha() (uint64, bool) {
 // -------- PLA --------
 
 func (c *CPU6502) pla() (uint64, bool) {
+	c.dummyStackRead()
 	c.A = c.pop()
 	c.nzFlags(c.A)
 	return 4, false
@@ -165,6 +162,7 @@ func (c *CPU6502) phx() (uint64, bool) {
 // -------- PLX --------
 
 func (c *CPU6502) plx() (uint64, bool) {
+	c.dummyStackRead()
 	c.X = c.pop()
 	c.nzFlags(c.X)
 	return 4, false
@@ -180,6 +178,7 @@ func (c *CPU6502) phy() (uint64, bool) {
 // -------- PLY --------
 
 func (c *CPU6502) ply() (uint64, bool) {
+	c.dummyStackRead()
 	c.Y = c.pop()
 	c.nzFlags(c.Y)
 	return 4, false
@@ -240,6 +239,7 @@ func (c *CPU6502) php() (uint64, bool) {
 // -------- PLP --------
 
 func (c *CPU6502) plp() (uint64, bool) {
+	c.dummyStackRead()
 	c.Flags = c.pop()
 
 	return 4, false
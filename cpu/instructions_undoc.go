@@ -0,0 +1,497 @@
+package cpu
+
+// This file implements the stable undocumented instructions of the NMOS 6502/6510. Unstable
+// instructions (XAA, LXA, SHA, SHX, SHY, TAS) and the JAM opcodes are not simulated.
+
+// -------- Read-modify-write combinations --------
+
+// A ComboOp combines the result of a read-modify-write operation with the accumulator
+type ComboOp func(c *CPU6502, val uint8)
+
+func oraAccu(c *CPU6502, val uint8) {
+	c.A |= val
+	c.nzFlags(c.A)
+}
+
+func andAccu(c *CPU6502, val uint8) {
+	c.A &= val
+	c.nzFlags(c.A)
+}
+
+func eorAccu(c *CPU6502, val uint8) {
+	c.A ^= val
+	c.nzFlags(c.A)
+}
+
+func adcAccu(c *CPU6502, val uint8) {
+	c.A, _ = c.addBase(c.A, val)
+}
+
+func sbcAccu(c *CPU6502, val uint8) {
+	c.A, _ = c.subBase(c.A, val)
+}
+
+func cmpAccu(c *CPU6502, val uint8) {
+	c.cmpBase(c.A, val)
+}
+
+func (c *CPU6502) comboBase(operAddr uint16, modifier ModifierOp, combine ComboOp) {
+	oper := c.Mem.Load(operAddr)
+	res := modifier(c, oper)
+	c.storeModified(operAddr, oper, res)
+	combine(c, res)
+}
+
+func (c *CPU6502) comboZeroPage(modifier ModifierOp, combine ComboOp) (uint64, bool) {
+	c.comboBase(c.getAddrZeroPage(), modifier, combine)
+	c.PC++
+
+	return 5, false
+}
+
+func (c *CPU6502) comboZeroPageX(modifier ModifierOp, combine ComboOp) (uint64, bool) {
+	c.comboBase(c.getAddrZeroPageX(), modifier, combine)
+	c.PC++
+
+	return 6, false
+}
+
+func (c *CPU6502) comboAbsolute(modifier ModifierOp, combine ComboOp) (uint64, bool) {
+	c.comboBase(c.getAddrAbsolute(), modifier, combine)
+	c.PC++
+
+	return 6, false
+}
+
+func (c *CPU6502) comboAbsoluteX(modifier ModifierOp, combine ComboOp) (uint64, bool) {
+	operAddr := c.getAddrAbsoluteXWrite()
+	c.comboBase(operAddr, modifier, combine)
+	c.PC++
+
+	return 7, false
+}
+
+func (c *CPU6502) comboAbsoluteY(modifier ModifierOp, combine ComboOp) (uint64, bool) {
+	operAddr := c.getAddrAbsoluteYWrite()
+	c.comboBase(operAddr, modifier, combine)
+	c.PC++
+
+	return 7, false
+}
+
+func (c *CPU6502) comboIdxXIndirect(modifier ModifierOp, combine ComboOp) (uint64, bool) {
+	c.comboBase(c.getAddrIdxIndirectX(), modifier, combine)
+	c.PC++
+
+	return 8, false
+}
+
+func (c *CPU6502) comboIndirectIdxY(modifier ModifierOp, combine ComboOp) (uint64, bool) {
+	operAddr := c.getAddrIndirectIdxYWrite()
+	c.comboBase(operAddr, modifier, combine)
+	c.PC++
+
+	return 8, false
+}
+
+// -------- SLO (ASL + ORA) --------
+
+func (c *CPU6502) sloZeroPage() (uint64, bool) {
+	return c.comboZeroPage(Asl, oraAccu)
+}
+
+func (c *CPU6502) sloZeroPageX() (uint64, bool) {
+	return c.comboZeroPageX(Asl, oraAccu)
+}
+
+func (c *CPU6502) sloAbsolute() (uint64, bool) {
+	return c.comboAbsolute(Asl, oraAccu)
+}
+
+func (c *CPU6502) sloAbsoluteX() (uint64, bool) {
+	return c.comboAbsoluteX(Asl, oraAccu)
+}
+
+func (c *CPU6502) sloAbsoluteY() (uint64, bool) {
+	return c.comboAbsoluteY(Asl, oraAccu)
+}
+
+func (c *CPU6502) sloIdxXIndirect() (uint64, bool) {
+	return c.comboIdxXIndirect(Asl, oraAccu)
+}
+
+func (c *CPU6502) sloIndirectIdxY() (uint64, bool) {
+	return c.comboIndirectIdxY(Asl, oraAccu)
+}
+
+// -------- RLA (ROL + AND) --------
+
+func (c *CPU6502) rlaZeroPage() (uint64, bool) {
+	return c.comboZeroPage(Rol, andAccu)
+}
+
+func (c *CPU6502) rlaZeroPageX() (uint64, bool) {
+	return c.comboZeroPageX(Rol, andAccu)
+}
+
+func (c *CPU6502) rlaAbsolute() (uint64, bool) {
+	return c.comboAbsolute(Rol, andAccu)
+}
+
+func (c *CPU6502) rlaAbsoluteX() (uint64, bool) {
+	return c.comboAbsoluteX(Rol, andAccu)
+}
+
+func (c *CPU6502) rlaAbsoluteY() (uint64, bool) {
+	return c.comboAbsoluteY(Rol, andAccu)
+}
+
+func (c *CPU6502) rlaIdxXIndirect() (uint64, bool) {
+	return c.comboIdxXIndirect(Rol, andAccu)
+}
+
+func (c *CPU6502) rlaIndirectIdxY() (uint64, bool) {
+	return c.comboIndirectIdxY(Rol, andAccu)
+}
+
+// -------- SRE (LSR + EOR) --------
+
+func (c *CPU6502) sreZeroPage() (uint64, bool) {
+	return c.comboZeroPage(Lsr, eorAccu)
+}
+
+func (c *CPU6502) sreZeroPageX() (uint64, bool) {
+	return c.comboZeroPageX(Lsr, eorAccu)
+}
+
+func (c *CPU6502) sreAbsolute() (uint64, bool) {
+	return c.comboAbsolute(Lsr, eorAccu)
+}
+
+func (c *CPU6502) sreAbsoluteX() (uint64, bool) {
+	return c.comboAbsoluteX(Lsr, eorAccu)
+}
+
+func (c *CPU6502) sreAbsoluteY() (uint64, bool) {
+	return c.comboAbsoluteY(Lsr, eorAccu)
+}
+
+func (c *CPU6502) sreIdxXIndirect() (uint64, bool) {
+	return c.comboIdxXIndirect(Lsr, eorAccu)
+}
+
+func (c *CPU6502) sreIndirectIdxY() (uint64, bool) {
+	return c.comboIndirectIdxY(Lsr, eorAccu)
+}
+
+// -------- RRA (ROR + ADC) --------
+
+func (c *CPU6502) rraZeroPage() (uint64, bool) {
+	return c.comboZeroPage(Ror, adcAccu)
+}
+
+func (c *CPU6502) rraZeroPageX() (uint64, bool) {
+	return c.comboZeroPageX(Ror, adcAccu)
+}
+
+func (c *CPU6502) rraAbsolute() (uint64, bool) {
+	return c.comboAbsolute(Ror, adcAccu)
+}
+
+func (c *CPU6502) rraAbsoluteX() (uint64, bool) {
+	return c.comboAbsoluteX(Ror, adcAccu)
+}
+
+func (c *CPU6502) rraAbsoluteY() (uint64, bool) {
+	return c.comboAbsoluteY(Ror, adcAccu)
+}
+
+func (c *CPU6502) rraIdxXIndirect() (uint64, bool) {
+	return c.comboIdxXIndirect(Ror, adcAccu)
+}
+
+func (c *CPU6502) rraIndirectIdxY() (uint64, bool) {
+	return c.comboIndirectIdxY(Ror, adcAccu)
+}
+
+// -------- DCP (DEC + CMP) --------
+
+func (c *CPU6502) dcpZeroPage() (uint64, bool) {
+	return c.comboZeroPage(Dec, cmpAccu)
+}
+
+func (c *CPU6502) dcpZeroPageX() (uint64, bool) {
+	return c.comboZeroPageX(Dec, cmpAccu)
+}
+
+func (c *CPU6502) dcpAbsolute() (uint64, bool) {
+	return c.comboAbsolute(Dec, cmpAccu)
+}
+
+func (c *CPU6502) dcpAbsoluteX() (uint64, bool) {
+	return c.comboAbsoluteX(Dec, cmpAccu)
+}
+
+func (c *CPU6502) dcpAbsoluteY() (uint64, bool) {
+	return c.comboAbsoluteY(Dec, cmpAccu)
+}
+
+func (c *CPU6502) dcpIdxXIndirect() (uint64, bool) {
+	return c.comboIdxXIndirect(Dec, cmpAccu)
+}
+
+func (c *CPU6502) dcpIndirectIdxY() (uint64, bool) {
+	return c.comboIndirectIdxY(Dec, cmpAccu)
+}
+
+// -------- ISC (INC + SBC) --------
+
+func (c *CPU6502) iscZeroPage() (uint64, bool) {
+	return c.comboZeroPage(Inc, sbcAccu)
+}
+
+func (c *CPU6502) iscZeroPageX() (uint64, bool) {
+	return c.comboZeroPageX(Inc, sbcAccu)
+}
+
+func (c *CPU6502) iscAbsolute() (uint64, bool) {
+	return c.comboAbsolute(Inc, sbcAccu)
+}
+
+func (c *CPU6502) iscAbsoluteX() (uint64, bool) {
+	return c.comboAbsoluteX(Inc, sbcAccu)
+}
+
+func (c *CPU6502) iscAbsoluteY() (uint64, bool) {
+	return c.comboAbsoluteY(Inc, sbcAccu)
+}
+
+func (c *CPU6502) iscIdxXIndirect() (uint64, bool) {
+	return c.comboIdxXIndirect(Inc, sbcAccu)
+}
+
+func (c *CPU6502) iscIndirectIdxY() (uint64, bool) {
+	return c.comboIndirectIdxY(Inc, sbcAccu)
+}
+
+// -------- SAX --------
+
+func (c *CPU6502) saxZeroPage() (uint64, bool) {
+	c.Mem.Store(c.getAddrZeroPage(), c.A&c.X)
+	c.PC++
+
+	return 3, false
+}
+
+func (c *CPU6502) saxZeroPageY() (uint64, bool) {
+	c.Mem.Store(c.getAddrZeroPageY(), c.A&c.X)
+	c.PC++
+
+	return 4, false
+}
+
+func (c *CPU6502) saxAbsolute() (uint64, bool) {
+	c.Mem.Store(c.getAddrAbsolute(), c.A&c.X)
+	c.PC++
+
+	return 4, false
+}
+
+func (c *CPU6502) saxIdxXIndirect() (uint64, bool) {
+	c.Mem.Store(c.getAddrIdxIndirectX(), c.A&c.X)
+	c.PC++
+
+	return 6, false
+}
+
+// -------- LAX --------
+
+func (c *CPU6502) laxBase(value uint8) bool {
+	c.A = value
+	c.X = value
+	c.nzFlags(value)
+
+	return false
+}
+
+func (c *CPU6502) laxZeroPage() (uint64, bool) {
+	stop := c.laxBase(c.Mem.Load(c.getAddrZeroPage()))
+	c.PC++
+
+	return 3, stop
+}
+
+func (c *CPU6502) laxZeroPageY() (uint64, bool) {
+	stop := c.laxBase(c.Mem.Load(c.getAddrZeroPageY()))
+	c.PC++
+
+	return 4, stop
+}
+
+func (c *CPU6502) laxAbsolute() (uint64, bool) {
+	stop := c.laxBase(c.Mem.Load(c.getAddrAbsolute()))
+	c.PC++
+
+	return 4, stop
+}
+
+func (c *CPU6502) laxAbsoluteY() (uint64, bool) {
+	operandAddress, additionalCycle := c.getAddrAbsoluteY()
+	stop := c.laxBase(c.Mem.Load(operandAddress))
+	c.PC++
+
+	return 4 + additionalCycle, stop
+}
+
+func (c *CPU6502) laxIdxXIndirect() (uint64, bool) {
+	stop := c.laxBase(c.Mem.Load(c.getAddrIdxIndirectX()))
+	c.PC++
+
+	return 6, stop
+}
+
+func (c *CPU6502) laxIndirectIdxY() (uint64, bool) {
+	operandAddress, additionalCycle := c.getAddrIndirectIdxY()
+	stop := c.laxBase(c.Mem.Load(operandAddress))
+	c.PC++
+
+	return 5 + additionalCycle, stop
+}
+
+// -------- LAS --------
+
+func (c *CPU6502) lasAbsoluteY() (uint64, bool) {
+	operandAddress, additionalCycle := c.getAddrAbsoluteY()
+	val := c.Mem.Load(operandAddress) & c.SP
+	c.A = val
+	c.X = val
+	c.SP = val
+	c.nzFlags(val)
+	c.PC++
+
+	return 4 + additionalCycle, false
+}
+
+// -------- ANC --------
+
+func (c *CPU6502) ancImmediate() (uint64, bool) {
+	c.A &= c.fetchOperand()
+	c.nzFlags(c.A)
+
+	if (c.A & 0x80) != 0 {
+		c.Flags |= Flag_C
+	} else {
+		c.Flags &= (^Flag_C)
+	}
+
+	c.PC++
+
+	return 2, false
+}
+
+// -------- ALR --------
+
+func (c *CPU6502) alrImmediate() (uint64, bool) {
+	c.A = Lsr(c, c.A&c.fetchOperand())
+	c.nzFlags(c.A)
+	c.PC++
+
+	return 2, false
+}
+
+// -------- ARR --------
+
+// See "No More Secrets - NMOS 6510 Unintended Opcodes" for the description of the flag
+// behaviour in binary and decimal mode.
+func (c *CPU6502) arrImmediate() (uint64, bool) {
+	t := c.A & c.fetchOperand()
+	var carryIn uint8 = 0
+
+	if (c.Flags & Flag_C) != 0 {
+		carryIn = 0x80
+	}
+
+	res := (t >> 1) | carryIn
+	c.nzFlags(res)
+
+	if ((c.Flags & Flag_D) == 0) || !c.model.HasDecimalMode() {
+		c.setFlag(Flag_C, (res&0x40) != 0)
+		c.setFlag(Flag_V, ((res>>6)^(res>>5))&1 != 0)
+	} else {
+		// In decimal mode N is a copy of the carry flag and Z is based on the binary result
+		c.setFlag(Flag_V, ((t^res)&0x40) != 0)
+		loNibble := t & 0x0F
+		hiNibble := t >> 4
+
+		if (loNibble + (loNibble & 1)) > 5 {
+			res = (res & 0xF0) | ((res + 6) & 0x0F)
+		}
+
+		carry := (hiNibble + (hiNibble & 1)) > 5
+		c.setFlag(Flag_C, carry)
+
+		if carry {
+			res += 0x60
+		}
+	}
+
+	c.A = res
+	c.PC++
+
+	return 2, false
+}
+
+// -------- SBX --------
+
+func (c *CPU6502) sbxImmediate() (uint64, bool) {
+	t := c.A & c.X
+	oper := c.fetchOperand()
+
+	c.setFlag(Flag_C, t >= oper)
+	c.X = t - oper
+	c.nzFlags(c.X)
+	c.PC++
+
+	return 2, false
+}
+
+// -------- NOP variants --------
+
+func (c *CPU6502) nopImplied() (uint64, bool) {
+	return 2, false
+}
+
+func (c *CPU6502) nopImmediate() (uint64, bool) {
+	c.PC++
+
+	return 2, false
+}
+
+func (c *CPU6502) nopZeroPage() (uint64, bool) {
+	_ = c.Mem.Load(c.getAddrZeroPage())
+	c.PC++
+
+	return 3, false
+}
+
+func (c *CPU6502) nopZeroPageX() (uint64, bool) {
+	_ = c.Mem.Load(c.getAddrZeroPageX())
+	c.PC++
+
+	return 4, false
+}
+
+func (c *CPU6502) nopAbsolute() (uint64, bool) {
+	_ = c.Mem.Load(c.getAddrAbsolute())
+	c.PC++
+
+	return 4, false
+}
+
+func (c *CPU6502) nopAbsoluteX() (uint64, bool) {
+	addr, additionalCycle := c.getAddrAbsoluteX()
+	_ = c.Mem.Load(addr)
+	c.PC++
+
+	return 4 + additionalCycle, false
+}
@@ -0,0 +1,574 @@
+package cpu
+
+import (
+	"6502profiler/memory"
+	"testing"
+)
+
+// -------- Model selection --------
+
+func TestUndocumentedOnlyIn6510(t *testing.T) {
+	cpu := New6502(Model6502)
+	cpu.Init(memory.NewLinearMemory(8192))
+
+	// lax $20
+	// brk
+	err := cpu.CopyAndRun([]byte{0xA7, 0x20, 0x00}, UnitProgStart)
+	if err == nil {
+		t.Fatal("Undocumented opcode was accepted by 6502 model")
+	}
+
+	cpu = New6502(Model65C02)
+	cpu.Init(memory.NewLinearMemory(8192))
+
+	// nop
+	// brk
+	err = cpu.CopyAndRun([]byte{0xEA, 0x00}, UnitProgStart)
+	if err != nil {
+		t.Fatal("65C02 model does not work")
+	}
+
+	if _, ok := cpu.opCodes[0x03]; ok {
+		t.Fatal("Undocumented opcode was accepted by 65C02 model")
+	}
+}
+
+// -------- LAX --------
+
+func TestLAXZeroPage(t *testing.T) {
+	arranger := func(c *CPU6502) {
+		c.Mem.Store(0x0020, 0x81)
+	}
+
+	verifier := func(c *CPU6502) bool {
+		return (c.A == 0x81) && (c.X == 0x81) && ((c.Flags & Flag_N) != 0) && ((c.Flags & Flag_Z) == 0) && (c.NumCycles() == 3)
+	}
+
+	// lax $20
+	// brk
+	c := InstructionTestCase{
+		model:           Model6510,
+		testProg:        []byte{0xA7, 0x20, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "LAX zero page",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
+
+func TestLAXAbsoluteYPageCross(t *testing.T) {
+	arranger := func(c *CPU6502) {
+		c.Mem.Store(0x1100, 0x00)
+		c.Y = 0x01
+		c.A = 0x12
+	}
+
+	verifier := func(c *CPU6502) bool {
+		return (c.A == 0x00) && (c.X == 0x00) && ((c.Flags & Flag_Z) != 0) && (c.NumCycles() == 5)
+	}
+
+	// lax $10ff,y
+	// brk
+	c := InstructionTestCase{
+		model:           Model6510,
+		testProg:        []byte{0xBF, 0xFF, 0x10, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "LAX absolute Y",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
+
+func TestLAXIndirectIdxY(t *testing.T) {
+	arranger := func(c *CPU6502) {
+		c.Mem.Store(0x0040, 0x00)
+		c.Mem.Store(0x0041, 0x10)
+		c.Mem.Store(0x1005, 0x42)
+		c.Y = 0x05
+	}
+
+	verifier := func(c *CPU6502) bool {
+		return (c.A == 0x42) && (c.X == 0x42) && (c.NumCycles() == 5)
+	}
+
+	// lax ($40),y
+	// brk
+	c := InstructionTestCase{
+		model:           Model6510,
+		testProg:        []byte{0xB3, 0x40, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "LAX indirect indexed Y",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
+
+// -------- SAX --------
+
+func TestSAXZeroPageY(t *testing.T) {
+	var flagsBefore uint8
+
+	arranger := func(c *CPU6502) {
+		c.A = 0xF3
+		c.X = 0x3F
+		c.Y = 0x02
+		flagsBefore = c.Flags
+	}
+
+	verifier := func(c *CPU6502) bool {
+		return (c.Mem.Load(0x0022) == 0x33) && (c.Flags == flagsBefore) && (c.NumCycles() == 4)
+	}
+
+	// sax $20,y
+	// brk
+	c := InstructionTestCase{
+		model:           Model6510,
+		testProg:        []byte{0x97, 0x20, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "SAX zero page Y",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
+
+func TestSAXAbsolute(t *testing.T) {
+	arranger := func(c *CPU6502) {
+		c.A = 0x0F
+		c.X = 0xF0
+	}
+
+	verifier := func(c *CPU6502) bool {
+		return (c.Mem.Load(0x1000) == 0x00) && ((c.Flags & Flag_Z) == 0) && (c.NumCycles() == 4)
+	}
+
+	// sax $1000
+	// brk
+	c := InstructionTestCase{
+		model:           Model6510,
+		testProg:        []byte{0x8F, 0x00, 0x10, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "SAX absolute",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
+
+// -------- SLO --------
+
+func TestSLOZeroPage(t *testing.T) {
+	arranger := func(c *CPU6502) {
+		c.Mem.Store(0x0030, 0x81)
+		c.A = 0x10
+	}
+
+	verifier := func(c *CPU6502) bool {
+		return (c.Mem.Load(0x0030) == 0x02) && (c.A == 0x12) && ((c.Flags & Flag_C) != 0) && ((c.Flags & Flag_N) == 0) && (c.NumCycles() == 5)
+	}
+
+	// slo $30
+	// brk
+	c := InstructionTestCase{
+		model:           Model6510,
+		testProg:        []byte{0x07, 0x30, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "SLO zero page",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
+
+func TestSLOAbsoluteY(t *testing.T) {
+	arranger := func(c *CPU6502) {
+		c.Mem.Store(0x1100, 0x40)
+		c.Y = 0x01
+		c.A = 0x00
+	}
+
+	verifier := func(c *CPU6502) bool {
+		return (c.Mem.Load(0x1100) == 0x80) && (c.A == 0x80) && ((c.Flags & Flag_C) == 0) && ((c.Flags & Flag_N) != 0) && (c.NumCycles() == 7)
+	}
+
+	// slo $10ff,y
+	// brk
+	c := InstructionTestCase{
+		model:           Model6510,
+		testProg:        []byte{0x1B, 0xFF, 0x10, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "SLO absolute Y",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
+
+// -------- RLA --------
+
+func TestRLAAbsolute(t *testing.T) {
+	arranger := func(c *CPU6502) {
+		c.Mem.Store(0x1000, 0x81)
+		c.A = 0xFF
+		c.Flags |= Flag_C
+	}
+
+	verifier := func(c *CPU6502) bool {
+		return (c.Mem.Load(0x1000) == 0x03) && (c.A == 0x03) && ((c.Flags & Flag_C) != 0) && (c.NumCycles() == 6)
+	}
+
+	// rla $1000
+	// brk
+	c := InstructionTestCase{
+		model:           Model6510,
+		testProg:        []byte{0x2F, 0x00, 0x10, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "RLA absolute",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
+
+// -------- SRE --------
+
+func TestSREIdxXIndirect(t *testing.T) {
+	arranger := func(c *CPU6502) {
+		c.Mem.Store(0x0042, 0x00)
+		c.Mem.Store(0x0043, 0x10)
+		c.Mem.Store(0x1000, 0x03)
+		c.X = 0x02
+		c.A = 0x01
+	}
+
+	verifier := func(c *CPU6502) bool {
+		return (c.Mem.Load(0x1000) == 0x01) && (c.A == 0x00) && ((c.Flags & Flag_C) != 0) && ((c.Flags & Flag_Z) != 0) && (c.NumCycles() == 8)
+	}
+
+	// sre ($40,x)
+	// brk
+	c := InstructionTestCase{
+		model:           Model6510,
+		testProg:        []byte{0x43, 0x40, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "SRE indexed indirect X",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
+
+// -------- RRA --------
+
+func TestRRAZeroPageX(t *testing.T) {
+	arranger := func(c *CPU6502) {
+		c.Mem.Store(0x0031, 0x03)
+		c.X = 0x01
+		c.A = 0x10
+	}
+
+	// ror yields $01 and sets carry, adc calculates $10 + $01 + 1
+	verifier := func(c *CPU6502) bool {
+		return (c.Mem.Load(0x0031) == 0x01) && (c.A == 0x12) && ((c.Flags & Flag_C) == 0) && (c.NumCycles() == 6)
+	}
+
+	// rra $30,x
+	// brk
+	c := InstructionTestCase{
+		model:           Model6510,
+		testProg:        []byte{0x77, 0x30, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "RRA zero page X",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
+
+// -------- DCP --------
+
+func TestDCPAbsoluteX(t *testing.T) {
+	arranger := func(c *CPU6502) {
+		c.Mem.Store(0x1010, 0x43)
+		c.X = 0x10
+		c.A = 0x42
+	}
+
+	verifier := func(c *CPU6502) bool {
+		return (c.Mem.Load(0x1010) == 0x42) && ((c.Flags & Flag_Z) != 0) && ((c.Flags & Flag_C) != 0) && (c.A == 0x42) && (c.NumCycles() == 7)
+	}
+
+	// dcp $1000,x
+	// brk
+	c := InstructionTestCase{
+		model:           Model6510,
+		testProg:        []byte{0xDF, 0x00, 0x10, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "DCP absolute X",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
+
+// -------- ISC --------
+
+func TestISCIndirectIdxY(t *testing.T) {
+	arranger := func(c *CPU6502) {
+		c.Mem.Store(0x0040, 0xFF)
+		c.Mem.Store(0x0041, 0x10)
+		c.Mem.Store(0x1100, 0x0F)
+		c.Y = 0x01
+		c.A = 0x20
+		c.Flags |= Flag_C
+	}
+
+	verifier := func(c *CPU6502) bool {
+		return (c.Mem.Load(0x1100) == 0x10) && (c.A == 0x10) && ((c.Flags & Flag_C) != 0) && (c.NumCycles() == 8)
+	}
+
+	// isc ($40),y
+	// brk
+	c := InstructionTestCase{
+		model:           Model6510,
+		testProg:        []byte{0xF3, 0x40, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "ISC indirect indexed Y",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
+
+// -------- ANC --------
+
+func TestANC(t *testing.T) {
+	arranger := func(c *CPU6502) {
+		c.A = 0xF0
+	}
+
+	verifier := func(c *CPU6502) bool {
+		return (c.A == 0x80) && ((c.Flags & Flag_C) != 0) && ((c.Flags & Flag_N) != 0) && (c.NumCycles() == 2)
+	}
+
+	// anc #$8F
+	// brk
+	c := InstructionTestCase{
+		model:           Model6510,
+		testProg:        []byte{0x0B, 0x8F, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "ANC",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
+
+// -------- ALR --------
+
+func TestALR(t *testing.T) {
+	arranger := func(c *CPU6502) {
+		c.A = 0xFF
+	}
+
+	verifier := func(c *CPU6502) bool {
+		return (c.A == 0x01) && ((c.Flags & Flag_C) != 0) && ((c.Flags & Flag_N) == 0) && (c.NumCycles() == 2)
+	}
+
+	// alr #$03
+	// brk
+	c := InstructionTestCase{
+		model:           Model6510,
+		testProg:        []byte{0x4B, 0x03, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "ALR",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
+
+// -------- ARR --------
+
+func TestARR(t *testing.T) {
+	arranger := func(c *CPU6502) {
+		c.A = 0xFF
+		c.Flags |= Flag_C
+	}
+
+	// $C0 ror with carry => $E0, C = bit 6, V = bit 6 xor bit 5
+	verifier := func(c *CPU6502) bool {
+		return (c.A == 0xE0) && ((c.Flags & Flag_C) != 0) && ((c.Flags & Flag_V) == 0) && ((c.Flags & Flag_N) != 0)
+	}
+
+	// arr #$C0
+	// brk
+	c := InstructionTestCase{
+		model:           Model6510,
+		testProg:        []byte{0x6B, 0xC0, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "ARR",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
+
+func TestARROverflow(t *testing.T) {
+	arranger := func(c *CPU6502) {
+		c.A = 0xFF
+		c.Flags &= ^Flag_C
+	}
+
+	// $80 ror without carry => $40, C = 1, V = 1
+	verifier := func(c *CPU6502) bool {
+		return (c.A == 0x40) && ((c.Flags & Flag_C) != 0) && ((c.Flags & Flag_V) != 0) && ((c.Flags & Flag_N) == 0)
+	}
+
+	// arr #$80
+	// brk
+	c := InstructionTestCase{
+		model:           Model6510,
+		testProg:        []byte{0x6B, 0x80, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "ARR",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
+
+// -------- SBX --------
+
+func TestSBX(t *testing.T) {
+	arranger := func(c *CPU6502) {
+		c.A = 0x3F
+		c.X = 0xF3
+	}
+
+	verifier := func(c *CPU6502) bool {
+		return (c.X == 0x30) && ((c.Flags & Flag_C) != 0) && (c.A == 0x3F) && (c.NumCycles() == 2)
+	}
+
+	// sbx #$03
+	// brk
+	c := InstructionTestCase{
+		model:           Model6510,
+		testProg:        []byte{0xCB, 0x03, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "SBX",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
+
+func TestSBXBorrow(t *testing.T) {
+	arranger := func(c *CPU6502) {
+		c.A = 0x01
+		c.X = 0x01
+	}
+
+	verifier := func(c *CPU6502) bool {
+		return (c.X == 0xFF) && ((c.Flags & Flag_C) == 0) && ((c.Flags & Flag_N) != 0)
+	}
+
+	// sbx #$02
+	// brk
+	c := InstructionTestCase{
+		model:           Model6510,
+		testProg:        []byte{0xCB, 0x02, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "SBX",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
+
+// -------- LAS --------
+
+func TestLAS(t *testing.T) {
+	arranger := func(c *CPU6502) {
+		c.Mem.Store(0x1002, 0xF3)
+		c.Y = 0x02
+		c.SP = 0x3F
+	}
+
+	verifier := func(c *CPU6502) bool {
+		return (c.A == 0x33) && (c.X == 0x33) && (c.SP == 0x33) && (c.NumCycles() == 4)
+	}
+
+	// las $1000,y
+	// brk
+	c := InstructionTestCase{
+		model:           Model6510,
+		testProg:        []byte{0xBB, 0x00, 0x10, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "LAS",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
+
+// -------- SBC $EB --------
+
+func TestSBCUndocumented(t *testing.T) {
+	arranger := func(c *CPU6502) {
+		c.A = 0x50
+		c.Flags |= Flag_C
+	}
+
+	verifier := func(c *CPU6502) bool {
+		return (c.A == 0x40) && ((c.Flags & Flag_C) != 0)
+	}
+
+	// sbc #$10
+	// brk
+	c := InstructionTestCase{
+		model:           Model6510,
+		testProg:        []byte{0xEB, 0x10, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "SBC $EB",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
+
+// -------- NOPs --------
+
+func TestNOPVariants(t *testing.T) {
+	var flagsBefore uint8
+
+	arranger := func(c *CPU6502) {
+		c.X = 0xFF
+		flagsBefore = c.Flags
+	}
+
+	// 2 + 2 + 3 + 4 + 4 + 5
+	verifier := func(c *CPU6502) bool {
+		return (c.Flags == flagsBefore) && (c.PC == 0x080E) && (c.NumCycles() == 20)
+	}
+
+	// nop
+	// nop #$12
+	// nop $12
+	// nop $12,x
+	// nop $1000
+	// nop $1001,x
+	// brk
+	c := InstructionTestCase{
+		model:           Model6510,
+		testProg:        []byte{0x1A, 0x80, 0x12, 0x04, 0x12, 0x14, 0x12, 0x0C, 0x00, 0x10, 0x1C, 0x01, 0x10, 0x00},
+		arranger:        arranger,
+		verifier:        verifier,
+		instructionName: "NOP variants",
+	}
+
+	testSingleInstructionWithCase(t, c)
+}
@@ -0,0 +1,207 @@
+package cpu
+
+import (
+	"6502profiler/memory"
+	"fmt"
+)
+
+const NmiVector uint16 = 0xFFFA
+const ResetVector uint16 = 0xFFFC
+const IrqVector uint16 = 0xFFFE
+
+// The unused bit 5 of the flag register is always set when the flags are pushed by BRK or an interrupt
+const flagUnused uint8 = 0x20
+
+// InterruptController models the IRQ and NMI lines of the CPU. IRQ is level triggered, i.e. an
+// interrupt is requested as long as the line is asserted and the I flag is clear. NMI is edge
+// triggered, i.e. asserting the line once causes exactly one interrupt. The line has to be cleared
+// before the next NMI can be triggered.
+type InterruptController struct {
+	irqLine    bool
+	nmiLine    bool
+	nmiPending bool
+}
+
+func NewInterruptController() *InterruptController {
+	return &InterruptController{
+		irqLine:    false,
+		nmiLine:    false,
+		nmiPending: false,
+	}
+}
+
+func (i *InterruptController) RaiseIrq() {
+	i.irqLine = true
+}
+
+func (i *InterruptController) ClearIrq() {
+	i.irqLine = false
+}
+
+func (i *InterruptController) RaiseNmi() {
+	if !i.nmiLine {
+		i.nmiPending = true
+	}
+
+	i.nmiLine = true
+}
+
+func (i *InterruptController) ClearNmi() {
+	i.nmiLine = false
+}
+
+func (i *InterruptController) IrqActive() bool {
+	return i.irqLine
+}
+
+func (i *InterruptController) NmiPending() bool {
+	return i.nmiPending
+}
+
+// interruptPossible returns true if an interrupt will be serviced before the next instruction
+func (i *InterruptController) interruptPossible(flags uint8) bool {
+	return i.nmiPending || (i.irqLine && ((flags & Flag_I) == 0))
+}
+
+func (i *InterruptController) Reset() {
+	i.irqLine = false
+	i.nmiLine = false
+	i.nmiPending = false
+}
+
+// AddIrqAckPort wraps the memory of p in such a way that writing to port deasserts the IRQ line. This
+// allows interrupt routines to acknowledge an IRQ which has been raised from outside the program. The
+// returned function removes the wrapper.
+func AddIrqAckPort(p Processor, port uint16) func() {
+	baseMem := p.GetMem()
+
+	wrapper := memory.NewMemWrapper(baseMem, port)
+	wrapper.AddSpecialWriteAddress(port, func(data uint8) {
+		p.GetInterrupts().ClearIrq()
+	})
+	p.SetMem(wrapper)
+
+	return func() {
+		p.SetMem(baseMem)
+	}
+}
+
+// -------- Interrupt handling in the CPU --------
+
+func (c *CPU6502) readVector(vector uint16) uint16 {
+	lo := c.Mem.Load(vector)
+
+	return uint16(c.Mem.Load(vector+1))*256 + uint16(lo)
+}
+
+// enterInterrupt pushes the return address and the flags and jumps to the routine referenced by
+// the given vector. The B flag is only set in the pushed value, never in the flag register itself.
+// The byte following the opcode of BRK is read like the operand of an implied instruction. An
+// interrupt request instead reads the instruction at the PC twice without executing it.
+func (c *CPU6502) enterInterrupt(vector uint16, returnAddr uint16, brk bool) uint64 {
+	if !brk {
+		c.dummyInstructionRead()
+		c.dummyInstructionRead()
+	}
+
+	c.push(uint8((returnAddr & 0xFF00) >> 8))
+	c.push(uint8(returnAddr & 0x00FF))
+
+	flags := (c.Flags | flagUnused) & (^Flag_B)
+	if brk {
+		flags |= Flag_B
+	}
+
+	c.push(flags)
+	c.Flags |= Flag_I
+
+	// The 65C02 clears the decimal flag when entering an interrupt routine, the NMOS 6502 does not
+	if c.model.IsCmos() {
+		c.Flags &= (^Flag_D)
+	}
+
+	c.PC = c.readVector(vector)
+
+	return 7
+}
+
+// pollInterrupts is called before each instruction is executed. It returns the number of clock
+// cycles used to enter an interrupt routine. An NMI takes precedence over an IRQ.
+func (c *CPU6502) pollInterrupts() uint64 {
+	if c.Interrupts.nmiPending {
+		c.Interrupts.nmiPending = false
+		return c.enterInterrupt(NmiVector, c.PC, false)
+	}
+
+	if c.Interrupts.irqLine && ((c.Flags & Flag_I) == 0) {
+		return c.enterInterrupt(IrqVector, c.PC, false)
+	}
+
+	return 0
+}
+
+// SetBrkIsInterrupt selects the semantics of the BRK instruction. By default BRK halts the simulator.
+// When brkIsInterrupt is true BRK is executed as a software interrupt through the vector at $FFFE.
+// In this mode a BRK still halts the simulator if no routine has been installed, i.e. if the vector
+// contains $0000.
+func (c *CPU6502) SetBrkIsInterrupt(brkIsInterrupt bool) {
+	if brkIsInterrupt {
+		c.opCodes[0x00] = (*CPU6502).brkInterrupt
+	} else {
+		c.opCodes[0x00] = (*CPU6502).brkHalt
+	}
+}
+
+// -------- BRK --------
+
+func (c *CPU6502) brkHalt() (uint64, bool) {
+	return 7, true
+}
+
+func (c *CPU6502) brkInterrupt() (uint64, bool) {
+	// The vector is inspected without a bus access, as the hardware only reads it after the pushes
+	if (memory.Peek(c.Mem, IrqVector) == 0) && (memory.Peek(c.Mem, IrqVector+1) == 0) {
+		return 7, true
+	}
+
+	// BRK is a two byte instruction. The byte following the opcode is skipped.
+	return c.enterInterrupt(IrqVector, c.PC+1, true), false
+}
+
+// -------- STP --------
+
+// STP stops the clock of the processor until the next reset. The simulator is therefore halted.
+func (c *CPU6502) stp() (uint64, bool) {
+	c.dummyInstructionRead()
+	c.dummyInstructionRead()
+
+	return 3, true
+}
+
+// -------- WAI --------
+
+// WAI waits until an interrupt is requested. If the I flag is set a pending IRQ ends the wait but
+// is not serviced. As no external hardware is simulated nothing can request an interrupt while the
+// processor is waiting. Waiting without a pending interrupt is therefore treated as an error.
+func (c *CPU6502) wai() (uint64, bool) {
+	if !c.Interrupts.nmiPending && !c.Interrupts.irqLine {
+		panic(fmt.Sprintf("WAI at $%04x would wait forever as no interrupt is pending", c.PC-1))
+	}
+
+	c.dummyInstructionRead()
+	c.dummyInstructionRead()
+
+	return 3, false
+}
+
+// -------- RTI --------
+
+func (c *CPU6502) rti() (uint64, bool) {
+	c.dummyStackRead()
+	c.Flags = c.pop() & (^(Flag_B | flagUnused))
+	loByte := uint16(c.pop())
+	hiByte := uint16(c.pop())
+	c.PC = hiByte*256 + loByte
+
+	return 6, false
+}
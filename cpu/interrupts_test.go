@@ -0,0 +1,263 @@
+package cpu
+
+import (
+	"6502profiler/memory"
+	"testing"
+)
+
+const irqHandler = 0x0900
+const nmiHandler = 0x0A00
+
+func newInterruptTestCpu(model CpuModel, testProg []byte) *CPU6502 {
+	cpu := New6502(model)
+	cpu.Init(memory.NewLinearMemory(65536))
+
+	cpu.CopyToMem(testProg, UnitProgStart)
+	cpu.CopyToMem([]byte{nmiHandler & 0xFF, nmiHandler >> 8}, NmiVector)
+	cpu.CopyToMem([]byte{irqHandler & 0xFF, irqHandler >> 8}, IrqVector)
+
+	// inx
+	// rti
+	cpu.CopyToMem([]byte{0xE8, 0x40}, irqHandler)
+	// iny
+	// rti
+	cpu.CopyToMem([]byte{0xC8, 0x40}, nmiHandler)
+
+	return cpu
+}
+
+func TestIrqServicedOnce(t *testing.T) {
+	// cli
+	// lda #$42
+	// brk
+	cpu := newInterruptTestCpu(Model6502, []byte{0x58, 0xA9, 0x42, 0x00})
+	cpu.Flags = Flag_I | Flag_C
+
+	// Writing to $D019 acknowledges the interrupt
+	AddIrqAckPort(cpu, 0xD019)
+
+	// inx
+	// sta $d019
+	// rti
+	cpu.CopyToMem([]byte{0xE8, 0x8D, 0x19, 0xD0, 0x40}, irqHandler)
+	cpu.Interrupts.RaiseIrq()
+
+	err := cpu.Run(UnitProgStart)
+	if err != nil {
+		t.Fatalf("IRQ test failed: %v", err)
+	}
+
+	if (cpu.X != 1) || (cpu.A != 0x42) {
+		t.Fatalf("IRQ handler not called exactly once: X=%d, A=%02X", cpu.X, cpu.A)
+	}
+
+	// RTI restores the flags from before the interrupt. I was clear at that point.
+	if (cpu.Flags & Flag_I) != 0 {
+		t.Fatal("I flag not restored by RTI")
+	}
+
+	if (cpu.Flags & Flag_C) == 0 {
+		t.Fatal("C flag not restored by RTI")
+	}
+
+	// cli (2) + irq entry (7) + inx (2) + sta (4) + rti (6) + lda (2)
+	if cpu.NumCycles() != 23 {
+		t.Fatalf("Wrong number of cycles: %d", cpu.NumCycles())
+	}
+}
+
+func TestIrqMasked(t *testing.T) {
+	// lda #$42
+	// brk
+	cpu := newInterruptTestCpu(Model6502, []byte{0xA9, 0x42, 0x00})
+	cpu.Flags = Flag_I
+	cpu.Interrupts.RaiseIrq()
+
+	err := cpu.Run(UnitProgStart)
+	if err != nil {
+		t.Fatalf("IRQ test failed: %v", err)
+	}
+
+	if cpu.X != 0 {
+		t.Fatal("IRQ was not masked by I flag")
+	}
+}
+
+func TestIrqStackContents(t *testing.T) {
+	// nop
+	// brk
+	cpu := newInterruptTestCpu(Model6502, []byte{0xEA, 0x00})
+	cpu.Flags = Flag_N | Flag_D
+
+	// brk
+	cpu.CopyToMem([]byte{0x00}, irqHandler)
+	cpu.Interrupts.RaiseIrq()
+
+	err := cpu.Run(UnitProgStart)
+	if err != nil {
+		t.Fatalf("IRQ test failed: %v", err)
+	}
+
+	if (cpu.SP != 0xFC) || (cpu.Mem.Load(0x1FF) != 0x08) || (cpu.Mem.Load(0x1FE) != 0x00) {
+		t.Fatal("Return address not pushed correctly")
+	}
+
+	if cpu.Mem.Load(0x1FD) != (Flag_N | Flag_D | flagUnused) {
+		t.Fatalf("Flags not pushed correctly: %02X", cpu.Mem.Load(0x1FD))
+	}
+
+	if (cpu.Flags & Flag_I) == 0 {
+		t.Fatal("I flag not set when entering interrupt")
+	}
+
+	if (cpu.Flags & Flag_D) == 0 {
+		t.Fatal("D flag was cleared by NMOS 6502")
+	}
+}
+
+func TestIrqClearsDecimal65C02(t *testing.T) {
+	// nop
+	// brk
+	cpu := newInterruptTestCpu(Model65C02, []byte{0xEA, 0x00})
+	cpu.Flags = Flag_D
+
+	// brk
+	cpu.CopyToMem([]byte{0x00}, irqHandler)
+	cpu.Interrupts.RaiseIrq()
+
+	err := cpu.Run(UnitProgStart)
+	if err != nil {
+		t.Fatalf("IRQ test failed: %v", err)
+	}
+
+	if (cpu.Flags & Flag_D) != 0 {
+		t.Fatal("D flag was not cleared by 65C02")
+	}
+}
+
+func TestNmi(t *testing.T) {
+	// lda #$42
+	// lda #$43
+	// brk
+	cpu := newInterruptTestCpu(Model6502, []byte{0xA9, 0x42, 0xA9, 0x43, 0x00})
+	cpu.Flags = Flag_I
+	cpu.Interrupts.RaiseNmi()
+	// A second edge is not seen as long as the line is not cleared
+	cpu.Interrupts.RaiseNmi()
+
+	err := cpu.Run(UnitProgStart)
+	if err != nil {
+		t.Fatalf("NMI test failed: %v", err)
+	}
+
+	if (cpu.Y != 1) || (cpu.A != 0x43) {
+		t.Fatalf("NMI was not serviced exactly once: %d", cpu.Y)
+	}
+
+	// nmi entry (7) + iny (2) + rti (6) + lda (2) + lda (2)
+	if cpu.NumCycles() != 19 {
+		t.Fatalf("Wrong number of cycles: %d", cpu.NumCycles())
+	}
+}
+
+func TestBrkHaltsByDefault(t *testing.T) {
+	// brk
+	// nop
+	// brk
+	cpu := newInterruptTestCpu(Model6502, []byte{0x00, 0xEA, 0x00})
+
+	err := cpu.Run(UnitProgStart)
+	if err != nil {
+		t.Fatalf("BRK test failed: %v", err)
+	}
+
+	if (cpu.PC != UnitProgStart+1) || (cpu.X != 0) {
+		t.Fatal("BRK did not halt simulator")
+	}
+}
+
+func TestBrkInterrupt(t *testing.T) {
+	// brk
+	// !byte $ff
+	// lda #$42
+	// brk
+	cpu := newInterruptTestCpu(Model6502, []byte{0x00, 0xFF, 0xA9, 0x42, 0x00})
+	cpu.SetBrkIsInterrupt(true)
+	cpu.Flags = Flag_Z
+
+	// php
+	// pla
+	// sta $10
+	// inx
+	// lda #0
+	// sta $fffe
+	// sta $ffff
+	// rti
+	cpu.CopyToMem([]byte{0x08, 0x68, 0x85, 0x10, 0xE8, 0xA9, 0x00, 0x8D, 0xFE, 0xFF, 0x8D, 0xFF, 0xFF, 0x40}, irqHandler)
+
+	// The handler removes itself. Therefore the second BRK halts the simulator.
+	err := cpu.Run(UnitProgStart)
+	if err != nil {
+		t.Fatalf("BRK test failed: %v", err)
+	}
+
+	if (cpu.X != 1) || (cpu.A != 0x42) || (cpu.PC != UnitProgStart+5) {
+		t.Fatalf("BRK handler not executed correctly: X=%d A=%02X PC=%04X", cpu.X, cpu.A, cpu.PC)
+	}
+
+	// Inside the handler B is not visible in the flag register
+	if (cpu.Mem.Load(0x0010) & Flag_B) != 0 {
+		t.Fatal("B flag visible in flag register")
+	}
+
+	if (cpu.Mem.Load(0x0010) & Flag_I) == 0 {
+		t.Fatal("I flag not set in BRK handler")
+	}
+
+	if (cpu.Flags & Flag_B) != 0 {
+		t.Fatal("B flag set after RTI")
+	}
+
+	if (cpu.Flags & Flag_I) != 0 {
+		t.Fatal("I flag not restored after RTI")
+	}
+}
+
+func TestBrkInterruptPushesB(t *testing.T) {
+	// brk
+	// !byte $ff
+	cpu := newInterruptTestCpu(Model6502, []byte{0x00, 0xFF})
+	cpu.SetBrkIsInterrupt(true)
+
+	// brk => halts because vector is removed below
+	cpu.CopyToMem([]byte{0x00}, irqHandler)
+
+	cpu.PC = UnitProgStart
+	_, halt := cpu.executeInstruction()
+	if halt {
+		t.Fatal("BRK halted simulator")
+	}
+
+	if cpu.PC != irqHandler {
+		t.Fatal("BRK did not jump through IRQ vector")
+	}
+
+	if (cpu.Mem.Load(0x1FD) & Flag_B) == 0 {
+		t.Fatal("B flag not pushed by BRK")
+	}
+
+	if (cpu.Mem.Load(0x1FF) != 0x08) || (cpu.Mem.Load(0x1FE) != 0x02) {
+		t.Fatal("BRK did not push correct return address")
+	}
+
+	cpu.CopyToMem([]byte{0x00, 0x00}, IrqVector)
+
+	err := cpu.RunExt(cpu.PC, false)
+	if err != nil {
+		t.Fatalf("BRK test failed: %v", err)
+	}
+
+	if cpu.PC != irqHandler+1 {
+		t.Fatal("BRK did not halt without handler")
+	}
+}
@@ -0,0 +1,202 @@
+package cpu
+
+import (
+	"6502profiler/memory"
+	"fmt"
+	"os"
+)
+
+// Register identifies a processor register when it is accessed through the Processor interface
+type Register uint8
+
+const RegA Register = 0
+const RegX Register = 1
+const RegY Register = 2
+const RegSP Register = 3
+const RegFlags Register = 4
+
+// The following registers only exist in the 65816
+const RegC Register = 5
+const RegD Register = 6
+const RegDBR Register = 7
+const RegPBR Register = 8
+const RegE Register = 9
+
+// The following registers only exist in the 65CE02 and the 45GS02
+const RegZ Register = 10
+const RegB Register = 11
+
+// Processor is implemented by all simulated CPU cores. It allows the test infrastructure, the Lua
+// bridge and the profiler to work with the 6502 family as well as with the 65816 and the 65CE02.
+type Processor interface {
+	Init(m memory.Memory)
+	Reset()
+	Model() CpuModel
+	NumCycles() uint64
+	GetMem() memory.Memory
+	SetMem(m memory.Memory)
+	GetPC() uint16
+	SetPC(pc uint16)
+	// GetRegister returns false as its second value if the register does not exist in this CPU
+	GetRegister(r Register) (uint16, bool)
+	// SetRegister returns false if the register does not exist in this CPU
+	SetRegister(r Register, val uint16) bool
+	// FlagNames returns the names of the flag bits, starting with bit 7. Unused bits are named '-'.
+	FlagNames() string
+	GetInterrupts() *InterruptController
+	SetBrkIsInterrupt(brkIsInterrupt bool)
+	SetExecutionLimits(l ExecutionLimits)
+	GetExecutionLimits() ExecutionLimits
+	// CallStack returns the subroutine calls which are active in the current or the last program run
+	CallStack() []CallFrame
+	SetTermination(t TerminationConditions)
+	GetTermination() TerminationConditions
+	// RequestExit ends the current program run after the current instruction has been executed
+	RequestExit(exitCode uint8)
+	// ExitCode returns false as its second value if the last program run was not ended by RequestExit
+	ExitCode() (uint8, bool)
+	GetExecState() ExecState
+	// SetExecState returns to a state returned by GetExecState. If it is called while a Debugger has
+	// stopped the program, the instruction at the current PC is started over.
+	SetExecState(s ExecState)
+	// SetTracer sets the Tracer which is notified about each executed instruction. nil turns tracing off.
+	SetTracer(t Tracer)
+	// SetBusAccurate returns an error if the CPU does not support the simulation of dummy bus cycles
+	SetBusAccurate(busAccurate bool) error
+	Load(fileName string) (uint16, uint16, error)
+	LoadAndRun(fileName string) (uint16, uint16, error)
+	CopyToMem(binary []byte, startAddress uint16) error
+	CopyFromMem(startAddress uint16, length uint16) ([]byte, error)
+	CopyAndRun(program []byte, startAddress uint16) error
+	Run(startAddress uint16) error
+	RunExt(startAddress uint16, resetCycleCount bool) error
+}
+
+// NewProcessor creates a CPU core for the given model
+func NewProcessor(m CpuModel) Processor {
+	switch m {
+	case Model65816:
+		return New65816()
+	case Model65CE02, Model45GS02:
+		return New65CE02(m)
+	}
+
+	return New6502(m)
+}
+
+// -------- Functions shared by all CPU cores --------
+
+// addWithCarry adds data and the carry to a. Both operands consist of the given number of digits,
+// i.e. nibbles. If subtract is true data is complemented before the addition, which turns the
+// addition into a subtraction with borrow. In decimal mode the digits are adjusted one after another
+// and the overflow flag is determined before the most significant digit is adjusted, as the 65816 does.
+func addWithCarry(a uint32, data uint32, carryIn bool, digits uint, decimal bool, subtract bool) (res uint32, carry bool, overflow bool) {
+	mask := (int64(1) << (4 * digits)) - 1
+	sign := (mask + 1) >> 1
+	av := int64(a) & mask
+	dv := int64(data) & mask
+
+	if subtract {
+		dv ^= mask
+	}
+
+	var c int64 = 0
+	if carryIn {
+		c = 1
+	}
+
+	var r int64 = 0
+
+	if !decimal {
+		r = av + dv + c
+		overflow = ((^(av ^ dv)) & (av ^ r) & sign) != 0
+
+		return uint32(r & mask), r > mask, overflow
+	}
+
+	var i uint
+	for i = 0; i < digits; i++ {
+		shift := 4 * i
+		digitMask := int64(0xF) << shift
+		lowerMask := (int64(1) << shift) - 1
+
+		r = (av & digitMask) + (dv & digitMask) + (c << shift) + (r & lowerMask)
+
+		if i == digits-1 {
+			overflow = ((^(av ^ dv)) & (av ^ r) & sign) != 0
+		}
+
+		if subtract {
+			if r <= (digitMask | lowerMask) {
+				r -= 6 << shift
+			}
+		} else {
+			if r > ((9 << shift) | lowerMask) {
+				r += 6 << shift
+			}
+		}
+
+		c = 0
+		if r > (digitMask | lowerMask) {
+			c = 1
+		}
+	}
+
+	return uint32(r & mask), r > mask, overflow
+}
+
+func loadBinary(mem memory.Memory, fileName string) (loadAddress uint16, progLen uint16, err error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to load binary: %v", err)
+	}
+
+	if len(data) < 3 {
+		return 0, 0, fmt.Errorf("no program data found")
+	}
+
+	loadAddress = uint16(data[1])*256 + uint16(data[0])
+	copyToMem(mem, data[2:], loadAddress)
+
+	return loadAddress, uint16(len(data) - 2), nil
+}
+
+func copyToMem(mem memory.Memory, binary []byte, startAddress uint16) (err error) {
+	// Recover from panic created by memory access
+	defer func() {
+		if res := recover(); res != nil {
+			// Use named return value to return a value after handling the panic
+			err = fmt.Errorf("error copying to memory: %v", res)
+		}
+	}()
+
+	copyAddress := startAddress
+
+	for _, j := range binary {
+		mem.Store(copyAddress, j)
+		copyAddress++ // This can overflow
+	}
+
+	return err
+}
+
+func copyFromMem(mem memory.Memory, startAddress uint16, length uint16) (data []byte, err error) {
+	// Recover from panic created by memory access
+	defer func() {
+		if res := recover(); res != nil {
+			// Use named return value to return a value after handling the panic
+			err = fmt.Errorf("error copying from memory: %v", res)
+		}
+	}()
+
+	data = []byte{}
+	copyAddress := startAddress
+	var count uint16
+
+	for count = 0; count < length; count++ {
+		data = append(data, mem.Load(copyAddress))
+		copyAddress++
+	}
+
+	return data, err
+}
@@ -0,0 +1,27 @@
+package cpu
+
+import "6502profiler/memory"
+
+// TerminationConditions define when a program ends in addition to BRK and STP, which always
+// end a program unless BRK is treated as an interrupt
+type TerminationConditions struct {
+	// StopOnReturn ends the program when the routine at the start address returns via RTS or RTL
+	StopOnReturn bool
+	// StopAddresses end the program before the instruction at one of these addresses is executed.
+	// Addresses of the 65816 include the program bank.
+	StopAddresses []uint32
+}
+
+// AddExitPort wraps the memory of p in such a way that writing to port ends the program. The
+// written value can be retrieved by calling ExitCode. The returned function removes the wrapper.
+func AddExitPort(p Processor, port uint16) func() {
+	baseMem := p.GetMem()
+
+	wrapper := memory.NewMemWrapper(baseMem, port)
+	wrapper.AddSpecialWriteAddress(port, p.RequestExit)
+	p.SetMem(wrapper)
+
+	return func() {
+		p.SetMem(baseMem)
+	}
+}
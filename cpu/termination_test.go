@@ -0,0 +1,162 @@
+package cpu
+
+import (
+	"6502profiler/memory"
+	"testing"
+)
+
+func runWithTermination(t *testing.T, p Processor, prog []byte, term TerminationConditions) {
+	p.Init(memory.NewLinearMemory(65536))
+	p.CopyToMem(prog, UnitProgStart)
+	p.SetTermination(term)
+
+	if err := p.Run(UnitProgStart); err != nil {
+		t.Fatalf("program failed: %v", err)
+	}
+}
+
+func TestStopOnReturn(t *testing.T) {
+	// jsr sub
+	// inx
+	// rts
+	// sub iny
+	// rts
+	cpu := New6502(Model6502)
+	runWithTermination(t, cpu, []byte{0x20, 0x05, 0x08, 0xE8, 0x60, 0xC8, 0x60}, TerminationConditions{StopOnReturn: true})
+
+	if (cpu.X != 1) || (cpu.Y != 1) {
+		t.Fatalf("program did not run to its end: X=%02X Y=%02X", cpu.X, cpu.Y)
+	}
+
+	// jsr (6) + iny (2) + rts (6) + inx (2) + rts (6)
+	if cpu.NumCycles() != 22 {
+		t.Fatalf("Wrong number of cycles: %d", cpu.NumCycles())
+	}
+}
+
+func TestRtsAsJumpDoesNotStop(t *testing.T) {
+	// lda #$08
+	// pha
+	// lda #$06
+	// pha
+	// rts
+	// inx
+	// rts
+	cpu := New6502(Model6502)
+	runWithTermination(t, cpu, []byte{0xA9, 0x08, 0x48, 0xA9, 0x06, 0x48, 0x60, 0xE8, 0x60}, TerminationConditions{StopOnReturn: true})
+
+	if cpu.X != 1 {
+		t.Fatal("RTS used as a jump ended the program")
+	}
+}
+
+func TestStopOnReturn65816(t *testing.T) {
+	// clc
+	// xce
+	// rep #$30
+	// inx
+	// rtl
+	cpu := New65816()
+	runWithTermination(t, cpu, []byte{0x18, 0xFB, 0xC2, 0x30, 0xE8, 0x6B}, TerminationConditions{StopOnReturn: true})
+
+	if cpu.X != 1 {
+		t.Fatalf("RTL did not end the program: X=%04X", cpu.X)
+	}
+
+	// clc (2) + xce (2) + rep (3) + inx (2) + rtl (6)
+	if cpu.NumCycles() != 15 {
+		t.Fatalf("Wrong number of cycles: %d", cpu.NumCycles())
+	}
+}
+
+func TestStopOnReturn65CE02(t *testing.T) {
+	// inz
+	// rts
+	cpu := New65CE02(Model65CE02)
+	runWithTermination(t, cpu, []byte{0x1B, 0x60}, TerminationConditions{StopOnReturn: true})
+
+	if cpu.Z != 1 {
+		t.Fatalf("RTS did not end the program: Z=%02X", cpu.Z)
+	}
+
+	// inz (1) + rts (4)
+	if cpu.NumCycles() != 5 {
+		t.Fatalf("Wrong number of cycles: %d", cpu.NumCycles())
+	}
+}
+
+func TestStopAddress(t *testing.T) {
+	// loop inx
+	//      jmp loop2
+	// loop2 inx
+	//      jmp loop
+	cpu := New6502(Model6502)
+	runWithTermination(t, cpu, []byte{0xE8, 0x4C, 0x04, 0x08, 0xE8, 0x4C, 0x00, 0x08}, TerminationConditions{StopAddresses: []uint32{0x0800}})
+
+	if cpu.X != 2 {
+		t.Fatalf("program did not stop at the given address: X=%02X", cpu.X)
+	}
+
+	// inx (2) + jmp (3) + inx (2) + jmp (3)
+	if cpu.NumCycles() != 10 {
+		t.Fatalf("Wrong number of cycles: %d", cpu.NumCycles())
+	}
+}
+
+func TestExitPort(t *testing.T) {
+	// lda #$2A
+	// sta $C000
+	// inx
+	// brk
+	cpu := New6502(Model6502)
+	cpu.Init(memory.NewLinearMemory(65536))
+	cpu.CopyToMem([]byte{0xA9, 0x2A, 0x8D, 0x00, 0xC0, 0xE8, 0x00}, UnitProgStart)
+	AddExitPort(cpu, 0xC000)
+
+	if err := cpu.Run(UnitProgStart); err != nil {
+		t.Fatalf("program failed: %v", err)
+	}
+
+	exitCode, ok := cpu.ExitCode()
+	if !ok || (exitCode != 0x2A) {
+		t.Fatalf("wrong exit code: %d %v", exitCode, ok)
+	}
+
+	if cpu.X != 0 {
+		t.Fatal("program continued after writing to the exit port")
+	}
+
+	// lda # (2) + sta abs (4)
+	if cpu.NumCycles() != 6 {
+		t.Fatalf("Wrong number of cycles: %d", cpu.NumCycles())
+	}
+
+	if err := cpu.Run(UnitProgStart + 5); err != nil {
+		t.Fatalf("program failed: %v", err)
+	}
+
+	if _, ok := cpu.ExitCode(); ok {
+		t.Fatal("exit code not reset by the next run")
+	}
+}
+
+func TestStpEndsProgramIfBrkIsInterrupt(t *testing.T) {
+	// brk
+	// nop
+	// stp
+	cpu := New6502(ModelW65C02S)
+	cpu.Init(memory.NewLinearMemory(65536))
+	cpu.CopyToMem([]byte{0x00, 0xEA, 0xDB}, UnitProgStart)
+	// The interrupt routine returns immediately
+	cpu.CopyToMem([]byte{0xE8, 0x40}, 0x0900)
+	cpu.CopyToMem([]byte{0x00, 0x09}, IrqVector)
+	cpu.SetBrkIsInterrupt(true)
+
+	if err := cpu.Run(UnitProgStart); err != nil {
+		t.Fatalf("program failed: %v", err)
+	}
+
+	if (cpu.X != 1) || (cpu.PC != UnitProgStart+3) {
+		t.Fatalf("program did not stop at STP: X=%02X PC=%04X", cpu.X, cpu.PC)
+	}
+}
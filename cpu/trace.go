@@ -0,0 +1,267 @@
+package cpu
+
+import (
+	"6502profiler/memory"
+)
+
+// TraceRegisters are the registers recorded in a TraceRecord. Registers which do not exist in a
+// CPU model are always zero.
+var TraceRegisters = [...]Register{RegA, RegC, RegX, RegY, RegZ, RegB, RegSP, RegD, RegDBR, RegPBR, RegFlags, RegE}
+
+// MaxTracedBytes is the maximum number of instruction bytes stored in a TraceRecord
+const MaxTracedBytes = 5
+
+// BranchOutcome tells whether a conditional branch has been taken
+type BranchOutcome uint8
+
+const (
+	// BranchNone is used for all instructions which are not conditional branches
+	BranchNone BranchOutcome = iota
+	BranchTaken
+	BranchNotTaken
+)
+
+// TraceRecord describes an executed instruction
+type TraceRecord struct {
+	// Clock cycle count before the instruction has been executed
+	Cycle      uint64
+	CyclesUsed uint64
+	// Address of the instruction. Addresses of the 65816 include the program bank.
+	PC uint32
+	// The bytes fetched from PC onwards, i.e. the opcode and its operands
+	Bytes    [MaxTracedBytes]byte
+	NumBytes int
+	// Values of TraceRegisters before the instruction has been executed
+	Registers [len(TraceRegisters)]uint16
+	// EffectiveAddress is the address of the last memory access made by the instruction which did
+	// not fetch the instruction itself. It is only valid if HasEffectiveAddress is true.
+	EffectiveAddress    uint32
+	HasEffectiveAddress bool
+	EffectiveWrite      bool
+	// Branch is set by conditional branches. Unconditional branches like BRA are BranchNone.
+	Branch BranchOutcome
+}
+
+// Tracer is notified after each instruction which has been executed by RunExt. p is in the state
+// after the instruction. The record is reused for the next instruction.
+type Tracer interface {
+	Trace(p Processor, r *TraceRecord)
+}
+
+type tracerList []Tracer
+
+func (l tracerList) Trace(p Processor, r *TraceRecord) {
+	for _, j := range l {
+		j.Trace(p, r)
+	}
+}
+
+// CombineTracers returns a Tracer which passes each record to all given Tracers which are not nil.
+// It returns nil if there is none. The returned Tracer never implements Debugger, i.e. Debuggers
+// must not be combined with other Tracers.
+func CombineTracers(tracers ...Tracer) Tracer {
+	res := tracerList{}
+
+	for _, j := range tracers {
+		if j != nil {
+			res = append(res, j)
+		}
+	}
+
+	switch len(res) {
+	case 0:
+		return nil
+	case 1:
+		return res[0]
+	}
+
+	return res
+}
+
+// Debugger is a Tracer which is able to interrupt the program. If the Tracer set through SetTracer
+// implements Debugger, it is also notified before each instruction and about each memory access
+// which does not fetch the instruction itself.
+type Debugger interface {
+	Tracer
+	// BeforeInstruction returns false if the program has to stop before the instruction at pc
+	BeforeInstruction(p Processor, pc uint32) bool
+	MemoryAccess(addr uint32, write bool)
+}
+
+// WriteRecorder is implemented by Debuggers which need the previous value of each byte written by
+// the program, e.g. to be able to undo instructions. large is true if addr belongs to the linear
+// address space.
+type WriteRecorder interface {
+	BeforeWrite(addr uint32, large bool, old uint8)
+}
+
+// tracingMemory observes the memory accesses made by a traced instruction
+type tracingMemory struct {
+	memory.Memory
+	record   *TraceRecord
+	debugger Debugger
+	recorder WriteRecorder
+}
+
+func (t *tracingMemory) access(addr uint32, b uint8, write bool) {
+	r := t.record
+
+	if !write && (r.NumBytes < MaxTracedBytes) && (addr == r.PC+uint32(r.NumBytes)) {
+		r.Bytes[r.NumBytes] = b
+		r.NumBytes++
+
+		return
+	}
+
+	r.EffectiveAddress = addr
+	r.HasEffectiveAddress = true
+	r.EffectiveWrite = write
+
+	if t.debugger != nil {
+		t.debugger.MemoryAccess(addr, write)
+	}
+}
+
+func (t *tracingMemory) Load(address uint16) uint8 {
+	b := t.Memory.Load(address)
+	t.access(uint32(address), b, false)
+
+	return b
+}
+
+func (t *tracingMemory) LoadAs(address uint16, kind memory.AccessKind) uint8 {
+	b := t.Memory.LoadAs(address, kind)
+	t.access(uint32(address), b, false)
+
+	return b
+}
+
+func (t *tracingMemory) Store(address uint16, b uint8) {
+	if t.recorder != nil {
+		t.recorder.BeforeWrite(uint32(address), false, memory.Peek(t.Memory, address))
+	}
+
+	t.Memory.Store(address, b)
+	t.access(uint32(address), b, true)
+}
+
+func (t *tracingMemory) ToLargeMemory() memory.LargeMemory {
+	return &tracingLargeMemory{t.Memory.ToLargeMemory(), t}
+}
+
+// Peek and Poke are not traced
+func (t *tracingMemory) Peek(address uint16) uint8 {
+	return memory.Peek(t.Memory, address)
+}
+
+func (t *tracingMemory) Poke(address uint16, b uint8) {
+	memory.Poke(t.Memory, address, b)
+}
+
+func (t *tracingMemory) PeekLarge(address uint32) uint8 {
+	return memory.PeekLarge(t.Memory, address)
+}
+
+func (t *tracingMemory) PokeLarge(address uint32, b uint8) {
+	memory.PokeLarge(t.Memory, address, b)
+}
+
+type tracingLargeMemory struct {
+	memory.LargeMemory
+	t *tracingMemory
+}
+
+func (l *tracingLargeMemory) LoadLarge(address uint32) uint8 {
+	b := l.LargeMemory.LoadLarge(address)
+	l.t.access(address, b, false)
+
+	return b
+}
+
+func (l *tracingLargeMemory) LoadLargeAs(address uint32, kind memory.AccessKind) uint8 {
+	b := l.LargeMemory.LoadLargeAs(address, kind)
+	l.t.access(address, b, false)
+
+	return b
+}
+
+func (l *tracingLargeMemory) StoreLarge(address uint32, b uint8) {
+	if l.t.recorder != nil {
+		l.t.recorder.BeforeWrite(address, true, memory.PeekLarge(l.t.Memory, address))
+	}
+
+	l.LargeMemory.StoreLarge(address, b)
+	l.t.access(address, b, true)
+}
+
+// instructionTracer is used by all CPU cores to create the trace records. It is only active if a
+// Tracer has been set, so that untraced programs do not pay for it.
+type instructionTracer struct {
+	tracer   Tracer
+	debugger Debugger
+	record   TraceRecord
+	mem      *tracingMemory
+	// restart is set by SetExecState. The CPU then starts over with the instruction at the restored PC.
+	restart bool
+}
+
+func (t *instructionTracer) set(tracer Tracer) {
+	t.tracer = tracer
+	t.debugger, _ = tracer.(Debugger)
+}
+
+// attach returns the memory which has to be used by the CPU while a program is traced
+func (t *instructionTracer) attach(m memory.Memory) memory.Memory {
+	t.mem = &tracingMemory{Memory: m, record: &t.record, debugger: t.debugger}
+	t.mem.recorder, _ = t.debugger.(WriteRecorder)
+	t.restart = false
+
+	return t.mem
+}
+
+// detach returns the memory which has been passed to attach
+func (t *instructionTracer) detach() memory.Memory {
+	return t.mem.Memory
+}
+
+// branch records the outcome of a conditional branch. It is cheap enough to be called when no
+// Tracer has been set.
+func (t *instructionTracer) branch(taken bool) {
+	if taken {
+		t.record.Branch = BranchTaken
+	} else {
+		t.record.Branch = BranchNotTaken
+	}
+}
+
+// execute runs the instruction at pc through exec and reports it to the tracer. If a debugger
+// stops the program the instruction is not executed and halt is returned as true. If the debugger
+// has restored an earlier state the instruction is not executed either and restart is set.
+func (t *instructionTracer) execute(p Processor, pc uint32, cycle uint64, exec func() (uint64, bool)) (uint64, bool) {
+	if (t.debugger != nil) && !t.debugger.BeforeInstruction(p, pc) {
+		return 0, true
+	}
+
+	if t.restart {
+		return 0, false
+	}
+
+	r := &t.record
+	r.Cycle = cycle
+	r.PC = pc
+	r.NumBytes = 0
+	r.HasEffectiveAddress = false
+	r.EffectiveWrite = false
+	r.Branch = BranchNone
+
+	for i, j := range TraceRegisters {
+		r.Registers[i], _ = p.GetRegister(j)
+	}
+
+	cyclesUsed, halt := exec()
+
+	r.CyclesUsed = cyclesUsed
+	t.tracer.Trace(p, r)
+
+	return cyclesUsed, halt
+}
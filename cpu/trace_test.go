@@ -0,0 +1,120 @@
+package cpu
+
+import (
+	"6502profiler/memory"
+	"testing"
+)
+
+type recordingTracer struct {
+	records []TraceRecord
+}
+
+func (r *recordingTracer) Trace(p Processor, rec *TraceRecord) {
+	r.records = append(r.records, *rec)
+}
+
+func TestTraceRecords(t *testing.T) {
+	// lda #$05
+	// sta $0720
+	// brk
+	cpu := New6502(Model6502)
+	mem := memory.NewLinearMemory(65536)
+	cpu.Init(mem)
+	cpu.CopyToMem([]byte{0xA9, 0x05, 0x8D, 0x20, 0x07, 0x00}, UnitProgStart)
+
+	tracer := &recordingTracer{}
+	cpu.SetTracer(tracer)
+
+	if err := cpu.Run(UnitProgStart); err != nil {
+		t.Fatalf("program failed: %v", err)
+	}
+
+	if len(tracer.records) != 3 {
+		t.Fatalf("expected 3 trace records, got %d", len(tracer.records))
+	}
+
+	lda := tracer.records[0]
+	if (lda.PC != UnitProgStart) || (lda.NumBytes != 2) || (lda.Bytes[0] != 0xA9) || (lda.Bytes[1] != 0x05) {
+		t.Fatalf("wrong instruction bytes in first record: %+v", lda)
+	}
+
+	if lda.HasEffectiveAddress || (lda.Cycle != 0) || (lda.CyclesUsed != 2) {
+		t.Fatalf("wrong first record: %+v", lda)
+	}
+
+	sta := tracer.records[1]
+	if (sta.NumBytes != 3) || !sta.HasEffectiveAddress || !sta.EffectiveWrite || (sta.EffectiveAddress != 0x0720) {
+		t.Fatalf("wrong effective address in second record: %+v", sta)
+	}
+
+	// The registers are recorded before the instruction is executed
+	if (lda.Registers[0] != 0) || (sta.Registers[0] != 5) || (sta.Cycle != 2) {
+		t.Fatalf("wrong registers in trace records: %+v %+v", lda, sta)
+	}
+
+	if cpu.GetMem() != memory.Memory(mem) {
+		t.Fatal("memory not restored after traced run")
+	}
+
+	if mem.Load(0x0720) != 5 {
+		t.Fatal("traced program did not store value")
+	}
+}
+
+func TestCombineTracers(t *testing.T) {
+	if CombineTracers(nil, nil) != nil {
+		t.Fatal("combination of nil tracers is not nil")
+	}
+
+	first := &recordingTracer{}
+	if CombineTracers(nil, first) != Tracer(first) {
+		t.Fatal("single tracer has been wrapped")
+	}
+
+	second := &recordingTracer{}
+
+	cpu := New6502(Model6502)
+	cpu.Init(memory.NewLinearMemory(65536))
+	cpu.CopyToMem([]byte{0xA9, 0x05, 0x00}, UnitProgStart)
+	cpu.SetTracer(CombineTracers(first, nil, second))
+
+	if err := cpu.Run(UnitProgStart); err != nil {
+		t.Fatalf("program failed: %v", err)
+	}
+
+	if (len(first.records) != 2) || (len(second.records) != 2) {
+		t.Fatalf("records not passed to all tracers: %d %d", len(first.records), len(second.records))
+	}
+}
+
+func TestTraceBranchOutcome(t *testing.T) {
+	//     ldx #2
+	// loop
+	//     dex
+	//     bne loop
+	//     brk
+	expected := []BranchOutcome{BranchNone, BranchNone, BranchTaken, BranchNone, BranchNotTaken, BranchNone}
+
+	for _, model := range []CpuModel{Model6502, Model65816, Model65CE02} {
+		cpu := NewProcessor(model)
+		cpu.Init(memory.NewLinearMemory(65536))
+		cpu.CopyToMem([]byte{0xA2, 0x02, 0xCA, 0xD0, 0xFD, 0x00}, UnitProgStart)
+
+		tracer := &recordingTracer{}
+		cpu.SetTracer(tracer)
+
+		if err := cpu.Run(UnitProgStart); err != nil {
+			t.Fatalf("%v: program failed: %v", model, err)
+		}
+
+		if len(tracer.records) != len(expected) {
+			t.Fatalf("%v: expected %d trace records, got %d", model, len(expected), len(tracer.records))
+		}
+
+		for i, j := range expected {
+			if tracer.records[i].Branch != j {
+				t.Fatalf("%v: wrong branch outcome in record %d: %d", model, i, tracer.records[i].Branch)
+			}
+		}
+	}
+}
@@ -0,0 +1,818 @@
+package dap
+
+import (
+	"6502profiler/cpu"
+	"6502profiler/debugger"
+	"6502profiler/srcmap"
+	"6502profiler/trace"
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Launch describes the program which is debugged
+type Launch struct {
+	Labels map[uint16][]string
+	// Listings are loaded when the first instruction of the program is executed, i.e. after Run
+	// has assembled the program
+	Listings      []string
+	ListingFormat string
+	// RelocBase is the address relative addresses in ca65 listings refer to
+	RelocBase   uint32
+	StopOnEntry bool
+	// History is the number of instructions which can be undone one by one. 0 disables stepping
+	// backwards.
+	History int
+	// Run executes the program under the control of dbg. It returns the CPU which has executed the
+	// program or nil if the program could not be started.
+	Run func(dbg *debugger.Debugger) (cpu.Processor, error)
+}
+
+// Launcher creates a Launch from the arguments of a launch request
+type Launcher func(args json.RawMessage) (*Launch, error)
+
+type runState int
+
+const (
+	stateIdle runState = iota
+	stateRunning
+	stateStopped
+	stateEnded
+)
+
+const threadID = 1
+const registersReference = 1
+
+// breakpointSpec is a breakpoint as requested by the client together with the addresses it has
+// been resolved to
+type breakpointSpec struct {
+	bp        breakpoint
+	line      int
+	condition string
+	addrs     []uint32
+}
+
+// Server implements the Debug Adapter Protocol. Requests are processed by the goroutine which calls
+// Serve while the program runs in a goroutine of its own. The state of the CPU is only accessed by
+// the latter. When the program is stopped, requests which need the CPU are passed to it as actions.
+type Server struct {
+	in        *bufio.Reader
+	out       io.Writer
+	launcher  Launcher
+	writeLock sync.Mutex
+	seq       int
+	launch    *Launch
+	dbg       *debugger.Debugger
+	actions   chan func(p cpu.Processor) bool
+	quitReq   chan struct{}
+	finished  chan struct{}
+	quitOnce  sync.Once
+	nextBpID  int
+
+	// lock protects the fields which are used by both goroutines
+	lock      sync.Mutex
+	state     runState
+	srcMap    *srcmap.Map
+	sourceBps map[string][]*breakpointSpec
+	instrBps  []*breakpointSpec
+
+	// These fields are only used by the goroutine which runs the program
+	checkpointIDs []int
+	stopPC        uint32
+	numStops      int
+}
+
+func NewServer(in io.Reader, out io.Writer, launcher Launcher) *Server {
+	return &Server{
+		in:        bufio.NewReader(in),
+		out:       out,
+		launcher:  launcher,
+		actions:   make(chan func(p cpu.Processor) bool),
+		quitReq:   make(chan struct{}),
+		finished:  make(chan struct{}),
+		nextBpID:  1,
+		state:     stateIdle,
+		sourceBps: map[string][]*breakpointSpec{},
+	}
+}
+
+// Serve processes requests until the client disconnects or closes the connection
+func (s *Server) Serve() error {
+	for {
+		data, err := readMessage(s.in)
+		if err == io.EOF {
+			s.terminate()
+			return nil
+		}
+
+		if err != nil {
+			s.terminate()
+			return err
+		}
+
+		var req request
+		if err = json.Unmarshal(data, &req); err != nil {
+			return fmt.Errorf("invalid message: %v", err)
+		}
+
+		if req.Type != "request" {
+			continue
+		}
+
+		if !s.handle(&req) {
+			return nil
+		}
+	}
+}
+
+// Output shows text in the debug console of the client
+func (s *Server) Output(category string, text string) {
+	s.sendEvent("output", map[string]interface{}{"category": category, "output": text})
+}
+
+func (s *Server) send(msg interface{}) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	s.seq++
+
+	switch m := msg.(type) {
+	case *response:
+		m.Seq = s.seq
+	case *event:
+		m.Seq = s.seq
+	}
+
+	// There is nobody to report a broken connection to. Serve ends when the input is closed.
+	_ = writeMessage(s.out, msg)
+}
+
+func (s *Server) sendEvent(name string, body interface{}) {
+	s.send(&event{Type: "event", Event: name, Body: body})
+}
+
+func (s *Server) respond(req *request, body interface{}, err error) {
+	res := &response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: err == nil, Body: body}
+	if err != nil {
+		res.Message = err.Error()
+	}
+
+	s.send(res)
+}
+
+func (s *Server) getState() runState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.state
+}
+
+func (s *Server) setState(state runState) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.state = state
+}
+
+// handle processes a request. It returns false if the session has ended.
+func (s *Server) handle(req *request) bool {
+	var body interface{}
+	var err error
+
+	switch req.Command {
+	case "initialize":
+		body = capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsConditionalBreakpoints:   true,
+			SupportsInstructionBreakpoints:   true,
+			SupportsSetVariable:              true,
+			SupportsReadMemoryRequest:        true,
+			SupportsEvaluateForHovers:        true,
+			SupportsTerminateRequest:         true,
+			SupportsStepBack:                 true,
+		}
+	case "launch":
+		err = s.doLaunch(req.Arguments)
+		s.respond(req, nil, err)
+
+		if err == nil {
+			s.sendEvent("initialized", nil)
+		}
+
+		return true
+	case "setBreakpoints":
+		body, err = s.setBreakpoints(req.Arguments)
+	case "setInstructionBreakpoints":
+		body, err = s.setInstructionBreakpoints(req.Arguments)
+	case "setExceptionBreakpoints":
+		body = map[string]interface{}{"breakpoints": []breakpoint{}}
+	case "configurationDone":
+		err = s.start()
+	case "threads":
+		body = map[string]interface{}{"threads": []thread{{threadID, "CPU"}}}
+	case "stackTrace":
+		body, err = s.stackTrace()
+	case "scopes":
+		body = map[string]interface{}{"scopes": []scope{{"Registers", registersReference, false}}}
+	case "variables":
+		body, err = s.variables()
+	case "setVariable":
+		body, err = s.setVariable(req.Arguments)
+	case "evaluate":
+		body, err = s.evaluate(req.Arguments)
+	case "readMemory":
+		body, err = s.readMemory(req.Arguments)
+	case "continue":
+		s.resume(req, map[string]interface{}{"allThreadsContinued": true}, func(p cpu.Processor) error {
+			s.dbg.Continue()
+			return nil
+		})
+
+		return true
+	case "next":
+		s.resume(req, nil, func(p cpu.Processor) error {
+			s.dbg.StepOver(p, s.stopPC)
+			return nil
+		})
+
+		return true
+	case "stepIn":
+		s.resume(req, nil, func(p cpu.Processor) error {
+			s.dbg.Step(1)
+			return nil
+		})
+
+		return true
+	case "stepOut":
+		s.resume(req, nil, func(p cpu.Processor) error {
+			return s.dbg.StepOut(p)
+		})
+
+		return true
+	case "stepBack":
+		s.rewind(req, func(p cpu.Processor) (uint32, string, error) {
+			return s.dbg.StepBack(p, 1)
+		})
+
+		return true
+	case "reverseContinue":
+		s.rewind(req, s.dbg.ReverseContinue)
+
+		return true
+	case "pause":
+		err = s.pause()
+	case "terminate":
+		s.terminate()
+	case "disconnect":
+		s.terminate()
+		s.respond(req, nil, nil)
+
+		return false
+	default:
+		err = fmt.Errorf("unsupported request '%s'", req.Command)
+	}
+
+	s.respond(req, body, err)
+
+	return true
+}
+
+func (s *Server) doLaunch(args json.RawMessage) error {
+	if s.launch != nil {
+		return fmt.Errorf("a program has already been launched")
+	}
+
+	l, err := s.launcher(args)
+	if err != nil {
+		return err
+	}
+
+	s.launch = l
+	s.dbg = debugger.NewWithHandler(l.Labels, s.stopped)
+	if l.History > 0 {
+		s.dbg.EnableHistory(l.History)
+	}
+
+	return nil
+}
+
+// start runs the program in a goroutine of its own
+func (s *Server) start() error {
+	if s.launch == nil {
+		return fmt.Errorf("no program has been launched")
+	}
+
+	if s.getState() != stateIdle {
+		return fmt.Errorf("the program has already been started")
+	}
+
+	s.setState(stateRunning)
+	s.dbg.Interrupt(s.loadSources)
+
+	if !s.launch.StopOnEntry {
+		s.dbg.Continue()
+	}
+
+	go s.run()
+
+	return nil
+}
+
+func (s *Server) run() {
+	p, err := s.launch.Run(s.dbg)
+	s.setState(stateEnded)
+
+	if (err != nil) && !s.dbg.Quit() {
+		s.Output("stderr", err.Error()+"\n")
+	}
+
+	exitCode := 0
+	if p != nil {
+		if code, ok := p.ExitCode(); ok {
+			exitCode = int(code)
+		}
+	}
+
+	if (err != nil) && (exitCode == 0) {
+		exitCode = 1
+	}
+
+	s.sendEvent("exited", map[string]interface{}{"exitCode": exitCode})
+	s.sendEvent("terminated", nil)
+	close(s.finished)
+}
+
+// stopped is the StopHandler of the Debugger. It executes actions until one of them resumes the program.
+func (s *Server) stopped(p cpu.Processor, pc uint32, reason string) bool {
+	s.stopPC = pc
+
+	entry := (s.numStops == 0) && s.launch.StopOnEntry && (reason == "step")
+
+	s.numStops++
+	s.setState(stateStopped)
+	s.sendStopped(reason, entry)
+
+	for {
+		select {
+		case f := <-s.actions:
+			if f(p) {
+				return true
+			}
+		case <-s.quitReq:
+			s.dbg.Terminate()
+			return false
+		}
+	}
+}
+
+func (s *Server) sendStopped(reason string, entry bool) {
+	dapReason := "step"
+	switch {
+	case entry:
+		dapReason = "entry"
+	case strings.HasPrefix(reason, "breakpoint"):
+		dapReason = "breakpoint"
+	case strings.HasPrefix(reason, "watchpoint"):
+		dapReason = "data breakpoint"
+	case reason == "pause":
+		dapReason = "pause"
+	}
+
+	s.sendEvent("stopped", map[string]interface{}{
+		"reason":            dapReason,
+		"description":       reason,
+		"threadId":          threadID,
+		"allThreadsStopped": true,
+	})
+}
+
+// inspect executes f while the program is stopped
+func (s *Server) inspect(f func(p cpu.Processor)) error {
+	if s.getState() != stateStopped {
+		return fmt.Errorf("the program is not stopped")
+	}
+
+	done := make(chan struct{})
+	s.actions <- func(p cpu.Processor) bool {
+		f(p)
+		close(done)
+
+		return false
+	}
+	<-done
+
+	return nil
+}
+
+// resume executes f while the program is stopped and resumes the program if f does not return an
+// error. The response is sent before the program continues, so that it precedes the next stopped event.
+func (s *Server) resume(req *request, body interface{}, f func(p cpu.Processor) error) {
+	if s.getState() != stateStopped {
+		s.respond(req, nil, fmt.Errorf("the program is not stopped"))
+		return
+	}
+
+	result := make(chan error)
+	proceed := make(chan struct{})
+	s.actions <- func(p cpu.Processor) bool {
+		err := f(p)
+		result <- err
+		<-proceed
+
+		return err == nil
+	}
+
+	err := <-result
+	if err == nil {
+		s.setState(stateRunning)
+	}
+
+	s.respond(req, body, err)
+	close(proceed)
+}
+
+// rewind executes f, which runs the program backwards, while the program is stopped. The program
+// stays stopped at the restored state, which is announced by a new stopped event.
+func (s *Server) rewind(req *request, f func(p cpu.Processor) (uint32, string, error)) {
+	var reason string
+	var err error
+
+	inspectErr := s.inspect(func(p cpu.Processor) {
+		var pc uint32
+		if pc, reason, err = f(p); err == nil {
+			s.stopPC = pc
+		}
+	})
+
+	if inspectErr != nil {
+		err = inspectErr
+	}
+
+	s.respond(req, nil, err)
+
+	if err == nil {
+		s.sendStopped(reason, false)
+	}
+}
+
+func (s *Server) pause() error {
+	if s.getState() != stateRunning {
+		return nil
+	}
+
+	s.dbg.Interrupt(func(p cpu.Processor) {
+		s.dbg.RequestStop("pause")
+	})
+
+	return nil
+}
+
+// terminate ends the program and waits until its goroutine has finished
+func (s *Server) terminate() {
+	state := s.getState()
+	if (state == stateIdle) || (state == stateEnded) {
+		return
+	}
+
+	s.quitOnce.Do(func() {
+		close(s.quitReq)
+		s.dbg.Interrupt(func(p cpu.Processor) {
+			s.dbg.Terminate()
+		})
+	})
+
+	<-s.finished
+}
+
+// -------- Breakpoints --------
+
+// resolve looks up the addresses of a source breakpoint. The caller has to hold the lock.
+func (s *Server) resolve(fileName string, spec *breakpointSpec) {
+	spec.addrs = nil
+	spec.bp.Verified = false
+	spec.bp.Line = spec.line
+
+	if s.srcMap == nil {
+		spec.bp.Message = "the program has not been assembled yet"
+		return
+	}
+
+	addrs, line, ok := s.srcMap.Resolve(fileName, spec.line)
+	if !ok {
+		spec.bp.Message = "no code has been generated for this line"
+		return
+	}
+
+	spec.addrs = addrs
+	spec.bp.Verified = true
+	spec.bp.Line = line
+	spec.bp.Message = ""
+}
+
+func (s *Server) setBreakpoints(args json.RawMessage) (interface{}, error) {
+	var a setBreakpointsArguments
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	specs := []*breakpointSpec{}
+	res := []breakpoint{}
+
+	for _, j := range a.Breakpoints {
+		spec := &breakpointSpec{bp: breakpoint{ID: s.nextBpID, Source: &a.Source}, line: j.Line, condition: j.Condition}
+		s.nextBpID++
+		s.resolve(a.Source.Path, spec)
+
+		specs = append(specs, spec)
+		res = append(res, spec.bp)
+	}
+
+	s.sourceBps[a.Source.Path] = specs
+	s.lock.Unlock()
+
+	s.syncBreakpoints()
+
+	return map[string]interface{}{"breakpoints": res}, nil
+}
+
+func (s *Server) setInstructionBreakpoints(args json.RawMessage) (interface{}, error) {
+	var a setInstructionBreakpointsArguments
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+
+	labels := s.labels()
+
+	s.lock.Lock()
+	specs := []*breakpointSpec{}
+	res := []breakpoint{}
+
+	for _, j := range a.Breakpoints {
+		spec := &breakpointSpec{bp: breakpoint{ID: s.nextBpID, InstructionReference: j.InstructionReference}, condition: j.Condition}
+		s.nextBpID++
+
+		addr, err := trace.ParseAddress(j.InstructionReference, labels)
+		if err != nil {
+			spec.bp.Message = err.Error()
+		} else {
+			spec.addrs = []uint32{uint32(int(addr) + j.Offset)}
+			spec.bp.Verified = true
+		}
+
+		specs = append(specs, spec)
+		res = append(res, spec.bp)
+	}
+
+	s.instrBps = specs
+	s.lock.Unlock()
+
+	s.syncBreakpoints()
+
+	return map[string]interface{}{"breakpoints": res}, nil
+}
+
+// syncBreakpoints passes the breakpoints to the Debugger before the next instruction is executed
+func (s *Server) syncBreakpoints() {
+	if s.dbg != nil {
+		s.dbg.Interrupt(s.applyBreakpoints)
+	}
+}
+
+func (s *Server) applyBreakpoints(p cpu.Processor) {
+	for _, j := range s.checkpointIDs {
+		_ = s.dbg.DeleteCheckpoint(j)
+	}
+
+	s.checkpointIDs = nil
+	messages := []string{}
+
+	s.lock.Lock()
+	specs := append([]*breakpointSpec{}, s.instrBps...)
+	for _, j := range s.sourceBps {
+		specs = append(specs, j...)
+	}
+
+	for _, spec := range specs {
+		for _, addr := range spec.addrs {
+			id, err := s.dbg.AddBreakpoint(addr, addr, spec.condition)
+			if err != nil {
+				messages = append(messages, fmt.Sprintf("breakpoint %d: %v\n", spec.bp.ID, err))
+				break
+			}
+
+			s.checkpointIDs = append(s.checkpointIDs, id)
+		}
+	}
+	s.lock.Unlock()
+
+	for _, j := range messages {
+		s.Output("console", j)
+	}
+}
+
+// loadSources reads the listings and resolves the source breakpoints. It is called before the first
+// instruction is executed.
+func (s *Server) loadSources(p cpu.Processor) {
+	m := srcmap.New()
+
+	for _, j := range s.launch.Listings {
+		listing, err := srcmap.LoadListing(j, srcmap.FormatForFile(j, s.launch.ListingFormat), s.launch.RelocBase)
+		if err != nil {
+			s.Output("console", err.Error()+"\n")
+			continue
+		}
+
+		m.Merge(listing)
+	}
+
+	changed := []breakpoint{}
+
+	s.lock.Lock()
+	s.srcMap = m
+
+	for fileName, specs := range s.sourceBps {
+		for _, spec := range specs {
+			s.resolve(fileName, spec)
+			changed = append(changed, spec.bp)
+		}
+	}
+	s.lock.Unlock()
+
+	for _, j := range changed {
+		s.sendEvent("breakpoint", map[string]interface{}{"reason": "changed", "breakpoint": j})
+	}
+
+	s.applyBreakpoints(p)
+}
+
+// -------- Inspecting the stopped program --------
+
+func (s *Server) labels() map[uint16][]string {
+	if s.launch == nil {
+		return nil
+	}
+
+	return s.launch.Labels
+}
+
+func (s *Server) addressName(addr uint32) string {
+	if addr <= 0xFFFF {
+		if names := s.labels()[uint16(addr)]; len(names) != 0 {
+			return names[0]
+		}
+	}
+
+	return fmt.Sprintf("$%04X", addr)
+}
+
+func (s *Server) frame(id int, name string, pc uint32) stackFrame {
+	res := stackFrame{ID: id, Name: name, Column: 1, InstructionPointerReference: fmt.Sprintf("0x%04X", pc)}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.srcMap == nil {
+		return res
+	}
+
+	if loc, ok := s.srcMap.Lookup(pc); ok {
+		res.Source = &source{Name: filepath.Base(loc.File), Path: loc.File}
+		res.Line = loc.Line
+	}
+
+	return res
+}
+
+func (s *Server) stackTrace() (interface{}, error) {
+	frames := []stackFrame{}
+
+	err := s.inspect(func(p cpu.Processor) {
+		calls := p.CallStack()
+		pc := s.stopPC
+
+		for i := len(calls) - 1; i >= -1; i-- {
+			if i < 0 {
+				frames = append(frames, s.frame(len(frames), "program", pc))
+				break
+			}
+
+			frames = append(frames, s.frame(len(frames), s.addressName(calls[i].Target), pc))
+			pc = calls[i].CallSite
+		}
+	})
+
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, err
+}
+
+func formatValue(v uint16) string {
+	if v > 0xFF {
+		return fmt.Sprintf("$%04X", v)
+	}
+
+	return fmt.Sprintf("$%02X", v)
+}
+
+func (s *Server) variables() (interface{}, error) {
+	vars := []variable{}
+
+	err := s.inspect(func(p cpu.Processor) {
+		for _, j := range debugger.Registers(p) {
+			value := formatValue(j.Value)
+			if j.Name == "FLAGS" {
+				value += " " + debugger.FormatFlags(p)
+			}
+
+			vars = append(vars, variable{Name: j.Name, Value: value})
+		}
+
+		vars = append(vars, variable{Name: "Cycles", Value: fmt.Sprintf("%d", p.NumCycles())})
+	})
+
+	return map[string]interface{}{"variables": vars}, err
+}
+
+func (s *Server) setVariable(args json.RawMessage) (interface{}, error) {
+	var a setVariableArguments
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+
+	var setErr error
+	value := ""
+
+	err := s.inspect(func(p cpu.Processor) {
+		if setErr = debugger.SetRegister(p, a.Name, a.Value); setErr != nil {
+			return
+		}
+
+		for _, j := range debugger.Registers(p) {
+			if strings.EqualFold(j.Name, a.Name) {
+				value = formatValue(j.Value)
+			}
+		}
+	})
+
+	if err == nil {
+		err = setErr
+	}
+
+	return map[string]interface{}{"value": value}, err
+}
+
+func (s *Server) evaluate(args json.RawMessage) (interface{}, error) {
+	var a evaluateArguments
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+
+	var value uint32
+	var evalErr error
+
+	err := s.inspect(func(p cpu.Processor) {
+		value, evalErr = debugger.Evaluate(p, a.Expression, s.labels())
+	})
+
+	if err == nil {
+		err = evalErr
+	}
+
+	return map[string]interface{}{"result": fmt.Sprintf("$%02X (%d)", value, value), "variablesReference": 0}, err
+}
+
+func (s *Server) readMemory(args json.RawMessage) (interface{}, error) {
+	var a readMemoryArguments
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+
+	start, err := trace.ParseAddress(a.MemoryReference, s.labels())
+	if err != nil {
+		return nil, err
+	}
+
+	start = uint32(int(start) + a.Offset)
+	data := []byte{}
+
+	err = s.inspect(func(p cpu.Processor) {
+		for i := 0; i < a.Count; i++ {
+			b, err := debugger.ReadByte(p, start+uint32(i))
+			if err != nil {
+				break
+			}
+
+			data = append(data, b)
+		}
+	})
+
+	return map[string]interface{}{
+		"address":         fmt.Sprintf("0x%04X", start),
+		"data":            base64.StdEncoding.EncodeToString(data),
+		"unreadableBytes": a.Count - len(data),
+	}, err
+}
@@ -0,0 +1,342 @@
+package dap
+
+import (
+	"6502profiler/cpu"
+	"6502profiler/debugger"
+	"6502profiler/memory"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Line 2: jsr sub, 3: inx, 4: inx, 5: brk, 6: sub iny, 7: iny, 8: rts
+var testProgram = []byte{0x20, 0x06, 0x08, 0xE8, 0xE8, 0x00, 0xC8, 0xC8, 0x60}
+
+const testReport = `
+; ******** Source: %s
+     1                          * = $0800
+     2  0800 200608             jsr sub
+     3  0803 e8                 inx
+     4  0804 e8                 inx
+     5  0805 00                 brk
+     6  0806 c8                 sub iny
+     7  0807 c8                 iny
+     8  0808 60                 rts
+`
+
+type testClient struct {
+	t        *testing.T
+	in       *io.PipeWriter
+	messages chan map[string]interface{}
+	seq      int
+}
+
+func newTestClient(t *testing.T, launcher Launcher) (*testClient, chan error) {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+
+	c := &testClient{t: t, in: clientOut, messages: make(chan map[string]interface{}, 100)}
+
+	go func() {
+		r := bufio.NewReader(clientIn)
+		for {
+			data, err := readMessage(r)
+			if err != nil {
+				close(c.messages)
+				return
+			}
+
+			var msg map[string]interface{}
+			if err = json.Unmarshal(data, &msg); err != nil {
+				panic(err)
+			}
+
+			c.messages <- msg
+		}
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- NewServer(serverIn, serverOut, launcher).Serve()
+		serverOut.Close()
+	}()
+
+	return c, done
+}
+
+func (c *testClient) send(command string, args interface{}) {
+	c.t.Helper()
+
+	c.seq++
+	msg := map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args}
+
+	if err := writeMessage(c.in, msg); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// expect skips messages until a response to command or an event with the given name arrives
+func (c *testClient) expect(kind string, name string) map[string]interface{} {
+	c.t.Helper()
+
+	key := "command"
+	if kind == "event" {
+		key = "event"
+	}
+
+	for {
+		select {
+		case msg, ok := <-c.messages:
+			if !ok {
+				c.t.Fatalf("connection closed while waiting for %s %s", kind, name)
+			}
+
+			if (msg["type"] == kind) && (msg[key] == name) {
+				return msg
+			}
+		case <-time.After(5 * time.Second):
+			c.t.Fatalf("timeout while waiting for %s %s", kind, name)
+		}
+	}
+}
+
+func (c *testClient) request(command string, args interface{}) map[string]interface{} {
+	c.t.Helper()
+
+	c.send(command, args)
+	res := c.expect("response", command)
+
+	if res["success"] != true {
+		c.t.Fatalf("%s failed: %v", command, res["message"])
+	}
+
+	body, _ := res["body"].(map[string]interface{})
+
+	return body
+}
+
+func (c *testClient) checkFrame(frames interface{}, index int, name string, line int) {
+	c.t.Helper()
+
+	frame := frames.([]interface{})[index].(map[string]interface{})
+	if (frame["name"] != name) || (frame["line"] != float64(line)) {
+		c.t.Fatalf("frame %d: expected %s at line %d, got %v", index, name, line, frame)
+	}
+}
+
+func TestDebugSession(t *testing.T) {
+	dir := t.TempDir()
+	srcName := filepath.Join(dir, "main.a")
+	reportName := filepath.Join(dir, "main.lst")
+
+	if err := os.WriteFile(reportName, []byte(fmt.Sprintf(testReport, srcName)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := cpu.New6502(cpu.Model6502)
+
+	launcher := func(args json.RawMessage) (*Launch, error) {
+		return &Launch{
+			Labels:        map[uint16][]string{0x0806: {"sub"}},
+			Listings:      []string{reportName},
+			ListingFormat: "acme",
+			Run: func(dbg *debugger.Debugger) (cpu.Processor, error) {
+				p.Init(memory.NewLinearMemory(65536))
+				p.CopyToMem(testProgram, 0x0800)
+				p.SetTracer(dbg)
+
+				return p, p.Run(0x0800)
+			},
+		}, nil
+	}
+
+	c, done := newTestClient(t, launcher)
+
+	c.request("initialize", map[string]interface{}{"adapterID": "6502profiler"})
+	c.request("launch", map[string]interface{}{})
+	c.expect("event", "initialized")
+
+	bps := c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": srcName},
+		"breakpoints": []interface{}{map[string]interface{}{"line": 7}},
+	})
+
+	if bps["breakpoints"].([]interface{})[0].(map[string]interface{})["verified"] != false {
+		t.Fatal("breakpoint verified before the program has been assembled")
+	}
+
+	c.request("configurationDone", nil)
+
+	changed := c.expect("event", "breakpoint")["body"].(map[string]interface{})["breakpoint"].(map[string]interface{})
+	if (changed["verified"] != true) || (changed["line"] != float64(7)) {
+		t.Fatalf("breakpoint not resolved: %v", changed)
+	}
+
+	stopped := c.expect("event", "stopped")["body"].(map[string]interface{})
+	if stopped["reason"] != "breakpoint" {
+		t.Fatalf("unexpected stop: %v", stopped)
+	}
+
+	frames := c.request("stackTrace", map[string]interface{}{"threadId": 1})["stackFrames"]
+	c.checkFrame(frames, 0, "sub", 7)
+	c.checkFrame(frames, 1, "program", 2)
+
+	c.request("setVariable", map[string]interface{}{"variablesReference": 1, "name": "X", "value": "$10"})
+
+	vars := c.request("variables", map[string]interface{}{"variablesReference": 1})["variables"].([]interface{})
+	found := map[string]interface{}{}
+	for _, j := range vars {
+		v := j.(map[string]interface{})
+		found[v["name"].(string)] = v["value"]
+	}
+
+	if (found["X"] != "$10") || (found["Y"] != "$01") || (found["PC"] != "$0807") {
+		t.Fatalf("unexpected registers: %v", found)
+	}
+
+	if res := c.request("evaluate", map[string]interface{}{"expression": "[sub]"})["result"]; res != "$C8 (200)" {
+		t.Fatalf("unexpected result of evaluation: %v", res)
+	}
+
+	if mem := c.request("readMemory", map[string]interface{}{"memoryReference": "0x0800", "count": 3})["data"]; mem != "IAYI" {
+		t.Fatalf("unexpected memory contents: %v", mem)
+	}
+
+	c.request("stepOut", map[string]interface{}{"threadId": 1})
+	c.expect("event", "stopped")
+
+	frames = c.request("stackTrace", map[string]interface{}{"threadId": 1})["stackFrames"]
+	c.checkFrame(frames, 0, "program", 3)
+
+	c.request("continue", map[string]interface{}{"threadId": 1})
+	c.expect("event", "exited")
+	c.expect("event", "terminated")
+
+	if p.X != 0x12 {
+		t.Fatalf("program did not run to its end: X=%02X", p.X)
+	}
+
+	c.request("disconnect", nil)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStopOnEntry(t *testing.T) {
+	p := cpu.New6502(cpu.Model6502)
+
+	launcher := func(args json.RawMessage) (*Launch, error) {
+		return &Launch{
+			StopOnEntry: true,
+			Run: func(dbg *debugger.Debugger) (cpu.Processor, error) {
+				p.Init(memory.NewLinearMemory(65536))
+				p.CopyToMem(testProgram, 0x0800)
+				p.SetTracer(dbg)
+
+				return p, p.Run(0x0800)
+			},
+		}, nil
+	}
+
+	c, done := newTestClient(t, launcher)
+
+	c.request("initialize", nil)
+	c.request("launch", nil)
+	c.request("configurationDone", nil)
+
+	if reason := c.expect("event", "stopped")["body"].(map[string]interface{})["reason"]; reason != "entry" {
+		t.Fatalf("unexpected reason for the first stop: %v", reason)
+	}
+
+	c.send("continue", nil)
+	if c.expect("response", "continue")["success"] != true {
+		t.Fatal("unable to continue")
+	}
+
+	c.send("stackTrace", nil)
+	if c.expect("response", "stackTrace")["success"] != false {
+		t.Fatal("stack trace of a running program returned")
+	}
+
+	c.request("disconnect", nil)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStepBack(t *testing.T) {
+	p := cpu.New6502(cpu.Model6502)
+
+	launcher := func(args json.RawMessage) (*Launch, error) {
+		return &Launch{
+			StopOnEntry: true,
+			History:     debugger.DefaultHistoryLength,
+			Run: func(dbg *debugger.Debugger) (cpu.Processor, error) {
+				p.Init(memory.NewLinearMemory(65536))
+				p.CopyToMem(testProgram, 0x0800)
+				p.SetTracer(dbg)
+
+				return p, p.Run(0x0800)
+			},
+		}, nil
+	}
+
+	c, done := newTestClient(t, launcher)
+
+	pc := func() interface{} {
+		vars := c.request("variables", map[string]interface{}{"variablesReference": 1})["variables"].([]interface{})
+		for _, j := range vars {
+			if v := j.(map[string]interface{}); v["name"] == "PC" {
+				return v["value"]
+			}
+		}
+
+		return nil
+	}
+
+	c.request("initialize", nil)
+	c.request("launch", nil)
+	c.request("configurationDone", nil)
+	c.expect("event", "stopped")
+
+	for i := 0; i < 3; i++ {
+		c.request("stepIn", map[string]interface{}{"threadId": 1})
+		c.expect("event", "stopped")
+	}
+
+	c.request("stepBack", map[string]interface{}{"threadId": 1})
+	c.expect("event", "stopped")
+
+	if res := pc(); res != "$0807" {
+		t.Fatalf("unexpected PC after step back: %v", res)
+	}
+
+	c.request("reverseContinue", map[string]interface{}{"threadId": 1})
+	if desc := c.expect("event", "stopped")["body"].(map[string]interface{})["description"]; desc != "start of recorded history" {
+		t.Fatalf("unexpected stop: %v", desc)
+	}
+
+	if res := pc(); res != "$0800" {
+		t.Fatalf("unexpected PC after reverse continue: %v", res)
+	}
+
+	c.request("continue", map[string]interface{}{"threadId": 1})
+	c.expect("event", "terminated")
+
+	if (p.X != 2) || (p.Y != 2) {
+		t.Fatalf("program did not run to its end: X=%02X Y=%02X", p.X, p.Y)
+	}
+
+	c.request("disconnect", nil)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
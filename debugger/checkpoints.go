@@ -0,0 +1,254 @@
+package debugger
+
+import (
+	"6502profiler/cpu"
+	"6502profiler/trace"
+	"fmt"
+	"strings"
+)
+
+type checkpointKind int
+
+const (
+	kindExec checkpointKind = iota
+	kindRead
+	kindWrite
+	kindReadWrite
+)
+
+func (k checkpointKind) String() string {
+	switch k {
+	case kindRead:
+		return "watch r"
+	case kindWrite:
+		return "watch w"
+	case kindReadWrite:
+		return "watch rw"
+	}
+
+	return "break"
+}
+
+// checkpoint is a breakpoint or a watchpoint. Both are numbered in the same sequence.
+type checkpoint struct {
+	id    int
+	kind  checkpointKind
+	start uint32
+	end   uint32
+	cond  *condition
+	hits  uint64
+}
+
+func (c *checkpoint) contains(addr uint32) bool {
+	return (addr >= c.start) && (addr <= c.end)
+}
+
+func (c *checkpoint) matchesAccess(addr uint32, write bool) bool {
+	if (c.kind == kindExec) || !c.contains(addr) {
+		return false
+	}
+
+	if write {
+		return c.kind != kindRead
+	}
+
+	return c.kind != kindWrite
+}
+
+func (c *checkpoint) String() string {
+	res := fmt.Sprintf("%d: %s %s", c.id, c.kind, formatAddress(c.start))
+	if c.end != c.start {
+		res += "-" + formatAddress(c.end)
+	}
+
+	if c.cond != nil {
+		res += " if " + c.cond.text
+	}
+
+	return res + fmt.Sprintf(" (%d hits)", c.hits)
+}
+
+func formatAddress(addr uint32) string {
+	if addr > 0xFFFF {
+		return fmt.Sprintf("$%02X:%04X", addr>>16, addr&0xFFFF)
+	}
+
+	return fmt.Sprintf("$%04X", addr)
+}
+
+// parseRange parses an address or an inclusive address range in the form start-end
+func parseRange(spec string, labels map[uint16][]string) (uint32, uint32, error) {
+	startSpec, endSpec, isRange := strings.Cut(spec, "-")
+	if !isRange {
+		endSpec = startSpec
+	}
+
+	start, err := trace.ParseAddress(startSpec, labels)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	end, err := trace.ParseAddress(endSpec, labels)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if end < start {
+		return 0, 0, fmt.Errorf("end of address range '%s' is lower than its start", spec)
+	}
+
+	return start, end, nil
+}
+
+// -------- Conditions of breakpoints --------
+
+type operandKind int
+
+const (
+	operandConst operandKind = iota
+	operandRegister
+	operandPC
+	operandMemory
+)
+
+type operand struct {
+	kind  operandKind
+	value uint32
+	reg   cpu.Register
+}
+
+type comparison struct {
+	left  operand
+	op    string
+	right operand
+}
+
+// condition is a conjunction of comparisons, e.g. "A == $10 && [$0720] != 0"
+type condition struct {
+	text        string
+	comparisons []comparison
+}
+
+var comparisonOps = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+func parseOperand(spec string, labels map[uint16][]string) (operand, error) {
+	spec = strings.TrimSpace(spec)
+
+	if strings.HasPrefix(spec, "[") && strings.HasSuffix(spec, "]") {
+		addr, err := trace.ParseAddress(spec[1:len(spec)-1], labels)
+		if err != nil {
+			return operand{}, err
+		}
+
+		return operand{kind: operandMemory, value: addr}, nil
+	}
+
+	if strings.ToUpper(spec) == "PC" {
+		return operand{kind: operandPC}, nil
+	}
+
+	if r, ok := registerByName(spec); ok {
+		return operand{kind: operandRegister, reg: r}, nil
+	}
+
+	v, err := trace.ParseAddress(spec, labels)
+	if err != nil {
+		return operand{}, fmt.Errorf("'%s' is neither a register, a memory reference, a number nor a known label", spec)
+	}
+
+	return operand{kind: operandConst, value: v}, nil
+}
+
+// parseCondition parses comparisons of registers, memory bytes ([addr]), numbers and labels
+// which are joined by &&
+func parseCondition(spec string, labels map[uint16][]string) (*condition, error) {
+	res := &condition{text: strings.TrimSpace(spec)}
+
+	for _, term := range strings.Split(spec, "&&") {
+		found := false
+
+		for _, op := range comparisonOps {
+			left, right, ok := strings.Cut(term, op)
+			if !ok {
+				continue
+			}
+
+			l, err := parseOperand(left, labels)
+			if err != nil {
+				return nil, err
+			}
+
+			r, err := parseOperand(right, labels)
+			if err != nil {
+				return nil, err
+			}
+
+			res.comparisons = append(res.comparisons, comparison{l, op, r})
+			found = true
+
+			break
+		}
+
+		if !found {
+			return nil, fmt.Errorf("'%s' is not a comparison", strings.TrimSpace(term))
+		}
+	}
+
+	return res, nil
+}
+
+func (o operand) eval(p cpu.Processor) (uint32, error) {
+	switch o.kind {
+	case operandRegister:
+		v, ok := p.GetRegister(o.reg)
+		if !ok {
+			return 0, fmt.Errorf("register does not exist in this CPU")
+		}
+
+		return uint32(v), nil
+	case operandPC:
+		return uint32(p.GetPC()), nil
+	case operandMemory:
+		v, err := ReadByte(p, o.value)
+		return uint32(v), err
+	}
+
+	return o.value, nil
+}
+
+func (c *condition) eval(p cpu.Processor) (bool, error) {
+	for _, j := range c.comparisons {
+		l, err := j.left.eval(p)
+		if err != nil {
+			return false, err
+		}
+
+		r, err := j.right.eval(p)
+		if err != nil {
+			return false, err
+		}
+
+		var res bool
+
+		switch j.op {
+		case "==":
+			res = l == r
+		case "!=":
+			res = l != r
+		case "<=":
+			res = l <= r
+		case ">=":
+			res = l >= r
+		case "<":
+			res = l < r
+		case ">":
+			res = l > r
+		}
+
+		if !res {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,132 @@
+package debugger
+
+import (
+	"6502profiler/cpu"
+	"6502profiler/memory"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// jsr sub
+// inx
+// inx
+// brk
+// sub iny
+// iny
+// rts
+var testProgram = []byte{0x20, 0x06, 0x08, 0xE8, 0xE8, 0x00, 0xC8, 0xC8, 0x60}
+
+func debugProgram(t *testing.T, commands string) (*cpu.CPU6502, string) {
+	t.Helper()
+
+	p := cpu.New6502(cpu.Model6502)
+	p.Init(memory.NewLinearMemory(65536))
+	p.CopyToMem(testProgram, 0x0800)
+
+	out := &bytes.Buffer{}
+	d := New(strings.NewReader(commands), out, map[uint16][]string{0x0806: {"sub"}})
+	p.SetTracer(d)
+
+	if err := p.Run(0x0800); err != nil {
+		t.Fatalf("program failed: %v", err)
+	}
+
+	return p, out.String()
+}
+
+func checkStops(t *testing.T, output string, expected ...string) {
+	t.Helper()
+
+	stops := []string{}
+	for _, j := range strings.Split(output, "\n") {
+		if i := strings.Index(j, "Stopped at "); i >= 0 {
+			stops = append(stops, j[i+len("Stopped at "):])
+		}
+	}
+
+	if strings.Join(stops, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("wrong stops:\n%s\noutput:\n%s", strings.Join(stops, "\n"), output)
+	}
+}
+
+func TestStepAndNext(t *testing.T) {
+	p, out := debugProgram(t, "n\ns\nc\n")
+
+	checkStops(t, out, "$0800: step", "$0803: step", "$0804: step")
+
+	if (p.X != 2) || (p.Y != 2) {
+		t.Fatalf("program did not run to its end: X=%02X Y=%02X", p.X, p.Y)
+	}
+}
+
+func TestStepIntoAndFinish(t *testing.T) {
+	_, out := debugProgram(t, "s\nbt\nfinish\nc\n")
+
+	checkStops(t, out, "$0800: step", "$0806 (sub): step", "$0803: step")
+
+	if !strings.Contains(out, "#0  $0806 (sub) called from $0800") {
+		t.Fatalf("backtrace missing:\n%s", out)
+	}
+}
+
+func TestConditionalBreakpoint(t *testing.T) {
+	_, out := debugProgram(t, "b sub+1\nb $0803-$0804 if X == 1 && Y >= 2\nc\nc\nc\n")
+
+	checkStops(t, out, "$0800: step", "$0804: breakpoint 1")
+
+	if !strings.Contains(out, "'sub+1' is neither an address nor a known label") {
+		t.Fatalf("illegal breakpoint accepted:\n%s", out)
+	}
+}
+
+func TestWatchpointAndRegisters(t *testing.T) {
+	// JSR pushes the return address to $01FF and $01FE. The write is reported before the first
+	// instruction of the subroutine, the read by RTS before the INX.
+	p, out := debugProgram(t, "watch $01FF\nr X=$10\nc\nc\nc\n")
+
+	checkStops(t, out,
+		"$0800: step",
+		"$0806 (sub): watchpoint 1: write to $01FF by instruction at $0800",
+		"$0803: watchpoint 1: read from $01FF by instruction at $0808")
+
+	if p.X != 0x12 {
+		t.Fatalf("register not changed: X=%02X", p.X)
+	}
+}
+
+func TestQuit(t *testing.T) {
+	p, out := debugProgram(t, "s\nq\n")
+
+	if (p.Y != 0) || (p.NumCycles() != 6) {
+		t.Fatalf("program not stopped: Y=%02X, %d cycles\n%s", p.Y, p.NumCycles(), out)
+	}
+}
+
+func TestParseCondition(t *testing.T) {
+	for _, j := range []string{"A", "A = 5", "Q == 1", "[$10 == 3"} {
+		if _, err := parseCondition(j, nil); err == nil {
+			t.Fatalf("condition '%s' accepted", j)
+		}
+	}
+
+	p := cpu.New6502(cpu.Model6502)
+	p.Init(memory.NewLinearMemory(65536))
+	p.A = 5
+	p.GetMem().Store(0x10, 3)
+
+	c, err := parseCondition("A > 4 && [$10] == 3 && pc != $1000", nil)
+	if err != nil {
+		t.Fatalf("unable to parse condition: %v", err)
+	}
+
+	if match, err := c.eval(p); !match || (err != nil) {
+		t.Fatal("condition does not match")
+	}
+
+	p.A = 4
+
+	if match, _ := c.eval(p); match {
+		t.Fatal("condition matches")
+	}
+}
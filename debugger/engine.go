@@ -0,0 +1,414 @@
+package debugger
+
+import (
+	"6502profiler/cpu"
+	"6502profiler/disasm"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+type stepMode int
+
+const (
+	// Stop when remaining reaches zero
+	modeStep stepMode = iota
+	// Stop when the call stack is not deeper than depth
+	modeDepth
+	// Stop only at breakpoints and watchpoints
+	modeRun
+	// Stop when the clock cycle count reaches targetCycle
+	modeCycle
+)
+
+// StopHandler is called when the program stops before the instruction at pc. The program is
+// resumed when it returns true and ended when it returns false. While it runs, the state of the
+// CPU can be inspected and the stepping mode can be changed through the methods of the Debugger.
+type StopHandler func(p cpu.Processor, pc uint32, reason string) bool
+
+// Debugger controls a program through the cpu.Debugger interface. It keeps track of breakpoints,
+// watchpoints and the stepping mode and calls its StopHandler whenever the program has to stop.
+// Initially it stops before the first instruction. All methods with the exception of Interrupt have
+// to be called by the goroutine which runs the program, i.e. from the StopHandler or from a
+// function passed to Interrupt.
+type Debugger struct {
+	labels      map[uint16][]string
+	handler     StopHandler
+	checkpoints []*checkpoint
+	nextID      int
+	mode        stepMode
+	remaining   uint64
+	depth       int
+	targetCycle uint64
+	stopped     bool
+	ended       bool
+	quit        bool
+	currentPC   uint32
+	watchHit    string
+	stopReason  string
+	interrupted atomic.Bool
+	lock        sync.Mutex
+	pending     []func(p cpu.Processor)
+	tracer      cpu.Tracer
+	history     *History
+	// rewound is set when an earlier state has been restored while the program was stopped
+	rewound bool
+	// restarting is set when the CPU starts over with the instruction at the restored PC
+	restarting bool
+}
+
+// NewWithHandler creates a Debugger which calls h when the program stops
+func NewWithHandler(labels map[uint16][]string, h StopHandler) *Debugger {
+	if labels == nil {
+		labels = map[uint16][]string{}
+	}
+
+	return &Debugger{
+		labels:      labels,
+		handler:     h,
+		checkpoints: []*checkpoint{},
+		nextID:      1,
+		mode:        modeStep,
+		remaining:   1,
+	}
+}
+
+// EnableHistory records the executed instructions, so that the program can be run backwards. The
+// last length instructions can be undone one by one.
+func (d *Debugger) EnableHistory(length int) {
+	d.history = NewHistory(length)
+}
+
+// History returns nil if the executed instructions are not recorded
+func (d *Debugger) History() *History {
+	return d.history
+}
+
+// Labels returns the labels used to resolve and show addresses
+func (d *Debugger) Labels() map[uint16][]string {
+	return d.labels
+}
+
+// Quit returns true if the debugging session has been ended
+func (d *Debugger) Quit() bool {
+	return d.quit
+}
+
+// Running returns false if the StopHandler has been called by PostMortem
+func (d *Debugger) Running() bool {
+	return !d.ended
+}
+
+// Terminate ends the program before the next instruction
+func (d *Debugger) Terminate() {
+	d.quit = true
+}
+
+// Step resumes the program and stops it after count instructions
+func (d *Debugger) Step(count uint64) {
+	d.mode = modeStep
+	d.remaining = count
+}
+
+// StepOver works like Step(1) but executes a subroutine call as a whole
+func (d *Debugger) StepOver(p cpu.Processor, pc uint32) {
+	instr := d.disassembler(p).DecodeAt(p, pc)
+	if !isCall(instr.Mnemonic) {
+		d.Step(1)
+		return
+	}
+
+	d.mode = modeDepth
+	d.depth = len(p.CallStack())
+}
+
+// StepOut resumes the program and stops it after the current subroutine has returned
+func (d *Debugger) StepOut(p cpu.Processor) error {
+	depth := len(p.CallStack())
+	if depth == 0 {
+		return fmt.Errorf("not inside a subroutine")
+	}
+
+	d.mode = modeDepth
+	d.depth = depth - 1
+
+	return nil
+}
+
+// Continue resumes the program until a breakpoint or a watchpoint is hit
+func (d *Debugger) Continue() {
+	d.mode = modeRun
+}
+
+// RequestStop stops the program before the next instruction
+func (d *Debugger) RequestStop(reason string) {
+	d.stopReason = reason
+}
+
+// Interrupt queues f. It is executed by the goroutine which runs the program before the next
+// instruction. Interrupt can be called from any goroutine.
+func (d *Debugger) Interrupt(f func(p cpu.Processor)) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.pending = append(d.pending, f)
+	d.interrupted.Store(true)
+}
+
+func (d *Debugger) runInterrupts(p cpu.Processor) {
+	d.lock.Lock()
+	pending := d.pending
+	d.pending = nil
+	d.interrupted.Store(false)
+	d.lock.Unlock()
+
+	for _, f := range pending {
+		f(p)
+	}
+}
+
+// AddBreakpoint stops the program before an instruction in the given address range is executed and
+// cond, if not empty, is true. It returns the number of the new breakpoint.
+func (d *Debugger) AddBreakpoint(start uint32, end uint32, cond string) (int, error) {
+	c := &checkpoint{kind: kindExec, start: start, end: end}
+
+	if cond != "" {
+		var err error
+
+		c.cond, err = parseCondition(cond, d.labels)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return d.addCheckpoint(c), nil
+}
+
+// AddWatchpoint stops the program after an instruction has accessed the given address range. access
+// is "r", "w" or "rw". It returns the number of the new watchpoint.
+func (d *Debugger) AddWatchpoint(access string, start uint32, end uint32) (int, error) {
+	kind := kindReadWrite
+
+	switch access {
+	case "r":
+		kind = kindRead
+	case "w":
+		kind = kindWrite
+	case "rw":
+	default:
+		return 0, fmt.Errorf("unknown access type '%s'", access)
+	}
+
+	return d.addCheckpoint(&checkpoint{kind: kind, start: start, end: end}), nil
+}
+
+func (d *Debugger) addCheckpoint(c *checkpoint) int {
+	c.id = d.nextID
+	d.nextID++
+	d.checkpoints = append(d.checkpoints, c)
+
+	return c.id
+}
+
+// DeleteCheckpoint deletes the breakpoint or watchpoint with the given number
+func (d *Debugger) DeleteCheckpoint(id int) error {
+	for i, j := range d.checkpoints {
+		if j.id == id {
+			d.checkpoints = append(d.checkpoints[:i], d.checkpoints[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("there is no breakpoint or watchpoint %d", id)
+}
+
+// SetTracer passes the trace records of the executed instructions on to t
+func (d *Debugger) SetTracer(t cpu.Tracer) {
+	d.tracer = t
+}
+
+// Trace implements cpu.Tracer
+func (d *Debugger) Trace(p cpu.Processor, r *cpu.TraceRecord) {
+	if d.tracer != nil {
+		d.tracer.Trace(p, r)
+	}
+}
+
+// BeforeWrite implements cpu.WriteRecorder. It records the previous value of a byte written by the
+// current instruction.
+func (d *Debugger) BeforeWrite(addr uint32, large bool, old uint8) {
+	if (d.history != nil) && !d.stopped {
+		d.history.write(memKey{addr, large}, old)
+	}
+}
+
+// MemoryAccess implements cpu.Debugger. It checks the watchpoints.
+func (d *Debugger) MemoryAccess(addr uint32, write bool) {
+	if d.stopped || (d.watchHit != "") {
+		return
+	}
+
+	for _, j := range d.checkpoints {
+		if !j.matchesAccess(addr, write) {
+			continue
+		}
+
+		j.hits++
+
+		access := "read from"
+		if write {
+			access = "write to"
+		}
+
+		d.watchHit = fmt.Sprintf("watchpoint %d: %s %s by instruction at %s", j.id, access, d.addressWithLabel(addr), d.addressWithLabel(d.currentPC))
+
+		return
+	}
+}
+
+// BeforeInstruction implements cpu.Debugger. It calls the StopHandler if the program has to stop
+// before the instruction at pc.
+func (d *Debugger) BeforeInstruction(p cpu.Processor, pc uint32) bool {
+	if d.interrupted.Load() {
+		d.runInterrupts(p)
+	}
+
+	if d.quit {
+		return false
+	}
+
+	// The user has already decided to resume before the instruction was started over
+	if d.restarting {
+		d.restarting = false
+		d.currentPC = pc
+		d.record(p, pc)
+
+		return true
+	}
+
+	reason := ""
+
+	switch d.mode {
+	case modeStep:
+		d.remaining--
+		if d.remaining == 0 {
+			reason = "step"
+		}
+	case modeDepth:
+		if len(p.CallStack()) <= d.depth {
+			reason = "step"
+		}
+	case modeCycle:
+		if p.NumCycles() >= d.targetCycle {
+			reason = fmt.Sprintf("clock cycle %d reached", d.targetCycle)
+		}
+	}
+
+	if d.watchHit != "" {
+		reason = d.watchHit
+		d.watchHit = ""
+	}
+
+	if d.stopReason != "" {
+		reason = d.stopReason
+		d.stopReason = ""
+	}
+
+	for _, j := range d.checkpoints {
+		if (j.kind != kindExec) || !j.contains(pc) {
+			continue
+		}
+
+		if j.cond != nil {
+			match, err := j.cond.eval(p)
+			if err != nil {
+				j.hits++
+				reason = fmt.Sprintf("breakpoint %d, condition can not be evaluated: %v", j.id, err)
+
+				continue
+			}
+
+			if !match {
+				continue
+			}
+		}
+
+		j.hits++
+		reason = fmt.Sprintf("breakpoint %d", j.id)
+	}
+
+	d.currentPC = pc
+
+	if (reason != "") && !d.stop(p, pc, reason) {
+		return false
+	}
+
+	if d.rewound {
+		d.rewound = false
+		d.restarting = true
+
+		return true
+	}
+
+	d.record(p, pc)
+
+	return true
+}
+
+func (d *Debugger) record(p cpu.Processor, pc uint32) {
+	if d.history != nil {
+		d.history.record(p, pc)
+	}
+}
+
+func (d *Debugger) stop(p cpu.Processor, pc uint32, reason string) bool {
+	d.stopped = true
+	defer func() { d.stopped = false }()
+
+	if !d.handler(p, pc, reason) {
+		d.quit = true
+	}
+
+	return !d.quit
+}
+
+// PostMortem is called after the program has ended. It calls the StopHandler once more, so that
+// the final state of the machine can be inspected. Running returns false while the StopHandler runs.
+func (d *Debugger) PostMortem(p cpu.Processor) {
+	if d.quit {
+		return
+	}
+
+	d.ended = true
+	defer func() { d.ended = false }()
+
+	reason := "end of program"
+	if d.watchHit != "" {
+		reason = d.watchHit
+		d.watchHit = ""
+	}
+
+	d.stop(p, uint32(p.GetPC()), reason)
+	d.rewound = false
+}
+
+func (d *Debugger) addressWithLabel(addr uint32) string {
+	if addr <= 0xFFFF {
+		if names := d.labels[uint16(addr)]; len(names) != 0 {
+			return fmt.Sprintf("%s (%s)", formatAddress(addr), names[0])
+		}
+	}
+
+	return formatAddress(addr)
+}
+
+func (d *Debugger) disassembler(p cpu.Processor) *disasm.Disassembler {
+	res := disasm.ForProcessor(p)
+	res.SetLabels(d.labels)
+
+	return res
+}
+
+func isCall(mnemonic string) bool {
+	return (mnemonic == "JSR") || (mnemonic == "JSL") || (mnemonic == "BSR")
+}
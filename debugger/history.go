@@ -0,0 +1,269 @@
+package debugger
+
+import (
+	"6502profiler/cpu"
+	"6502profiler/memory"
+)
+
+// DefaultHistoryLength is the default number of instructions which can be undone one by one
+const DefaultHistoryLength = 1000000
+
+// DefaultSnapshotInterval is the number of instructions between two snapshots
+const DefaultSnapshotInterval = 100000
+
+// maxSnapshots limits the number of snapshots which are kept in addition to the undo log
+const maxSnapshots = 100
+
+// memKey identifies a byte in the 16 bit or in the linear address space
+type memKey struct {
+	addr  uint32
+	large bool
+}
+
+type memWrite struct {
+	key memKey
+	old uint8
+}
+
+// cpuState is the state of the CPU before an instruction has been executed
+type cpuState struct {
+	pc        uint32
+	registers [len(cpu.TraceRegisters)]uint16
+	exec      cpu.ExecState
+}
+
+// undoEntry allows to undo one instruction
+type undoEntry struct {
+	cpuState
+	writes []memWrite
+	snap   *snapshot
+	// firstOfSnapshot is true if the instruction is the first one recorded for snap
+	firstOfSnapshot bool
+}
+
+// snapshot describes the state at the beginning of an interval of DefaultSnapshotInterval
+// instructions. When the undo entries of the interval are dropped from the undo log their writes are
+// folded into old, which then contains the values of all bytes changed in the interval at its start.
+type snapshot struct {
+	cpuState
+	old        map[memKey]uint8
+	numEntries int
+}
+
+// WriteInfo describes the most recent write access to an address
+type WriteInfo struct {
+	// Address of the instruction which has written to the address
+	PC uint32
+	// Clock cycle count before the instruction has been executed
+	Cycle uint64
+	// Value of the byte before it has been written
+	Old uint8
+}
+
+// History records the executed instructions of the current program run, so that they can be undone.
+// The most recent instructions are kept in an undo log. Older instructions are only kept as
+// snapshots, i.e. the program can only return to the beginning of a snapshot interval.
+type History struct {
+	length     int
+	interval   int
+	entries    []undoEntry
+	snapshots  []*snapshot
+	lastWrites map[memKey]WriteInfo
+}
+
+// NewHistory creates a History which allows to undo the last length instructions one by one
+func NewHistory(length int) *History {
+	return &History{
+		length:     length,
+		interval:   DefaultSnapshotInterval,
+		entries:    []undoEntry{},
+		snapshots:  []*snapshot{},
+		lastWrites: map[memKey]WriteInfo{},
+	}
+}
+
+func (h *History) clear() {
+	h.entries = h.entries[:0]
+	h.snapshots = h.snapshots[:0]
+	h.lastWrites = map[memKey]WriteInfo{}
+}
+
+// Len returns the number of instructions which can be undone one by one
+func (h *History) Len() int {
+	return len(h.entries)
+}
+
+// OldestCycle returns the clock cycle count of the oldest state the program can return to
+func (h *History) OldestCycle() (uint64, bool) {
+	if len(h.snapshots) > 0 {
+		return h.snapshots[0].exec.Cycles, true
+	}
+
+	if len(h.entries) > 0 {
+		return h.entries[0].exec.Cycles, true
+	}
+
+	return 0, false
+}
+
+func saveState(p cpu.Processor, pc uint32) cpuState {
+	res := cpuState{pc: pc, exec: p.GetExecState()}
+
+	for i, j := range cpu.TraceRegisters {
+		res.registers[i], _ = p.GetRegister(j)
+	}
+
+	return res
+}
+
+func (s *cpuState) restore(p cpu.Processor) {
+	// The emulation flag and the flags change the width of the other registers. They have to be
+	// restored first.
+	for _, first := range []bool{true, false} {
+		for i, j := range cpu.TraceRegisters {
+			if ((j == cpu.RegE) || (j == cpu.RegFlags)) == first {
+				_ = p.SetRegister(j, s.registers[i])
+			}
+		}
+	}
+
+	p.SetPC(uint16(s.pc))
+	p.SetExecState(s.exec)
+}
+
+// record adds the instruction at pc which is about to be executed
+func (h *History) record(p cpu.Processor, pc uint32) {
+	state := saveState(p, pc)
+
+	// A new program run has started
+	if state.exec.Instructions == 1 {
+		h.clear()
+	}
+
+	entry := undoEntry{cpuState: state}
+
+	if (len(h.snapshots) == 0) || (h.snapshots[len(h.snapshots)-1].numEntries >= h.interval) {
+		h.snapshots = append(h.snapshots, &snapshot{cpuState: state, old: map[memKey]uint8{}})
+		entry.firstOfSnapshot = true
+
+		if len(h.snapshots) > maxSnapshots {
+			h.snapshots = append(h.snapshots[:0], h.snapshots[1:]...)
+		}
+	}
+
+	entry.snap = h.snapshots[len(h.snapshots)-1]
+	entry.snap.numEntries++
+
+	h.entries = append(h.entries, entry)
+
+	if len(h.entries) >= 2*h.length {
+		h.fold(len(h.entries) - h.length)
+	}
+}
+
+// fold removes the oldest n entries from the undo log and keeps their writes in the snapshots
+func (h *History) fold(n int) {
+	for _, e := range h.entries[:n] {
+		for _, w := range e.writes {
+			if _, ok := e.snap.old[w.key]; !ok {
+				e.snap.old[w.key] = w.old
+			}
+		}
+	}
+
+	h.entries = append(h.entries[:0], h.entries[n:]...)
+}
+
+// write records that the current instruction changes a byte
+func (h *History) write(key memKey, old uint8) {
+	if len(h.entries) == 0 {
+		return
+	}
+
+	e := &h.entries[len(h.entries)-1]
+	e.writes = append(e.writes, memWrite{key, old})
+	h.lastWrites[key] = WriteInfo{PC: e.pc, Cycle: e.exec.Cycles, Old: old}
+}
+
+func restoreByte(p cpu.Processor, key memKey, b uint8) {
+	if key.large {
+		memory.PokeLarge(p.GetMem(), key.addr, b)
+	} else {
+		memory.Poke(p.GetMem(), uint16(key.addr), b)
+	}
+}
+
+// undo returns to the state before the most recent instruction. It returns false if the undo log
+// is empty.
+func (h *History) undo(p cpu.Processor) (undoEntry, bool) {
+	if len(h.entries) == 0 {
+		return undoEntry{}, false
+	}
+
+	e := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+
+	for i := len(e.writes) - 1; i >= 0; i-- {
+		restoreByte(p, e.writes[i].key, e.writes[i].old)
+		delete(h.lastWrites, e.writes[i].key)
+	}
+
+	e.snap.numEntries--
+	if e.firstOfSnapshot && (len(h.snapshots) > 0) && (h.snapshots[len(h.snapshots)-1] == e.snap) {
+		h.snapshots = h.snapshots[:len(h.snapshots)-1]
+	}
+
+	e.restore(p)
+
+	return e, true
+}
+
+// restoreSnapshot returns to the beginning of the newest snapshot interval which starts at or
+// before cycle. The undo log has to be empty. It returns the restored PC or false if there is no
+// snapshot.
+func (h *History) restoreSnapshot(p cpu.Processor, cycle uint64) (uint32, bool) {
+	if (len(h.entries) != 0) || (len(h.snapshots) == 0) {
+		return 0, false
+	}
+
+	var pc uint32
+
+	for len(h.snapshots) > 0 {
+		s := h.snapshots[len(h.snapshots)-1]
+		h.snapshots = h.snapshots[:len(h.snapshots)-1]
+
+		for key, b := range s.old {
+			restoreByte(p, key, b)
+			delete(h.lastWrites, key)
+		}
+
+		s.restore(p)
+		pc = s.pc
+
+		if s.exec.Cycles <= cycle {
+			break
+		}
+	}
+
+	return pc, true
+}
+
+// LastWrite returns the most recent write access to an address before the current state. large is
+// true if addr belongs to the linear address space. Writes are forgotten when they are undone, i.e.
+// older writes are only found if they are still contained in the undo log.
+func (h *History) LastWrite(addr uint32, large bool) (WriteInfo, bool) {
+	key := memKey{addr, large}
+
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		e := &h.entries[i]
+		for j := len(e.writes) - 1; j >= 0; j-- {
+			if e.writes[j].key == key {
+				return WriteInfo{PC: e.pc, Cycle: e.exec.Cycles, Old: e.writes[j].old}, true
+			}
+		}
+	}
+
+	info, ok := h.lastWrites[key]
+
+	return info, ok
+}
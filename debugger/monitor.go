@@ -0,0 +1,447 @@
+package debugger
+
+import (
+	"6502profiler/cpu"
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const defaultDisasmCount = 10
+const defaultDumpLength = 128
+
+// monitor is a line oriented user interface for a Debugger which is similar to the VICE monitor
+type monitor struct {
+	d          *Debugger
+	in         *bufio.Scanner
+	out        io.Writer
+	pc         uint32
+	nextDisasm uint32
+	nextDump   uint32
+}
+
+// New creates a Debugger which reads monitor commands from in whenever the program stops
+func New(in io.Reader, out io.Writer, labels map[uint16][]string) *Debugger {
+	m := &monitor{
+		in:  bufio.NewScanner(in),
+		out: out,
+	}
+
+	m.d = NewWithHandler(labels, m.stopped)
+
+	return m.d
+}
+
+func (m *monitor) printState(p cpu.Processor, pc uint32) {
+	fmt.Fprintln(m.out, m.d.disassembler(p).DecodeAt(p, pc).String())
+	fmt.Fprintln(m.out, FormatRegisters(p))
+}
+
+// stopped reads and executes commands until the program is resumed
+func (m *monitor) stopped(p cpu.Processor, pc uint32, reason string) bool {
+	if m.d.Running() {
+		fmt.Fprintf(m.out, "Stopped at %s: %s\n", m.d.addressWithLabel(pc), reason)
+	} else {
+		if reason != "end of program" {
+			fmt.Fprintln(m.out, reason)
+		}
+
+		fmt.Fprintf(m.out, "Program stopped after %d clock cycles\n", p.NumCycles())
+	}
+
+	m.pc = pc
+	m.nextDisasm = pc
+	m.printState(p, pc)
+
+	for {
+		fmt.Fprintf(m.out, "(%s) ", formatAddress(m.pc))
+
+		if !m.in.Scan() {
+			fmt.Fprintln(m.out)
+			return false
+		}
+
+		fields := strings.Fields(m.in.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		resume, err := m.execute(p, strings.ToLower(fields[0]), fields[1:])
+		if err != nil {
+			fmt.Fprintf(m.out, "error: %v\n", err)
+			continue
+		}
+
+		if m.d.Quit() {
+			return false
+		}
+
+		if resume {
+			return true
+		}
+	}
+}
+
+const helpText = `break|b [addr [if cond]]   list breakpoints or set one at an address or label
+watch|w [r|w|rw] range     stop after an instruction has accessed an address or a range start-end
+del <n>                    delete breakpoint or watchpoint n
+step|s|z [n]               execute n instructions (default 1)
+next|n                     like step, but execute subroutine calls as a whole
+finish|ret                 run until the current subroutine has returned
+continue|c|x|g             run until a breakpoint or a watchpoint is hit
+registers|r [reg=val ...]  show or change registers, e.g. r A=$10 PC=$0810
+m [range]                  show memory, e.g. m $0800-$083F
+> addr byte ...            change memory
+d [addr [count]]           disassemble
+bt                         show the active subroutine calls
+back|bs [n]                undo the last n instructions (default 1)
+rc                         run backwards until a breakpoint or a write watchpoint is hit
+goto <cycle>               go back or forward to a clock cycle count
+lastwrite|lw addr          show which instruction has written to an address most recently
+quit|q                     end the debugging session
+Conditions compare registers, PC, memory bytes ([addr]), numbers and labels with
+== != < <= > >= and can be joined by &&, e.g. break loop if X == 3 && [$20] != 0`
+
+// execute runs one monitor command. It returns true if the program has to be resumed.
+func (m *monitor) execute(p cpu.Processor, cmd string, args []string) (bool, error) {
+	resumes := map[string]bool{"step": true, "s": true, "z": true, "next": true, "n": true, "finish": true, "ret": true,
+		"continue": true, "c": true, "x": true, "g": true}
+
+	if resumes[cmd] && !m.d.Running() {
+		return false, fmt.Errorf("the program has stopped")
+	}
+
+	switch cmd {
+	case "help", "?":
+		fmt.Fprintln(m.out, helpText)
+	case "break", "b":
+		return false, m.addBreakpoint(args)
+	case "watch", "w":
+		return false, m.addWatchpoint(args)
+	case "del":
+		if len(args) != 1 {
+			return false, fmt.Errorf("del expects the number of a breakpoint or a watchpoint")
+		}
+
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return false, fmt.Errorf("'%s' is not a valid number", args[0])
+		}
+
+		return false, m.d.DeleteCheckpoint(id)
+	case "step", "s", "z":
+		count := uint64(1)
+
+		if len(args) > 0 {
+			n, err := strconv.ParseUint(args[0], 10, 64)
+			if (err != nil) || (n == 0) {
+				return false, fmt.Errorf("'%s' is not a valid number of instructions", args[0])
+			}
+
+			count = n
+		}
+
+		m.d.Step(count)
+	case "next", "n":
+		m.d.StepOver(p, m.pc)
+	case "finish", "ret":
+		if err := m.d.StepOut(p); err != nil {
+			return false, err
+		}
+	case "continue", "c", "x", "g":
+		m.d.Continue()
+	case "registers", "r":
+		for _, j := range args {
+			name, value, ok := strings.Cut(j, "=")
+			if !ok {
+				return false, fmt.Errorf("expected register=value instead of '%s'", j)
+			}
+
+			if err := SetRegister(p, name, value); err != nil {
+				return false, err
+			}
+		}
+
+		fmt.Fprintln(m.out, FormatRegisters(p))
+	case "m":
+		return false, m.dumpMemory(p, args)
+	case ">":
+		return false, m.changeMemory(p, args)
+	case "d":
+		return false, m.disassemble(p, args)
+	case "bt":
+		m.backtrace(p)
+	case "back", "bs":
+		count := uint64(1)
+
+		if len(args) > 0 {
+			n, err := strconv.ParseUint(args[0], 10, 64)
+			if (err != nil) || (n == 0) {
+				return false, fmt.Errorf("'%s' is not a valid number of instructions", args[0])
+			}
+
+			count = n
+		}
+
+		pc, reason, err := m.d.StepBack(p, count)
+		if err != nil {
+			return false, err
+		}
+
+		m.rewound(p, pc, reason)
+	case "rc":
+		pc, reason, err := m.d.ReverseContinue(p)
+		if err != nil {
+			return false, err
+		}
+
+		m.rewound(p, pc, reason)
+	case "goto":
+		if len(args) != 1 {
+			return false, fmt.Errorf("goto expects a clock cycle count")
+		}
+
+		cycle, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("'%s' is not a valid clock cycle count", args[0])
+		}
+
+		pc, reason, resume, err := m.d.GoTo(p, cycle)
+		if err != nil {
+			return false, err
+		}
+
+		if resume {
+			return true, nil
+		}
+
+		m.rewound(p, pc, reason)
+	case "lastwrite", "lw":
+		return false, m.lastWrite(args)
+	case "quit", "q":
+		m.d.Terminate()
+	default:
+		return false, fmt.Errorf("unknown command '%s', use help to list all commands", cmd)
+	}
+
+	return resumes[cmd], nil
+}
+
+// rewound shows the state after the program has been run backwards
+func (m *monitor) rewound(p cpu.Processor, pc uint32, reason string) {
+	m.pc = pc
+	m.nextDisasm = pc
+
+	fmt.Fprintf(m.out, "Stopped at %s: %s\n", m.d.addressWithLabel(pc), reason)
+	m.printState(p, pc)
+}
+
+func (m *monitor) lastWrite(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("lastwrite expects an address")
+	}
+
+	msg, err := m.d.DescribeLastWrite(args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(m.out, msg)
+
+	return nil
+}
+
+func (m *monitor) addBreakpoint(args []string) error {
+	if len(args) == 0 {
+		for _, j := range m.d.checkpoints {
+			fmt.Fprintln(m.out, j)
+		}
+
+		return nil
+	}
+
+	start, end, err := parseRange(args[0], m.d.labels)
+	if err != nil {
+		return err
+	}
+
+	cond := ""
+	if len(args) > 1 {
+		if (strings.ToLower(args[1]) != "if") || (len(args) < 3) {
+			return fmt.Errorf("expected 'if' followed by a condition")
+		}
+
+		cond = strings.Join(args[2:], " ")
+	}
+
+	id, err := m.d.AddBreakpoint(start, end, cond)
+	if err != nil {
+		return err
+	}
+
+	m.printCheckpoint(id)
+
+	return nil
+}
+
+func (m *monitor) addWatchpoint(args []string) error {
+	access := "rw"
+
+	if len(args) > 1 {
+		access = strings.ToLower(args[0])
+		args = args[1:]
+	}
+
+	if len(args) != 1 {
+		return fmt.Errorf("watch expects an address or an address range")
+	}
+
+	start, end, err := parseRange(args[0], m.d.labels)
+	if err != nil {
+		return err
+	}
+
+	id, err := m.d.AddWatchpoint(access, start, end)
+	if err != nil {
+		return err
+	}
+
+	m.printCheckpoint(id)
+
+	return nil
+}
+
+func (m *monitor) printCheckpoint(id int) {
+	for _, j := range m.d.checkpoints {
+		if j.id == id {
+			fmt.Fprintln(m.out, j)
+		}
+	}
+}
+
+func (m *monitor) dumpMemory(p cpu.Processor, args []string) error {
+	start, end := m.nextDump, m.nextDump+defaultDumpLength-1
+
+	if len(args) > 0 {
+		var err error
+
+		start, end, err = parseRange(args[0], m.d.labels)
+		if err != nil {
+			return err
+		}
+
+		if !strings.Contains(args[0], "-") {
+			end = start + defaultDumpLength - 1
+		}
+	}
+
+	for lineStart := start; lineStart <= end; lineStart += 16 {
+		hex := ""
+		printable := ""
+
+		for addr := lineStart; (addr < lineStart+16) && (addr <= end); addr++ {
+			b, err := ReadByte(p, addr)
+			if err != nil {
+				return err
+			}
+
+			hex += fmt.Sprintf(" %02X", b)
+
+			if (b >= 0x20) && (b < 0x7F) {
+				printable += string(rune(b))
+			} else {
+				printable += "."
+			}
+		}
+
+		fmt.Fprintf(m.out, "%s %-48s  |%s|\n", formatAddress(lineStart), hex, printable)
+	}
+
+	m.nextDump = end + 1
+
+	return nil
+}
+
+func (m *monitor) changeMemory(p cpu.Processor, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("> expects an address followed by at least one byte")
+	}
+
+	start, _, err := parseRange(args[0], m.d.labels)
+	if err != nil {
+		return err
+	}
+
+	data := []uint8{}
+	for _, j := range args[1:] {
+		v, err := parseValue(j)
+		if (err != nil) || (v > 0xFF) {
+			return fmt.Errorf("'%s' is not a valid byte", j)
+		}
+
+		data = append(data, uint8(v))
+	}
+
+	for i, j := range data {
+		if err = WriteByte(p, start+uint32(i), j); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *monitor) disassemble(p cpu.Processor, args []string) error {
+	count := defaultDisasmCount
+
+	if len(args) > 0 {
+		start, _, err := parseRange(args[0], m.d.labels)
+		if err != nil {
+			return err
+		}
+
+		m.nextDisasm = start
+	}
+
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if (err != nil) || (n <= 0) {
+			return fmt.Errorf("'%s' is not a valid number of instructions", args[1])
+		}
+
+		count = n
+	}
+
+	dis := m.d.disassembler(p)
+
+	for i := 0; i < count; i++ {
+		if m.nextDisasm <= 0xFFFF {
+			for _, label := range m.d.labels[uint16(m.nextDisasm)] {
+				fmt.Fprintf(m.out, "%s:\n", label)
+			}
+		}
+
+		instr := dis.DecodeAt(p, m.nextDisasm)
+		if len(instr.Bytes) == 0 {
+			break
+		}
+
+		fmt.Fprintln(m.out, instr.String())
+		m.nextDisasm += uint32(len(instr.Bytes))
+	}
+
+	return nil
+}
+
+func (m *monitor) backtrace(p cpu.Processor) {
+	frames := p.CallStack()
+	if len(frames) == 0 {
+		fmt.Fprintln(m.out, "no active subroutine calls")
+	}
+
+	for i := len(frames) - 1; i >= 0; i-- {
+		fmt.Fprintf(m.out, "#%d  %s called from %s\n", len(frames)-1-i, m.d.addressWithLabel(frames[i].Target), m.d.addressWithLabel(frames[i].CallSite))
+	}
+}
@@ -0,0 +1,194 @@
+package debugger
+
+import (
+	"6502profiler/cpu"
+	"fmt"
+)
+
+const reasonHistoryStart = "start of recorded history"
+
+// The methods in this file run the program backwards by undoing the recorded instructions. They have
+// to be called while the program is stopped. They return the address of the instruction the program
+// has returned to and the reason why it has stopped there. If the program is resumed afterwards the
+// instruction at this address is executed next.
+
+func (d *Debugger) checkHistory() error {
+	if d.history == nil {
+		return fmt.Errorf("the executed instructions are not recorded")
+	}
+
+	return nil
+}
+
+// StepBack undoes the last count instructions
+func (d *Debugger) StepBack(p cpu.Processor, count uint64) (uint32, string, error) {
+	if err := d.checkHistory(); err != nil {
+		return 0, "", err
+	}
+
+	var pc uint32
+	reason := "step back"
+
+	for i := uint64(0); i < count; i++ {
+		e, ok := d.history.undo(p)
+		if !ok {
+			if i == 0 {
+				return 0, "", fmt.Errorf("the %s has been reached", reasonHistoryStart)
+			}
+
+			reason = reasonHistoryStart
+			break
+		}
+
+		d.rewound = true
+		pc = e.pc
+	}
+
+	return pc, reason, nil
+}
+
+// ReverseContinue undoes instructions until a breakpoint at the address of the instruction or a
+// watchpoint for one of its writes is hit. Read accesses are not recorded, i.e. watchpoints for reads
+// are ignored.
+func (d *Debugger) ReverseContinue(p cpu.Processor) (uint32, string, error) {
+	if err := d.checkHistory(); err != nil {
+		return 0, "", err
+	}
+
+	var pc uint32
+
+	for first := true; ; first = false {
+		e, ok := d.history.undo(p)
+		if !ok {
+			if first {
+				return 0, "", fmt.Errorf("the %s has been reached", reasonHistoryStart)
+			}
+
+			return pc, reasonHistoryStart, nil
+		}
+
+		d.rewound = true
+		pc = e.pc
+
+		if reason := d.reverseHit(p, &e); reason != "" {
+			return pc, reason, nil
+		}
+	}
+}
+
+func (d *Debugger) reverseHit(p cpu.Processor, e *undoEntry) string {
+	for _, w := range e.writes {
+		for _, j := range d.checkpoints {
+			if j.matchesAccess(w.key.addr, true) {
+				j.hits++
+				return fmt.Sprintf("watchpoint %d: write to %s by instruction at %s", j.id, d.addressWithLabel(w.key.addr), d.addressWithLabel(e.pc))
+			}
+		}
+	}
+
+	for _, j := range d.checkpoints {
+		if (j.kind != kindExec) || !j.contains(e.pc) {
+			continue
+		}
+
+		if j.cond != nil {
+			if match, err := j.cond.eval(p); (err != nil) || !match {
+				continue
+			}
+		}
+
+		j.hits++
+
+		return fmt.Sprintf("breakpoint %d", j.id)
+	}
+
+	return ""
+}
+
+// GoTo returns to the state at the given clock cycle count. If the cycle count lies within an
+// instruction the state before this instruction is restored. If the state lies in the future resume
+// is returned as true and the program has to be resumed in order to reach it. The program then stops
+// when the cycle count has been reached.
+func (d *Debugger) GoTo(p cpu.Processor, cycle uint64) (pc uint32, reason string, resume bool, err error) {
+	if err = d.checkHistory(); err != nil {
+		return 0, "", false, err
+	}
+
+	if p.NumCycles() < cycle {
+		if !d.Running() {
+			return 0, "", false, fmt.Errorf("the program has ended")
+		}
+
+		d.mode = modeCycle
+		d.targetCycle = cycle
+
+		return 0, "", true, nil
+	}
+
+	pc = d.currentPC
+	if !d.Running() {
+		pc = uint32(p.GetPC())
+	}
+
+	for p.NumCycles() > cycle {
+		e, ok := d.history.undo(p)
+		if !ok {
+			break
+		}
+
+		d.rewound = true
+		pc = e.pc
+	}
+
+	if p.NumCycles() > cycle {
+		if restored, ok := d.history.restoreSnapshot(p, cycle); ok {
+			d.rewound = true
+			pc = restored
+		}
+
+		if p.NumCycles() > cycle {
+			return pc, reasonHistoryStart, false, nil
+		}
+	}
+
+	return pc, fmt.Sprintf("clock cycle %d reached", p.NumCycles()), false, nil
+}
+
+// LastWrite returns the most recent write access to an address before the current state. Writes
+// to the 16 bit address space and to the linear address space are both taken into account.
+func (d *Debugger) LastWrite(addr uint32) (WriteInfo, bool, error) {
+	if err := d.checkHistory(); err != nil {
+		return WriteInfo{}, false, err
+	}
+
+	info, ok := d.history.LastWrite(addr, true)
+
+	if addr <= 0xFFFF {
+		if info16, ok16 := d.history.LastWrite(addr, false); ok16 && (!ok || (info16.Cycle > info.Cycle)) {
+			return info16, true, nil
+		}
+	}
+
+	return info, ok, nil
+}
+
+// DescribeLastWrite returns a message describing the most recent write access to the address given
+// by spec, which may also be a label
+func (d *Debugger) DescribeLastWrite(spec string) (string, error) {
+	addr, _, err := parseRange(spec, d.labels)
+	if err != nil {
+		return "", err
+	}
+
+	info, ok, err := d.LastWrite(addr)
+	if err != nil {
+		return "", err
+	}
+
+	if !ok {
+		return fmt.Sprintf("no write to %s recorded", d.addressWithLabel(addr)), nil
+	}
+
+	return fmt.Sprintf("%s was last written by the instruction at %s in clock cycle %d, previous value $%02X",
+		d.addressWithLabel(addr), d.addressWithLabel(info.PC), info.Cycle, info.Old), nil
+}
@@ -0,0 +1,135 @@
+package debugger
+
+import (
+	"6502profiler/cpu"
+	"6502profiler/memory"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// ldx #0
+// loop txa
+// sta $0900,x
+// inx
+// cpx #4
+// bne loop
+// brk
+var loopProgram = []byte{0xA2, 0x00, 0x8A, 0x9D, 0x00, 0x09, 0xE8, 0xE0, 0x04, 0xD0, 0xF7, 0x00}
+
+func debugLoop(t *testing.T, commands string, historyLength int, interval int) (*cpu.CPU6502, string) {
+	t.Helper()
+
+	p := cpu.New6502(cpu.Model6502)
+	p.Init(memory.NewLinearMemory(65536))
+	p.CopyToMem(loopProgram, 0x0800)
+	p.CopyToMem([]byte{0xFF, 0xFF, 0xFF, 0xFF}, 0x0900)
+
+	out := &bytes.Buffer{}
+	d := New(strings.NewReader(commands), out, map[uint16][]string{0x0802: {"loop"}})
+	d.EnableHistory(historyLength)
+	d.History().interval = interval
+	p.SetTracer(d)
+
+	if err := p.Run(0x0800); err != nil {
+		t.Fatalf("program failed: %v", err)
+	}
+
+	d.PostMortem(p)
+
+	return p, out.String()
+}
+
+func checkLoopResult(t *testing.T, p *cpu.CPU6502, out string) {
+	t.Helper()
+
+	for i := uint16(0); i < 4; i++ {
+		if b := p.Mem.Load(0x0900 + i); b != uint8(i) {
+			t.Fatalf("wrong result at $%04X: $%02X\n%s", 0x0900+i, b, out)
+		}
+	}
+
+	if p.NumCycles() != 57 {
+		t.Fatalf("wrong number of clock cycles: %d\n%s", p.NumCycles(), out)
+	}
+}
+
+func TestStepBack(t *testing.T) {
+	p, out := debugLoop(t, "s 4\nback 2\nr\ns\nback\nback 10\nback\nc\n", DefaultHistoryLength, DefaultSnapshotInterval)
+
+	checkStops(t, out, "$0800: step", "$0807: step", "$0803: step back", "$0806: step",
+		"$0803: step back", "$0800: start of recorded history")
+
+	if !strings.Contains(out, "A=$00 X=$00 Y=$00 SP=$FF PC=$803 Flags=......Z. Cycles=4") {
+		t.Fatalf("registers not restored:\n%s", out)
+	}
+
+	if !strings.Contains(out, "error: the start of recorded history has been reached") {
+		t.Fatalf("undo beyond the start of the history accepted:\n%s", out)
+	}
+
+	checkLoopResult(t, p, out)
+}
+
+func TestReverseContinue(t *testing.T) {
+	p, out := debugLoop(t, "b $080B\nc\nw w $0901\nrc\nm $0901-$0901\nc\ndel 2\nc\nrc\nc\n", DefaultHistoryLength, DefaultSnapshotInterval)
+
+	checkStops(t, out, "$0800: step", "$080B: breakpoint 1", "$0803: watchpoint 2: write to $0901 by instruction at $0803",
+		"$0806: watchpoint 2: write to $0901 by instruction at $0803", "$080B: breakpoint 1", "$0800: start of recorded history",
+		"$080B: breakpoint 1")
+
+	if !strings.Contains(out, "$0901  FF ") {
+		t.Fatalf("write has not been undone:\n%s", out)
+	}
+
+	checkLoopResult(t, p, out)
+}
+
+func TestGoTo(t *testing.T) {
+	p, out := debugLoop(t, "goto 30\ngoto 10\nlw $0900\nlw $0901\nc\ngoto 23\n", DefaultHistoryLength, DefaultSnapshotInterval)
+
+	checkStops(t, out, "$0800: step", "$0802 (loop): clock cycle 30 reached", "$0806: clock cycle 9 reached",
+		"$0806: clock cycle 23 reached")
+
+	for _, j := range []string{
+		"$0900 was last written by the instruction at $0803 in clock cycle 4, previous value $FF",
+		"no write to $0901 recorded",
+	} {
+		if !strings.Contains(out, j) {
+			t.Fatalf("'%s' missing:\n%s", j, out)
+		}
+	}
+
+	if b := p.Mem.Load(0x0902); b != 0xFF {
+		t.Fatalf("post mortem state has not been restored:\n%s", out)
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	// Only the last two instructions are kept in the undo log, older states are restored from
+	// snapshots taken every four instructions
+	p, out := debugLoop(t, "c\ngoto 30\nm $0900-$0903\nback\ngoto 5\n", 2, 4)
+
+	checkStops(t, out, "$0800: step", "$0806: clock cycle 23 reached", "$0800: clock cycle 0 reached")
+
+	if !strings.Contains(out, "$0900  00 01 FF FF ") {
+		t.Fatalf("snapshot has not been restored:\n%s", out)
+	}
+
+	if !strings.Contains(out, "error: the start of recorded history has been reached") {
+		t.Fatalf("undo of a folded instruction accepted:\n%s", out)
+	}
+
+	for i := uint16(0); i < 4; i++ {
+		if b := p.Mem.Load(0x0900 + i); b != 0xFF {
+			t.Fatalf("wrong memory contents at $%04X: $%02X\n%s", 0x0900+i, b, out)
+		}
+	}
+}
+
+func TestResumeFromSnapshot(t *testing.T) {
+	p, out := debugLoop(t, "b $080B\nc\ngoto 30\nc\nc\n", 2, 4)
+
+	checkStops(t, out, "$0800: step", "$080B: breakpoint 1", "$0806: clock cycle 23 reached", "$080B: breakpoint 1")
+	checkLoopResult(t, p, out)
+}
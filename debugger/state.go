@@ -0,0 +1,165 @@
+package debugger
+
+import (
+	"6502profiler/cpu"
+	"6502profiler/memory"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RegisterValue is the value of a register which exists in a CPU
+type RegisterValue struct {
+	Name  string
+	Value uint16
+}
+
+var registers = []struct {
+	name string
+	reg  cpu.Register
+}{
+	{"A", cpu.RegA}, {"C", cpu.RegC}, {"X", cpu.RegX}, {"Y", cpu.RegY}, {"Z", cpu.RegZ}, {"B", cpu.RegB},
+	{"SP", cpu.RegSP}, {"D", cpu.RegD}, {"DBR", cpu.RegDBR}, {"PBR", cpu.RegPBR}, {"FLAGS", cpu.RegFlags},
+	{"E", cpu.RegE},
+}
+
+func registerByName(name string) (cpu.Register, bool) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if name == "P" {
+		name = "FLAGS"
+	}
+
+	for _, j := range registers {
+		if j.name == name {
+			return j.reg, true
+		}
+	}
+
+	return 0, false
+}
+
+// Registers returns the values of all registers which exist in p followed by the PC
+func Registers(p cpu.Processor) []RegisterValue {
+	res := []RegisterValue{}
+
+	for _, j := range registers {
+		if v, ok := p.GetRegister(j.reg); ok {
+			res = append(res, RegisterValue{j.name, v})
+		}
+	}
+
+	return append(res, RegisterValue{"PC", p.GetPC()})
+}
+
+// FormatFlags shows the set flags of p by their names and the cleared ones as dots
+func FormatFlags(p cpu.Processor) string {
+	flags, _ := p.GetRegister(cpu.RegFlags)
+	names := p.FlagNames()
+	res := ""
+
+	for i := 0; i < 8; i++ {
+		if (flags & (0x80 >> i)) != 0 {
+			res += string(names[i])
+		} else {
+			res += "."
+		}
+	}
+
+	return res
+}
+
+// FormatRegisters returns the registers, the flags and the clock cycle count of p in one line
+func FormatRegisters(p cpu.Processor) string {
+	res := []string{}
+
+	for _, j := range Registers(p) {
+		if j.Name != "FLAGS" {
+			res = append(res, fmt.Sprintf("%s=$%02X", j.Name, j.Value))
+		}
+	}
+
+	res = append(res, "Flags="+FormatFlags(p))
+	res = append(res, fmt.Sprintf("Cycles=%d", p.NumCycles()))
+
+	return strings.Join(res, " ")
+}
+
+// SetRegister changes the register or the PC of p. The value can be given in decimal or in hex using
+// $ or 0x as a prefix.
+func SetRegister(p cpu.Processor, name string, valueSpec string) error {
+	value, err := parseValue(valueSpec)
+	if err != nil {
+		return err
+	}
+
+	if strings.ToUpper(name) == "PC" {
+		p.SetPC(value)
+		return nil
+	}
+
+	r, ok := registerByName(name)
+	if !ok || !p.SetRegister(r, value) {
+		return fmt.Errorf("register '%s' does not exist in this CPU", name)
+	}
+
+	return nil
+}
+
+// Evaluate returns the value of a register, the PC, a memory byte ([addr]), a number or a label
+func Evaluate(p cpu.Processor, expr string, labels map[uint16][]string) (uint32, error) {
+	o, err := parseOperand(expr, labels)
+	if err != nil {
+		return 0, err
+	}
+
+	return o.eval(p)
+}
+
+func parseValue(spec string) (uint16, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "$") {
+		spec = "0x" + spec[1:]
+	}
+
+	v, err := strconv.ParseUint(spec, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not a valid value", spec)
+	}
+
+	return uint16(v), nil
+}
+
+// ReadByte reads a byte from the memory of p without side effects. Addresses above $FFFF are read
+// from the linear address space.
+func ReadByte(p cpu.Processor, addr uint32) (b uint8, err error) {
+	// Reading beyond the end of the memory causes a panic
+	defer func() {
+		if res := recover(); res != nil {
+			err = fmt.Errorf("unable to read %s: %v", formatAddress(addr), res)
+		}
+	}()
+
+	if addr > 0xFFFF {
+		return memory.PeekLarge(p.GetMem(), addr), nil
+	}
+
+	return memory.Peek(p.GetMem(), uint16(addr)), nil
+}
+
+// WriteByte writes a byte to the memory of p. Addresses above $FFFF are written to the linear
+// address space.
+func WriteByte(p cpu.Processor, addr uint32, b uint8) (err error) {
+	defer func() {
+		if res := recover(); res != nil {
+			err = fmt.Errorf("unable to write %s: %v", formatAddress(addr), res)
+		}
+	}()
+
+	if addr > 0xFFFF {
+		memory.PokeLarge(p.GetMem(), addr, b)
+	} else {
+		memory.Poke(p.GetMem(), uint16(addr), b)
+	}
+
+	return nil
+}
@@ -0,0 +1,324 @@
+package disasm
+
+import (
+	"6502profiler/cpu"
+	"fmt"
+	"strings"
+)
+
+// MaxInstructionLength is the maximum number of bytes an instruction of a supported CPU can occupy.
+// The longest instructions are the quad instructions of the 45GS02 which use flat addressing.
+const MaxInstructionLength = 5
+
+const unknownMnemonic = "???"
+
+// Instruction is a decoded machine language instruction
+type Instruction struct {
+	// Address of the first byte. Addresses of the 65816 include the program bank.
+	Address  uint32
+	Bytes    []byte
+	Mnemonic string
+	Operand  string
+}
+
+// Text returns the instruction in assembler syntax, e.g. "LDA #$12"
+func (i Instruction) Text() string {
+	if i.Operand == "" {
+		return i.Mnemonic
+	}
+
+	return i.Mnemonic + " " + i.Operand
+}
+
+// String returns the address, the bytes and the text of the instruction
+func (i Instruction) String() string {
+	hexBytes := []string{}
+	for _, j := range i.Bytes {
+		hexBytes = append(hexBytes, fmt.Sprintf("%02X", j))
+	}
+
+	return fmt.Sprintf("%s  %-14s  %s", FormatAddress(i.Address), strings.Join(hexBytes, " "), i.Text())
+}
+
+// IsValid returns false if the bytes do not form an instruction of the CPU
+func (i Instruction) IsValid() bool {
+	return i.Mnemonic != unknownMnemonic
+}
+
+// FormatAddress formats an address as it is shown in a disassembly. Addresses of the 65816 which
+// are not in bank 0 are shown with their bank.
+func FormatAddress(addr uint32) string {
+	if addr > 0xFFFF {
+		return fmt.Sprintf("%02X:%04X", addr>>16, addr&0xFFFF)
+	}
+
+	return fmt.Sprintf("%04X", addr)
+}
+
+// Disassembler turns the machine code of a CPU model into assembler source
+type Disassembler struct {
+	model  cpu.CpuModel
+	table  *opcodeTable
+	labels map[uint16]string
+	// Width of the accumulator and the index registers of the 65816
+	accu16  bool
+	index16 bool
+}
+
+// New returns a disassembler for the given CPU model
+func New(model cpu.CpuModel) *Disassembler {
+	return &Disassembler{
+		model:  model,
+		table:  newOpcodeTable(model),
+		labels: map[uint16]string{},
+	}
+}
+
+// SetLabels sets the labels which replace the addresses used in operands. Labels are expected in
+// the form returned by Assembler.ParseLabelFile. If several labels refer to the same address the
+// first one is used.
+func (d *Disassembler) SetLabels(labels map[uint16][]string) {
+	d.labels = map[uint16]string{}
+
+	for addr, names := range labels {
+		if len(names) > 0 {
+			d.labels[addr] = names[0]
+		}
+	}
+}
+
+// SetRegisterWidths determines the width of the immediate operands of 65816 instructions. By
+// default 8 bit registers, i.e. emulation mode, are assumed.
+func (d *Disassembler) SetRegisterWidths(accu16 bool, index16 bool) {
+	d.accu16 = accu16
+	d.index16 = index16
+}
+
+func (d *Disassembler) operandLength(mode addrMode) int {
+	switch mode {
+	case modeImplied, modeAccumulator:
+		return 0
+	case modeImmediateM:
+		if d.accu16 {
+			return 2
+		}
+
+		return 1
+	case modeImmediateX:
+		if d.index16 {
+			return 2
+		}
+
+		return 1
+	case modeImmediateWord, modeAbsolute, modeAbsoluteX, modeAbsoluteY, modeIndirect, modeAbsIndirectX,
+		modeAbsIndirectLong, modeRelativeLong, modeRelativeWord, modeZeroPageRelative, modeBlockMove:
+		return 2
+	case modeLong, modeLongX, modeAug:
+		return 3
+	}
+
+	return 1
+}
+
+// address returns the label for addr or addr as a hex number with the given number of digits
+func (d *Disassembler) address(addr uint32, digits int) string {
+	if addr <= 0xFFFF {
+		if label, ok := d.labels[uint16(addr)]; ok {
+			return label
+		}
+	} else {
+		digits = 6
+	}
+
+	return fmt.Sprintf("$%0*X", digits, addr)
+}
+
+// branchTarget adds a relative offset to base. Branches do not leave the current program bank.
+func branchTarget(instrAddr uint32, base uint32, offset int32) uint32 {
+	return (instrAddr & 0xFF0000) | (uint32(int32(base)+offset) & 0xFFFF)
+}
+
+func (d *Disassembler) formatOperand(mode addrMode, ops []byte, addr uint32, next uint32, quad bool, flat bool) string {
+	var b8 uint32
+	var w16 uint32
+	var l24 uint32
+
+	if len(ops) > 0 {
+		b8 = uint32(ops[0])
+	}
+
+	if len(ops) > 1 {
+		w16 = b8 | (uint32(ops[1]) << 8)
+	}
+
+	if len(ops) > 2 {
+		l24 = w16 | (uint32(ops[2]) << 16)
+	}
+
+	switch mode {
+	case modeImplied, modeAccumulator, modeAug:
+		return ""
+	case modeImmediate:
+		return fmt.Sprintf("#$%02X", b8)
+	case modeImmediateM, modeImmediateX, modeImmediateWord:
+		if len(ops) == 2 {
+			return fmt.Sprintf("#$%04X", w16)
+		}
+
+		return fmt.Sprintf("#$%02X", b8)
+	case modeZeroPage:
+		return d.address(b8, 2)
+	case modeZeroPageX:
+		return d.address(b8, 2) + ",X"
+	case modeZeroPageY:
+		return d.address(b8, 2) + ",Y"
+	case modeAbsolute:
+		return d.address(w16, 4)
+	case modeAbsoluteX:
+		return d.address(w16, 4) + ",X"
+	case modeAbsoluteY:
+		return d.address(w16, 4) + ",Y"
+	case modeIndirect:
+		return "(" + d.address(w16, 4) + ")"
+	case modeIndirectX:
+		return "(" + d.address(b8, 2) + ",X)"
+	case modeIndirectY:
+		return "(" + d.address(b8, 2) + "),Y"
+	case modeIndirectZ:
+		// Quad instructions do not use Z as an index because it is part of Q
+		res := "(" + d.address(b8, 2) + ")"
+		if flat {
+			res = "[" + d.address(b8, 2) + "]"
+		}
+
+		if !quad {
+			res += ",Z"
+		}
+
+		return res
+	case modeIndirectZeroPage:
+		return "(" + d.address(b8, 2) + ")"
+	case modeAbsIndirectX:
+		return "(" + d.address(w16, 4) + ",X)"
+	case modeAbsIndirectLong:
+		return "[" + d.address(w16, 4) + "]"
+	case modeRelative:
+		return d.address(branchTarget(addr, next, int32(int8(b8))), 4)
+	case modeRelativeLong:
+		return d.address(branchTarget(addr, next, int32(int16(w16))), 4)
+	case modeRelativeWord:
+		return d.address(branchTarget(addr, next-1, int32(int16(w16))), 4)
+	case modeZeroPageRelative:
+		return d.address(b8, 2) + "," + d.address(branchTarget(addr, next, int32(int8(ops[1]))), 4)
+	case modeLong:
+		return d.address(l24, 6)
+	case modeLongX:
+		return d.address(l24, 6) + ",X"
+	case modeIndirectLong:
+		return "[" + d.address(b8, 2) + "]"
+	case modeIndirectLongY:
+		return "[" + d.address(b8, 2) + "],Y"
+	case modeStackRelative:
+		return fmt.Sprintf("$%02X,S", b8)
+	case modeStackIndirectY:
+		return fmt.Sprintf("($%02X,S),Y", b8)
+	case modeStackPtrIndirectY:
+		return fmt.Sprintf("($%02X,SP),Y", b8)
+	case modeBlockMove:
+		// The destination bank is stored first
+		return fmt.Sprintf("$%02X,$%02X", ops[1], ops[0])
+	}
+
+	return ""
+}
+
+// prefixes determines the prefixes of 45GS02 instructions. It returns the position of the opcode.
+func (d *Disassembler) prefixes(code []byte) (pos int, quad bool, flat bool) {
+	if d.model != cpu.Model45GS02 {
+		return 0, false, false
+	}
+
+	isFlat := func(i int) bool {
+		return (len(code) > i+1) && (code[i] == 0xEA) && ((code[i+1] & 0x1F) == 0x12)
+	}
+
+	if (len(code) > 2) && (code[0] == 0x42) && (code[1] == 0x42) {
+		pos = 2
+		if isFlat(2) {
+			pos = 3
+			flat = true
+		}
+
+		if _, ok := quadMnemonics[d.table[code[pos]].mnemonic]; ok {
+			return pos, true, flat
+		}
+
+		return 0, false, false
+	}
+
+	if isFlat(0) {
+		return 1, false, true
+	}
+
+	return 0, false, false
+}
+
+// Decode decodes the instruction at the start of code. addr is the address of the first byte. If the
+// bytes do not form a valid instruction the mnemonic is "???" and the instruction consists of one
+// byte or of all bytes if code is shorter than the instruction.
+func (d *Disassembler) Decode(code []byte, addr uint32) Instruction {
+	if len(code) == 0 {
+		return Instruction{Address: addr, Bytes: []byte{}, Mnemonic: unknownMnemonic}
+	}
+
+	pos, quad, flat := d.prefixes(code)
+	op := d.table[code[pos]]
+
+	if op.mnemonic == "" {
+		return Instruction{Address: addr, Bytes: code[:1], Mnemonic: unknownMnemonic}
+	}
+
+	length := pos + 1 + d.operandLength(op.mode)
+	if length > len(code) {
+		return Instruction{Address: addr, Bytes: code, Mnemonic: unknownMnemonic}
+	}
+
+	mnemonic := op.mnemonic
+	if quad {
+		mnemonic = quadMnemonics[mnemonic]
+	}
+
+	next := addr + uint32(length)
+
+	return Instruction{
+		Address:  addr,
+		Bytes:    code[:length],
+		Mnemonic: mnemonic,
+		Operand:  d.formatOperand(op.mode, code[pos+1:length], addr, next, quad, flat),
+	}
+}
+
+// Range disassembles code from beginning to end. start is the address of the first byte. For the
+// 65816 REP and SEP change the assumed width of the registers for the following instructions.
+func (d *Disassembler) Range(code []byte, start uint32) []Instruction {
+	res := []Instruction{}
+
+	for pos := 0; pos < len(code); {
+		instr := d.Decode(code[pos:], start+uint32(pos))
+		res = append(res, instr)
+		pos += len(instr.Bytes)
+
+		if (d.model == cpu.Model65816) && (len(instr.Bytes) == 2) {
+			switch instr.Bytes[0] {
+			case 0xC2:
+				d.accu16 = d.accu16 || ((instr.Bytes[1] & 0x20) != 0)
+				d.index16 = d.index16 || ((instr.Bytes[1] & 0x10) != 0)
+			case 0xE2:
+				d.accu16 = d.accu16 && ((instr.Bytes[1] & 0x20) == 0)
+				d.index16 = d.index16 && ((instr.Bytes[1] & 0x10) == 0)
+			}
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,169 @@
+package disasm
+
+import (
+	"6502profiler/cpu"
+	"6502profiler/memory"
+	"strings"
+	"testing"
+)
+
+func checkDecode(t *testing.T, d *Disassembler, code []byte, addr uint32, expected string, length int) {
+	t.Helper()
+
+	instr := d.Decode(code, addr)
+	if (instr.Text() != expected) || (len(instr.Bytes) != length) {
+		t.Fatalf("%X: expected '%s' (%d bytes), got '%s' (%d bytes)", code, expected, length, instr.Text(), len(instr.Bytes))
+	}
+}
+
+func TestDecode6502(t *testing.T) {
+	d := New(cpu.Model6502)
+
+	checkDecode(t, d, []byte{0xA9, 0x12}, 0x0800, "LDA #$12", 2)
+	checkDecode(t, d, []byte{0xBD, 0x34, 0x12}, 0x0800, "LDA $1234,X", 3)
+	checkDecode(t, d, []byte{0xB1, 0xFB}, 0x0800, "LDA ($FB),Y", 2)
+	checkDecode(t, d, []byte{0x81, 0x10}, 0x0800, "STA ($10,X)", 2)
+	checkDecode(t, d, []byte{0xB6, 0x10}, 0x0800, "LDX $10,Y", 2)
+	checkDecode(t, d, []byte{0x6C, 0xFC, 0xFF}, 0x0800, "JMP ($FFFC)", 3)
+	checkDecode(t, d, []byte{0x0A}, 0x0800, "ASL", 1)
+	checkDecode(t, d, []byte{0xD0, 0xFE}, 0x0800, "BNE $0800", 2)
+	checkDecode(t, d, []byte{0x10, 0x10}, 0x0800, "BPL $0812", 2)
+}
+
+func TestUnknownAndTruncatedInstructions(t *testing.T) {
+	d := New(cpu.Model6502)
+
+	checkDecode(t, d, []byte{0x03, 0x10}, 0x0800, "???", 1)
+	checkDecode(t, d, []byte{0xAD, 0x10}, 0x0800, "???", 2)
+
+	if d.Decode([]byte{0x02}, 0x0800).IsValid() {
+		t.Fatal("JAM opcode regarded as valid")
+	}
+}
+
+func TestDecodeModels(t *testing.T) {
+	checkDecode(t, New(cpu.Model6510), []byte{0xA7, 0x10}, 0x0800, "LAX $10", 2)
+	checkDecode(t, New(cpu.Model6510), []byte{0x1B, 0x00, 0x20}, 0x0800, "SLO $2000,Y", 3)
+	checkDecode(t, New(cpu.Model6510), []byte{0x80, 0x00}, 0x0800, "NOP #$00", 2)
+	checkDecode(t, New(cpu.Model2A03), []byte{0xA7, 0x10}, 0x0800, "LAX $10", 2)
+	checkDecode(t, New(cpu.Model65C02), []byte{0xB2, 0x10}, 0x0800, "LDA ($10)", 2)
+	checkDecode(t, New(cpu.Model65C02), []byte{0x7C, 0x00, 0x20}, 0x0800, "JMP ($2000,X)", 3)
+	checkDecode(t, New(cpu.Model65C02), []byte{0x8F, 0x10, 0x02}, 0x0800, "BBS0 $10,$0805", 3)
+	checkDecode(t, New(cpu.Model65SC02), []byte{0x8F, 0x10, 0x02}, 0x0800, "???", 1)
+	checkDecode(t, New(cpu.Model65C02), []byte{0xDB}, 0x0800, "???", 1)
+	checkDecode(t, New(cpu.ModelW65C02S), []byte{0xDB}, 0x0800, "STP", 1)
+}
+
+func TestDecode65816(t *testing.T) {
+	d := New(cpu.Model65816)
+
+	checkDecode(t, d, []byte{0xA9, 0x34, 0x12}, 0x0800, "LDA #$34", 2)
+	checkDecode(t, d, []byte{0x22, 0x56, 0x34, 0x12}, 0x0800, "JSL $123456", 4)
+	checkDecode(t, d, []byte{0xB7, 0x10}, 0x0800, "LDA [$10],Y", 2)
+	checkDecode(t, d, []byte{0x13, 0x03}, 0x0800, "ORA ($03,S),Y", 2)
+	checkDecode(t, d, []byte{0x54, 0x01, 0x02}, 0x0800, "MVN $02,$01", 3)
+	checkDecode(t, d, []byte{0x82, 0xFD, 0xFF}, 0x020800, "BRL $020800", 3)
+
+	// rep #$30
+	// lda #$1234
+	// ldx #$5678
+	// sep #$20
+	// lda #$12
+	instrs := d.Range([]byte{0xC2, 0x30, 0xA9, 0x34, 0x12, 0xA2, 0x78, 0x56, 0xE2, 0x20, 0xA9, 0x12}, 0x0800)
+	if (len(instrs) != 5) || (instrs[1].Text() != "LDA #$1234") || (instrs[2].Text() != "LDX #$5678") || (instrs[4].Text() != "LDA #$12") {
+		t.Fatalf("register width not tracked: %v", instrs)
+	}
+}
+
+func TestDecode65CE02(t *testing.T) {
+	d := New(cpu.Model65CE02)
+
+	checkDecode(t, d, []byte{0xB2, 0x10}, 0x0800, "LDA ($10),Z", 2)
+	checkDecode(t, d, []byte{0xB3, 0x00, 0x01}, 0x0800, "BCS $0902", 3)
+	checkDecode(t, d, []byte{0xF4, 0x34, 0x12}, 0x0800, "PHW #$1234", 3)
+	checkDecode(t, d, []byte{0xE2, 0x03}, 0x0800, "LDA ($03,SP),Y", 2)
+	checkDecode(t, d, []byte{0x5C, 0x00, 0x00, 0x00}, 0x0800, "AUG", 4)
+	checkDecode(t, d, []byte{0x42, 0x42, 0xAD, 0x00, 0x10}, 0x0800, "NEG", 1)
+}
+
+func TestDecode45GS02Prefixes(t *testing.T) {
+	d := New(cpu.Model45GS02)
+
+	checkDecode(t, d, []byte{0x42, 0x42, 0xAD, 0x00, 0x10}, 0x0800, "LDQ $1000", 5)
+	checkDecode(t, d, []byte{0xEA, 0xB2, 0x10}, 0x0800, "LDA [$10],Z", 3)
+	checkDecode(t, d, []byte{0x42, 0x42, 0xEA, 0x92, 0x10}, 0x0800, "STQ [$10]", 5)
+	checkDecode(t, d, []byte{0x42, 0x42, 0xB2, 0x10}, 0x0800, "LDQ ($10)", 4)
+	checkDecode(t, d, []byte{0x42, 0x42, 0xE8}, 0x0800, "NEG", 1)
+	checkDecode(t, d, []byte{0xEA, 0xE8}, 0x0800, "EOM", 1)
+	checkDecode(t, d, []byte{0x5C}, 0x0800, "MAP", 1)
+}
+
+func TestLabels(t *testing.T) {
+	d := New(cpu.Model6502)
+	d.SetLabels(map[uint16][]string{0x0800: {"loop", "start"}, 0x00FB: {"ptr"}, 0xFFD2: {"CHROUT"}})
+
+	checkDecode(t, d, []byte{0x20, 0xD2, 0xFF}, 0x0800, "JSR CHROUT", 3)
+	checkDecode(t, d, []byte{0xB1, 0xFB}, 0x0800, "LDA (ptr),Y", 2)
+	checkDecode(t, d, []byte{0xD0, 0xFC}, 0x0802, "BNE loop", 2)
+	checkDecode(t, d, []byte{0xA9, 0xFB}, 0x0800, "LDA #$FB", 2)
+}
+
+func TestInstructionString(t *testing.T) {
+	instr := New(cpu.Model6502).Decode([]byte{0x8D, 0x20, 0xD0}, 0xC000)
+
+	if instr.String() != "C000  8D 20 D0        STA $D020" {
+		t.Fatalf("wrong format: '%s'", instr.String())
+	}
+}
+
+func TestAnnotateLimitError(t *testing.T) {
+	// loop inx
+	//      jmp loop
+	p := cpu.New6502(cpu.Model6502)
+	p.Init(memory.NewLinearMemory(65536))
+	p.CopyToMem([]byte{0xE8, 0x4C, 0x00, 0x08}, 0x0800)
+	p.SetExecutionLimits(cpu.ExecutionLimits{MaxInstructions: 10})
+
+	err := AnnotateError(p, p.Run(0x0800))
+	if err == nil {
+		t.Fatal("program was not stopped")
+	}
+
+	if !strings.Contains(err.Error(), "0801  4C 00 08        JMP $0800") {
+		t.Fatalf("instructions not disassembled: %v", err)
+	}
+}
+
+func TestDecodeAtWithoutStatistics(t *testing.T) {
+	mem := memory.NewLinearMemory(65536)
+	p := cpu.New6502(cpu.Model6502)
+	p.Init(mem)
+	p.CopyToMem([]byte{0x8D, 0x20, 0x07}, 0x0800)
+	mem.ClearStatistics()
+
+	if res := New(cpu.Model6502).DecodeAt(p, 0x0800).Text(); res != "STA $0720" {
+		t.Fatalf("unexpected instruction '%s'", res)
+	}
+
+	for addr := uint16(0x0800); addr < 0x0800+MaxInstructionLength; addr++ {
+		if mem.GetStatistics(addr) != 0 {
+			t.Fatalf("disassembly has been counted as an access to $%04X", addr)
+		}
+	}
+}
+
+func TestOpcodes(t *testing.T) {
+	opcodes := Opcodes(cpu.Model65C02)
+
+	if opcodes["LDA"]["izp"] != 0xB2 {
+		t.Fatalf("wrong opcode for LDA (zp): $%02X", opcodes["LDA"]["izp"])
+	}
+
+	if _, ok := Opcodes(cpu.Model6502)["STZ"]; ok {
+		t.Fatal("STZ is not an instruction of the 6502")
+	}
+
+	if Opcodes(cpu.Model6510)["SBC"]["imm"] != 0xE9 {
+		t.Fatal("documented opcode not preferred")
+	}
+}
@@ -0,0 +1,306 @@
+package disasm
+
+import (
+	"6502profiler/cpu"
+	"fmt"
+	"strings"
+)
+
+type addrMode uint8
+
+const (
+	modeImplied addrMode = iota
+	modeAccumulator
+	modeImmediate
+	// Immediate operand whose width depends on the M flag of the 65816
+	modeImmediateM
+	// Immediate operand whose width depends on the X flag of the 65816
+	modeImmediateX
+	modeImmediateWord
+	modeZeroPage
+	modeZeroPageX
+	modeZeroPageY
+	modeAbsolute
+	modeAbsoluteX
+	modeAbsoluteY
+	modeIndirect
+	modeIndirectX
+	modeIndirectY
+	modeIndirectZ
+	modeIndirectZeroPage
+	modeAbsIndirectX
+	modeAbsIndirectLong
+	modeRelative
+	// 16 bit offset relative to the next instruction (65816)
+	modeRelativeLong
+	// 16 bit offset relative to the last byte of the instruction (65CE02)
+	modeRelativeWord
+	modeZeroPageRelative
+	modeLong
+	modeLongX
+	modeIndirectLong
+	modeIndirectLongY
+	modeStackRelative
+	modeStackIndirectY
+	modeStackPtrIndirectY
+	modeBlockMove
+	// AUG of the 65CE02 is a four byte NOP
+	modeAug
+)
+
+var modeNames = map[string]addrMode{
+	"imp":   modeImplied,
+	"acc":   modeAccumulator,
+	"imm":   modeImmediate,
+	"imm_m": modeImmediateM,
+	"imm_x": modeImmediateX,
+	"imw":   modeImmediateWord,
+	"zp":    modeZeroPage,
+	"zpx":   modeZeroPageX,
+	"zpy":   modeZeroPageY,
+	"abs":   modeAbsolute,
+	"abx":   modeAbsoluteX,
+	"aby":   modeAbsoluteY,
+	"ind":   modeIndirect,
+	"izx":   modeIndirectX,
+	"izy":   modeIndirectY,
+	"izz":   modeIndirectZ,
+	"izp":   modeIndirectZeroPage,
+	"iax":   modeAbsIndirectX,
+	"ial":   modeAbsIndirectLong,
+	"rel":   modeRelative,
+	"rll":   modeRelativeLong,
+	"rlw":   modeRelativeWord,
+	"zpr":   modeZeroPageRelative,
+	"abl":   modeLong,
+	"alx":   modeLongX,
+	"ilz":   modeIndirectLong,
+	"ily":   modeIndirectLongY,
+	"sr":    modeStackRelative,
+	"isy":   modeStackIndirectY,
+	"isp":   modeStackPtrIndirectY,
+	"bm":    modeBlockMove,
+	"aug":   modeAug,
+}
+
+type opcode struct {
+	mnemonic string
+	mode     addrMode
+}
+
+type opcodeTable [256]opcode
+
+// The documented instructions of the NMOS 6502. Empty entries are undefined opcodes.
+var nmosOpcodes = [256]string{
+	"BRK imp", "ORA izx", "", "", "", "ORA zp", "ASL zp", "", "PHP imp", "ORA imm", "ASL acc", "", "", "ORA abs", "ASL abs", "",
+	"BPL rel", "ORA izy", "", "", "", "ORA zpx", "ASL zpx", "", "CLC imp", "ORA aby", "", "", "", "ORA abx", "ASL abx", "",
+	"JSR abs", "AND izx", "", "", "BIT zp", "AND zp", "ROL zp", "", "PLP imp", "AND imm", "ROL acc", "", "BIT abs", "AND abs", "ROL abs", "",
+	"BMI rel", "AND izy", "", "", "", "AND zpx", "ROL zpx", "", "SEC imp", "AND aby", "", "", "", "AND abx", "ROL abx", "",
+	"RTI imp", "EOR izx", "", "", "", "EOR zp", "LSR zp", "", "PHA imp", "EOR imm", "LSR acc", "", "JMP abs", "EOR abs", "LSR abs", "",
+	"BVC rel", "EOR izy", "", "", "", "EOR zpx", "LSR zpx", "", "CLI imp", "EOR aby", "", "", "", "EOR abx", "LSR abx", "",
+	"RTS imp", "ADC izx", "", "", "", "ADC zp", "ROR zp", "", "PLA imp", "ADC imm", "ROR acc", "", "JMP ind", "ADC abs", "ROR abs", "",
+	"BVS rel", "ADC izy", "", "", "", "ADC zpx", "ROR zpx", "", "SEI imp", "ADC aby", "", "", "", "ADC abx", "ROR abx", "",
+	"", "STA izx", "", "", "STY zp", "STA zp", "STX zp", "", "DEY imp", "", "TXA imp", "", "STY abs", "STA abs", "STX abs", "",
+	"BCC rel", "STA izy", "", "", "STY zpx", "STA zpx", "STX zpy", "", "TYA imp", "STA aby", "TXS imp", "", "", "STA abx", "", "",
+	"LDY imm", "LDA izx", "LDX imm", "", "LDY zp", "LDA zp", "LDX zp", "", "TAY imp", "LDA imm", "TAX imp", "", "LDY abs", "LDA abs", "LDX abs", "",
+	"BCS rel", "LDA izy", "", "", "LDY zpx", "LDA zpx", "LDX zpy", "", "CLV imp", "LDA aby", "TSX imp", "", "LDY abx", "LDA abx", "LDX aby", "",
+	"CPY imm", "CMP izx", "", "", "CPY zp", "CMP zp", "DEC zp", "", "INY imp", "CMP imm", "DEX imp", "", "CPY abs", "CMP abs", "DEC abs", "",
+	"BNE rel", "CMP izy", "", "", "", "CMP zpx", "DEC zpx", "", "CLD imp", "CMP aby", "", "", "", "CMP abx", "DEC abx", "",
+	"CPX imm", "SBC izx", "", "", "CPX zp", "SBC zp", "INC zp", "", "INX imp", "SBC imm", "NOP imp", "", "CPX abs", "SBC abs", "INC abs", "",
+	"BEQ rel", "SBC izy", "", "", "", "SBC zpx", "INC zpx", "", "SED imp", "SBC aby", "", "", "", "SBC abx", "INC abx", "",
+}
+
+// The stable undocumented instructions of the 6510
+var undocumentedOpcodes = map[byte]string{
+	0x87: "SAX zp", 0x97: "SAX zpy", 0x8F: "SAX abs", 0x83: "SAX izx",
+	0xA7: "LAX zp", 0xB7: "LAX zpy", 0xAF: "LAX abs", 0xBF: "LAX aby", 0xA3: "LAX izx", 0xB3: "LAX izy",
+	0xBB: "LAS aby", 0x0B: "ANC imm", 0x2B: "ANC imm", 0x4B: "ALR imm", 0x6B: "ARR imm", 0xCB: "SBX imm", 0xEB: "SBC imm",
+	0x0C: "NOP abs",
+}
+
+// Read-modify-write instructions of the 6510 which combine a shift or an increment with an ALU operation
+var undocumentedRmwOpcodes = map[byte]string{
+	0x03: "SLO", 0x23: "RLA", 0x43: "SRE", 0x63: "RRA", 0xC3: "DCP", 0xE3: "ISC",
+}
+
+// The instructions and addressing modes added by the 65C02
+var cmosOpcodes = map[byte]string{
+	0x80: "BRA rel", 0x64: "STZ zp", 0x74: "STZ zpx", 0x9C: "STZ abs", 0x9E: "STZ abx",
+	0xDA: "PHX imp", 0xFA: "PLX imp", 0x5A: "PHY imp", 0x7A: "PLY imp",
+	0x14: "TRB zp", 0x1C: "TRB abs", 0x04: "TSB zp", 0x0C: "TSB abs",
+	0x7C: "JMP iax", 0x1A: "INC acc", 0x3A: "DEC acc",
+	0x89: "BIT imm", 0x34: "BIT zpx", 0x3C: "BIT abx",
+	0x12: "ORA izp", 0x32: "AND izp", 0x52: "EOR izp", 0x72: "ADC izp",
+	0x92: "STA izp", 0xB2: "LDA izp", 0xD2: "CMP izp", 0xF2: "SBC izp",
+}
+
+// The instructions added by the 65816
+var opcodes65816 = map[byte]string{
+	0x09: "ORA imm_m", 0x29: "AND imm_m", 0x49: "EOR imm_m", 0x69: "ADC imm_m", 0x89: "BIT imm_m", 0xA9: "LDA imm_m", 0xC9: "CMP imm_m", 0xE9: "SBC imm_m",
+	0xA0: "LDY imm_x", 0xA2: "LDX imm_x", 0xC0: "CPY imm_x", 0xE0: "CPX imm_x",
+	0x02: "COP imm", 0x0B: "PHD imp", 0x1B: "TCS imp", 0x2B: "PLD imp", 0x3B: "TSC imp", 0x4B: "PHK imp", 0x5B: "TCD imp",
+	0x6B: "RTL imp", 0x7B: "TDC imp", 0x8B: "PHB imp", 0x9B: "TXY imp", 0xAB: "PLB imp", 0xBB: "TYX imp", 0xCB: "WAI imp",
+	0xDB: "STP imp", 0xEB: "XBA imp", 0xFB: "XCE imp",
+	0x22: "JSL abl", 0x42: "WDM imm", 0x44: "MVP bm", 0x54: "MVN bm", 0x5C: "JML abl", 0x62: "PER rll", 0x82: "BRL rll",
+	0xC2: "REP imm", 0xE2: "SEP imm", 0xD4: "PEI izp", 0xF4: "PEA abs", 0xDC: "JML ial", 0xFC: "JSR iax",
+}
+
+// The instructions and addressing modes added by the 65CE02
+var opcodes65CE02 = map[byte]string{
+	0x02: "CLE imp", 0x03: "SEE imp", 0x0B: "TSY imp", 0x1B: "INZ imp", 0x22: "JSR ind", 0x23: "JSR iax", 0x2B: "TYS imp",
+	0x3B: "DEZ imp", 0x42: "NEG imp", 0x43: "ASR acc", 0x44: "ASR zp", 0x4B: "TAZ imp", 0x54: "ASR zpx", 0x5B: "TAB imp",
+	0x5C: "AUG aug", 0x62: "RTS imm", 0x63: "BSR rlw", 0x6B: "TZA imp", 0x7B: "TBA imp", 0x82: "STA isp", 0x83: "BRA rlw",
+	0x8B: "STY abx", 0x9B: "STX aby", 0xA3: "LDZ imm", 0xAB: "LDZ abs", 0xBB: "LDZ abx", 0xC2: "CPZ imm", 0xC3: "DEW zp",
+	0xCB: "ASW abs", 0xD4: "CPZ zp", 0xDB: "PHZ imp", 0xDC: "CPZ abs", 0xE2: "LDA isp", 0xE3: "INW zp", 0xEB: "ROW abs",
+	0xF4: "PHW imw", 0xFB: "PLZ imp", 0xFC: "PHW abs",
+}
+
+// The mnemonics of the quad instructions of the 45GS02 which are created by the prefix NEG NEG
+var quadMnemonics = map[string]string{
+	"LDA": "LDQ", "STA": "STQ", "ADC": "ADCQ", "SBC": "SBCQ", "AND": "ANDQ", "ORA": "ORQ", "EOR": "EORQ", "CMP": "CPQ",
+	"BIT": "BITQ", "ASL": "ASLQ", "LSR": "LSRQ", "ROL": "ROLQ", "ROR": "RORQ", "ASR": "ASRQ", "INC": "INQ", "DEC": "DEQ",
+}
+
+func parseOpcode(entry string) opcode {
+	if entry == "" {
+		return opcode{}
+	}
+
+	parts := strings.Fields(entry)
+	mode, ok := modeNames[parts[1]]
+	if !ok {
+		panic(fmt.Sprintf("unknown addressing mode in opcode table: %s", entry))
+	}
+
+	return opcode{mnemonic: parts[0], mode: mode}
+}
+
+func (t *opcodeTable) apply(entries map[byte]string) {
+	for i, j := range entries {
+		t[i] = parseOpcode(j)
+	}
+}
+
+// newOpcodeTable creates the opcode table of the given model in the same way as the CPU cores
+// build their tables, i.e. by adding the extensions of each CPU to the instructions of the NMOS 6502
+func newOpcodeTable(m cpu.CpuModel) *opcodeTable {
+	res := &opcodeTable{}
+
+	for i, j := range nmosOpcodes {
+		res[i] = parseOpcode(j)
+	}
+
+	if m.HasUndocumentedInstructions() {
+		res.apply(undocumentedOpcodes)
+
+		for base, name := range undocumentedRmwOpcodes {
+			res.apply(map[byte]string{
+				base:        name + " izx",
+				base + 0x04: name + " zp",
+				base + 0x0C: name + " abs",
+				base + 0x10: name + " izy",
+				base + 0x14: name + " zpx",
+				base + 0x18: name + " aby",
+				base + 0x1C: name + " abx",
+			})
+		}
+
+		for _, j := range []byte{0x1A, 0x3A, 0x5A, 0x7A, 0xDA, 0xFA} {
+			res[j] = opcode{"NOP", modeImplied}
+		}
+
+		for _, j := range []byte{0x80, 0x82, 0x89, 0xC2, 0xE2} {
+			res[j] = opcode{"NOP", modeImmediate}
+		}
+
+		for _, j := range []byte{0x04, 0x44, 0x64} {
+			res[j] = opcode{"NOP", modeZeroPage}
+		}
+
+		for _, j := range []byte{0x14, 0x34, 0x54, 0x74, 0xD4, 0xF4} {
+			res[j] = opcode{"NOP", modeZeroPageX}
+		}
+
+		for _, j := range []byte{0x1C, 0x3C, 0x5C, 0x7C, 0xDC, 0xFC} {
+			res[j] = opcode{"NOP", modeAbsoluteX}
+		}
+	}
+
+	if m.IsCmos() || (m == cpu.Model65816) || (m == cpu.Model65CE02) || (m == cpu.Model45GS02) {
+		res.apply(cmosOpcodes)
+	}
+
+	if m.HasBitInstructions() || (m == cpu.Model65CE02) || (m == cpu.Model45GS02) {
+		var bit byte
+		for bit = 0; bit < 8; bit++ {
+			res[0x07+(bit<<4)] = opcode{fmt.Sprintf("RMB%d", bit), modeZeroPage}
+			res[0x87+(bit<<4)] = opcode{fmt.Sprintf("SMB%d", bit), modeZeroPage}
+			res[0x0F+(bit<<4)] = opcode{fmt.Sprintf("BBR%d", bit), modeZeroPageRelative}
+			res[0x8F+(bit<<4)] = opcode{fmt.Sprintf("BBS%d", bit), modeZeroPageRelative}
+		}
+	}
+
+	switch m {
+	case cpu.ModelW65C02S:
+		res.apply(map[byte]string{0xDB: "STP imp", 0xCB: "WAI imp"})
+	case cpu.Model65816:
+		res.apply(opcodes65816)
+
+		for _, base := range []byte{0x01, 0x21, 0x41, 0x61, 0x81, 0xA1, 0xC1, 0xE1} {
+			name := res[base].mnemonic
+			res.apply(map[byte]string{
+				base + 0x02: name + " sr",
+				base + 0x06: name + " ilz",
+				base + 0x0E: name + " abl",
+				base + 0x12: name + " isy",
+				base + 0x16: name + " ily",
+				base + 0x1E: name + " alx",
+			})
+		}
+	case cpu.Model65CE02, cpu.Model45GS02:
+		res.apply(opcodes65CE02)
+
+		// (bp) has become (bp),z and all conditional branches have a 16 bit variant
+		for _, j := range []byte{0x12, 0x32, 0x52, 0x72, 0x92, 0xB2, 0xD2, 0xF2} {
+			res[j].mode = modeIndirectZ
+		}
+
+		for _, j := range []byte{0x10, 0x30, 0x50, 0x70, 0x90, 0xB0, 0xD0, 0xF0} {
+			res[j+3] = opcode{res[j].mnemonic, modeRelativeWord}
+		}
+
+		if m == cpu.Model45GS02 {
+			res.apply(map[byte]string{0x5C: "MAP imp", 0xEA: "EOM imp"})
+		}
+	}
+
+	return res
+}
+
+// Opcodes returns the opcodes of the given model indexed by the mnemonic and the name of the
+// addressing mode as used in the opcode tables, e.g. "zp" or "izy". If an instruction has more
+// than one encoding the lowest opcode is returned.
+func Opcodes(m cpu.CpuModel) map[string]map[string]byte {
+	res := map[string]map[string]byte{}
+	modes := map[addrMode]string{}
+
+	for name, mode := range modeNames {
+		modes[mode] = name
+	}
+
+	for i, j := range newOpcodeTable(m) {
+		if j.mnemonic == "" {
+			continue
+		}
+
+		if _, ok := res[j.mnemonic]; !ok {
+			res[j.mnemonic] = map[string]byte{}
+		}
+
+		if _, ok := res[j.mnemonic][modes[j.mode]]; !ok {
+			res[j.mnemonic][modes[j.mode]] = byte(i)
+		}
+	}
+
+	return res
+}
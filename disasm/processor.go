@@ -0,0 +1,83 @@
+package disasm
+
+import (
+	"6502profiler/cpu"
+	"6502profiler/memory"
+	"errors"
+	"fmt"
+)
+
+// ForProcessor creates a disassembler for the model of p. For the 65816 the width of the registers
+// is taken from the current state of the CPU.
+func ForProcessor(p cpu.Processor) *Disassembler {
+	res := New(p.Model())
+
+	if p.Model() == cpu.Model65816 {
+		flags, _ := p.GetRegister(cpu.RegFlags)
+		emulation, _ := p.GetRegister(cpu.RegE)
+		native := emulation == 0
+		res.SetRegisterWidths(native && ((flags&uint16(cpu.Flag_M)) == 0), native && ((flags&uint16(cpu.Flag_X)) == 0))
+	}
+
+	return res
+}
+
+// Fetch reads the bytes of the instruction at addr from the memory of p. Addresses above $FFFF are
+// read from the linear address space. Fewer bytes are returned if the end of the memory is reached.
+// The access statistics of the memory are not changed.
+func Fetch(p cpu.Processor, addr uint32) (data []byte) {
+	length := uint32(MaxInstructionLength)
+	if (addr <= 0xFFFF) && (addr+length > 0x10000) {
+		length = 0x10000 - addr
+	}
+
+	data = []byte{}
+
+	defer func() {
+		// Reading beyond the end of the memory causes a panic. data contains the bytes which could be read.
+		_ = recover()
+	}()
+
+	mem := p.GetMem()
+	for i := uint32(0); i < length; i++ {
+		if addr <= 0xFFFF {
+			data = append(data, memory.Peek(mem, uint16(addr+i)))
+		} else {
+			data = append(data, memory.PeekLarge(mem, addr+i))
+		}
+	}
+
+	return data
+}
+
+// DecodeAt decodes the instruction at addr in the memory of p
+func (d *Disassembler) DecodeAt(p cpu.Processor, addr uint32) Instruction {
+	return d.Decode(Fetch(p, addr), addr)
+}
+
+// AnnotateError adds disassembled instructions to an error returned by running a program on p. The
+// last executed instructions of a LimitError are disassembled. Other errors, e.g. an illegal
+// opcode, are supplemented by the instruction at the current PC if it can be read.
+func AnnotateError(p cpu.Processor, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	d := ForProcessor(p)
+
+	var limitErr *cpu.LimitError
+	if errors.As(err, &limitErr) {
+		limitErr.Disassemble = func(addr uint32) string {
+			return d.DecodeAt(p, addr).String()
+		}
+
+		return err
+	}
+
+	code := Fetch(p, uint32(p.GetPC()))
+	if len(code) == 0 {
+		return err
+	}
+
+	return fmt.Errorf("%w\nPC: %s", err, d.Decode(code, uint32(p.GetPC())))
+}
@@ -17,6 +17,17 @@ const SDiv = 8
 
 type Config struct {
 	Model            string
+	BrkIsInterrupt   bool
+	BusAccurate      bool
+	CycleLimit       uint64
+	InstructionLimit uint64
+	StopOnReturn     bool
+	StopAddresses    []uint32
+	ExitPort         *uint16
+	IrqAckPort       *uint16
+	BootFromReset    bool
+	BootCycles       uint64
+	BootReadyAddress uint16
 	MemSpec          string
 	IoMask           uint8
 	IoAddrConfig     map[uint8]string
@@ -31,6 +42,19 @@ type Config struct {
 	AcmeTestDir      string
 }
 
+var cpuModels map[string]cpu.CpuModel = map[string]cpu.CpuModel{
+	Proc6502:    cpu.Model6502,
+	Proc65C02:   cpu.Model65C02,
+	Proc6510:    cpu.Model6510,
+	ProcW65C02S: cpu.ModelW65C02S,
+	ProcR65C02:  cpu.ModelR65C02,
+	Proc65SC02:  cpu.Model65SC02,
+	Proc2A03:    cpu.Model2A03,
+	Proc65816:   cpu.Model65816,
+	Proc65CE02:  cpu.Model65CE02,
+	Proc45GS02:  cpu.Model45GS02,
+}
+
 type ConfParser func(cnf string) (memory.MemWrapper, bool)
 
 var confParsers []ConfParser = []ConfParser{
@@ -51,10 +75,19 @@ const F256_512 = "F256_512K"
 const F256_768 = "F256_768K"
 const Proc6502 = "6502"
 const Proc65C02 = "65C02"
+const Proc6510 = "6510"
+const ProcW65C02S = "W65C02S"
+const ProcR65C02 = "R65C02"
+const Proc65SC02 = "65SC02"
+const Proc2A03 = "2A03"
+const Proc65816 = "65816"
+const Proc65CE02 = "65CE02"
+const Proc45GS02 = "45GS02"
 const AsmDefault = ""
 const AsmAcme = "acme"
 const Asm64Tass = "64tass"
 const AsmCa65 = "ca65"
+const AsmBuiltin = "builtin"
 
 const IllegalTrapAddress = 0
 const Ca65DefaultLoadAddr = 0x0800
@@ -75,16 +108,12 @@ func NewConfigFromFile(fileName string) (*Config, error) {
 		F256_768: true,
 	}
 
-	allowedCpuModels := map[string]bool{
-		Proc6502:  true,
-		Proc65C02: true,
-	}
-
 	allowedAsmTypes := map[string]bool{
 		AsmDefault: true,
 		AsmAcme:    true,
 		Asm64Tass:  true,
 		AsmCa65:    true,
+		AsmBuiltin: true,
 	}
 
 	configData, err := os.ReadFile(fileName)
@@ -102,9 +131,9 @@ func NewConfigFromFile(fileName string) (*Config, error) {
 		return nil, fmt.Errorf("unknown memory model: %v", res.MemSpec)
 	}
 
-	_, ok = allowedCpuModels[res.Model]
+	_, ok = cpuModels[res.Model]
 	if !ok {
-		return nil, fmt.Errorf("unknown CPU model: %v", res.MemSpec)
+		return nil, fmt.Errorf("unknown CPU model: %v", res.Model)
 	}
 
 	_, ok = allowedAsmTypes[res.AsmType]
@@ -118,6 +147,17 @@ func NewConfigFromFile(fileName string) (*Config, error) {
 func DefaultConfig() *Config {
 	res := &Config{
 		Model:            Proc6502,
+		BrkIsInterrupt:   false,
+		BusAccurate:      false,
+		CycleLimit:       0,
+		InstructionLimit: 0,
+		StopOnReturn:     false,
+		StopAddresses:    []uint32{},
+		ExitPort:         nil,
+		IrqAckPort:       nil,
+		BootFromReset:    false,
+		BootCycles:       0,
+		BootReadyAddress: 0,
 		MemSpec:          L32,
 		IoMask:           0,
 		IoAddrConfig:     map[uint8]string{},
@@ -164,7 +204,7 @@ func (c *Config) Save(fileName string) error {
 }
 
 type CpuProvider interface {
-	NewCpu() (*cpu.CPU6502, error)
+	NewCpu() (cpu.Processor, error)
 }
 
 type AsmProvider interface {
@@ -198,6 +238,8 @@ func (c *Config) GetAssembler() assembler.Assembler {
 			loadAddress = c.Ca65StartAddress
 		}
 		return assembler.NewCa65(c.AcmeBinary, c.AcmeSrcDir, c.AcmeBinDir, c.AcmeTestDir, loadAddress)
+	case c.AsmType == AsmBuiltin:
+		return assembler.NewBuiltin(c.AcmeSrcDir, c.AcmeBinDir, c.AcmeTestDir)
 	default:
 		return assembler.NewACME(c.AcmeBinary, c.AcmeSrcDir, c.AcmeBinDir, c.AcmeTestDir)
 	}
@@ -251,7 +293,7 @@ func (c *Config) AddF256Func(mem memory.Memory) memory.Memory {
 	return mem
 }
 
-func (c *Config) PreloadRoms(cpu *cpu.CPU6502) error {
+func (c *Config) PreloadRoms(cpu cpu.Processor) error {
 	for i, j := range c.PreLoad {
 		data, err := os.ReadFile(j)
 		if err != nil {
@@ -267,14 +309,47 @@ func (c *Config) PreloadRoms(cpu *cpu.CPU6502) error {
 	return nil
 }
 
-func (c *Config) NewCpu() (*cpu.CPU6502, error) {
-	var model cpu.CpuModel = cpu.Model6502
+// ExecutionLimits returns the maximum number of clock cycles and instructions a program may use
+func (c *Config) ExecutionLimits() cpu.ExecutionLimits {
+	return cpu.ExecutionLimits{
+		MaxCycles:       c.CycleLimit,
+		MaxInstructions: c.InstructionLimit,
+	}
+}
+
+// Termination returns the conditions which end a program in addition to BRK and STP
+func (c *Config) Termination() cpu.TerminationConditions {
+	return cpu.TerminationConditions{
+		StopOnReturn:  c.StopOnReturn,
+		StopAddresses: c.StopAddresses,
+	}
+}
+
+// StartUp returns how the machine is started before the program under test is run
+func (c *Config) StartUp() cpu.StartUp {
+	return cpu.StartUp{
+		FromReset:    c.BootFromReset,
+		MaxCycles:    c.BootCycles,
+		ReadyAddress: c.BootReadyAddress,
+	}
+}
+
+func (c *Config) NewCpu() (cpu.Processor, error) {
+	model, ok := cpuModels[c.Model]
+	if !ok {
+		model = cpu.Model6502
+	}
+
+	processor := cpu.NewProcessor(model)
+	processor.SetBrkIsInterrupt(c.BrkIsInterrupt)
 
-	if c.Model != Proc6502 {
-		model = cpu.Model65C02
+	if err := processor.SetBusAccurate(c.BusAccurate); err != nil {
+		return nil, err
 	}
 
-	cpu := cpu.New6502(model)
+	processor.SetExecutionLimits(c.ExecutionLimits())
+	processor.SetTermination(c.Termination())
+
 	var mem memory.Memory
 
 	switch c.MemSpec {
@@ -311,12 +386,20 @@ func (c *Config) NewCpu() (*cpu.CPU6502, error) {
 		}
 	}
 
-	cpu.Init(mem)
+	processor.Init(mem)
 
-	err = c.PreloadRoms(cpu)
+	err = c.PreloadRoms(processor)
 	if err != nil {
 		return nil, err
 	}
 
-	return cpu, nil
+	if c.ExitPort != nil {
+		cpu.AddExitPort(processor, *c.ExitPort)
+	}
+
+	if c.IrqAckPort != nil {
+		cpu.AddIrqAckPort(processor, *c.IrqAckPort)
+	}
+
+	return processor, nil
 }
@@ -2,6 +2,7 @@ package luabridge
 
 import (
 	"6502profiler/cpu"
+	"6502profiler/memory"
 	"encoding/hex"
 	"fmt"
 	"os"
@@ -10,13 +11,13 @@ import (
 )
 
 type LuaCtx struct {
-	cpu     *cpu.CPU6502
+	cpu     cpu.Processor
 	L       *lua.LState
 	testDir string
 	ident   string
 }
 
-func NewLuaCtx(cpu *cpu.CPU6502, testDir string, l *lua.LState) *LuaCtx {
+func NewLuaCtx(cpu cpu.Processor, testDir string, l *lua.LState) *LuaCtx {
 	strBytes := ([]byte(testDir))
 	length := len(strBytes)
 
@@ -59,6 +60,26 @@ func (c *LuaCtx) RegisterGlobals(L *lua.LState, loadAddress uint16, progLen uint
 	L.SetGlobal("set_xreg", L.NewFunction(c.SetX))
 	L.SetGlobal("set_yreg", L.NewFunction(c.SetY))
 	L.SetGlobal("set_sp", L.NewFunction(c.SetSP))
+	L.SetGlobal("raise_irq", L.NewFunction(c.RaiseIrq))
+	L.SetGlobal("clear_irq", L.NewFunction(c.ClearIrq))
+	L.SetGlobal("raise_nmi", L.NewFunction(c.RaiseNmi))
+	L.SetGlobal("clear_nmi", L.NewFunction(c.ClearNmi))
+	L.SetGlobal("get_creg", L.NewFunction(c.GetC))
+	L.SetGlobal("set_creg", L.NewFunction(c.SetC))
+	L.SetGlobal("get_dreg", L.NewFunction(c.GetD))
+	L.SetGlobal("set_dreg", L.NewFunction(c.SetD))
+	L.SetGlobal("get_dbr", L.NewFunction(c.GetDBR))
+	L.SetGlobal("set_dbr", L.NewFunction(c.SetDBR))
+	L.SetGlobal("get_pbr", L.NewFunction(c.GetPBR))
+	L.SetGlobal("set_pbr", L.NewFunction(c.SetPBR))
+	L.SetGlobal("get_emulation", L.NewFunction(c.GetEmulation))
+	L.SetGlobal("set_emulation", L.NewFunction(c.SetEmulation))
+	L.SetGlobal("get_zreg", L.NewFunction(c.GetZ))
+	L.SetGlobal("set_zreg", L.NewFunction(c.SetZ))
+	L.SetGlobal("get_breg", L.NewFunction(c.GetB))
+	L.SetGlobal("set_breg", L.NewFunction(c.SetB))
+	L.SetGlobal("get_access_counts", L.NewFunction(c.GetAccessCounts))
+	L.SetGlobal("clear_access_counts", L.NewFunction(c.ClearAccessCounts))
 
 	L.SetGlobal("load_address", lua.LNumber(loadAddress))
 	L.SetGlobal("prog_len", lua.LNumber(progLen))
@@ -69,53 +90,134 @@ func (c *LuaCtx) RegisterGlobals(L *lua.LState, loadAddress uint16, progLen uint
 }
 
 func (c *LuaCtx) GetSP(L *lua.LState) int {
-	return c.GetRegister(L, &c.cpu.SP)
+	return c.GetRegister(L, cpu.RegSP)
 }
 
 func (c *LuaCtx) SetSP(L *lua.LState) int {
-	return c.SetRegister(L, &c.cpu.SP)
+	return c.SetRegister(L, cpu.RegSP)
 }
 
 func (c *LuaCtx) GetAccu(L *lua.LState) int {
-	return c.GetRegister(L, &c.cpu.A)
+	return c.GetRegister(L, cpu.RegA)
 }
 
 func (c *LuaCtx) GetX(L *lua.LState) int {
-	return c.GetRegister(L, &c.cpu.X)
+	return c.GetRegister(L, cpu.RegX)
 }
 
 func (c *LuaCtx) GetY(L *lua.LState) int {
-	return c.GetRegister(L, &c.cpu.Y)
+	return c.GetRegister(L, cpu.RegY)
 }
 
 func (c *LuaCtx) SetAccu(L *lua.LState) int {
-	return c.SetRegister(L, &c.cpu.A)
+	return c.SetRegister(L, cpu.RegA)
 }
 
 func (c *LuaCtx) SetX(L *lua.LState) int {
-	return c.SetRegister(L, &c.cpu.X)
+	return c.SetRegister(L, cpu.RegX)
 }
 
 func (c *LuaCtx) SetY(L *lua.LState) int {
-	return c.SetRegister(L, &c.cpu.Y)
+	return c.SetRegister(L, cpu.RegY)
+}
+
+func (c *LuaCtx) GetC(L *lua.LState) int {
+	return c.GetRegister(L, cpu.RegC)
+}
+
+func (c *LuaCtx) SetC(L *lua.LState) int {
+	return c.SetRegister(L, cpu.RegC)
+}
+
+func (c *LuaCtx) GetD(L *lua.LState) int {
+	return c.GetRegister(L, cpu.RegD)
+}
+
+func (c *LuaCtx) SetD(L *lua.LState) int {
+	return c.SetRegister(L, cpu.RegD)
+}
+
+func (c *LuaCtx) GetDBR(L *lua.LState) int {
+	return c.GetRegister(L, cpu.RegDBR)
+}
+
+func (c *LuaCtx) SetDBR(L *lua.LState) int {
+	return c.SetRegister(L, cpu.RegDBR)
+}
+
+func (c *LuaCtx) GetZ(L *lua.LState) int {
+	return c.GetRegister(L, cpu.RegZ)
+}
+
+func (c *LuaCtx) SetZ(L *lua.LState) int {
+	return c.SetRegister(L, cpu.RegZ)
+}
+
+func (c *LuaCtx) GetB(L *lua.LState) int {
+	return c.GetRegister(L, cpu.RegB)
+}
+
+func (c *LuaCtx) SetB(L *lua.LState) int {
+	return c.SetRegister(L, cpu.RegB)
+}
+
+func (c *LuaCtx) GetPBR(L *lua.LState) int {
+	return c.GetRegister(L, cpu.RegPBR)
+}
+
+func (c *LuaCtx) SetPBR(L *lua.LState) int {
+	return c.SetRegister(L, cpu.RegPBR)
+}
+
+func (c *LuaCtx) GetEmulation(L *lua.LState) int {
+	val, ok := c.cpu.GetRegister(cpu.RegE)
+	if !ok {
+		L.RaiseError("emulation flag does not exist in this CPU")
+		return 0
+	}
+
+	L.Push(lua.LBool(val != 0))
+
+	return 1
+}
+
+func (c *LuaCtx) SetEmulation(L *lua.LState) int {
+	var newValue uint16 = 0
+	if L.ToBool(1) {
+		newValue = 1
+	}
+
+	if !c.cpu.SetRegister(cpu.RegE, newValue) {
+		L.RaiseError("emulation flag does not exist in this CPU")
+	}
+
+	return 0
 }
 
 func (c *LuaCtx) GetPC(L *lua.LState) int {
-	L.Push(lua.LNumber(c.cpu.PC))
+	L.Push(lua.LNumber(c.cpu.GetPC()))
 
 	return 1
 }
 
-func (c *LuaCtx) GetRegister(L *lua.LState, reg *uint8) int {
-	L.Push(lua.LNumber(*reg))
+func (c *LuaCtx) GetRegister(L *lua.LState, reg cpu.Register) int {
+	val, ok := c.cpu.GetRegister(reg)
+	if !ok {
+		L.RaiseError("register does not exist in this CPU")
+		return 0
+	}
+
+	L.Push(lua.LNumber(val))
 
 	return 1
 }
 
-func (c *LuaCtx) SetRegister(L *lua.LState, reg *uint8) int {
-	newValue := uint8(L.ToInt(1))
+func (c *LuaCtx) SetRegister(L *lua.LState, reg cpu.Register) int {
+	newValue := uint16(L.ToInt(1))
 
-	*reg = newValue
+	if !c.cpu.SetRegister(reg, newValue) {
+		L.RaiseError("register does not exist in this CPU")
+	}
 
 	return 0
 }
@@ -123,7 +225,31 @@ func (c *LuaCtx) SetRegister(L *lua.LState, reg *uint8) int {
 func (c *LuaCtx) SetPC(L *lua.LState) int {
 	newValue := uint16(L.ToInt(1))
 
-	c.cpu.PC = newValue
+	c.cpu.SetPC(newValue)
+
+	return 0
+}
+
+func (c *LuaCtx) RaiseIrq(L *lua.LState) int {
+	c.cpu.GetInterrupts().RaiseIrq()
+
+	return 0
+}
+
+func (c *LuaCtx) ClearIrq(L *lua.LState) int {
+	c.cpu.GetInterrupts().ClearIrq()
+
+	return 0
+}
+
+func (c *LuaCtx) RaiseNmi(L *lua.LState) int {
+	c.cpu.GetInterrupts().RaiseNmi()
+
+	return 0
+}
+
+func (c *LuaCtx) ClearNmi(L *lua.LState) int {
+	c.cpu.GetInterrupts().ClearNmi()
 
 	return 0
 }
@@ -134,84 +260,77 @@ func (c *LuaCtx) GetCycles(L *lua.LState) int {
 	return 1
 }
 
-func (c *LuaCtx) GetFlags() string {
-	res := []byte{}
+// GetAccessCounts returns a table which contains the number of accesses to the given address for each
+// kind of access and their total. If a length is given as the second argument the accesses to all
+// bytes of the range are summed up.
+func (c *LuaCtx) GetAccessCounts(L *lua.LState) int {
+	addr := L.ToInt(1)
+	length := L.OptInt(2, 1)
+	stats := memory.AccessStats{}
 
-	if (c.cpu.Flags & cpu.Flag_N) != 0 {
-		res = append(res, 'N')
-	} else {
-		res = append(res, '-')
+	for i := 0; i < length; i++ {
+		s := c.cpu.GetMem().GetAccessStatistics(uint16(addr + i))
+		for kind := range stats {
+			stats[kind] += s[kind]
+		}
 	}
 
-	if (c.cpu.Flags & cpu.Flag_V) != 0 {
-		res = append(res, 'V')
-	} else {
-		res = append(res, '-')
+	res := L.NewTable()
+	for kind, count := range stats {
+		res.RawSetString(memory.AccessKind(kind).String(), lua.LNumber(count))
 	}
 
-	res = append(res, '-')
+	res.RawSetString("total", lua.LNumber(stats.Total()))
+	L.Push(res)
 
-	if (c.cpu.Flags & cpu.Flag_B) != 0 {
-		res = append(res, 'B')
-	} else {
-		res = append(res, '-')
-	}
+	return 1
+}
 
-	if (c.cpu.Flags & cpu.Flag_D) != 0 {
-		res = append(res, 'D')
-	} else {
-		res = append(res, '-')
-	}
+func (c *LuaCtx) ClearAccessCounts(L *lua.LState) int {
+	c.cpu.GetMem().ClearStatistics()
 
-	if (c.cpu.Flags & cpu.Flag_I) != 0 {
-		res = append(res, 'I')
-	} else {
-		res = append(res, '-')
-	}
+	return 0
+}
 
-	if (c.cpu.Flags & cpu.Flag_Z) != 0 {
-		res = append(res, 'Z')
-	} else {
-		res = append(res, '-')
-	}
+// GetFlags returns the flag register as a string of flag names. A flag which is not set is
+// represented by '-'. The names depend on the CPU, e.g. "NV-BDIZC" for the 6502.
+func (c *LuaCtx) GetFlags() string {
+	names := c.cpu.FlagNames()
+	flags, _ := c.cpu.GetRegister(cpu.RegFlags)
+	res := []byte{}
 
-	if (c.cpu.Flags & cpu.Flag_C) != 0 {
-		res = append(res, 'C')
-	} else {
-		res = append(res, '-')
+	for i := 0; i < len(names); i++ {
+		if (flags & (0x80 >> i)) != 0 {
+			res = append(res, names[i])
+		} else {
+			res = append(res, '-')
+		}
 	}
 
 	return string(res)
 }
 
 func (c *LuaCtx) SetFlags(flags string) {
-	var res uint8 = 0
+	var res uint16 = 0
+	names := c.cpu.FlagNames()
 
 	if len(flags) > 8 {
 		panic("flag value is too large")
 	}
 
 	for _, j := range flags {
-		switch j {
-		case 'N':
-			res |= cpu.Flag_N
-		case 'V':
-			res |= cpu.Flag_V
-		case 'B':
-			res |= cpu.Flag_B
-		case 'D':
-			res |= cpu.Flag_D
-		case 'I':
-			res |= cpu.Flag_I
-		case 'Z':
-			res |= cpu.Flag_Z
-		case 'C':
-			res |= cpu.Flag_C
+		if j == '-' {
+			continue
 		}
 
+		for i := 0; i < len(names); i++ {
+			if rune(names[i]) == j {
+				res |= 0x80 >> i
+			}
+		}
 	}
 
-	c.cpu.Flags = res
+	c.cpu.SetRegister(cpu.RegFlags, res)
 }
 
 func (c *LuaCtx) GetFlagsLua(L *lua.LState) int {
@@ -246,7 +365,7 @@ func (c *LuaCtx) GetMemory(L *lua.LState) int {
 func (c *LuaCtx) ReadSingleByte(L *lua.LState) int {
 	addr := uint16(L.ToInt(1))
 
-	data := c.cpu.Mem.Load(addr)
+	data := c.cpu.GetMem().Load(addr)
 	L.Push(lua.LNumber(data))
 
 	return 1
@@ -255,7 +374,7 @@ func (c *LuaCtx) ReadSingleByte(L *lua.LState) int {
 func (c *LuaCtx) ReadSingleByteLarge(L *lua.LState) int {
 	addr := uint32(L.ToInt(1))
 
-	data := c.cpu.Mem.ToLargeMemory().LoadLarge(addr)
+	data := c.cpu.GetMem().ToLargeMemory().LoadLarge(addr)
 	L.Push(lua.LNumber(data))
 
 	return 1
@@ -282,7 +401,7 @@ func (c *LuaCtx) WriteSingleByte(L *lua.LState) int {
 	dataByte := uint8(L.ToInt(2))
 	addr := uint16(L.ToInt(1))
 
-	c.cpu.Mem.Store(addr, dataByte)
+	c.cpu.GetMem().Store(addr, dataByte)
 
 	return 0
 }
@@ -291,7 +410,7 @@ func (c *LuaCtx) WriteSingleByteLarge(L *lua.LState) int {
 	dataByte := uint8(L.ToInt(2))
 	addr := uint32(L.ToInt(1))
 
-	c.cpu.Mem.ToLargeMemory().StoreLarge(addr, dataByte)
+	c.cpu.GetMem().ToLargeMemory().StoreLarge(addr, dataByte)
 
 	return 0
 }
@@ -311,6 +430,30 @@ func (c *LuaCtx) CallArrange() error {
 	return nil
 }
 
+// CallArrangeRun calls arrange with the number of the current run as its argument. arrange can return a
+// value which describes the input it has generated. It is returned as a string.
+func (c *LuaCtx) CallArrangeRun(run uint) (string, error) {
+	arrangeLua := lua.P{
+		Fn:      c.L.GetGlobal("arrange"),
+		NRet:    1,
+		Protect: true,
+	}
+
+	err := c.L.CallByParam(arrangeLua, lua.LNumber(run))
+	if err != nil {
+		return "", fmt.Errorf("unable to call arrange function in test script: %v", err)
+	}
+
+	ret := c.L.Get(-1) // description of the input
+	c.L.Pop(1)
+
+	if ret == lua.LNil {
+		return "", nil
+	}
+
+	return ret.String(), nil
+}
+
 func (c *LuaCtx) CallAssert() (bool, string, error) {
 	assertLua := lua.P{
 		Fn:      c.L.GetGlobal("assert"),
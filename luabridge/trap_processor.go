@@ -11,7 +11,7 @@ type TrapProcessor struct {
 	Ctx *LuaCtx
 }
 
-func NewTrapProcessor(l *lua.LState, scriptToRun string, cpu *cpu.CPU6502, loadAddress uint16, progLen uint16, id string) (*TrapProcessor, error) {
+func NewTrapProcessor(l *lua.LState, scriptToRun string, cpu cpu.Processor, loadAddress uint16, progLen uint16, id string) (*TrapProcessor, error) {
 	res := &TrapProcessor{
 		Ctx: NewLuaCtx(cpu, "", l),
 	}
@@ -3,6 +3,7 @@ package main
 import (
 	"6502profiler/commands"
 	"6502profiler/util"
+	"os"
 )
 
 func main() {
@@ -12,9 +13,15 @@ func main() {
 	subcommParser.AddCommand("run", commands.RunCommand, "Run program")
 	subcommParser.AddCommand("verify", commands.VerifyCommand, "Run a test on an assembler program")
 	subcommParser.AddCommand("verifyall", commands.VerifyAllCommand, "Run all tests")
+	subcommParser.AddCommand("bench", commands.BenchCommand, "Run a test driver many times and show statistics of its clock cycles")
+	subcommParser.AddCommand("debug", commands.DebugCommand, "Run a program or a test case in an interactive monitor")
+	subcommParser.AddCommand("dap", commands.DapCommand, "Serve the Debug Adapter Protocol on stdin and stdout")
+	subcommParser.AddCommand("disasm", commands.DisasmCommand, "Disassemble a program or a memory range")
+	subcommParser.AddCommand("trace", commands.TraceCommand, "Print or search a binary instruction trace")
+	subcommParser.AddCommand("profdiff", commands.ProfDiffCommand, "Compare two profiles or the profiles of two programs")
 	subcommParser.AddCommand("info", commands.InfoCommand, "Return info about program")
 	subcommParser.AddCommand("newcase", commands.NewCaseCommand, "Create a new test case skeleton")
 	subcommParser.AddCommand("delcase", commands.DelCommand, "Delete the files of an existing test case")
 	subcommParser.AddCommand("list", commands.ListCommand, "List all test cases and their descriptions")
-	subcommParser.Execute()
+	os.Exit(subcommParser.Execute())
 }
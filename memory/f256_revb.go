@@ -7,8 +7,8 @@ type F256RevBMemory struct {
 	mmuMemCtrlSnap uint8
 	mmuIoCtrlSnap  uint8
 
-	accessMmuMemCtrl uint64
-	accessMmuIoCtrl  uint64
+	accessMmuMemCtrl AccessStats
+	accessMmuIoCtrl  AccessStats
 
 	systemMemory []byte
 	ioMemory     []byte
@@ -18,9 +18,9 @@ type F256RevBMemory struct {
 	ioMemorySnap     []byte
 	mLutSnap         []byte
 
-	accessSystem []uint64
-	accessIo     []uint64
-	accessMLut   []uint64
+	accessSystem []AccessStats
+	accessIo     []AccessStats
+	accessMLut   []AccessStats
 }
 
 const bankSize uint16 = 8192
@@ -48,11 +48,11 @@ func NewF56JrMemory(expandedMem bool) *F256RevBMemory {
 		ioMemorySnap:     make([]byte, numIoBanks*bankSize),
 		mLutSnap:         make([]byte, numLuts*lutSize),
 
-		accessMmuMemCtrl: 0,
-		accessMmuIoCtrl:  0,
-		accessSystem:     make([]uint64, memSize),
-		accessIo:         make([]uint64, numIoBanks*bankSize),
-		accessMLut:       make([]uint64, numLuts*lutSize),
+		accessMmuMemCtrl: AccessStats{},
+		accessMmuIoCtrl:  AccessStats{},
+		accessSystem:     make([]AccessStats, memSize),
+		accessIo:         make([]AccessStats, numIoBanks*bankSize),
+		accessMLut:       make([]AccessStats, numLuts*lutSize),
 	}
 
 	res.SetMlut(0, []byte{0, 1, 2, 3, 4, 5, 6, 7})
@@ -81,7 +81,7 @@ const activeLutMask uint8 = 0b00000011
 const ioDisableMask uint8 = 0b00000100
 const activeIoBankMask uint8 = 0b00000011
 
-func (f *F256RevBMemory) calcLongIndex(addr uint32) (*uint8, *uint64) {
+func (f *F256RevBMemory) calcLongIndex(addr uint32) (*uint8, *AccessStats) {
 	switch {
 	case addr < 16:
 		return f.calcIndex((uint16)(addr))
@@ -93,7 +93,7 @@ func (f *F256RevBMemory) calcLongIndex(addr uint32) (*uint8, *uint64) {
 	}
 }
 
-func (f *F256RevBMemory) calcIndex(addr uint16) (*uint8, *uint64) {
+func (f *F256RevBMemory) calcIndex(addr uint16) (*uint8, *AccessStats) {
 	// lower 13 bits
 	loBits := addr & loBitMask
 	hiBits := addr >> numLoBits
@@ -130,7 +130,11 @@ func (f *F256RevBMemory) calcIndex(addr uint16) (*uint8, *uint64) {
 }
 
 func (f *F256RevBMemory) Load(address uint16) uint8 {
-	return loadGen(address, f.calcIndex)
+	return loadGen(address, AccessDataRead, f.calcIndex)
+}
+
+func (f *F256RevBMemory) LoadAs(address uint16, kind AccessKind) uint8 {
+	return loadGen(address, kind, f.calcIndex)
 }
 
 func (f *F256RevBMemory) Store(address uint16, b uint8) {
@@ -141,8 +145,16 @@ func (f *F256RevBMemory) GetStatistics(address uint16) uint64 {
 	return statGen(address, f.calcIndex)
 }
 
+func (f *F256RevBMemory) GetAccessStatistics(address uint16) AccessStats {
+	return accessStatGen(address, f.calcIndex)
+}
+
 func (f *F256RevBMemory) LoadLarge(address uint32) uint8 {
-	return loadGen(address, f.calcLongIndex)
+	return loadGen(address, AccessDataRead, f.calcLongIndex)
+}
+
+func (f *F256RevBMemory) LoadLargeAs(address uint32, kind AccessKind) uint8 {
+	return loadGen(address, kind, f.calcLongIndex)
 }
 
 func (f *F256RevBMemory) StoreLarge(address uint32, b uint8) {
@@ -153,21 +165,17 @@ func (f *F256RevBMemory) GetStatisticsLarge(address uint32) uint64 {
 	return statGen(address, f.calcLongIndex)
 }
 
-func (f *F256RevBMemory) ClearStatistics() {
-	for count := 0; count < len(f.accessSystem); count++ {
-		f.accessSystem[count] = 0
-	}
-
-	for count := 0; count < len(f.accessIo); count++ {
-		f.accessIo[count] = 0
-	}
+func (f *F256RevBMemory) GetAccessStatisticsLarge(address uint32) AccessStats {
+	return accessStatGen(address, f.calcLongIndex)
+}
 
-	for count := 0; count < len(f.accessMLut); count++ {
-		f.accessMLut[count] = 0
-	}
+func (f *F256RevBMemory) ClearStatistics() {
+	clearAccessStats(f.accessSystem)
+	clearAccessStats(f.accessIo)
+	clearAccessStats(f.accessMLut)
 
-	f.accessMmuMemCtrl = 0
-	f.accessMmuIoCtrl = 0
+	f.accessMmuMemCtrl = AccessStats{}
+	f.accessMmuIoCtrl = AccessStats{}
 }
 
 func (f *F256RevBMemory) TakeSnapshot() {
@@ -194,3 +202,19 @@ func (f *F256RevBMemory) SetMlut(lutNum uint8, mlutData []byte) {
 func (f *F256RevBMemory) ToLargeMemory() LargeMemory {
 	return f
 }
+
+func (f *F256RevBMemory) Peek(address uint16) uint8 {
+	return peekGen(address, f.calcIndex)
+}
+
+func (f *F256RevBMemory) Poke(address uint16, b uint8) {
+	pokeGen(address, b, f.calcIndex)
+}
+
+func (f *F256RevBMemory) PeekLarge(address uint32) uint8 {
+	return peekGen(address, f.calcLongIndex)
+}
+
+func (f *F256RevBMemory) PokeLarge(address uint32, b uint8) {
+	pokeGen(address, b, f.calcLongIndex)
+}
@@ -2,7 +2,7 @@ package memory
 
 type LinearMemory struct {
 	memory         []byte
-	accessCount    []uint64
+	accessCount    []AccessStats
 	memorySnapshot []byte
 }
 
@@ -13,7 +13,7 @@ func NewLinearMemory(size uint32) *LinearMemory {
 
 	res := &LinearMemory{
 		memory:         make([]byte, size),
-		accessCount:    make([]uint64, size),
+		accessCount:    make([]AccessStats, size),
 		memorySnapshot: make([]byte, size),
 	}
 
@@ -31,22 +31,29 @@ func (l *LinearMemory) RestoreSnapshot() {
 }
 
 func (l *LinearMemory) ClearStatistics() {
-	for count := 0; count < len(l.memory); count++ {
-		l.accessCount[count] = 0
-	}
+	clearAccessStats(l.accessCount)
 }
 
 func (l *LinearMemory) Load(address uint16) uint8 {
-	l.accessCount[address]++
+	l.accessCount[address][AccessDataRead]++
+	return l.memory[address]
+}
+
+func (l *LinearMemory) LoadAs(address uint16, kind AccessKind) uint8 {
+	l.accessCount[address][kind]++
 	return l.memory[address]
 }
 
 func (l *LinearMemory) Store(address uint16, b uint8) {
-	l.accessCount[address]++
+	l.accessCount[address][AccessDataWrite]++
 	l.memory[address] = b
 }
 
 func (l *LinearMemory) GetStatistics(address uint16) uint64 {
+	return l.accessCount[address].Total()
+}
+
+func (l *LinearMemory) GetAccessStatistics(address uint16) AccessStats {
 	return l.accessCount[address]
 }
 
@@ -54,6 +61,10 @@ func (l *LinearMemory) LoadLarge(address uint32) uint8 {
 	return l.Load((uint16)(address & 0xFFFF))
 }
 
+func (l *LinearMemory) LoadLargeAs(address uint32, kind AccessKind) uint8 {
+	return l.LoadAs((uint16)(address&0xFFFF), kind)
+}
+
 func (l *LinearMemory) StoreLarge(address uint32, b uint8) {
 	l.Store((uint16)(address&0xFFFF), b)
 }
@@ -62,6 +73,26 @@ func (l *LinearMemory) GetStatisticsLarge(address uint32) uint64 {
 	return l.GetStatistics((uint16)(address & 0xFFFF))
 }
 
+func (l *LinearMemory) GetAccessStatisticsLarge(address uint32) AccessStats {
+	return l.GetAccessStatistics((uint16)(address & 0xFFFF))
+}
+
 func (l *LinearMemory) ToLargeMemory() LargeMemory {
 	return l
 }
+
+func (l *LinearMemory) Peek(address uint16) uint8 {
+	return l.memory[address]
+}
+
+func (l *LinearMemory) Poke(address uint16, b uint8) {
+	l.memory[address] = b
+}
+
+func (l *LinearMemory) PeekLarge(address uint32) uint8 {
+	return l.Peek((uint16)(address & 0xFFFF))
+}
+
+func (l *LinearMemory) PokeLarge(address uint32, b uint8) {
+	l.Poke((uint16)(address&0xFFFF), b)
+}
@@ -10,22 +10,130 @@ type AddrType interface {
 	uint16 | uint32
 }
 
+// AccessKind describes the purpose of a memory access. Every address has a separate counter for
+// each kind.
+type AccessKind uint8
+
+const (
+	// AccessDataRead is used for all reads which are not made to fetch an instruction
+	AccessDataRead AccessKind = iota
+	// AccessOpcodeFetch is used for reading the opcode of an instruction
+	AccessOpcodeFetch
+	// AccessOperandFetch is used for reading the operand bytes which follow the opcode
+	AccessOperandFetch
+	// AccessDataWrite is used for all writes
+	AccessDataWrite
+	NumAccessKinds
+)
+
+var accessKindNames = [NumAccessKinds]string{"read", "opcode", "operand", "write"}
+
+func (k AccessKind) String() string {
+	if k >= NumAccessKinds {
+		return "unknown"
+	}
+
+	return accessKindNames[k]
+}
+
+// AccessStats holds the number of accesses to an address, indexed by AccessKind
+type AccessStats [NumAccessKinds]uint64
+
+// Total returns the number of accesses of all kinds
+func (a *AccessStats) Total() uint64 {
+	var res uint64
+
+	for _, j := range a {
+		res += j
+	}
+
+	return res
+}
+
+// Executed returns the number of instruction fetches, i.e. opcode and operand fetches
+func (a *AccessStats) Executed() uint64 {
+	return a[AccessOpcodeFetch] + a[AccessOperandFetch]
+}
+
+func clearAccessStats(stats []AccessStats) {
+	for i := range stats {
+		stats[i] = AccessStats{}
+	}
+}
+
 type LargeMemory interface {
 	LoadLarge(address uint32) uint8
+	// LoadLargeAs reads a byte and counts the access as the given kind
+	LoadLargeAs(address uint32, kind AccessKind) uint8
 	StoreLarge(address uint32, b uint8)
 	GetStatisticsLarge(address uint32) uint64
+	GetAccessStatisticsLarge(address uint32) AccessStats
 }
 
 type Memory interface {
+	// Load reads a byte. The access is counted as a data read.
 	Load(address uint16) uint8
+	// LoadAs reads a byte and counts the access as the given kind. It is used by the CPU to fetch
+	// instructions.
+	LoadAs(address uint16, kind AccessKind) uint8
 	Store(address uint16, b uint8)
+	// GetStatistics returns the number of accesses of all kinds to an address
 	GetStatistics(address uint16) uint64
+	// GetAccessStatistics returns the number of accesses to an address for each AccessKind
+	GetAccessStatistics(address uint16) AccessStats
 	ToLargeMemory() LargeMemory
 	ClearStatistics()
 	TakeSnapshot()
 	RestoreSnapshot()
 }
 
+// Inspector is implemented by memories which can be accessed without changing the access statistics,
+// e.g. by a disassembler or a debugger
+type Inspector interface {
+	Peek(address uint16) uint8
+	Poke(address uint16, b uint8)
+	PeekLarge(address uint32) uint8
+	PokeLarge(address uint32, b uint8)
+}
+
+// Peek reads a byte from m. The access statistics are not changed if m implements Inspector.
+func Peek(m Memory, address uint16) uint8 {
+	if i, ok := m.(Inspector); ok {
+		return i.Peek(address)
+	}
+
+	return m.Load(address)
+}
+
+// Poke writes a byte to m. The access statistics are not changed if m implements Inspector.
+func Poke(m Memory, address uint16, b uint8) {
+	if i, ok := m.(Inspector); ok {
+		i.Poke(address, b)
+		return
+	}
+
+	m.Store(address, b)
+}
+
+// PeekLarge reads a byte from the linear address space of m
+func PeekLarge(m Memory, address uint32) uint8 {
+	if i, ok := m.(Inspector); ok {
+		return i.PeekLarge(address)
+	}
+
+	return m.ToLargeMemory().LoadLarge(address)
+}
+
+// PokeLarge writes a byte to the linear address space of m
+func PokeLarge(m Memory, address uint32, b uint8) {
+	if i, ok := m.(Inspector); ok {
+		i.PokeLarge(address, b)
+		return
+	}
+
+	m.ToLargeMemory().StoreLarge(address, b)
+}
+
 func Dump(m Memory, start uint16, end uint16) {
 	byteCount := 0
 	crlfWritten := false
@@ -81,19 +189,34 @@ func Dump(m Memory, start uint16, end uint16) {
 	fmt.Printf("$%04x\n", end+1)
 }
 
-func loadGen[T AddrType](address T, indexer func(T) (*uint8, *uint64)) uint8 {
+func loadGen[T AddrType](address T, kind AccessKind, indexer func(T) (*uint8, *AccessStats)) uint8 {
 	mem, stat := indexer(address)
-	(*stat)++
+	stat[kind]++
 	return *mem
 }
 
-func statGen[T AddrType](address T, indexer func(T) (*uint8, *uint64)) uint64 {
+func statGen[T AddrType](address T, indexer func(T) (*uint8, *AccessStats)) uint64 {
+	_, stat := indexer(address)
+	return stat.Total()
+}
+
+func accessStatGen[T AddrType](address T, indexer func(T) (*uint8, *AccessStats)) AccessStats {
 	_, stat := indexer(address)
 	return *stat
 }
 
-func storeGen[T AddrType](address T, b uint8, indexer func(T) (*uint8, *uint64)) {
+func storeGen[T AddrType](address T, b uint8, indexer func(T) (*uint8, *AccessStats)) {
 	mem, stat := indexer(address)
-	(*stat)++
+	stat[AccessDataWrite]++
+	*mem = b
+}
+
+func peekGen[T AddrType](address T, indexer func(T) (*uint8, *AccessStats)) uint8 {
+	mem, _ := indexer(address)
+	return *mem
+}
+
+func pokeGen[T AddrType](address T, b uint8, indexer func(T) (*uint8, *AccessStats)) {
+	mem, _ := indexer(address)
 	*mem = b
 }
@@ -21,3 +21,70 @@ func TestDump(t *testing.T) {
 
 	Dump(mem, 0x0800, 0x85a)
 }
+
+func TestPeekAndPoke(t *testing.T) {
+	mem := NewMemWrapper(NewLinearMemory(65536), 0xDE00)
+
+	written := []uint8{}
+	mem.AddSpecialWriteAddress(0xDE00, func(data uint8) { written = append(written, data) })
+
+	Poke(mem, 0x0800, 0x42)
+	PokeLarge(mem, 0x0801, 0x43)
+	Poke(mem, 0xDE00, 0x44)
+
+	if (Peek(mem, 0x0800) != 0x42) || (PeekLarge(mem, 0x0801) != 0x43) || (Peek(mem, 0xDE00) != 0x44) {
+		t.Fatal("poked values not found")
+	}
+
+	if (mem.GetStatistics(0x0800) != 0) || (mem.GetStatistics(0x0801) != 0) {
+		t.Fatal("access statistics changed by Peek or Poke")
+	}
+
+	if len(written) != 0 {
+		t.Fatal("IO triggered by Poke")
+	}
+
+	if mem.Load(0x0800) != 0x42 || (mem.GetStatistics(0x0800) != 1) {
+		t.Fatal("Load does not see poked value")
+	}
+}
+
+func TestAccessKinds(t *testing.T) {
+	mems := map[string]Memory{
+		"linear":  NewLinearMemory(65536),
+		"x16":     NewX16Memory(X512K),
+		"neogeo":  NewNeoGeo(0xDE00, 6),
+		"f256":    NewF56JrMemory(false),
+		"wrapper": NewMemWrapper(NewLinearMemory(65536), 0xDE00),
+	}
+
+	for name, mem := range mems {
+		mem.Store(0x0800, 0xA9)
+		mem.LoadAs(0x0800, AccessOpcodeFetch)
+		mem.LoadAs(0x0800, AccessOpcodeFetch)
+		mem.LoadAs(0x0800, AccessOperandFetch)
+		mem.Load(0x0800)
+
+		expected := AccessStats{AccessDataRead: 1, AccessOpcodeFetch: 2, AccessOperandFetch: 1, AccessDataWrite: 1}
+		if stats := mem.GetAccessStatistics(0x0800); stats != expected {
+			t.Fatalf("%s: wrong access statistics %v", name, stats)
+		}
+
+		if mem.GetStatistics(0x0800) != 5 {
+			t.Fatalf("%s: wrong total %d", name, mem.GetStatistics(0x0800))
+		}
+
+		large := mem.ToLargeMemory()
+		large.LoadLargeAs(0x0800, AccessOperandFetch)
+
+		if stats := large.GetAccessStatisticsLarge(0x0800); stats[AccessOperandFetch] != 2 {
+			t.Fatalf("%s: operand fetch through linear address space not counted", name)
+		}
+
+		mem.ClearStatistics()
+
+		if stats := mem.GetAccessStatistics(0x0800); stats.Total() != 0 {
+			t.Fatalf("%s: statistics not cleared", name)
+		}
+	}
+}
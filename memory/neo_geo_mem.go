@@ -15,8 +15,8 @@ type NeoGeoRam struct {
 	baseMemSnapshot []byte
 	neoGeoSnapshot  []byte
 
-	statBase   []uint64
-	statNeoGeo []uint64
+	statBase   []AccessStats
+	statNeoGeo []AccessStats
 }
 
 func NewNeoGeo(trackPtrAddress uint16, sectorBits uint) *NeoGeoRam {
@@ -41,8 +41,8 @@ func NewNeoGeo(trackPtrAddress uint16, sectorBits uint) *NeoGeoRam {
 		neoGeo:         make([]byte, geoSize),
 		neoGeoSnapshot: make([]byte, geoSize),
 
-		statBase:   make([]uint64, 65536),
-		statNeoGeo: make([]uint64, geoSize),
+		statBase:   make([]AccessStats, 65536),
+		statNeoGeo: make([]AccessStats, geoSize),
 	}
 
 	*res.sectorPtr = 0
@@ -78,7 +78,7 @@ func (n *NeoGeoRam) calcIndexRaw(address uint16) uint {
 	return geoAddr
 }
 
-func (n *NeoGeoRam) calcLongIndex(address uint32) (*uint8, *uint64) {
+func (n *NeoGeoRam) calcLongIndex(address uint32) (*uint8, *AccessStats) {
 	switch {
 	case (address <= 0xFFFF):
 		return &n.baseMem[address], &n.statBase[address]
@@ -88,7 +88,7 @@ func (n *NeoGeoRam) calcLongIndex(address uint32) (*uint8, *uint64) {
 	}
 }
 
-func (n *NeoGeoRam) calcIndex(address uint16) (*uint8, *uint64) {
+func (n *NeoGeoRam) calcIndex(address uint16) (*uint8, *AccessStats) {
 	switch {
 	case (address < NeoGeoRamPage) || (address >= NeoGeoRegisterPage):
 		return &n.baseMem[address], &n.statBase[address]
@@ -109,17 +109,16 @@ func (n *NeoGeoRam) RestoreSnapshot() {
 }
 
 func (n *NeoGeoRam) ClearStatistics() {
-	for i := 0; i < len(n.statBase); i++ {
-		n.statBase[i] = 0
-	}
-
-	for i := 0; i < len(n.statNeoGeo); i++ {
-		n.statNeoGeo[i] = 0
-	}
+	clearAccessStats(n.statBase)
+	clearAccessStats(n.statNeoGeo)
 }
 
 func (n *NeoGeoRam) Load(address uint16) uint8 {
-	return loadGen(address, n.calcIndex)
+	return loadGen(address, AccessDataRead, n.calcIndex)
+}
+
+func (n *NeoGeoRam) LoadAs(address uint16, kind AccessKind) uint8 {
+	return loadGen(address, kind, n.calcIndex)
 }
 
 func (n *NeoGeoRam) Store(address uint16, b uint8) {
@@ -130,8 +129,16 @@ func (n *NeoGeoRam) GetStatistics(address uint16) uint64 {
 	return statGen(address, n.calcIndex)
 }
 
+func (n *NeoGeoRam) GetAccessStatistics(address uint16) AccessStats {
+	return accessStatGen(address, n.calcIndex)
+}
+
 func (n *NeoGeoRam) LoadLarge(address uint32) uint8 {
-	return loadGen(address, n.calcLongIndex)
+	return loadGen(address, AccessDataRead, n.calcLongIndex)
+}
+
+func (n *NeoGeoRam) LoadLargeAs(address uint32, kind AccessKind) uint8 {
+	return loadGen(address, kind, n.calcLongIndex)
 }
 
 func (n *NeoGeoRam) StoreLarge(address uint32, b uint8) {
@@ -142,6 +149,26 @@ func (n *NeoGeoRam) GetStatisticsLarge(address uint32) uint64 {
 	return statGen(address, n.calcLongIndex)
 }
 
+func (n *NeoGeoRam) GetAccessStatisticsLarge(address uint32) AccessStats {
+	return accessStatGen(address, n.calcLongIndex)
+}
+
 func (n *NeoGeoRam) ToLargeMemory() LargeMemory {
 	return n
 }
+
+func (n *NeoGeoRam) Peek(address uint16) uint8 {
+	return peekGen(address, n.calcIndex)
+}
+
+func (n *NeoGeoRam) Poke(address uint16, b uint8) {
+	pokeGen(address, b, n.calcIndex)
+}
+
+func (n *NeoGeoRam) PeekLarge(address uint32) uint8 {
+	return peekGen(address, n.calcLongIndex)
+}
+
+func (n *NeoGeoRam) PokeLarge(address uint32, b uint8) {
+	pokeGen(address, b, n.calcLongIndex)
+}
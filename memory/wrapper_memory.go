@@ -38,6 +38,10 @@ func (p *WrappingMemory) Load(address uint16) uint8 {
 	return p.mem.Load(address)
 }
 
+func (p *WrappingMemory) LoadAs(address uint16, kind AccessKind) uint8 {
+	return p.mem.LoadAs(address, kind)
+}
+
 func (p *WrappingMemory) Store(address uint16, b uint8) {
 	if (address & 0xFF00) != p.ioMask {
 		p.mem.Store(address, b)
@@ -57,6 +61,10 @@ func (p *WrappingMemory) GetStatistics(address uint16) uint64 {
 	return p.mem.GetStatistics(address)
 }
 
+func (p *WrappingMemory) GetAccessStatistics(address uint16) AccessStats {
+	return p.mem.GetAccessStatistics(address)
+}
+
 func (p *WrappingMemory) ClearStatistics() {
 	p.mem.ClearStatistics()
 }
@@ -73,6 +81,23 @@ func (p *WrappingMemory) ToLargeMemory() LargeMemory {
 	return p.mem.ToLargeMemory()
 }
 
+// Peek and Poke bypass the wrappers, i.e. they do not trigger any IO
+func (p *WrappingMemory) Peek(address uint16) uint8 {
+	return Peek(p.mem, address)
+}
+
+func (p *WrappingMemory) Poke(address uint16, b uint8) {
+	Poke(p.mem, address, b)
+}
+
+func (p *WrappingMemory) PeekLarge(address uint32) uint8 {
+	return PeekLarge(p.mem, address)
+}
+
+func (p *WrappingMemory) PokeLarge(address uint32, b uint8) {
+	PokeLarge(p.mem, address, b)
+}
+
 // ------------------------------------------------------------------------------
 
 type PlaceholderWrapper struct {
@@ -15,9 +15,9 @@ type X16Memory struct {
 	bankedRAM8KSnaphot   []byte
 	bankedROM16KSnapshot []byte
 
-	statBase      []uint64
-	statBankedRam []uint64
-	statBankedRom []uint64
+	statBase      []AccessStats
+	statBankedRam []AccessStats
+	statBankedRom []AccessStats
 }
 
 func NewX16Memory(model uint8) *X16Memory {
@@ -41,9 +41,9 @@ func NewX16Memory(model uint8) *X16Memory {
 		bankedRAM8KSnaphot:   make([]byte, ramBlocks*8192),
 		bankedROM16KSnapshot: make([]byte, 32*16384),
 
-		statBase:      make([]uint64, 40*1024),
-		statBankedRam: make([]uint64, ramBlocks*8192),
-		statBankedRom: make([]uint64, 32*16384),
+		statBase:      make([]AccessStats, 40*1024),
+		statBankedRam: make([]AccessStats, ramBlocks*8192),
+		statBankedRom: make([]AccessStats, 32*16384),
 	}
 
 	*res.ramSelector = 1
@@ -55,20 +55,12 @@ func NewX16Memory(model uint8) *X16Memory {
 }
 
 func (x *X16Memory) ClearStatistics() {
-	for i := 0; i < len(x.statBase); i++ {
-		x.statBase[i] = 0
-	}
-
-	for i := 0; i < len(x.statBankedRam); i++ {
-		x.statBankedRam[i] = 0
-	}
-
-	for i := 0; i < len(x.statBankedRom); i++ {
-		x.statBankedRom[i] = 0
-	}
+	clearAccessStats(x.statBase)
+	clearAccessStats(x.statBankedRam)
+	clearAccessStats(x.statBankedRom)
 }
 
-func (x *X16Memory) calcLongIndex(address uint32) (*uint8, *uint64) {
+func (x *X16Memory) calcLongIndex(address uint32) (*uint8, *AccessStats) {
 	switch {
 	case address < 0xA000:
 		return &x.baseMem[address], &x.statBase[address]
@@ -81,7 +73,7 @@ func (x *X16Memory) calcLongIndex(address uint32) (*uint8, *uint64) {
 	}
 }
 
-func (x *X16Memory) calcIndex(address uint16) (*uint8, *uint64) {
+func (x *X16Memory) calcIndex(address uint16) (*uint8, *AccessStats) {
 	switch {
 	case address < 0xA000:
 		return &x.baseMem[address], &x.statBase[address]
@@ -107,7 +99,11 @@ func (x *X16Memory) RestoreSnapshot() {
 }
 
 func (x *X16Memory) Load(address uint16) uint8 {
-	return loadGen(address, x.calcIndex)
+	return loadGen(address, AccessDataRead, x.calcIndex)
+}
+
+func (x *X16Memory) LoadAs(address uint16, kind AccessKind) uint8 {
+	return loadGen(address, kind, x.calcIndex)
 }
 
 func (x *X16Memory) Store(address uint16, b uint8) {
@@ -118,8 +114,16 @@ func (x *X16Memory) GetStatistics(address uint16) uint64 {
 	return statGen(address, x.calcIndex)
 }
 
+func (x *X16Memory) GetAccessStatistics(address uint16) AccessStats {
+	return accessStatGen(address, x.calcIndex)
+}
+
 func (x *X16Memory) LoadLarge(address uint32) uint8 {
-	return loadGen(address, x.calcLongIndex)
+	return loadGen(address, AccessDataRead, x.calcLongIndex)
+}
+
+func (x *X16Memory) LoadLargeAs(address uint32, kind AccessKind) uint8 {
+	return loadGen(address, kind, x.calcLongIndex)
 }
 
 func (x *X16Memory) StoreLarge(address uint32, b uint8) {
@@ -130,6 +134,26 @@ func (x *X16Memory) GetStatisticsLarge(address uint32) uint64 {
 	return statGen(address, x.calcLongIndex)
 }
 
+func (x *X16Memory) GetAccessStatisticsLarge(address uint32) AccessStats {
+	return accessStatGen(address, x.calcLongIndex)
+}
+
 func (x *X16Memory) ToLargeMemory() LargeMemory {
 	return x
 }
+
+func (x *X16Memory) Peek(address uint16) uint8 {
+	return peekGen(address, x.calcIndex)
+}
+
+func (x *X16Memory) Poke(address uint16, b uint8) {
+	pokeGen(address, b, x.calcIndex)
+}
+
+func (x *X16Memory) PeekLarge(address uint32) uint8 {
+	return peekGen(address, x.calcLongIndex)
+}
+
+func (x *X16Memory) PokeLarge(address uint32, b uint8) {
+	pokeGen(address, b, x.calcLongIndex)
+}
@@ -0,0 +1,169 @@
+package profiler
+
+import (
+	"6502profiler/memory"
+	"6502profiler/srcmap"
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// LineStats describes the execution of the code generated by a source line
+type LineStats struct {
+	srcmap.Location
+	Cycles uint64
+	// Executions is the number of times the most often executed instruction of the line has been
+	// executed
+	Executions uint64
+	// DataAccesses counts the reads and writes of the bytes generated by the line which have not been
+	// made to fetch an instruction
+	DataAccesses uint64
+}
+
+// SourceLines assigns the clock cycles and accesses of the program stored between start and end to
+// the source lines given in src. g may be nil if no call graph has been recorded.
+func SourceLines(src *srcmap.Map, g *CallGraph, m memory.Memory, start uint16, end uint16) map[srcmap.Location]*LineStats {
+	res := map[srcmap.Location]*LineStats{}
+
+	line := func(addr uint32) *LineStats {
+		loc, ok := src.Lookup(addr)
+		if !ok {
+			return nil
+		}
+
+		l, ok := res[loc]
+		if !ok {
+			l = &LineStats{Location: loc}
+			res[loc] = l
+		}
+
+		return l
+	}
+
+	accesses, _ := readAccesses(m, start, end)
+	for i, stats := range accesses {
+		if l := line(uint32(start) + uint32(i)); l != nil {
+			l.DataAccesses += stats[memory.AccessDataRead] + stats[memory.AccessDataWrite]
+		}
+	}
+
+	if g != nil {
+		executions := map[uint32]uint64{}
+
+		for _, j := range g.Instructions() {
+			if l := line(j.PC); l != nil {
+				l.Cycles += j.Cycles
+				executions[j.PC] += j.Executions
+
+				if executions[j.PC] > l.Executions {
+					l.Executions = executions[j.PC]
+				}
+			}
+		}
+	}
+
+	return res
+}
+
+// readSource returns the lines of a source file or nil if it can not be read
+func readSource(fileName string) []string {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil
+	}
+	defer func() { f.Close() }()
+
+	res := []string{}
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		res = append(res, scanner.Text())
+	}
+
+	return res
+}
+
+// WriteAnnotatedSource writes the source files to w. Each line is preceded by its clock cycles, their
+// percentage of totalCycles, its number of executions and data accesses. Lines which have used at
+// least the number of clock cycles determined by cutOff are marked with ###.
+func WriteAnnotatedSource(w io.Writer, lines map[srcmap.Location]*LineStats, totalCycles uint64, cutOff ValueCutOffCalc) error {
+	files := map[string][]*LineStats{}
+	cycles := []uint64{}
+
+	for _, j := range lines {
+		if j.Line < 1 {
+			continue
+		}
+
+		files[j.File] = append(files[j.File], j)
+
+		if j.Cycles != 0 {
+			cycles = append(cycles, j.Cycles)
+		}
+	}
+
+	hot := uint64(0)
+	if len(cycles) != 0 {
+		hot = cutOff(cycles)
+	}
+
+	names := []string{}
+	for i := range files {
+		names = append(names, i)
+	}
+
+	sort.Strings(names)
+
+	b := bufio.NewWriter(w)
+
+	for _, name := range names {
+		fileLines := files[name]
+		sort.Slice(fileLines, func(i, j int) bool { return fileLines[i].Line < fileLines[j].Line })
+
+		fmt.Fprintf(b, "Source: %s\n\n", name)
+		fmt.Fprintf(b, "     %10s %6s %10s %10s %6s\n", "Cycles", "%", "Executions", "Data", "Line")
+
+		text := readSource(name)
+
+		numLines := len(text)
+		if last := fileLines[len(fileLines)-1].Line; last > numLines {
+			numLines = last
+		}
+
+		next := 0
+
+		for i := 1; i <= numLines; i++ {
+			source := ""
+			if i <= len(text) {
+				source = text[i-1]
+			}
+
+			if (next >= len(fileLines)) || (fileLines[next].Line != i) {
+				fmt.Fprintln(b, strings.TrimRight(fmt.Sprintf("     %10s %6s %10s %10s %6d  %s", "", "", "", "", i, source), " "))
+				continue
+			}
+
+			l := fileLines[next]
+			next++
+
+			prefix := "     "
+			if (l.Cycles != 0) && (l.Cycles >= hot) {
+				prefix = "###  "
+			}
+
+			percentage := 0.0
+			if totalCycles != 0 {
+				percentage = 100.0 * float64(l.Cycles) / float64(totalCycles)
+			}
+
+			fmt.Fprintln(b, strings.TrimRight(fmt.Sprintf("%s%10d %6.2f %10d %10d %6d  %s", prefix, l.Cycles, percentage, l.Executions, l.DataAccesses, i, source), " "))
+		}
+
+		fmt.Fprintln(b)
+	}
+
+	return b.Flush()
+}
@@ -0,0 +1,100 @@
+package profiler
+
+import (
+	"6502profiler/srcmap"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newAnnotateMap assigns the program of recordExport to the lines of two source files, which are
+// stored in dir
+func newAnnotateMap(t *testing.T, dir string) (*srcmap.Map, string, string) {
+	t.Helper()
+
+	mainFile := filepath.Join(dir, "main.a")
+	subFile := filepath.Join(dir, "sub.a")
+
+	if err := os.WriteFile(mainFile, []byte("main\n    jsr sub\n    brk\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(subFile, []byte("sub\n    lda data\n    rts\n\ndata !byte 7\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	src := srcmap.New()
+	src.Add(0x0800, 3, srcmap.Location{File: mainFile, Line: 2})
+	src.Add(0x0803, 1, srcmap.Location{File: mainFile, Line: 3})
+	src.Add(0x0804, 3, srcmap.Location{File: subFile, Line: 2})
+	src.Add(0x0807, 1, srcmap.Location{File: subFile, Line: 3})
+	src.Add(0x0808, 1, srcmap.Location{File: subFile, Line: 5})
+
+	return src, mainFile, subFile
+}
+
+func TestSourceLines(t *testing.T) {
+	g, p := runExportProgram(t)
+	src, mainFile, subFile := newAnnotateMap(t, t.TempDir())
+
+	lines := SourceLines(src, g, p.GetMem(), 0x0800, 0x0808)
+
+	// Loading the program is not counted as a data access
+	expected := []LineStats{
+		{Location: srcmap.Location{File: mainFile, Line: 2}, Cycles: 6, Executions: 1},
+		{Location: srcmap.Location{File: mainFile, Line: 3}, Cycles: 0, Executions: 1},
+		{Location: srcmap.Location{File: subFile, Line: 2}, Cycles: 4, Executions: 1},
+		{Location: srcmap.Location{File: subFile, Line: 3}, Cycles: 6, Executions: 1},
+		{Location: srcmap.Location{File: subFile, Line: 5}, DataAccesses: 1},
+	}
+
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got %d", len(expected), len(lines))
+	}
+
+	for _, j := range expected {
+		if l, ok := lines[j.Location]; !ok || (*l != j) {
+			t.Fatalf("expected %+v, got %+v", j, lines[j.Location])
+		}
+	}
+
+	// Without a call graph only the data accesses are known
+	lines = SourceLines(src, nil, p.GetMem(), 0x0800, 0x0808)
+	if l := lines[srcmap.Location{File: subFile, Line: 2}]; (l == nil) || (l.Cycles != 0) || (l.Executions != 0) {
+		t.Fatalf("unexpected line without call graph: %+v", l)
+	}
+}
+
+func TestWriteAnnotatedSource(t *testing.T) {
+	g, p := runExportProgram(t)
+	src, mainFile, subFile := newAnnotateMap(t, t.TempDir())
+
+	out := &bytes.Buffer{}
+	cutOff := func(values []uint64) uint64 { return 6 }
+	if err := WriteAnnotatedSource(out, SourceLines(src, g, p.GetMem(), 0x0800, 0x0808), g.TotalCycles(), cutOff); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Source: " + mainFile + `
+
+         Cycles      % Executions       Data   Line
+                                                  1  main
+###           6  37.50          1          0      2      jsr sub
+              0   0.00          1          0      3      brk
+
+Source: ` + subFile + `
+
+         Cycles      % Executions       Data   Line
+                                                  1  sub
+              4  25.00          1          0      2      lda data
+###           6  37.50          1          0      3      rts
+                                                  4
+              0   0.00          0          1      5  data !byte 7
+
+`
+
+	if out.String() != expected {
+		t.Fatalf("unexpected annotated source:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
@@ -0,0 +1,475 @@
+package profiler
+
+import (
+	"6502profiler/cpu"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// RoutineStats describes the clock cycles used by a subroutine
+type RoutineStats struct {
+	Address uint32
+	Name    string
+	Calls   uint64
+	// Inclusive counts the clock cycles from the call of the routine until its return, i.e. including
+	// the cycles of the routines it has called. Recursive calls are only counted once.
+	Inclusive uint64
+	// Exclusive counts the clock cycles of the instructions executed by the routine itself
+	Exclusive uint64
+}
+
+// CallEdge describes the calls of a subroutine made from one call site
+type CallEdge struct {
+	Caller   uint32
+	Callee   uint32
+	CallSite uint32
+	Calls    uint64
+	// Inclusive is the sum of the inclusive clock cycles of all calls made from the call site
+	Inclusive uint64
+	// Instructions is the number of instructions executed by these calls
+	Instructions uint64
+}
+
+// InstructionStats describes how often an instruction has been executed by a subroutine
+type InstructionStats struct {
+	Routine    uint32
+	PC         uint32
+	Executions uint64
+	Cycles     uint64
+}
+
+type edgeKey struct {
+	caller   uint32
+	callee   uint32
+	callSite uint32
+}
+
+type instrKey struct {
+	routine uint32
+	pc      uint32
+}
+
+// activeCall is a subroutine call which has not returned yet
+type activeCall struct {
+	routine *RoutineStats
+	edge    *CallEdge
+	entry   uint64
+	// entryInstr is the number of instructions which have been executed before the call
+	entryInstr uint64
+	// stack holds the exclusive clock cycles of the call chain which has led to this call
+	stack *StackStats
+}
+
+// StackStats describes the clock cycles used by the last routine of a chain of subroutine calls
+type StackStats struct {
+	// Routines contains the routines of the call chain starting with the root of the call graph
+	Routines []uint32
+	Cycles   uint64
+	children map[uint32]*StackStats
+}
+
+// CallGraph is a cpu.Tracer which attributes the clock cycles of the executed instructions to the
+// subroutines which have been active at that time. It follows the subroutine calls tracked by the
+// CPU, which also recognizes returns through RTS tricks and calls which are abandoned by resetting
+// the stack. The routine at which the program has been started forms the root of the call graph.
+// A new program run, which is recognized by a reset of the clock cycle count, discards the data of
+// the previous one.
+type CallGraph struct {
+	labels       map[uint16][]string
+	started      bool
+	root         uint32
+	firstCycle   uint64
+	lastCycle    uint64
+	numInstr     uint64
+	routines     map[uint32]*RoutineStats
+	edges        map[edgeKey]*CallEdge
+	instructions map[instrKey]*InstructionStats
+	depth        map[uint32]int
+	stacks       []*StackStats
+	stackRoots   map[uint32]*StackStats
+	frames       []cpu.CallFrame
+	active       []activeCall
+	spIndex      int
+	// The most recently executed instruction and the routine which has executed it
+	lastInstr   *InstructionStats
+	lastRoutine *RoutineStats
+	lastStack   *StackStats
+}
+
+// NewCallGraph creates a CallGraph which names the subroutines by the given labels
+func NewCallGraph(labels map[uint16][]string) *CallGraph {
+	if labels == nil {
+		labels = map[uint16][]string{}
+	}
+
+	res := &CallGraph{labels: labels}
+
+	for i, j := range cpu.TraceRegisters {
+		if j == cpu.RegSP {
+			res.spIndex = i
+		}
+	}
+
+	res.clear()
+
+	return res
+}
+
+func (g *CallGraph) clear() {
+	g.started = false
+	g.routines = map[uint32]*RoutineStats{}
+	g.edges = map[edgeKey]*CallEdge{}
+	g.instructions = map[instrKey]*InstructionStats{}
+	g.depth = map[uint32]int{}
+	g.stacks = nil
+	g.stackRoots = map[uint32]*StackStats{}
+	g.frames = g.frames[:0]
+	g.active = g.active[:0]
+}
+
+// Name returns the first label of an address or the address itself if there is no label
+func (g *CallGraph) Name(addr uint32) string {
+	if addr <= 0xFFFF {
+		if names := g.labels[uint16(addr)]; len(names) != 0 {
+			return names[0]
+		}
+	}
+
+	return formatAddress(addr)
+}
+
+func formatAddress(addr uint32) string {
+	if addr > 0xFFFF {
+		return fmt.Sprintf("$%02X:%04X", addr>>16, addr&0xFFFF)
+	}
+
+	return fmt.Sprintf("$%04X", addr)
+}
+
+func (g *CallGraph) routine(addr uint32) *RoutineStats {
+	res, ok := g.routines[addr]
+	if !ok {
+		res = &RoutineStats{Address: addr, Name: g.Name(addr)}
+		g.routines[addr] = res
+	}
+
+	return res
+}
+
+// Trace implements cpu.Tracer
+func (g *CallGraph) Trace(p cpu.Processor, r *cpu.TraceRecord) {
+	if !g.started || (r.Cycle < g.lastCycle) {
+		g.clear()
+		g.started = true
+		g.root = r.PC
+		g.firstCycle = r.Cycle
+		g.numInstr = 0
+		g.enter(cpu.CallFrame{CallSite: r.PC, Target: r.PC}, nil, r.Cycle)
+	}
+
+	call := &g.active[len(g.active)-1]
+	current := call.routine
+	current.Exclusive += r.CyclesUsed
+	call.stack.Cycles += r.CyclesUsed
+
+	key := instrKey{current.Address, r.PC}
+	instr, ok := g.instructions[key]
+	if !ok {
+		instr = &InstructionStats{Routine: current.Address, PC: r.PC}
+		g.instructions[key] = instr
+	}
+
+	instr.Executions++
+	instr.Cycles += r.CyclesUsed
+	g.numInstr++
+	g.lastInstr = instr
+	g.lastRoutine = current
+	g.lastStack = call.stack
+
+	g.lastCycle = r.Cycle + r.CyclesUsed
+
+	// Subroutine calls and returns always change the stack pointer. Retrieving the call stack is
+	// not cheap, so it is only done if necessary.
+	if sp, _ := p.GetRegister(cpu.RegSP); sp != r.Registers[g.spIndex] {
+		g.update(p.CallStack(), g.lastCycle)
+	}
+}
+
+// update makes the active calls match the call stack of the CPU
+func (g *CallGraph) update(frames []cpu.CallFrame, cycle uint64) {
+	common := 0
+	for (common < len(g.frames)) && (common < len(frames)) && (g.frames[common] == frames[common]) {
+		common++
+	}
+
+	for len(g.frames) > common {
+		g.frames = g.frames[:len(g.frames)-1]
+		g.leave(cycle)
+	}
+
+	for _, j := range frames[common:] {
+		caller := g.active[len(g.active)-1].routine
+		g.frames = append(g.frames, j)
+		g.enter(j, caller, cycle)
+	}
+}
+
+func (g *CallGraph) enter(f cpu.CallFrame, caller *RoutineStats, cycle uint64) {
+	callee := g.routine(f.Target)
+	callee.Calls++
+	g.depth[f.Target]++
+
+	call := activeCall{routine: callee, entry: cycle, entryInstr: g.numInstr, stack: g.stack(f.Target)}
+
+	if caller != nil {
+		key := edgeKey{caller.Address, f.Target, f.CallSite}
+
+		call.edge = g.edges[key]
+		if call.edge == nil {
+			call.edge = &CallEdge{Caller: caller.Address, Callee: f.Target, CallSite: f.CallSite}
+			g.edges[key] = call.edge
+		}
+
+		call.edge.Calls++
+	}
+
+	g.active = append(g.active, call)
+}
+
+// stack returns the statistics of the call chain which consists of the active calls followed by a
+// call of addr
+func (g *CallGraph) stack(addr uint32) *StackStats {
+	var parent *StackStats
+	children := g.stackRoots
+
+	if len(g.active) != 0 {
+		parent = g.active[len(g.active)-1].stack
+		if parent.children == nil {
+			parent.children = map[uint32]*StackStats{}
+		}
+
+		children = parent.children
+	}
+
+	res, ok := children[addr]
+	if !ok {
+		routines := []uint32{addr}
+		if parent != nil {
+			routines = append(append(make([]uint32, 0, len(parent.Routines)+1), parent.Routines...), addr)
+		}
+
+		res = &StackStats{Routines: routines}
+		children[addr] = res
+		g.stacks = append(g.stacks, res)
+	}
+
+	return res
+}
+
+func (g *CallGraph) leave(cycle uint64) {
+	call := g.active[len(g.active)-1]
+	g.active = g.active[:len(g.active)-1]
+
+	g.depth[call.routine.Address]--
+	if g.depth[call.routine.Address] == 0 {
+		call.routine.Inclusive += cycle - call.entry
+	}
+
+	if call.edge != nil {
+		call.edge.Inclusive += cycle - call.entry
+		call.edge.Instructions += g.numInstr - call.entryInstr
+	}
+}
+
+// Finish has to be called after the program p has ended. Calls which have not returned are regarded
+// as having ended with the last instruction.
+func (g *CallGraph) Finish(p cpu.Processor) {
+	// The CPU does not count the clock cycles of an instruction which halts the program, e.g. BRK
+	if g.started && (p.NumCycles() < g.lastCycle) && ((g.lastCycle - p.NumCycles()) <= g.lastInstr.Cycles) {
+		diff := g.lastCycle - p.NumCycles()
+		g.lastInstr.Cycles -= diff
+		g.lastRoutine.Exclusive -= diff
+		g.lastStack.Cycles -= diff
+		g.lastCycle -= diff
+	}
+
+	g.frames = g.frames[:0]
+
+	for len(g.active) > 0 {
+		g.leave(g.lastCycle)
+	}
+}
+
+// TotalCycles returns the number of clock cycles used by the program
+func (g *CallGraph) TotalCycles() uint64 {
+	if !g.started {
+		return 0
+	}
+
+	return g.lastCycle - g.firstCycle
+}
+
+// NumInstructions returns the number of instructions executed by the program
+func (g *CallGraph) NumInstructions() uint64 {
+	return g.numInstr
+}
+
+// Root returns the address at which the program has been started
+func (g *CallGraph) Root() uint32 {
+	return g.root
+}
+
+// Routines returns all subroutines which have been called, the one with the most inclusive clock
+// cycles first
+func (g *CallGraph) Routines() []*RoutineStats {
+	res := make([]*RoutineStats, 0, len(g.routines))
+	for _, j := range g.routines {
+		res = append(res, j)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Inclusive != res[j].Inclusive {
+			return res[i].Inclusive > res[j].Inclusive
+		}
+
+		return res[i].Address < res[j].Address
+	})
+
+	return res
+}
+
+// Edges returns all calls between subroutines, sorted by caller, callee and call site
+func (g *CallGraph) Edges() []*CallEdge {
+	res := make([]*CallEdge, 0, len(g.edges))
+	for _, j := range g.edges {
+		res = append(res, j)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.Caller != b.Caller {
+			return a.Caller < b.Caller
+		}
+
+		if a.Callee != b.Callee {
+			return a.Callee < b.Callee
+		}
+
+		return a.CallSite < b.CallSite
+	})
+
+	return res
+}
+
+// Instructions returns the executed instructions sorted by routine and address
+func (g *CallGraph) Instructions() []*InstructionStats {
+	res := make([]*InstructionStats, 0, len(g.instructions))
+	for _, j := range g.instructions {
+		res = append(res, j)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Routine != res[j].Routine {
+			return res[i].Routine < res[j].Routine
+		}
+
+		return res[i].PC < res[j].PC
+	})
+
+	return res
+}
+
+// Stacks returns all chains of subroutine calls which have occurred together with the exclusive clock
+// cycles of the last routine in the chain. The result is sorted by the routines of the chains.
+func (g *CallGraph) Stacks() []*StackStats {
+	res := append([]*StackStats{}, g.stacks...)
+
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i].Routines, res[j].Routines
+		for k := 0; (k < len(a)) && (k < len(b)); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+
+		return len(a) < len(b)
+	})
+
+	return res
+}
+
+func (g *CallGraph) percentage(cycles uint64) float64 {
+	total := g.TotalCycles()
+	if total == 0 {
+		return 0
+	}
+
+	return 100.0 * float64(cycles) / float64(total)
+}
+
+func (g *CallGraph) nameWithAddress(addr uint32) string {
+	name := g.Name(addr)
+	if name[0] == '$' {
+		return name
+	}
+
+	return fmt.Sprintf("%s (%s)", name, formatAddress(addr))
+}
+
+func (g *CallGraph) writeRoutines(w io.Writer, routines []*RoutineStats) {
+	fmt.Fprintf(w, "%-32s %10s %12s %6s %12s %6s\n", "Routine", "Calls", "Inclusive", "%", "Exclusive", "%")
+
+	for _, j := range routines {
+		fmt.Fprintf(w, "%-32s %10d %12d %6.2f %12d %6.2f\n", g.nameWithAddress(j.Address), j.Calls, j.Inclusive,
+			g.percentage(j.Inclusive), j.Exclusive, g.percentage(j.Exclusive))
+	}
+}
+
+// WriteTop writes the n routines with the most inclusive clock cycles to w
+func (g *CallGraph) WriteTop(w io.Writer, n int) {
+	routines := g.Routines()
+	if len(routines) > n {
+		routines = routines[:n]
+	}
+
+	g.writeRoutines(w, routines)
+}
+
+// WriteReport writes all routines to w. Each routine is followed by its callers, the routines it
+// has called and the instructions it has executed.
+func (g *CallGraph) WriteReport(w io.Writer) {
+	routines := g.Routines()
+
+	fmt.Fprintf(w, "Total clock cycles: %d\n\n", g.TotalCycles())
+	g.writeRoutines(w, routines)
+
+	callers := map[uint32][]*CallEdge{}
+	callees := map[uint32][]*CallEdge{}
+
+	for _, j := range g.Edges() {
+		callers[j.Callee] = append(callers[j.Callee], j)
+		callees[j.Caller] = append(callees[j.Caller], j)
+	}
+
+	instructions := map[uint32][]*InstructionStats{}
+	for _, j := range g.Instructions() {
+		instructions[j.Routine] = append(instructions[j.Routine], j)
+	}
+
+	for _, r := range routines {
+		fmt.Fprintf(w, "\n%s: %d calls, %d inclusive, %d exclusive clock cycles\n", g.nameWithAddress(r.Address), r.Calls, r.Inclusive, r.Exclusive)
+
+		for _, j := range callers[r.Address] {
+			fmt.Fprintf(w, "    called by %s at %s: %d calls, %d clock cycles\n", g.nameWithAddress(j.Caller), formatAddress(j.CallSite), j.Calls, j.Inclusive)
+		}
+
+		for _, j := range callees[r.Address] {
+			fmt.Fprintf(w, "    calls %s at %s: %d calls, %d clock cycles\n", g.nameWithAddress(j.Callee), formatAddress(j.CallSite), j.Calls, j.Inclusive)
+		}
+
+		for _, j := range instructions[r.Address] {
+			fmt.Fprintf(w, "    %s: %d executions, %d clock cycles\n", formatAddress(j.PC), j.Executions, j.Cycles)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package profiler
+
+import (
+	"6502profiler/cpu"
+	"6502profiler/memory"
+	"testing"
+)
+
+// runCallGraph runs the given routines on a 6502 and returns the resulting call graph. The keys
+// of code are the addresses of the routines. The program is started at $0800.
+func runCallGraph(t *testing.T, code map[uint16][]byte, labels map[uint16][]string) (*CallGraph, *cpu.CPU6502) {
+	t.Helper()
+
+	processor := cpu.New6502(cpu.Model6502)
+	processor.Init(memory.NewLinearMemory(65536))
+
+	for addr, bytes := range code {
+		processor.CopyToMem(bytes, addr)
+	}
+
+	g := NewCallGraph(labels)
+	processor.SetTracer(g)
+
+	if err := processor.Run(0x0800); err != nil {
+		t.Fatalf("program failed: %v", err)
+	}
+
+	g.Finish(processor)
+
+	return g, processor
+}
+
+func checkRoutines(t *testing.T, g *CallGraph, expected []RoutineStats) {
+	t.Helper()
+
+	routines := g.Routines()
+	if len(routines) != len(expected) {
+		t.Fatalf("expected %d routines, got %d", len(expected), len(routines))
+	}
+
+	for i, j := range expected {
+		if *routines[i] != j {
+			t.Fatalf("expected %+v, got %+v", j, *routines[i])
+		}
+	}
+}
+
+func checkEdges(t *testing.T, g *CallGraph, expected []CallEdge) {
+	t.Helper()
+
+	edges := g.Edges()
+	if len(edges) != len(expected) {
+		t.Fatalf("expected %d edges, got %d", len(expected), len(edges))
+	}
+
+	for i, j := range expected {
+		if *edges[i] != j {
+			t.Fatalf("expected %+v, got %+v", j, *edges[i])
+		}
+	}
+}
+
+func checkStacks(t *testing.T, g *CallGraph, expected []StackStats) {
+	t.Helper()
+
+	stacks := g.Stacks()
+	if len(stacks) != len(expected) {
+		t.Fatalf("expected %d stacks, got %d", len(expected), len(stacks))
+	}
+
+	for i, j := range expected {
+		if (stacks[i].Cycles != j.Cycles) || (len(stacks[i].Routines) != len(j.Routines)) {
+			t.Fatalf("expected %v, got %v", j, *stacks[i])
+		}
+
+		for k := range j.Routines {
+			if stacks[i].Routines[k] != j.Routines[k] {
+				t.Fatalf("expected %v, got %v", j, *stacks[i])
+			}
+		}
+	}
+}
+
+func checkDepth(t *testing.T, g *CallGraph) {
+	t.Helper()
+
+	for addr, depth := range g.depth {
+		if depth != 0 {
+			t.Fatalf("routine %s still active after Finish", formatAddress(addr))
+		}
+	}
+}
+
+func TestCallGraphNested(t *testing.T) {
+	// main:   jsr sub1
+	//         jsr sub1
+	//         brk
+	// sub1:   jsr sub2
+	//         rts
+	// sub2:   nop
+	//         rts
+	g, p := runCallGraph(t, map[uint16][]byte{
+		0x0800: {0x20, 0x10, 0x08, 0x20, 0x10, 0x08, 0x00},
+		0x0810: {0x20, 0x20, 0x08, 0x60},
+		0x0820: {0xEA, 0x60},
+	}, map[uint16][]string{0x0800: {"main"}, 0x0810: {"sub1"}, 0x0820: {"sub2"}})
+
+	// The clock cycles of BRK are not counted by the CPU
+	if (g.TotalCycles() != 52) || (p.NumCycles() != 52) || (g.NumInstructions() != 11) || (g.Root() != 0x0800) {
+		t.Fatalf("unexpected totals: %d cycles, %d instructions", g.TotalCycles(), g.NumInstructions())
+	}
+
+	checkRoutines(t, g, []RoutineStats{
+		{Address: 0x0800, Name: "main", Calls: 1, Inclusive: 52, Exclusive: 12},
+		{Address: 0x0810, Name: "sub1", Calls: 2, Inclusive: 40, Exclusive: 24},
+		{Address: 0x0820, Name: "sub2", Calls: 2, Inclusive: 16, Exclusive: 16},
+	})
+
+	checkEdges(t, g, []CallEdge{
+		{Caller: 0x0800, Callee: 0x0810, CallSite: 0x0800, Calls: 1, Inclusive: 20, Instructions: 4},
+		{Caller: 0x0800, Callee: 0x0810, CallSite: 0x0803, Calls: 1, Inclusive: 20, Instructions: 4},
+		{Caller: 0x0810, Callee: 0x0820, CallSite: 0x0810, Calls: 2, Inclusive: 16, Instructions: 4},
+	})
+
+	checkStacks(t, g, []StackStats{
+		{Routines: []uint32{0x0800}, Cycles: 12},
+		{Routines: []uint32{0x0800, 0x0810}, Cycles: 24},
+		{Routines: []uint32{0x0800, 0x0810, 0x0820}, Cycles: 16},
+	})
+
+	checkDepth(t, g)
+
+	// BRK is attributed to main without clock cycles
+	for _, j := range g.Instructions() {
+		if (j.PC == 0x0806) && ((j.Routine != 0x0800) || (j.Executions != 1) || (j.Cycles != 0)) {
+			t.Fatalf("unexpected statistics of BRK: %+v", *j)
+		}
+	}
+}
+
+func TestCallGraphRecursion(t *testing.T) {
+	// main:   ldx #2
+	//         jsr rec
+	//         brk
+	// rec:    dex
+	//         beq done
+	//         jsr rec
+	// done:   rts
+	g, _ := runCallGraph(t, map[uint16][]byte{
+		0x0800: {0xA2, 0x02, 0x20, 0x10, 0x08, 0x00},
+		0x0810: {0xCA, 0xF0, 0x03, 0x20, 0x10, 0x08, 0x60},
+	}, nil)
+
+	if g.TotalCycles() != 35 {
+		t.Fatalf("expected 35 clock cycles, got %d", g.TotalCycles())
+	}
+
+	// The inclusive clock cycles of the recursive call are part of the outer call
+	checkRoutines(t, g, []RoutineStats{
+		{Address: 0x0800, Name: "$0800", Calls: 1, Inclusive: 35, Exclusive: 8},
+		{Address: 0x0810, Name: "$0810", Calls: 2, Inclusive: 27, Exclusive: 27},
+	})
+
+	checkEdges(t, g, []CallEdge{
+		{Caller: 0x0800, Callee: 0x0810, CallSite: 0x0802, Calls: 1, Inclusive: 27, Instructions: 7},
+		{Caller: 0x0810, Callee: 0x0810, CallSite: 0x0813, Calls: 1, Inclusive: 11, Instructions: 3},
+	})
+
+	checkStacks(t, g, []StackStats{
+		{Routines: []uint32{0x0800}, Cycles: 8},
+		{Routines: []uint32{0x0800, 0x0810}, Cycles: 16},
+		{Routines: []uint32{0x0800, 0x0810, 0x0810}, Cycles: 11},
+	})
+
+	checkDepth(t, g)
+}
+
+func TestCallGraphRtsTrick(t *testing.T) {
+	// main:   jsr sub
+	//         brk
+	// sub:    lda #>(cont-1)
+	//         pha
+	//         lda #<(cont-1)
+	//         pha
+	//         rts
+	// cont:   rts
+	g, _ := runCallGraph(t, map[uint16][]byte{
+		0x0800: {0x20, 0x10, 0x08, 0x00},
+		0x0810: {0xA9, 0x08, 0x48, 0xA9, 0x1F, 0x48, 0x60},
+		0x0820: {0x60},
+	}, nil)
+
+	// Using RTS as a jump does not return from sub
+	checkRoutines(t, g, []RoutineStats{
+		{Address: 0x0800, Name: "$0800", Calls: 1, Inclusive: 28, Exclusive: 6},
+		{Address: 0x0810, Name: "$0810", Calls: 1, Inclusive: 22, Exclusive: 22},
+	})
+
+	checkEdges(t, g, []CallEdge{
+		{Caller: 0x0800, Callee: 0x0810, CallSite: 0x0800, Calls: 1, Inclusive: 22, Instructions: 6},
+	})
+
+	checkDepth(t, g)
+}
+
+func TestCallGraphStackReset(t *testing.T) {
+	// main:   jsr sub
+	//         brk
+	// sub:    jsr inner
+	// inner:  ldx #$ff
+	//         txs
+	//         jsr other
+	// other:  brk
+	g, _ := runCallGraph(t, map[uint16][]byte{
+		0x0800: {0x20, 0x10, 0x08, 0x00},
+		0x0810: {0x20, 0x20, 0x08},
+		0x0820: {0xA2, 0xFF, 0x9A, 0x20, 0x30, 0x08},
+		0x0830: {0x00},
+	}, nil)
+
+	if g.TotalCycles() != 22 {
+		t.Fatalf("expected 22 clock cycles, got %d", g.TotalCycles())
+	}
+
+	// The calls abandoned by resetting the stack end with the next call, which is made by main
+	checkRoutines(t, g, []RoutineStats{
+		{Address: 0x0800, Name: "$0800", Calls: 1, Inclusive: 22, Exclusive: 6},
+		{Address: 0x0810, Name: "$0810", Calls: 1, Inclusive: 16, Exclusive: 6},
+		{Address: 0x0820, Name: "$0820", Calls: 1, Inclusive: 10, Exclusive: 10},
+		{Address: 0x0830, Name: "$0830", Calls: 1, Inclusive: 0, Exclusive: 0},
+	})
+
+	checkEdges(t, g, []CallEdge{
+		{Caller: 0x0800, Callee: 0x0810, CallSite: 0x0800, Calls: 1, Inclusive: 16, Instructions: 4},
+		{Caller: 0x0800, Callee: 0x0830, CallSite: 0x0823, Calls: 1, Inclusive: 0, Instructions: 1},
+		{Caller: 0x0810, Callee: 0x0820, CallSite: 0x0810, Calls: 1, Inclusive: 10, Instructions: 3},
+	})
+
+	checkStacks(t, g, []StackStats{
+		{Routines: []uint32{0x0800}, Cycles: 6},
+		{Routines: []uint32{0x0800, 0x0810}, Cycles: 6},
+		{Routines: []uint32{0x0800, 0x0810, 0x0820}, Cycles: 10},
+		{Routines: []uint32{0x0800, 0x0830}, Cycles: 0},
+	})
+
+	checkDepth(t, g)
+}
+
+func TestCallGraphNewRun(t *testing.T) {
+	g := NewCallGraph(nil)
+	processor := cpu.New6502(cpu.Model6502)
+	processor.Init(memory.NewLinearMemory(65536))
+	// nop
+	// brk
+	processor.CopyToMem([]byte{0xEA, 0x00}, 0x0800)
+	processor.SetTracer(g)
+
+	for i := 0; i < 2; i++ {
+		if err := processor.Run(0x0800); err != nil {
+			t.Fatalf("program failed: %v", err)
+		}
+
+		g.Finish(processor)
+	}
+
+	// The second run discards the data of the first one
+	checkRoutines(t, g, []RoutineStats{{Address: 0x0800, Name: "$0800", Calls: 1, Inclusive: 2, Exclusive: 2}})
+
+	if (g.TotalCycles() != 2) || (g.NumInstructions() != 2) {
+		t.Fatalf("unexpected totals: %d cycles, %d instructions", g.TotalCycles(), g.NumInstructions())
+	}
+}
@@ -0,0 +1,463 @@
+package profiler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Delta describes how a value has changed between two profiles
+type Delta struct {
+	Before uint64
+	After  uint64
+}
+
+// Change returns the difference between the new and the old value
+func (d Delta) Change() int64 {
+	return int64(d.After) - int64(d.Before)
+}
+
+// Percentage returns the change relative to the old value. The result is infinite if the old value
+// is zero and the new one is not.
+func (d Delta) Percentage() float64 {
+	if d.Before == 0 {
+		if d.After == 0 {
+			return 0.0
+		}
+
+		return math.Inf(1)
+	}
+
+	return 100.0 * float64(d.Change()) / float64(d.Before)
+}
+
+func (d Delta) String() string {
+	percentage := "n/a"
+	if !math.IsInf(d.Percentage(), 0) {
+		percentage = fmt.Sprintf("%+.2f%%", d.Percentage())
+	}
+
+	return fmt.Sprintf("%10d %10d %+10d %9s", d.Before, d.After, d.Change(), percentage)
+}
+
+// LabelDelta compares the data of a label. Labels are matched by their names as their addresses
+// usually differ between two versions of a program.
+type LabelDelta struct {
+	Name     string
+	Cycles   Delta
+	Accesses Delta
+}
+
+// RoutineDelta compares the data of a subroutine, which is matched by its name
+type RoutineDelta struct {
+	Name      string
+	Calls     Delta
+	Inclusive Delta
+	Exclusive Delta
+}
+
+// AddressDelta compares the number of accesses to an address
+type AddressDelta struct {
+	Address  uint16
+	Labels   []string
+	Accesses Delta
+}
+
+// ProfileDiff contains the differences between two profiles
+type ProfileDiff struct {
+	// Cycles holds the total clock cycles of both profiles. They are only compared if HasCycles is set.
+	Cycles    Delta
+	HasCycles bool
+	Accesses  Delta
+	Labels    []LabelDelta
+	Routines  []RoutineDelta
+	Addresses []AddressDelta
+}
+
+// absChange is used to sort deltas by the amount of their changes
+func absChange(d Delta) uint64 {
+	c := d.Change()
+	if c < 0 {
+		return uint64(-c)
+	}
+
+	return uint64(c)
+}
+
+// DiffProfiles compares two profiles. Labels, routines and addresses are sorted by the amount of
+// their changes in descending order. Entries which have not changed are left out. Clock cycles and
+// routines are only compared if both profiles contain clock cycles.
+func DiffProfiles(before *Profile, after *Profile) *ProfileDiff {
+	res := &ProfileDiff{
+		Labels:    []LabelDelta{},
+		Routines:  []RoutineDelta{},
+		Addresses: []AddressDelta{},
+	}
+
+	res.Cycles = Delta{Before: before.TotalCycles, After: after.TotalCycles}
+	res.HasCycles = (before.TotalCycles != 0) && (after.TotalCycles != 0)
+
+	if !res.HasCycles {
+		before = &Profile{Addresses: before.Addresses, Labels: before.Labels}
+		after = &Profile{Addresses: after.Addresses, Labels: after.Labels}
+	}
+
+	for _, j := range before.Addresses {
+		res.Accesses.Before += j.Total
+	}
+
+	for _, j := range after.Addresses {
+		res.Accesses.After += j.Total
+	}
+
+	labels := map[string]*LabelDelta{}
+	names := []string{}
+	label := func(name string) *LabelDelta {
+		l, ok := labels[name]
+		if !ok {
+			l = &LabelDelta{Name: name}
+			labels[name] = l
+			names = append(names, name)
+		}
+
+		return l
+	}
+
+	for _, j := range before.Labels {
+		l := label(j.Name)
+		if res.HasCycles {
+			l.Cycles.Before += j.Cycles
+		}
+		l.Accesses.Before += j.Total
+	}
+
+	for _, j := range after.Labels {
+		l := label(j.Name)
+		if res.HasCycles {
+			l.Cycles.After += j.Cycles
+		}
+		l.Accesses.After += j.Total
+	}
+
+	for _, j := range names {
+		if l := labels[j]; (l.Cycles.Change() != 0) || (l.Accesses.Change() != 0) {
+			res.Labels = append(res.Labels, *l)
+		}
+	}
+
+	sort.SliceStable(res.Labels, func(i, j int) bool {
+		a, b := res.Labels[i], res.Labels[j]
+		if absChange(a.Cycles) != absChange(b.Cycles) {
+			return absChange(a.Cycles) > absChange(b.Cycles)
+		}
+
+		return absChange(a.Accesses) > absChange(b.Accesses)
+	})
+
+	routines := map[string]*RoutineDelta{}
+	names = []string{}
+	routine := func(name string) *RoutineDelta {
+		r, ok := routines[name]
+		if !ok {
+			r = &RoutineDelta{Name: name}
+			routines[name] = r
+			names = append(names, name)
+		}
+
+		return r
+	}
+
+	for _, j := range before.Routines {
+		r := routine(j.Name)
+		r.Calls.Before += j.Calls
+		r.Inclusive.Before += j.Inclusive
+		r.Exclusive.Before += j.Exclusive
+	}
+
+	for _, j := range after.Routines {
+		r := routine(j.Name)
+		r.Calls.After += j.Calls
+		r.Inclusive.After += j.Inclusive
+		r.Exclusive.After += j.Exclusive
+	}
+
+	for _, j := range names {
+		if r := routines[j]; (r.Inclusive.Change() != 0) || (r.Exclusive.Change() != 0) || (r.Calls.Change() != 0) {
+			res.Routines = append(res.Routines, *r)
+		}
+	}
+
+	sort.SliceStable(res.Routines, func(i, j int) bool {
+		a, b := res.Routines[i], res.Routines[j]
+		if absChange(a.Inclusive) != absChange(b.Inclusive) {
+			return absChange(a.Inclusive) > absChange(b.Inclusive)
+		}
+
+		return absChange(a.Exclusive) > absChange(b.Exclusive)
+	})
+
+	addresses := map[uint16]*AddressDelta{}
+	address := func(a *AddressStats) *AddressDelta {
+		d, ok := addresses[a.Address]
+		if !ok {
+			d = &AddressDelta{Address: a.Address}
+			addresses[a.Address] = d
+		}
+
+		// The labels of the new version are preferred
+		if len(a.Labels) != 0 {
+			d.Labels = a.Labels
+		}
+
+		return d
+	}
+
+	for i := range before.Addresses {
+		address(&before.Addresses[i]).Accesses.Before = before.Addresses[i].Total
+	}
+
+	for i := range after.Addresses {
+		address(&after.Addresses[i]).Accesses.After = after.Addresses[i].Total
+	}
+
+	for _, j := range addresses {
+		if j.Accesses.Change() != 0 {
+			res.Addresses = append(res.Addresses, *j)
+		}
+	}
+
+	sort.Slice(res.Addresses, func(i, j int) bool {
+		a, b := res.Addresses[i], res.Addresses[j]
+		if absChange(a.Accesses) != absChange(b.Accesses) {
+			return absChange(a.Accesses) > absChange(b.Accesses)
+		}
+
+		return a.Address < b.Address
+	})
+
+	return res
+}
+
+// Write prints the total changes followed by at most top labels, routines and addresses
+func (d *ProfileDiff) Write(w io.Writer, top int) error {
+	limit := func(n int) int {
+		if n > top {
+			return top
+		}
+
+		return n
+	}
+
+	b := bufio.NewWriter(w)
+	columns := fmt.Sprintf("%10s %10s %10s %9s", "Before", "After", "Delta", "%")
+
+	if !d.HasCycles {
+		fmt.Fprintf(b, "Clock cycles are not compared as %s.\n\n", d.missingCycles())
+	}
+
+	fmt.Fprintf(b, "%-15s %s\n", "", columns)
+	if d.HasCycles {
+		fmt.Fprintf(b, "%-15s %s\n", "Total cycles", d.Cycles)
+	}
+	fmt.Fprintf(b, "%-15s %s\n", "Total accesses", d.Accesses)
+
+	if (len(d.Labels) != 0) && !d.HasCycles {
+		fmt.Fprintf(b, "\nLabels (accesses from the label up to the next one)\n\n")
+		fmt.Fprintf(b, "%s  %s\n", columns, "Label")
+
+		for _, j := range d.Labels[:limit(len(d.Labels))] {
+			fmt.Fprintf(b, "%s  %s\n", j.Accesses, j.Name)
+		}
+	}
+
+	if (len(d.Labels) != 0) && d.HasCycles {
+		fmt.Fprintf(b, "\nLabels (clock cycles and accesses from the label up to the next one)\n\n")
+		fmt.Fprintf(b, "%-42s %s\n", "Clock cycles", "Accesses")
+		fmt.Fprintf(b, "%s %s  %s\n", columns, columns, "Label")
+
+		for _, j := range d.Labels[:limit(len(d.Labels))] {
+			fmt.Fprintf(b, "%s %s  %s\n", j.Cycles, j.Accesses, j.Name)
+		}
+	}
+
+	if len(d.Routines) != 0 {
+		fmt.Fprintf(b, "\nSubroutines (inclusive and exclusive clock cycles)\n\n")
+		fmt.Fprintf(b, "%-42s %-42s %s\n", "Inclusive clock cycles", "Exclusive clock cycles", "Calls")
+		fmt.Fprintf(b, "%s %s %10s %10s  %s\n", columns, columns, "Before", "After", "Routine")
+
+		for _, j := range d.Routines[:limit(len(d.Routines))] {
+			fmt.Fprintf(b, "%s %s %10d %10d  %s\n", j.Inclusive, j.Exclusive, j.Calls.Before, j.Calls.After, j.Name)
+		}
+	}
+
+	if len(d.Addresses) != 0 {
+		fmt.Fprintf(b, "\nAddresses with the largest changes in accesses\n\n")
+		fmt.Fprintf(b, "%-7s %s  %s\n", "Address", columns, "Labels")
+
+		for _, j := range d.Addresses[:limit(len(d.Addresses))] {
+			fmt.Fprintln(b, strings.TrimRight(fmt.Sprintf("$%04X   %s  %s", j.Address, j.Accesses, strings.Join(j.Labels, " ")), " "))
+		}
+	}
+
+	return b.Flush()
+}
+
+// missingCycles tells which of the profiles does not contain clock cycles
+func (d *ProfileDiff) missingCycles() string {
+	switch {
+	case (d.Cycles.Before == 0) && (d.Cycles.After == 0):
+		return "neither profile contains them"
+	case d.Cycles.Before == 0:
+		return "the first profile does not contain them"
+	default:
+		return "the second profile does not contain them"
+	}
+}
+
+// CheckThreshold returns an error if the total clock cycles have increased by more than threshold
+// percent. Profiles without clock cycles are compared by their total number of accesses. A negative
+// threshold disables the check.
+func (d *ProfileDiff) CheckThreshold(threshold float64) error {
+	if threshold < 0.0 {
+		return nil
+	}
+
+	total, name := d.Cycles, "clock cycles"
+	if !d.HasCycles {
+		total, name = d.Accesses, "accesses"
+	}
+
+	if total.Percentage() <= threshold {
+		return nil
+	}
+
+	if total.Before == 0 {
+		return fmt.Errorf("total %s have increased from 0 to %d, which exceeds the threshold of %.2f%%", name, total.After, threshold)
+	}
+
+	return fmt.Errorf("total %s have increased by %.2f%%, which exceeds the threshold of %.2f%%", name, total.Percentage(), threshold)
+}
+
+// textProfileLine matches an address line written by DumpStatistics
+var textProfileLine = regexp.MustCompile(`^(?:###)?\s+([[:xdigit:]]{4}): ([[:xdigit:]]{2}) (\d+)\s*(.*)$`)
+
+// readTextProfile parses the output of DumpStatistics. Such files do not contain clock cycles.
+func readTextProfile(r io.Reader, program string) (*Profile, error) {
+	res := &Profile{Program: program, Addresses: []AddressStats{}, Routines: []*RoutineStats{}}
+	labels := []string{}
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		matches := textProfileLine.FindStringSubmatch(line)
+		if matches == nil {
+			if strings.ContainsAny(line, " \t") {
+				return nil, fmt.Errorf("line %d: can not parse '%s'", lineNum, line)
+			}
+
+			labels = append(labels, line)
+			continue
+		}
+
+		// Can not fail as the regex ensures that only valid numbers are parsed
+		addr, _ := strconv.ParseUint(matches[1], 16, 16)
+		value, _ := strconv.ParseUint(matches[2], 16, 8)
+		total, _ := strconv.ParseUint(matches[3], 10, 64)
+
+		if (len(res.Addresses) != 0) && (uint16(addr) != res.Addresses[len(res.Addresses)-1].Address+1) {
+			return nil, fmt.Errorf("line %d: address $%04x does not follow the previous one", lineNum, addr)
+		}
+
+		a := AddressStats{Address: uint16(addr), Value: uint8(value), Labels: labels, Total: total}
+		labels = nil
+
+		// The access counters are followed by the instruction
+		fields := strings.Fields(matches[4])
+		for len(fields) != 0 {
+			kind, count, ok := strings.Cut(fields[0], "=")
+			n, err := strconv.ParseUint(count, 10, 64)
+			if !ok || (err != nil) {
+				break
+			}
+
+			switch kind {
+			case "opcode":
+				a.Opcode = n
+			case "operand":
+				a.Operand = n
+			case "read":
+				a.Read = n
+			case "write":
+				a.Write = n
+			}
+
+			fields = fields[1:]
+		}
+
+		a.Instruction = strings.Join(fields, " ")
+		res.Addresses = append(res.Addresses, a)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(res.Addresses) == 0 {
+		return nil, fmt.Errorf("no address lines found")
+	}
+
+	res.sumLabels()
+
+	return res, nil
+}
+
+// ReadProfile reads a profile written in the JSON or text format
+func ReadProfile(r io.Reader, program string) (*Profile, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return readTextProfile(bytes.NewReader(data), program)
+	}
+
+	res := &Profile{}
+	if err = json.Unmarshal(data, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// LoadProfile reads the named profile, which has been written in the JSON or text format
+func LoadProfile(fileName string) (*Profile, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load profile: %v", err)
+	}
+	defer func() { f.Close() }()
+
+	res, err := ReadProfile(f, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse profile '%s': %v", fileName, err)
+	}
+
+	return res, nil
+}
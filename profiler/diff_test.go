@@ -0,0 +1,214 @@
+package profiler
+
+import (
+	"6502profiler/cpu"
+	"6502profiler/disasm"
+	"6502profiler/memory"
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffProfiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   *Profile
+		after    *Profile
+		expected *ProfileDiff
+	}{
+		{
+			name:   "labels are matched by name",
+			before: &Profile{TotalCycles: 200, Labels: []LabelStats{{Name: "loop", Address: 0x0810, Cycles: 100, Total: 50}, {Name: "same", Address: 0x0820, Cycles: 10, Total: 5}}},
+			after:  &Profile{TotalCycles: 150, Labels: []LabelStats{{Name: "same", Address: 0x0830, Cycles: 10, Total: 5}, {Name: "loop", Address: 0x0820, Cycles: 60, Total: 30}}},
+			expected: &ProfileDiff{
+				Cycles:    Delta{Before: 200, After: 150},
+				HasCycles: true,
+				Labels:    []LabelDelta{{Name: "loop", Cycles: Delta{Before: 100, After: 60}, Accesses: Delta{Before: 50, After: 30}}},
+				Routines:  []RoutineDelta{},
+				Addresses: []AddressDelta{},
+			},
+		},
+		{
+			name:   "routines are matched by name",
+			before: &Profile{TotalCycles: 100, Routines: []*RoutineStats{{Address: 0x0800, Name: "work", Calls: 2, Inclusive: 100, Exclusive: 80}}},
+			after: &Profile{TotalCycles: 130, Routines: []*RoutineStats{
+				{Address: 0x0900, Name: "new", Calls: 1, Inclusive: 10, Exclusive: 10},
+				{Address: 0x0910, Name: "work", Calls: 2, Inclusive: 120, Exclusive: 80},
+			}},
+			expected: &ProfileDiff{
+				Cycles:    Delta{Before: 100, After: 130},
+				HasCycles: true,
+				Labels:    []LabelDelta{},
+				Routines: []RoutineDelta{
+					{Name: "work", Calls: Delta{Before: 2, After: 2}, Inclusive: Delta{Before: 100, After: 120}, Exclusive: Delta{Before: 80, After: 80}},
+					{Name: "new", Calls: Delta{Before: 0, After: 1}, Inclusive: Delta{Before: 0, After: 10}, Exclusive: Delta{Before: 0, After: 10}},
+				},
+				Addresses: []AddressDelta{},
+			},
+		},
+		{
+			name:   "addresses are matched by address",
+			before: &Profile{Addresses: []AddressStats{{Address: 0x0800, Total: 5}, {Address: 0x0801, Total: 3, Labels: []string{"old"}}, {Address: 0x0802, Total: 2}}},
+			after:  &Profile{Addresses: []AddressStats{{Address: 0x0801, Total: 7, Labels: []string{"new"}}, {Address: 0x0802, Total: 2}, {Address: 0x0803, Total: 1}}},
+			expected: &ProfileDiff{
+				Accesses: Delta{Before: 10, After: 10},
+				Labels:   []LabelDelta{},
+				Routines: []RoutineDelta{},
+				Addresses: []AddressDelta{
+					{Address: 0x0800, Accesses: Delta{Before: 5, After: 0}},
+					{Address: 0x0801, Labels: []string{"new"}, Accesses: Delta{Before: 3, After: 7}},
+					{Address: 0x0803, Accesses: Delta{Before: 0, After: 1}},
+				},
+			},
+		},
+		{
+			name:   "clock cycles are only compared if both profiles contain them",
+			before: &Profile{Labels: []LabelStats{{Name: "loop", Total: 50}}},
+			after: &Profile{TotalCycles: 80, Labels: []LabelStats{{Name: "loop", Cycles: 80, Total: 40}},
+				Routines: []*RoutineStats{{Address: 0x0800, Name: "main", Calls: 1, Inclusive: 80, Exclusive: 80}}},
+			expected: &ProfileDiff{
+				Cycles:    Delta{Before: 0, After: 80},
+				Labels:    []LabelDelta{{Name: "loop", Accesses: Delta{Before: 50, After: 40}}},
+				Routines:  []RoutineDelta{},
+				Addresses: []AddressDelta{},
+			},
+		},
+	}
+
+	for _, j := range tests {
+		t.Run(j.name, func(t *testing.T) {
+			if res := DiffProfiles(j.before, j.after); !reflect.DeepEqual(res, j.expected) {
+				t.Fatalf("expected %+v, got %+v", *j.expected, *res)
+			}
+		})
+	}
+}
+
+func TestDiffWithoutCycles(t *testing.T) {
+	before := &Profile{Addresses: []AddressStats{{Address: 0x0800, Labels: []string{"main"}, Total: 5}}}
+	after := &Profile{TotalCycles: 20, Addresses: []AddressStats{{Address: 0x0800, Labels: []string{"main"}, Total: 4}}}
+	noCycles := &Profile{Addresses: []AddressStats{{Address: 0x0800, Labels: []string{"main"}, Total: 4}}}
+	before.sumLabels()
+	after.sumLabels()
+	noCycles.sumLabels()
+
+	for _, j := range []struct {
+		diff *ProfileDiff
+		note string
+	}{
+		{DiffProfiles(before, after), "the first profile does not contain them"},
+		{DiffProfiles(after, before), "the second profile does not contain them"},
+		{DiffProfiles(before, noCycles), "neither profile contains them"},
+	} {
+		out := &bytes.Buffer{}
+		if err := j.diff.Write(out, 10); err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(out.String(), "Clock cycles are not compared as "+j.note+".\n\n") || !strings.Contains(out.String(), "Labels (accesses from the label up to the next one)") || strings.Contains(out.String(), "Clock cycles  ") || strings.Contains(out.String(), "Total cycles") {
+			t.Fatalf("missing note about clock cycles:\n%s", out.String())
+		}
+	}
+}
+
+func TestCheckThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		diff      ProfileDiff
+		threshold float64
+		message   string
+	}{
+		{"negative threshold disables the check", ProfileDiff{Cycles: Delta{100, 200}, HasCycles: true}, -1.0, ""},
+		{"increase below the threshold", ProfileDiff{Cycles: Delta{100, 105}, HasCycles: true}, 10.0, ""},
+		{"increase at the threshold", ProfileDiff{Cycles: Delta{100, 110}, HasCycles: true}, 10.0, ""},
+		{"increase above the threshold", ProfileDiff{Cycles: Delta{100, 111}, HasCycles: true}, 10.0, "total clock cycles have increased by 11.00%"},
+		{"decrease", ProfileDiff{Cycles: Delta{100, 50}, HasCycles: true}, 0.0, ""},
+		{"accesses without clock cycles", ProfileDiff{Cycles: Delta{0, 500}, Accesses: Delta{100, 120}}, 10.0, "total accesses have increased by 20.00%"},
+		{"increase from zero", ProfileDiff{Accesses: Delta{0, 10}}, 50.0, "total accesses have increased from 0 to 10"},
+		{"no accesses", ProfileDiff{}, 0.0, ""},
+	}
+
+	for _, j := range tests {
+		t.Run(j.name, func(t *testing.T) {
+			err := j.diff.CheckThreshold(j.threshold)
+
+			if j.message == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				return
+			}
+
+			if (err == nil) || !strings.Contains(err.Error(), j.message) {
+				t.Fatalf("expected '%s', got %v", j.message, err)
+			}
+		})
+	}
+}
+
+// runProfiledProgram runs a program which reads, writes and executes its own bytes and returns the
+// memory containing its access statistics
+func runProfiledProgram(t *testing.T) memory.Memory {
+	t.Helper()
+
+	// main  lda data
+	//       sta data+1
+	//       brk
+	// data  !byte 5, 0
+	processor := cpu.New6502(cpu.Model6502)
+	mem := memory.NewLinearMemory(65536)
+	processor.Init(mem)
+	processor.CopyToMem([]byte{0xAD, 0x07, 0x08, 0x8D, 0x08, 0x08, 0x00, 0x05, 0x00}, 0x0800)
+
+	if err := processor.Run(0x0800); err != nil {
+		t.Fatalf("program failed: %v", err)
+	}
+
+	return mem
+}
+
+func TestTextProfileRoundTrip(t *testing.T) {
+	labels := map[uint16][]string{0x0800: {"main"}, 0x0807: {"data", "table"}}
+	dis := disasm.New(cpu.Model6502)
+	fileName := filepath.Join(t.TempDir(), "profile.txt")
+
+	mem := runProfiledProgram(t)
+	cutOff := func(m memory.Memory, start uint16, end uint16) uint64 { return 2 }
+	if err := DumpStatistics(mem, fileName, labels, 0x0800, 0x0808, cutOff, dis); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := NewProfile(fileName, mem, labels, 0x0800, 0x0808, nil, dis)
+
+	res, err := LoadProfile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if (res.Program != fileName) || (res.TotalCycles != 0) || (len(res.Routines) != 0) || (len(res.Addresses) != len(expected.Addresses)) {
+		t.Fatalf("unexpected profile %+v", *res)
+	}
+
+	for i, j := range expected.Addresses {
+		a := res.Addresses[i]
+		if (len(a.Labels) != len(j.Labels)) || (strings.Join(a.Labels, " ") != strings.Join(j.Labels, " ")) {
+			t.Fatalf("expected labels %v, got %v", j.Labels, a.Labels)
+		}
+
+		a.Labels = j.Labels
+		if !reflect.DeepEqual(a, j) {
+			t.Fatalf("expected %+v, got %+v", j, a)
+		}
+	}
+
+	if !reflect.DeepEqual(res.Labels, expected.Labels) {
+		t.Fatalf("expected labels %+v, got %+v", expected.Labels, res.Labels)
+	}
+
+	if (expected.Addresses[7].Read != 1) || (expected.Addresses[8].Write != 1) || (expected.Addresses[1].Operand != 1) {
+		t.Fatalf("program has not accessed its data: %+v", expected.Addresses)
+	}
+}
@@ -0,0 +1,300 @@
+package profiler
+
+import (
+	"6502profiler/disasm"
+	"6502profiler/memory"
+	"6502profiler/srcmap"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Formats of the profile data
+const FormatText = "text"
+const FormatCallgrind = "callgrind"
+const FormatFolded = "folded"
+const FormatJSON = "json"
+const FormatCSV = "csv"
+
+// Formats contains all supported formats of the profile data
+var Formats = []string{FormatText, FormatCallgrind, FormatFolded, FormatJSON, FormatCSV}
+
+// NeedsCallGraph returns true if the profile data in the given format is created from a CallGraph
+func NeedsCallGraph(format string) bool {
+	return format != FormatText
+}
+
+// AddressStats describes the accesses to a byte of the program
+type AddressStats struct {
+	Address uint16
+	Value   uint8
+	Labels  []string `json:",omitempty"`
+	Total   uint64
+	Opcode  uint64
+	Operand uint64
+	Read    uint64
+	Write   uint64
+	// Cycles counts the clock cycles used by the instruction which begins at this address
+	Cycles      uint64
+	Instruction string `json:",omitempty"`
+}
+
+// LabelStats sums up the accesses to the bytes from the address of a label up to the next label
+type LabelStats struct {
+	Name    string
+	Address uint16
+	Size    int
+	Total   uint64
+	Opcode  uint64
+	Operand uint64
+	Read    uint64
+	Write   uint64
+	Cycles  uint64
+}
+
+// Profile contains the data of a program run which is written in the JSON and CSV formats
+type Profile struct {
+	Program     string
+	TotalCycles uint64
+	Addresses   []AddressStats
+	Labels      []LabelStats
+	Routines    []*RoutineStats
+}
+
+// readAccesses returns the access statistics and the contents of the memory between start and end.
+// The write access caused by loading the program is not counted. The statistics are not changed.
+func readAccesses(m memory.Memory, start uint16, end uint16) ([]memory.AccessStats, []byte) {
+	// Memories which can not be inspected count the reads of their contents. The statistics
+	// therefore have to be retrieved first.
+	accesses := []memory.AccessStats{}
+	for count := uint32(start); count <= uint32(end); count++ {
+		stats := m.GetAccessStatistics(uint16(count))
+
+		// Loading the program has written each byte once
+		if stats[memory.AccessDataWrite] != 0 {
+			stats[memory.AccessDataWrite]--
+		}
+
+		accesses = append(accesses, stats)
+	}
+
+	code := []byte{}
+	for count := uint32(start); count <= uint32(end); count++ {
+		code = append(code, memory.Peek(m, uint16(count)))
+	}
+
+	return accesses, code
+}
+
+// NewProfile collects the data of the program stored between start and end. g may be nil if no call
+// graph has been recorded and dis may be nil if no instructions are to be disassembled.
+func NewProfile(program string, m memory.Memory, labels map[uint16][]string, start uint16, end uint16, g *CallGraph, dis *disasm.Disassembler) *Profile {
+	accesses, code := readAccesses(m, start, end)
+
+	res := &Profile{Program: program, Addresses: []AddressStats{}, Labels: []LabelStats{}, Routines: []*RoutineStats{}}
+
+	cycles := map[uint32]uint64{}
+	if g != nil {
+		res.TotalCycles = g.TotalCycles()
+		res.Routines = g.Routines()
+
+		for _, j := range g.Instructions() {
+			cycles[j.PC] += j.Cycles
+		}
+	}
+
+	instructions := map[uint16]string{}
+	if dis != nil {
+		for _, j := range dis.Range(code, uint32(start)) {
+			instructions[uint16(j.Address)] = j.Text()
+		}
+	}
+
+	for i, stats := range accesses {
+		addr := start + uint16(i)
+
+		a := AddressStats{
+			Address:     addr,
+			Value:       code[i],
+			Labels:      labels[addr],
+			Total:       stats.Total(),
+			Opcode:      stats[memory.AccessOpcodeFetch],
+			Operand:     stats[memory.AccessOperandFetch],
+			Read:        stats[memory.AccessDataRead],
+			Write:       stats[memory.AccessDataWrite],
+			Cycles:      cycles[uint32(addr)],
+			Instruction: instructions[addr],
+		}
+
+		res.Addresses = append(res.Addresses, a)
+	}
+
+	res.sumLabels()
+
+	return res
+}
+
+// sumLabels adds the accesses and clock cycles of the addresses to the labels which cover them.
+// Addresses have to be stored in ascending order without gaps.
+func (p *Profile) sumLabels() {
+	p.Labels = []LabelStats{}
+
+	for _, a := range p.Addresses {
+		for _, j := range a.Labels {
+			p.Labels = append(p.Labels, LabelStats{Name: j, Address: a.Address})
+		}
+	}
+
+	// All labels of an address cover the same bytes
+	for i := range p.Labels {
+		l := &p.Labels[i]
+
+		for _, a := range p.Addresses[l.Address-p.Addresses[0].Address:] {
+			if (a.Address != l.Address) && (len(a.Labels) != 0) {
+				break
+			}
+
+			l.Size++
+			l.Total += a.Total
+			l.Opcode += a.Opcode
+			l.Operand += a.Operand
+			l.Read += a.Read
+			l.Write += a.Write
+			l.Cycles += a.Cycles
+		}
+	}
+}
+
+// WriteJSON writes the profile as a JSON object
+func (p *Profile) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+
+	return enc.Encode(p)
+}
+
+// WriteCSV writes one record for each address and each label. The first column tells which kind of
+// record it is.
+func (p *Profile) WriteCSV(w io.Writer) error {
+	c := csv.NewWriter(w)
+	num := func(v uint64) string { return fmt.Sprint(v) }
+
+	_ = c.Write([]string{"Type", "Address", "Name", "Value", "Size", "Total", "Opcode", "Operand", "Read", "Write", "Cycles", "Instruction"})
+
+	for _, a := range p.Addresses {
+		_ = c.Write([]string{"address", fmt.Sprintf("$%04X", a.Address), strings.Join(a.Labels, " "), fmt.Sprintf("$%02X", a.Value), "1",
+			num(a.Total), num(a.Opcode), num(a.Operand), num(a.Read), num(a.Write), num(a.Cycles), a.Instruction})
+	}
+
+	for _, l := range p.Labels {
+		_ = c.Write([]string{"label", fmt.Sprintf("$%04X", l.Address), l.Name, "", fmt.Sprint(l.Size),
+			num(l.Total), num(l.Opcode), num(l.Operand), num(l.Read), num(l.Write), num(l.Cycles), ""})
+	}
+
+	c.Flush()
+
+	return c.Error()
+}
+
+// WriteFolded writes the call chains in the folded stack format which is read by flame graph
+// generators. Each line contains the routines of a chain separated by semicolons followed by the
+// number of clock cycles used by the last routine.
+func (g *CallGraph) WriteFolded(w io.Writer) error {
+	for _, j := range g.Stacks() {
+		if j.Cycles == 0 {
+			continue
+		}
+
+		names := make([]string, len(j.Routines))
+		for i, addr := range j.Routines {
+			names[i] = g.Name(addr)
+		}
+
+		if _, err := fmt.Fprintf(w, "%s %d\n", strings.Join(names, ";"), j.Cycles); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// callgrindPos returns the position of an address in a callgrind file, i.e. the address followed by
+// the source line
+func callgrindPos(addr uint32, src *srcmap.Map) string {
+	line := 0
+	if src != nil {
+		if loc, ok := src.Lookup(addr); ok {
+			line = loc.Line
+		}
+	}
+
+	return fmt.Sprintf("0x%04X %d", addr, line)
+}
+
+// WriteCallgrind writes the call graph in the format of callgrind, which can be viewed with
+// KCachegrind. If src is not nil the costs are assigned to the source lines it contains.
+func (g *CallGraph) WriteCallgrind(w io.Writer, program string, src *srcmap.Map) error {
+	file := func(addr uint32) string {
+		if src != nil {
+			if loc, ok := src.Lookup(addr); ok {
+				return loc.File
+			}
+		}
+
+		return program
+	}
+
+	b := &strings.Builder{}
+
+	fmt.Fprintln(b, "# callgrind format")
+	fmt.Fprintln(b, "version: 1")
+	fmt.Fprintln(b, "creator: 6502profiler")
+	fmt.Fprintf(b, "cmd: %s\n", program)
+	fmt.Fprintln(b, "positions: instr line")
+	fmt.Fprintln(b, "events: Cycles Instructions")
+	fmt.Fprintf(b, "summary: %d %d\n", g.TotalCycles(), g.NumInstructions())
+
+	instructions := map[uint32][]*InstructionStats{}
+	for _, j := range g.Instructions() {
+		instructions[j.Routine] = append(instructions[j.Routine], j)
+	}
+
+	calls := map[uint32][]*CallEdge{}
+	for _, j := range g.Edges() {
+		calls[j.Caller] = append(calls[j.Caller], j)
+	}
+
+	routines := g.Routines()
+	sort.Slice(routines, func(i, j int) bool { return routines[i].Address < routines[j].Address })
+
+	for _, r := range routines {
+		current := file(r.Address)
+
+		fmt.Fprintf(b, "\nob=%s\n", program)
+		fmt.Fprintf(b, "fl=%s\n", current)
+		fmt.Fprintf(b, "fn=%s\n", g.Name(r.Address))
+
+		for _, j := range instructions[r.Address] {
+			if f := file(j.PC); f != current {
+				current = f
+				fmt.Fprintf(b, "fi=%s\n", current)
+			}
+
+			fmt.Fprintf(b, "%s %d %d\n", callgrindPos(j.PC, src), j.Cycles, j.Executions)
+		}
+
+		for _, j := range calls[r.Address] {
+			fmt.Fprintf(b, "cfl=%s\n", file(j.Callee))
+			fmt.Fprintf(b, "cfn=%s\n", g.Name(j.Callee))
+			fmt.Fprintf(b, "calls=%d %s\n", j.Calls, callgrindPos(j.Callee, src))
+			fmt.Fprintf(b, "%s %d %d\n", callgrindPos(j.CallSite, src), j.Inclusive, j.Instructions)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
@@ -0,0 +1,272 @@
+package profiler
+
+import (
+	"6502profiler/cpu"
+	"6502profiler/disasm"
+	"6502profiler/srcmap"
+	"bytes"
+	"testing"
+)
+
+var exportLabels = map[uint16][]string{0x0800: {"main"}, 0x0804: {"sub"}, 0x0808: {"data"}}
+
+// runExportProgram runs a program which calls a subroutine reading a data byte
+func runExportProgram(t *testing.T) (*CallGraph, *cpu.CPU6502) {
+	t.Helper()
+
+	// main  jsr sub
+	//       brk
+	// sub   lda data
+	//       rts
+	// data  !byte 7
+	return runCallGraph(t, map[uint16][]byte{0x0800: {0x20, 0x04, 0x08, 0x00, 0xAD, 0x08, 0x08, 0x60, 0x07}}, exportLabels)
+}
+
+// recordExport returns the call graph and the profile of the program run by runExportProgram
+func recordExport(t *testing.T) (*CallGraph, *Profile) {
+	t.Helper()
+
+	g, p := runExportProgram(t)
+
+	return g, NewProfile("test.bin", p.GetMem(), exportLabels, 0x0804, 0x0808, g, disasm.New(cpu.Model6502))
+}
+
+func checkGolden(t *testing.T, format string, out *bytes.Buffer, expected string) {
+	t.Helper()
+
+	if out.String() != expected {
+		t.Fatalf("unexpected %s output:\n%s\nexpected:\n%s", format, out.String(), expected)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	_, prof := recordExport(t)
+
+	out := &bytes.Buffer{}
+	if err := prof.WriteJSON(out); err != nil {
+		t.Fatal(err)
+	}
+
+	checkGolden(t, "JSON", out, `{
+    "Program": "test.bin",
+    "TotalCycles": 16,
+    "Addresses": [
+        {
+            "Address": 2052,
+            "Value": 173,
+            "Labels": [
+                "sub"
+            ],
+            "Total": 1,
+            "Opcode": 1,
+            "Operand": 0,
+            "Read": 0,
+            "Write": 0,
+            "Cycles": 4,
+            "Instruction": "LDA $0808"
+        },
+        {
+            "Address": 2053,
+            "Value": 8,
+            "Total": 1,
+            "Opcode": 0,
+            "Operand": 1,
+            "Read": 0,
+            "Write": 0,
+            "Cycles": 0
+        },
+        {
+            "Address": 2054,
+            "Value": 8,
+            "Total": 1,
+            "Opcode": 0,
+            "Operand": 1,
+            "Read": 0,
+            "Write": 0,
+            "Cycles": 0
+        },
+        {
+            "Address": 2055,
+            "Value": 96,
+            "Total": 1,
+            "Opcode": 1,
+            "Operand": 0,
+            "Read": 0,
+            "Write": 0,
+            "Cycles": 6,
+            "Instruction": "RTS"
+        },
+        {
+            "Address": 2056,
+            "Value": 7,
+            "Labels": [
+                "data"
+            ],
+            "Total": 1,
+            "Opcode": 0,
+            "Operand": 0,
+            "Read": 1,
+            "Write": 0,
+            "Cycles": 0,
+            "Instruction": "???"
+        }
+    ],
+    "Labels": [
+        {
+            "Name": "sub",
+            "Address": 2052,
+            "Size": 4,
+            "Total": 4,
+            "Opcode": 2,
+            "Operand": 2,
+            "Read": 0,
+            "Write": 0,
+            "Cycles": 10
+        },
+        {
+            "Name": "data",
+            "Address": 2056,
+            "Size": 1,
+            "Total": 1,
+            "Opcode": 0,
+            "Operand": 0,
+            "Read": 1,
+            "Write": 0,
+            "Cycles": 0
+        }
+    ],
+    "Routines": [
+        {
+            "Address": 2048,
+            "Name": "main",
+            "Calls": 1,
+            "Inclusive": 16,
+            "Exclusive": 6
+        },
+        {
+            "Address": 2052,
+            "Name": "sub",
+            "Calls": 1,
+            "Inclusive": 10,
+            "Exclusive": 10
+        }
+    ]
+}
+`)
+
+	// A JSON profile can be read back
+	res, err := ReadProfile(out, "test.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if (res.TotalCycles != 16) || (len(res.Addresses) != 5) || (len(res.Labels) != 2) || (len(res.Routines) != 2) || (*res.Routines[1] != *prof.Routines[1]) {
+		t.Fatalf("profile not read back: %+v", *res)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	_, prof := recordExport(t)
+
+	out := &bytes.Buffer{}
+	if err := prof.WriteCSV(out); err != nil {
+		t.Fatal(err)
+	}
+
+	checkGolden(t, "CSV", out, `Type,Address,Name,Value,Size,Total,Opcode,Operand,Read,Write,Cycles,Instruction
+address,$0804,sub,$AD,1,1,1,0,0,0,4,LDA $0808
+address,$0805,,$08,1,1,0,1,0,0,0,
+address,$0806,,$08,1,1,0,1,0,0,0,
+address,$0807,,$60,1,1,1,0,0,0,6,RTS
+address,$0808,data,$07,1,1,0,0,1,0,0,???
+label,$0804,sub,,4,4,2,2,0,0,10,
+label,$0808,data,,1,1,0,0,1,0,0,
+`)
+}
+
+func TestWriteFolded(t *testing.T) {
+	g, _ := recordExport(t)
+
+	out := &bytes.Buffer{}
+	if err := g.WriteFolded(out); err != nil {
+		t.Fatal(err)
+	}
+
+	checkGolden(t, "folded", out, "main 6\nmain;sub 10\n")
+}
+
+func TestWriteCallgrind(t *testing.T) {
+	g, _ := recordExport(t)
+
+	out := &bytes.Buffer{}
+	if err := g.WriteCallgrind(out, "test.bin", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The costs of the call are given at the call site
+	checkGolden(t, "callgrind", out, `# callgrind format
+version: 1
+creator: 6502profiler
+cmd: test.bin
+positions: instr line
+events: Cycles Instructions
+summary: 16 4
+
+ob=test.bin
+fl=test.bin
+fn=main
+0x0800 0 6 1
+0x0803 0 0 1
+cfl=test.bin
+cfn=sub
+calls=1 0x0804 0
+0x0800 0 10 2
+
+ob=test.bin
+fl=test.bin
+fn=sub
+0x0804 0 4 1
+0x0807 0 6 1
+`)
+}
+
+func TestWriteCallgrindWithSource(t *testing.T) {
+	g, _ := recordExport(t)
+
+	src := srcmap.New()
+	src.Add(0x0800, 3, srcmap.Location{File: "main.a", Line: 2})
+	src.Add(0x0803, 1, srcmap.Location{File: "main.a", Line: 3})
+	src.Add(0x0804, 3, srcmap.Location{File: "sub.a", Line: 2})
+	src.Add(0x0807, 1, srcmap.Location{File: "macros.a", Line: 7})
+
+	out := &bytes.Buffer{}
+	if err := g.WriteCallgrind(out, "test.bin", src); err != nil {
+		t.Fatal(err)
+	}
+
+	checkGolden(t, "callgrind", out, `# callgrind format
+version: 1
+creator: 6502profiler
+cmd: test.bin
+positions: instr line
+events: Cycles Instructions
+summary: 16 4
+
+ob=test.bin
+fl=main.a
+fn=main
+0x0800 2 6 1
+0x0803 3 0 1
+cfl=sub.a
+cfn=sub
+calls=1 0x0804 2
+0x0800 2 10 2
+
+ob=test.bin
+fl=sub.a
+fn=sub
+0x0804 2 4 1
+fi=macros.a
+0x0807 7 6 1
+`)
+}
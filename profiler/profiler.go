@@ -1,14 +1,33 @@
 package profiler
 
 import (
+	"6502profiler/disasm"
 	"6502profiler/memory"
 	"fmt"
 	"os"
+	"strings"
 )
 
 type CutOffCalc func(m memory.Memory, start uint16, end uint16) uint64
 
-func DumpStatistics(m memory.Memory, fileName string, acmeLabels map[uint16][]string, start uint16, end uint16, determineCutOffValue CutOffCalc) error {
+// formatAccesses lists the non zero access counters of an address by kind
+func formatAccesses(a memory.AccessStats) string {
+	res := []string{}
+
+	for i, j := range a {
+		if j != 0 {
+			res = append(res, fmt.Sprintf("%s=%d", memory.AccessKind(i), j))
+		}
+	}
+
+	return strings.Join(res, " ")
+}
+
+// DumpStatistics writes the number of accesses to each address between start and end to the
+// named file. The total is followed by the number of opcode fetches, operand fetches, data reads
+// and data writes. If dis is not nil the disassembled instructions are added to the lines of the
+// addresses at which they begin.
+func DumpStatistics(m memory.Memory, fileName string, acmeLabels map[uint16][]string, start uint16, end uint16, determineCutOffValue CutOffCalc, dis *disasm.Disassembler) error {
 	f, err := os.Create(fileName)
 	if err != nil {
 		return err
@@ -18,7 +37,18 @@ func DumpStatistics(m memory.Memory, fileName string, acmeLabels map[uint16][]st
 
 	cutOff := determineCutOffValue(m, start, end)
 
-	for count := start; count <= end; count++ {
+	accesses, code := readAccesses(m, start, end)
+
+	instructions := map[uint16]disasm.Instruction{}
+	if dis != nil {
+		for _, j := range dis.Range(code, uint32(start)) {
+			instructions[uint16(j.Address)] = j
+		}
+	}
+
+	for i, stats := range accesses {
+		count := start + uint16(i)
+
 		labels, ok := acmeLabels[count]
 		if ok {
 			for _, j := range labels {
@@ -26,17 +56,20 @@ func DumpStatistics(m memory.Memory, fileName string, acmeLabels map[uint16][]st
 			}
 		}
 
-		numAccess := m.GetStatistics(count)
-		if numAccess != 0 {
-			numAccess -= 1
-		}
+		numAccess := stats.Total()
 
 		prefix := "     "
 		if numAccess >= cutOff {
 			prefix = "###  "
 		}
 
-		fmt.Fprintf(f, "%s%04x: %02X %d\n", prefix, count, m.Load(count), numAccess)
+		instr, ok := instructions[count]
+		if !ok {
+			fmt.Fprintln(f, strings.TrimRight(fmt.Sprintf("%s%04x: %02X %-10d %s", prefix, count, code[i], numAccess, formatAccesses(stats)), " "))
+			continue
+		}
+
+		fmt.Fprintf(f, "%s%04x: %02X %-10d %-36s %s\n", prefix, count, code[i], numAccess, formatAccesses(stats), instr.Text())
 	}
 
 	return nil
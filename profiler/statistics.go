@@ -5,11 +5,30 @@ import (
 	"sort"
 )
 
+// ValueCutOffCalc determines the lowest value which is regarded as a hot spot
+type ValueCutOffCalc func(values []uint64) uint64
+
+func accessNumbers(m memory.Memory, start uint16, end uint16) []uint64 {
+	res := []uint64{}
+
+	for count := uint32(start); count <= uint32(end); count++ {
+		res = append(res, m.GetStatistics(uint16(count)))
+	}
+
+	return res
+}
+
 func CutOffAbsoluteValue(m memory.Memory, start uint16, end uint16, p float64) uint64 {
+	return CutOffAbsoluteValueOf(accessNumbers(m, start, end), p)
+}
+
+// CutOffAbsoluteValueOf returns the lowest value in the top p percent of the values after duplicates
+// have been removed
+func CutOffAbsoluteValueOf(values []uint64, p float64) uint64 {
 	temp := map[uint64]bool{}
 
-	for count := start; count <= end; count++ {
-		temp[m.GetStatistics(count)] = true
+	for _, j := range values {
+		temp[j] = true
 	}
 
 	keys := []uint64{}
@@ -27,23 +46,33 @@ func CutOffAbsoluteValue(m memory.Memory, start uint16, end uint16, p float64) u
 
 	l := float64(lenKeys)
 	cutOffIndex := int(l * (1.0 - p))
+	if cutOffIndex >= lenKeys {
+		return keys[lenKeys-1] + 1
+	}
 
 	return keys[cutOffIndex]
 }
 
 func CutOffMedian(m memory.Memory, start uint16, end uint16, p float64) uint64 {
 	// length of memory area is at least 1
+	return CutOffMedianOf(accessNumbers(m, start, end), p)
+}
 
-	temp := []uint64{}
-
-	for count := start; count <= end; count++ {
-		temp = append(temp, m.GetStatistics(count))
+// CutOffMedianOf returns the lowest value in the top p percent of the values
+func CutOffMedianOf(values []uint64, p float64) uint64 {
+	if len(values) == 0 {
+		return 0
 	}
 
+	temp := append([]uint64{}, values...)
+
 	sort.Slice(temp, func(i, j int) bool { return temp[i] < temp[j] })
 
 	l := float64(len(temp))
 	cutOffIndex := int(l * (1.0 - p))
+	if cutOffIndex >= len(temp) {
+		return temp[len(temp)-1] + 1
+	}
 
 	return temp[cutOffIndex]
 }
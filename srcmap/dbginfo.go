@@ -0,0 +1,328 @@
+package srcmap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Type of the line records which belong to the definition of a macro
+const dbgLineMacro = 2
+
+type dbgSpan struct {
+	seg   int
+	start uint32
+	size  int
+}
+
+type dbgLine struct {
+	file  int
+	line  int
+	kind  int
+	spans []int
+}
+
+type dbgScope struct {
+	name   string
+	parent int
+}
+
+type dbgSymbol struct {
+	name  string
+	scope int
+	// parent is the id of the symbol a cheap local symbol belongs to or -1
+	parent int
+	value  uint32
+}
+
+// DebugInfo contains the data of a debug info file which has been written by ld65 (option --dbgfile
+// or -Wl --dbgfile,name for cl65)
+type DebugInfo struct {
+	files    map[int]string
+	segments map[int]uint32
+	spans    map[int]dbgSpan
+	lines    []dbgLine
+	scopes   map[int]dbgScope
+	symbols  map[int]dbgSymbol
+	values   []int
+}
+
+// dbgRecord is a line of a debug info file, e.g. `span	id=0,seg=0,start=0,size=2`
+type dbgRecord struct {
+	kind  string
+	attrs map[string]string
+}
+
+func (r *dbgRecord) int(name string) (int, error) {
+	v, ok := r.attrs[name]
+	if !ok {
+		return 0, fmt.Errorf("%s: attribute %s missing", r.kind, name)
+	}
+
+	res, err := strconv.ParseInt(v, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: attribute %s: %v", r.kind, name, err)
+	}
+
+	return int(res), nil
+}
+
+// ids parses a list of ids like 1+2+3
+func (r *dbgRecord) ids(name string) []int {
+	res := []int{}
+
+	v, ok := r.attrs[name]
+	if !ok {
+		return res
+	}
+
+	for _, j := range strings.Split(v, "+") {
+		if id, err := strconv.Atoi(j); err == nil {
+			res = append(res, id)
+		}
+	}
+
+	return res
+}
+
+// parseDbgRecord splits a line into its type and its comma separated attributes. Values may be
+// quoted strings.
+func parseDbgRecord(line string) dbgRecord {
+	res := dbgRecord{attrs: map[string]string{}}
+
+	kind, rest, _ := strings.Cut(line, "\t")
+	res.kind = strings.TrimSpace(kind)
+
+	for rest != "" {
+		name, value, _ := strings.Cut(rest, "=")
+		rest = ""
+
+		if strings.HasPrefix(value, "\"") {
+			end := strings.Index(value[1:], "\"")
+			if end < 0 {
+				end = len(value) - 1
+			}
+
+			rest = strings.TrimPrefix(value[end+2:], ",")
+			value = value[1 : end+1]
+		} else if i := strings.Index(value, ","); i >= 0 {
+			rest = value[i+1:]
+			value = value[:i]
+		}
+
+		res.attrs[strings.TrimSpace(name)] = value
+	}
+
+	return res
+}
+
+// ReadDebugInfo parses a debug info file created by ld65
+func ReadDebugInfo(r io.Reader) (*DebugInfo, error) {
+	res := &DebugInfo{
+		files:    map[int]string{},
+		segments: map[int]uint32{},
+		spans:    map[int]dbgSpan{},
+		scopes:   map[int]dbgScope{},
+		symbols:  map[int]dbgSymbol{},
+	}
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+
+		rec := parseDbgRecord(scanner.Text())
+		if err := res.add(&rec); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (d *DebugInfo) add(rec *dbgRecord) error {
+	switch rec.kind {
+	case "file", "seg", "span", "line", "scope", "sym":
+	default:
+		return nil
+	}
+
+	id, err := rec.int("id")
+	if err != nil {
+		return err
+	}
+
+	switch rec.kind {
+	case "file":
+		d.files[id] = absName(rec.attrs["name"])
+	case "seg":
+		start, err := rec.int("start")
+		if err != nil {
+			return err
+		}
+
+		d.segments[id] = uint32(start)
+	case "span":
+		seg, err := rec.int("seg")
+		if err != nil {
+			return err
+		}
+
+		start, err := rec.int("start")
+		if err != nil {
+			return err
+		}
+
+		size, err := rec.int("size")
+		if err != nil {
+			return err
+		}
+
+		d.spans[id] = dbgSpan{seg: seg, start: uint32(start), size: size}
+	case "line":
+		file, err := rec.int("file")
+		if err != nil {
+			return err
+		}
+
+		line, err := rec.int("line")
+		if err != nil {
+			return err
+		}
+
+		// The type is missing for lines of assembler source
+		kind, _ := rec.int("type")
+
+		d.lines = append(d.lines, dbgLine{file: file, line: line, kind: kind, spans: rec.ids("span")})
+	case "scope":
+		parent, err := rec.int("parent")
+		if err != nil {
+			// Only the outermost scope has no parent
+			parent = -1
+		}
+
+		d.scopes[id] = dbgScope{name: rec.attrs["name"], parent: parent}
+	case "sym":
+		sym := dbgSymbol{name: rec.attrs["name"], scope: -1, parent: -1}
+
+		if scope, err := rec.int("scope"); err == nil {
+			sym.scope = scope
+		} else if parent, err := rec.int("parent"); err == nil {
+			sym.parent = parent
+		}
+
+		// Imported symbols and symbols whose value is not known have no value
+		value, err := rec.int("val")
+		if (err == nil) && ((rec.attrs["type"] == "lab") || (rec.attrs["type"] == "equ")) {
+			sym.value = uint32(value)
+			d.values = append(d.values, id)
+		}
+
+		d.symbols[id] = sym
+	}
+
+	return nil
+}
+
+// SourceMap returns the source lines which have generated the program. Code generated by a macro is
+// assigned to the line which has invoked the macro.
+func (d *DebugInfo) SourceMap() *Map {
+	res := New()
+
+	lines := append([]dbgLine{}, d.lines...)
+	// Later lines replace the mapping of earlier ones, i.e. lines in macro definitions have to come first
+	sort.SliceStable(lines, func(i, j int) bool {
+		return (lines[i].kind == dbgLineMacro) && (lines[j].kind != dbgLineMacro)
+	})
+
+	for _, l := range lines {
+		file, ok := d.files[l.file]
+		if !ok {
+			continue
+		}
+
+		for _, j := range l.spans {
+			span, ok := d.spans[j]
+			if !ok || (span.size == 0) {
+				continue
+			}
+
+			res.Add(d.segments[span.seg]+span.start, span.size, Location{File: file, Line: l.line})
+		}
+	}
+
+	return res
+}
+
+// scopeName returns the qualified name of a scope, e.g. outer::inner. The outermost scope has no name.
+func (d *DebugInfo) scopeName(id int) string {
+	names := []string{}
+
+	for i := 0; i < len(d.scopes); i++ {
+		scope, ok := d.scopes[id]
+		if !ok {
+			break
+		}
+
+		if scope.name != "" {
+			names = append([]string{scope.name}, names...)
+		}
+
+		id = scope.parent
+	}
+
+	return strings.Join(names, "::")
+}
+
+// Labels returns the labels and constants of the program. Symbols defined in a scope are qualified by
+// the name of the scope, e.g. sub::loop, which is the syntax used by ca65 to refer to them.
+func (d *DebugInfo) Labels() map[uint16][]string {
+	res := map[uint16][]string{}
+
+	for _, id := range d.values {
+		j := d.symbols[id]
+		if j.value > 0xFFFF {
+			continue
+		}
+
+		// Cheap local symbols belong to the scope of the symbol they follow
+		scope := j.scope
+		if parent, ok := d.symbols[j.parent]; ok {
+			scope = parent.scope
+		}
+
+		name := j.name
+		if prefix := d.scopeName(scope); prefix != "" {
+			name = prefix + "::" + name
+		}
+
+		res[uint16(j.value)] = append(res[uint16(j.value)], name)
+	}
+
+	return res
+}
+
+// LoadDebugInfo reads the named debug info file
+func LoadDebugInfo(fileName string) (*DebugInfo, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load debug info: %v", err)
+	}
+	defer func() { f.Close() }()
+
+	res, err := ReadDebugInfo(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse debug info '%s': %v", fileName, err)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,225 @@
+package srcmap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const FormatAcme = "acme"
+const Format64tass = "64tass"
+const FormatCa65 = "ca65"
+const FormatCa65Dbg = "ca65dbg"
+
+// FormatForAssembler returns the listing format which is written by the given assembler type
+func FormatForAssembler(asmType string) string {
+	switch asmType {
+	case "64tass":
+		return Format64tass
+	case "ca65":
+		return FormatCa65
+	}
+
+	return FormatAcme
+}
+
+// FormatForFile returns the format of the named listing. Debug info files written by ld65 are
+// recognized by their extension. All other files are expected to be in defaultFormat.
+func FormatForFile(fileName string, defaultFormat string) string {
+	if strings.EqualFold(filepath.Ext(fileName), ".dbg") {
+		return FormatCa65Dbg
+	}
+
+	return defaultFormat
+}
+
+// LoadListing reads a listing file in the given format. Relative addresses in ca65 listings are
+// interpreted relative to relocBase. Debug info files of ld65 contain absolute addresses.
+func LoadListing(fileName string, format string, relocBase uint32) (*Map, error) {
+	if format == FormatCa65Dbg {
+		info, err := LoadDebugInfo(fileName)
+		if err != nil {
+			return nil, err
+		}
+
+		return info.SourceMap(), nil
+	}
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load listing: %v", err)
+	}
+	defer func() { f.Close() }()
+
+	var res *Map
+
+	switch format {
+	case FormatAcme:
+		res, err = ParseAcmeReport(f)
+	case Format64tass:
+		res, err = Parse64tassListing(f)
+	case FormatCa65:
+		res, err = ParseCa65Listing(f, relocBase)
+	default:
+		return nil, fmt.Errorf("unknown listing format '%s'", format)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse listing '%s': %v", fileName, err)
+	}
+
+	return res, nil
+}
+
+// absName makes relative source file names absolute. Assemblers print file names as they have been
+// passed to them, i.e. relative to the current directory.
+func absName(name string) string {
+	res, err := filepath.Abs(strings.TrimSpace(name))
+	if err != nil {
+		return name
+	}
+
+	return res
+}
+
+// countHexBytes returns the number of bytes in a string of hex digits which may be separated by
+// spaces. A trailing "..." is ignored.
+func countHexBytes(hexBytes string) int {
+	hexBytes = strings.TrimSuffix(hexBytes, "...")
+	return len(strings.ReplaceAll(hexBytes, " ", "")) / 2
+}
+
+// Report lines look like "    12  0810 a9008d2004          lda #0"
+var acmeLine = regexp.MustCompile(`^\s*(\d+)\s+([0-9a-fA-F]{4}) ([0-9a-fA-F]+(?:\.\.\.)?)`)
+
+const acmeSource = "; ******** Source: "
+
+// ParseAcmeReport parses a report file which has been created by ACME with the -r option
+func ParseAcmeReport(r io.Reader) (*Map, error) {
+	res := New()
+	currentFile := ""
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.HasPrefix(line, acmeSource) {
+			currentFile = absName(line[len(acmeSource):])
+			continue
+		}
+
+		parts := acmeLine.FindStringSubmatch(line)
+		if (parts == nil) || (currentFile == "") {
+			continue
+		}
+
+		lineNum, _ := strconv.Atoi(parts[1])
+		addr, _ := strconv.ParseUint(parts[2], 16, 16)
+
+		res.Add(uint32(addr), countHexBytes(parts[3]), Location{File: currentFile, Line: lineNum})
+	}
+
+	return res, scanner.Err()
+}
+
+// Listing lines look like "12\t.0810\ta9 00\t\tlda #$00\t  lda #0". If more than one file is
+// involved the line number is prefixed by the number of the file, e.g. "2:12".
+var tassLine = regexp.MustCompile(`^(?:(\d+):)?(\d+)\t\.([0-9a-fA-F]{4,6})\t((?:[0-9a-fA-F]{2} ?)+)`)
+var tassFile = regexp.MustCompile(`^:(\d+)\t;\*+\s+Processing (?:input )?file: (.+)$`)
+
+// Parse64tassListing parses a listing which has been created by 64tass with the options -L and
+// --line-numbers
+func Parse64tassListing(r io.Reader) (*Map, error) {
+	res := New()
+	files := map[string]string{}
+	currentFile := ""
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if parts := tassFile.FindStringSubmatch(line); parts != nil {
+			currentFile = absName(parts[2])
+			files[parts[1]] = currentFile
+
+			continue
+		}
+
+		parts := tassLine.FindStringSubmatch(line)
+		if parts == nil {
+			continue
+		}
+
+		file := currentFile
+		if parts[1] != "" {
+			file = files[parts[1]]
+		}
+
+		if file == "" {
+			continue
+		}
+
+		lineNum, _ := strconv.Atoi(parts[2])
+		addr, _ := strconv.ParseUint(parts[3], 16, 32)
+
+		res.Add(uint32(addr), countHexBytes(parts[4]), Location{File: file, Line: lineNum})
+	}
+
+	return res, scanner.Err()
+}
+
+// Listing lines look like "000010r 1  A9 00           lda #0". The r marks addresses which are
+// relative to the start of the segment. Bytes which are not known to the assembler are printed as
+// rr or xx.
+var ca65Line = regexp.MustCompile(`^([0-9A-Fa-f]{6})(r?)\s+(\d+)\s+((?:[0-9A-Frx]{2} )*)(.*)$`)
+var ca65MainFile = regexp.MustCompile(`^Main file\s*:\s*(.+)$`)
+
+// ParseCa65Listing parses a listing which has been created by ca65 with the -l option. ca65 does not
+// print line numbers, so they are counted. Only the lines of the main file are taken into account.
+func ParseCa65Listing(r io.Reader, relocBase uint32) (*Map, error) {
+	res := New()
+	mainFile := ""
+	lineNum := 0
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if parts := ca65MainFile.FindStringSubmatch(line); parts != nil {
+			mainFile = absName(parts[1])
+			continue
+		}
+
+		parts := ca65Line.FindStringSubmatch(line)
+		if (parts == nil) || (parts[3] != "1") {
+			continue
+		}
+
+		numBytes := countHexBytes(parts[4])
+
+		// Lines which only contain bytes continue the previous line
+		if (numBytes > 0) && (strings.TrimSpace(parts[5]) == "") && (lineNum > 0) {
+			continue
+		}
+
+		lineNum++
+
+		if (numBytes == 0) || (mainFile == "") {
+			continue
+		}
+
+		addr, _ := strconv.ParseUint(parts[1], 16, 32)
+		if parts[2] == "r" {
+			addr += uint64(relocBase)
+		}
+
+		res.Add(uint32(addr), numBytes, Location{File: mainFile, Line: lineNum})
+	}
+
+	return res, scanner.Err()
+}
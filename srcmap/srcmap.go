@@ -0,0 +1,137 @@
+package srcmap
+
+import (
+	"path/filepath"
+	"sort"
+)
+
+// Location is a line in a source file
+type Location struct {
+	File string
+	Line int
+}
+
+// Map links the addresses of a program to the source lines which have generated the bytes stored
+// at these addresses
+type Map struct {
+	locations map[uint32]Location
+	// Start addresses of the bytes generated by a source line. A line can generate code at more
+	// than one address, e.g. if it is part of a macro.
+	lines map[Location][]uint32
+}
+
+func New() *Map {
+	return &Map{
+		locations: map[uint32]Location{},
+		lines:     map[Location][]uint32{},
+	}
+}
+
+// Add records that the source line loc has generated length bytes starting at addr
+func (m *Map) Add(addr uint32, length int, loc Location) {
+	m.lines[loc] = append(m.lines[loc], addr)
+
+	for i := 0; i < length; i++ {
+		m.locations[addr+uint32(i)] = loc
+	}
+}
+
+// Merge adds all entries of other to m
+func (m *Map) Merge(other *Map) {
+	for addr, loc := range other.locations {
+		m.locations[addr] = loc
+	}
+
+	for loc, addrs := range other.lines {
+		m.lines[loc] = append(m.lines[loc], addrs...)
+	}
+}
+
+// Lookup returns the source line which has generated the byte at addr
+func (m *Map) Lookup(addr uint32) (Location, bool) {
+	loc, ok := m.locations[addr]
+	return loc, ok
+}
+
+// Lines returns all source lines contained in m
+func (m *Map) Lines() []Location {
+	res := []Location{}
+
+	for loc := range m.lines {
+		res = append(res, loc)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].File != res[j].File {
+			return res[i].File < res[j].File
+		}
+
+		return res[i].Line < res[j].Line
+	})
+
+	return res
+}
+
+// Files returns the names of all source files contained in m
+func (m *Map) Files() []string {
+	found := map[string]bool{}
+	res := []string{}
+
+	for loc := range m.lines {
+		if !found[loc.File] {
+			found[loc.File] = true
+			res = append(res, loc.File)
+		}
+	}
+
+	sort.Strings(res)
+
+	return res
+}
+
+// SameFile returns true if the file names a and b refer to the same file. If one of them is a
+// relative name, it is compared to the end of the other one. If this does not lead to a result
+// only the base names are compared.
+func SameFile(a string, b string) bool {
+	a, b = filepath.Clean(a), filepath.Clean(b)
+	if a == b {
+		return true
+	}
+
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if (errA == nil) && (errB == nil) && (absA == absB) {
+		return true
+	}
+
+	return filepath.Base(a) == filepath.Base(b)
+}
+
+// Resolve returns the addresses of the code generated by the given line of file. If the line has not
+// generated any code the next line which has generated code is used. The returned line is the one
+// which has been used. found is false if there is no such line.
+func (m *Map) Resolve(file string, line int) (addrs []uint32, actualLine int, found bool) {
+	bestLine := 0
+
+	for loc, j := range m.lines {
+		if (loc.Line < line) || !SameFile(loc.File, file) {
+			continue
+		}
+
+		if (bestLine == 0) || (loc.Line < bestLine) {
+			bestLine = loc.Line
+			addrs = j
+		} else if loc.Line == bestLine {
+			addrs = append(append([]uint32{}, addrs...), j...)
+		}
+	}
+
+	if bestLine == 0 {
+		return nil, 0, false
+	}
+
+	res := append([]uint32{}, addrs...)
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+
+	return res, bestLine, true
+}
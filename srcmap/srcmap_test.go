@@ -0,0 +1,227 @@
+package srcmap
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func checkLookup(t *testing.T, m *Map, addr uint32, file string, line int) {
+	t.Helper()
+
+	loc, ok := m.Lookup(addr)
+	if !ok {
+		t.Fatalf("$%04X: no source line found", addr)
+	}
+
+	if (filepath.Base(loc.File) != file) || (loc.Line != line) {
+		t.Fatalf("$%04X: expected %s:%d, got %s:%d", addr, file, line, loc.File, loc.Line)
+	}
+}
+
+func checkResolve(t *testing.T, m *Map, file string, line int, expectedLine int, expectedAddrs ...uint32) {
+	t.Helper()
+
+	addrs, actual, ok := m.Resolve(file, line)
+	if !ok {
+		t.Fatalf("%s:%d: no code found", file, line)
+	}
+
+	if (actual != expectedLine) || (len(addrs) != len(expectedAddrs)) {
+		t.Fatalf("%s:%d: expected line %d at %v, got line %d at %v", file, line, expectedLine, expectedAddrs, actual, addrs)
+	}
+
+	for i, j := range expectedAddrs {
+		if addrs[i] != j {
+			t.Fatalf("%s:%d: expected addresses %v, got %v", file, line, expectedAddrs, addrs)
+		}
+	}
+}
+
+const acmeReport = `
+; ******** Source: test/main.a
+     1                          * = $0800
+     2                          main
+     3  0800 a905               lda #5
+     4  0802 200808             jsr sub
+     5  0805 4c0508             jmp *
+
+; ******** Source: src/sub.a
+     1                          sub
+     2  0808 a9008d2004a2ff...  !byte $a9, $00, $8d, $20, $04, $a2, $ff, $60, $00
+`
+
+func TestAcmeReport(t *testing.T) {
+	m, err := ParseAcmeReport(strings.NewReader(acmeReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkLookup(t, m, 0x0800, "main.a", 3)
+	checkLookup(t, m, 0x0801, "main.a", 3)
+	checkLookup(t, m, 0x0804, "main.a", 4)
+	checkLookup(t, m, 0x080E, "sub.a", 2)
+
+	if _, ok := m.Lookup(0x0900); ok {
+		t.Fatal("address without code found in report")
+	}
+
+	checkResolve(t, m, "main.a", 2, 3, 0x0800)
+	checkResolve(t, m, "/somewhere/else/sub.a", 1, 2, 0x0808)
+
+	if _, _, ok := m.Resolve("main.a", 6); ok {
+		t.Fatal("line after the last line with code resolved")
+	}
+
+	if files := m.Files(); (len(files) != 2) || !filepath.IsAbs(files[0]) {
+		t.Fatalf("unexpected source files %v", files)
+	}
+}
+
+const tassListing = ";64tass Turbo Assembler Macro V1.56 listing file\n" +
+	";64tass --line-numbers -L test.lst main.asm\n" +
+	"\n" +
+	";Line\t;Offset\t;Hex\t\t;Monitor\t;Source\n" +
+	"\n" +
+	":1\t;******  Processing input file: main.asm\n" +
+	"\n" +
+	"3\t.0800\ta9 05\t\tlda #$05\t        lda #5\n" +
+	"4\t.0802\t20 0a 08\tjsr $080a\t        jsr sub\n" +
+	"\n" +
+	":2\t;******  Processing file: sub.asm\n" +
+	"\n" +
+	"2:2\t.080a\t60\t\trts\t        rts\n" +
+	"1:6\t.080b\t00\t\tbrk\t        brk\n"
+
+func Test64tassListing(t *testing.T) {
+	m, err := Parse64tassListing(strings.NewReader(tassListing))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkLookup(t, m, 0x0800, "main.asm", 3)
+	checkLookup(t, m, 0x0804, "main.asm", 4)
+	checkLookup(t, m, 0x080A, "sub.asm", 2)
+	checkLookup(t, m, 0x080B, "main.asm", 6)
+	checkResolve(t, m, "main.asm", 5, 6, 0x080B)
+}
+
+const ca65Listing = `ca65 V2.19 - Git 2c4d4d3
+Main file   : main.s
+Current file: main.s
+
+000000r 1               .segment "CODE"
+000000r 1  A9 05        main:   lda #5
+000002r 1  20 rr rr             jsr sub
+000005r 1               .include "sub.inc"
+000005r 2  60           sub:    rts
+000006r 1  01 02 03 04          .byte 1, 2, 3, 4, 5, 6
+00000Ar 1  05 06        
+00000Cr 1  EA                   nop
+`
+
+func TestCa65Listing(t *testing.T) {
+	m, err := ParseCa65Listing(strings.NewReader(ca65Listing), 0x0800)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkLookup(t, m, 0x0800, "main.s", 2)
+	checkLookup(t, m, 0x0804, "main.s", 3)
+	checkLookup(t, m, 0x0806, "main.s", 5)
+	checkLookup(t, m, 0x080C, "main.s", 6)
+
+	if _, ok := m.Lookup(0x0805); ok {
+		t.Fatal("line of an included file mapped to the main file")
+	}
+
+	checkResolve(t, m, "main.s", 4, 5, 0x0806)
+}
+
+func TestMerge(t *testing.T) {
+	m := New()
+	m.Add(0x0800, 2, Location{"a.a", 1})
+
+	other := New()
+	other.Add(0x0800, 1, Location{"b.a", 7})
+	other.Add(0x1000, 1, Location{"b.a", 7})
+
+	m.Merge(other)
+
+	checkLookup(t, m, 0x0800, "b.a", 7)
+	checkLookup(t, m, 0x0801, "a.a", 1)
+	checkResolve(t, m, "b.a", 7, 7, 0x0800, 0x1000)
+
+	if lines := m.Lines(); (len(lines) != 2) || (lines[0] != Location{"a.a", 1}) || (lines[1] != Location{"b.a", 7}) {
+		t.Fatalf("wrong lines: %v", lines)
+	}
+}
+
+const ca65DebugInfo = `version	major=2,minor=0
+info	csym=0,file=2,lib=0,line=6,mod=1,scope=1,seg=2,span=5,sym=2,type=3
+file	id=0,name="test/main.s",size=120,mtime=0x6550A1B2,mod=0
+file	id=1,name="test/macros.inc",size=40,mtime=0x6550A1B2,mod=0
+line	id=0,file=0,line=3,span=0
+line	id=1,file=0,line=4,span=1
+line	id=2,file=1,line=2,type=2,count=1,span=2
+line	id=3,file=0,line=6,span=2+3
+line	id=4,file=0,line=8,span=4
+line	id=5,file=0,line=1
+mod	id=0,name="main.o",file=0
+seg	id=0,name="CODE",start=0x000801,size=0x000A,addrsize=absolute,type=rw,oname="main.prg",ooffs=2
+seg	id=1,name="DATA",start=0x001000,size=0x0002,addrsize=absolute,type=rw,oname="main.prg",ooffs=12
+span	id=0,seg=0,start=0,size=2,type=0
+span	id=1,seg=0,start=2,size=3
+span	id=2,seg=0,start=5,size=1
+span	id=3,seg=0,start=6,size=2
+span	id=4,seg=1,start=0,size=2
+scope	id=0,name="",mod=0,size=10,span=0+1+2+3
+scope	id=1,name="sub",mod=0,type=scope,size=5,parent=0,sym=1,span=2+3
+scope	id=2,name="inner",mod=0,type=scope,size=2,parent=1,span=3
+sym	id=0,name="main",addrsize=absolute,scope=0,def=0,val=0x801,seg=0,type=lab
+sym	id=1,name="sub",addrsize=absolute,scope=0,def=3,val=0x806,seg=0,type=lab
+sym	id=2,name="loop",addrsize=absolute,scope=2,def=3,val=0x807,seg=0,type=lab
+sym	id=3,name="@skip",addrsize=absolute,parent=2,def=3,val=0x808,seg=0,type=lab
+sym	id=4,name="CHROUT",addrsize=absolute,scope=0,def=0,val=0xFFD2,type=equ
+sym	id=5,name="extern",addrsize=absolute,scope=0,ref=4,type=imp
+`
+
+func TestCa65DebugInfo(t *testing.T) {
+	info, err := ReadDebugInfo(strings.NewReader(ca65DebugInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := info.SourceMap()
+
+	checkLookup(t, m, 0x0801, "main.s", 3)
+	checkLookup(t, m, 0x0802, "main.s", 3)
+	checkLookup(t, m, 0x0803, "main.s", 4)
+	// The code of the macro is assigned to the line which has invoked it
+	checkLookup(t, m, 0x0806, "main.s", 6)
+	checkLookup(t, m, 0x0808, "main.s", 6)
+	checkLookup(t, m, 0x1001, "main.s", 8)
+
+	if _, ok := m.Lookup(0x0809); ok {
+		t.Fatal("address outside of the spans mapped")
+	}
+
+	checkResolve(t, m, "main.s", 5, 6, 0x0806, 0x0807)
+
+	labels := info.Labels()
+	expected := map[uint16]string{0x0801: "main", 0x0806: "sub", 0x0807: "sub::inner::loop", 0x0808: "sub::inner::@skip", 0xFFD2: "CHROUT"}
+
+	if len(labels) != len(expected) {
+		t.Fatalf("wrong number of labels: %v", labels)
+	}
+
+	for addr, name := range expected {
+		if (len(labels[addr]) != 1) || (labels[addr][0] != name) {
+			t.Fatalf("wrong label at $%04X: %v", addr, labels[addr])
+		}
+	}
+
+	if FormatForFile("main.DBG", FormatCa65) != FormatCa65Dbg || FormatForFile("main.lst", FormatCa65) != FormatCa65 {
+		t.Fatal("wrong format for file")
+	}
+}
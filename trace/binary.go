@@ -0,0 +1,172 @@
+package trace
+
+import (
+	"6502profiler/cpu"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The binary format starts with the magic bytes, the model, the register mask and the flag names.
+// Each record follows as a sequence of unsigned varints: the difference of its clock cycle count
+// to the one of the previous record, the number of cycles used, the PC, the number of instruction
+// bytes followed by the bytes themselves, the existing registers, a byte containing the access
+// type and the effective address if there is one.
+const binaryMagic = "6502TRC1"
+
+const flagHasEffectiveAddress = 1
+const flagEffectiveWrite = 2
+
+func appendHeader(buf []byte, h Header) []byte {
+	buf = append(buf, binaryMagic...)
+	buf = append(buf, byte(h.Model))
+	buf = binary.LittleEndian.AppendUint16(buf, h.RegisterMask)
+	buf = append(buf, byte(len(h.FlagNames)))
+
+	return append(buf, h.FlagNames...)
+}
+
+func appendRecord(buf []byte, h Header, r *cpu.TraceRecord, lastCycle uint64) []byte {
+	buf = binary.AppendUvarint(buf, r.Cycle-lastCycle)
+	buf = binary.AppendUvarint(buf, r.CyclesUsed)
+	buf = binary.AppendUvarint(buf, uint64(r.PC))
+	buf = append(buf, byte(r.NumBytes))
+	buf = append(buf, r.Bytes[:r.NumBytes]...)
+
+	for i, j := range r.Registers {
+		if (h.RegisterMask & (1 << i)) != 0 {
+			buf = binary.AppendUvarint(buf, uint64(j))
+		}
+	}
+
+	var flags byte
+	if r.HasEffectiveAddress {
+		flags |= flagHasEffectiveAddress
+	}
+
+	if r.EffectiveWrite {
+		flags |= flagEffectiveWrite
+	}
+
+	buf = append(buf, flags)
+
+	if r.HasEffectiveAddress {
+		buf = binary.AppendUvarint(buf, uint64(r.EffectiveAddress))
+	}
+
+	return buf
+}
+
+// Reader reads a trace in the binary format
+type Reader struct {
+	Header    Header
+	in        *bufio.Reader
+	lastCycle uint64
+	record    cpu.TraceRecord
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	res := &Reader{in: bufio.NewReader(r)}
+
+	fixed := make([]byte, len(binaryMagic)+4)
+	if _, err := io.ReadFull(res.in, fixed); err != nil {
+		return nil, fmt.Errorf("unable to read trace header: %v", err)
+	}
+
+	if string(fixed[:len(binaryMagic)]) != binaryMagic {
+		return nil, fmt.Errorf("not a binary trace file")
+	}
+
+	res.Header.Model = cpu.CpuModel(fixed[len(binaryMagic)])
+	res.Header.RegisterMask = binary.LittleEndian.Uint16(fixed[len(binaryMagic)+1:])
+
+	names := make([]byte, fixed[len(binaryMagic)+3])
+	if _, err := io.ReadFull(res.in, names); err != nil {
+		return nil, fmt.Errorf("unable to read trace header: %v", err)
+	}
+
+	res.Header.FlagNames = string(names)
+
+	return res, nil
+}
+
+// Next returns the next record of the trace. The record is reused by the following call. At the
+// end of the trace io.EOF is returned.
+func (t *Reader) Next() (*cpu.TraceRecord, error) {
+	r := &t.record
+
+	cycleDelta, err := binary.ReadUvarint(t.in)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.CyclesUsed, err = binary.ReadUvarint(t.in); err != nil {
+		return nil, t.truncated(err)
+	}
+
+	pc, err := binary.ReadUvarint(t.in)
+	if err != nil {
+		return nil, t.truncated(err)
+	}
+
+	t.lastCycle += cycleDelta
+	r.Cycle = t.lastCycle
+	r.PC = uint32(pc)
+
+	numBytes, err := t.in.ReadByte()
+	if err != nil {
+		return nil, t.truncated(err)
+	}
+
+	if int(numBytes) > cpu.MaxTracedBytes {
+		return nil, fmt.Errorf("corrupt trace record")
+	}
+
+	r.NumBytes = int(numBytes)
+	if _, err = io.ReadFull(t.in, r.Bytes[:r.NumBytes]); err != nil {
+		return nil, t.truncated(err)
+	}
+
+	for i := range r.Registers {
+		r.Registers[i] = 0
+
+		if (t.Header.RegisterMask & (1 << i)) != 0 {
+			v, err := binary.ReadUvarint(t.in)
+			if err != nil {
+				return nil, t.truncated(err)
+			}
+
+			r.Registers[i] = uint16(v)
+		}
+	}
+
+	flags, err := t.in.ReadByte()
+	if err != nil {
+		return nil, t.truncated(err)
+	}
+
+	r.HasEffectiveAddress = (flags & flagHasEffectiveAddress) != 0
+	r.EffectiveWrite = (flags & flagEffectiveWrite) != 0
+	r.EffectiveAddress = 0
+
+	if r.HasEffectiveAddress {
+		ea, err := binary.ReadUvarint(t.in)
+		if err != nil {
+			return nil, t.truncated(err)
+		}
+
+		r.EffectiveAddress = uint32(ea)
+	}
+
+	return r, nil
+}
+
+func (t *Reader) truncated(err error) error {
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("trace file is truncated")
+	}
+
+	return err
+}
@@ -0,0 +1,183 @@
+package trace
+
+import (
+	"6502profiler/cpu"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AddrRange is an inclusive range of addresses
+type AddrRange struct {
+	Start uint32
+	End   uint32
+}
+
+// Filter selects the instructions which are written to a trace. An instruction is traced if it
+// matches all conditions which have been set.
+type Filter struct {
+	// The address of the instruction has to be in one of the ranges
+	Ranges []AddrRange
+	// One of the subroutines has to be active, i.e. it has been called and has not returned yet
+	Subroutines []uint32
+	// The clock cycle count before the instruction has to be at least StartCycle and lower than
+	// StopCycle. A StopCycle of 0 means that there is no upper limit.
+	StartCycle uint64
+	StopCycle  uint64
+	subActive  bool
+}
+
+// ParseAddress converts an address or a label into an address. Addresses can be given in decimal
+// or in hex using $ or 0x as a prefix.
+func ParseAddress(spec string, labels map[uint16][]string) (uint32, error) {
+	spec = strings.TrimSpace(spec)
+
+	num := spec
+	if strings.HasPrefix(num, "$") {
+		num = "0x" + num[1:]
+	}
+
+	addr, err := strconv.ParseUint(num, 0, 24)
+	if err == nil {
+		return uint32(addr), nil
+	}
+
+	for labelAddr, names := range labels {
+		for _, j := range names {
+			if j == spec {
+				return uint32(labelAddr), nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("'%s' is neither an address nor a known label", spec)
+}
+
+// ParseFilter creates a filter from a comma separated list of conditions. The following
+// conditions are understood:
+//
+//	range=start-end  instructions between start and end (both inclusive)
+//	range=addr       the instruction at addr
+//	sub=addr         instructions executed while the subroutine at addr is active
+//	from=n           instructions which start at or after clock cycle n
+//	to=n             instructions which start before clock cycle n
+//
+// Addresses can be given as numbers or as labels. range and sub can be used more than once.
+func ParseFilter(spec string, labels map[uint16][]string) (*Filter, error) {
+	res := &Filter{
+		Ranges:      []AddrRange{},
+		Subroutines: []uint32{},
+	}
+
+	if strings.TrimSpace(spec) == "" {
+		return res, nil
+	}
+
+	for _, term := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(term, "=")
+		if !ok {
+			return nil, fmt.Errorf("trace filter condition '%s' has no value", term)
+		}
+
+		key = strings.TrimSpace(key)
+
+		switch key {
+		case "range":
+			startSpec, endSpec, isRange := strings.Cut(value, "-")
+			if !isRange {
+				endSpec = startSpec
+			}
+
+			start, err := ParseAddress(startSpec, labels)
+			if err != nil {
+				return nil, err
+			}
+
+			end, err := ParseAddress(endSpec, labels)
+			if err != nil {
+				return nil, err
+			}
+
+			if end < start {
+				return nil, fmt.Errorf("end of address range '%s' is lower than its start", value)
+			}
+
+			res.Ranges = append(res.Ranges, AddrRange{start, end})
+		case "sub":
+			addr, err := ParseAddress(value, labels)
+			if err != nil {
+				return nil, err
+			}
+
+			res.Subroutines = append(res.Subroutines, addr)
+		case "from", "to":
+			cycle, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("'%s' is not a valid clock cycle count", value)
+			}
+
+			if key == "from" {
+				res.StartCycle = cycle
+			} else {
+				res.StopCycle = cycle
+			}
+		default:
+			return nil, fmt.Errorf("unknown trace filter condition '%s'", key)
+		}
+	}
+
+	return res, nil
+}
+
+// subroutineActive returns true if one of the subroutines of the filter is on the call stack of p
+func (f *Filter) subroutineActive(p cpu.Processor) bool {
+	for _, frame := range p.CallStack() {
+		for _, j := range f.Subroutines {
+			if frame.Target == j {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Matches returns true if the instruction described by r has to be traced. p is the CPU which has
+// executed the instruction. Matches has to be called for every executed instruction because it
+// keeps track of the subroutine calls.
+func (f *Filter) Matches(p cpu.Processor, r *cpu.TraceRecord) bool {
+	inSubroutine := true
+
+	if len(f.Subroutines) != 0 {
+		// The tracer sees the call stack after the instruction. Whether the instruction has been
+		// executed inside a subroutine is therefore determined by the previous instruction. The
+		// first instruction of a subroutine counts as inside, so that a program which starts with
+		// the subroutine is also traced.
+		inSubroutine = f.subActive
+		for _, j := range f.Subroutines {
+			inSubroutine = inSubroutine || (r.PC == j)
+		}
+
+		f.subActive = f.subroutineActive(p)
+	}
+
+	if !inSubroutine || (r.Cycle < f.StartCycle) {
+		return false
+	}
+
+	if (f.StopCycle != 0) && (r.Cycle >= f.StopCycle) {
+		return false
+	}
+
+	if len(f.Ranges) == 0 {
+		return true
+	}
+
+	for _, j := range f.Ranges {
+		if (r.PC >= j.Start) && (r.PC <= j.End) {
+			return true
+		}
+	}
+
+	return false
+}
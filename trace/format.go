@@ -0,0 +1,109 @@
+package trace
+
+import (
+	"6502profiler/cpu"
+	"6502profiler/disasm"
+	"fmt"
+	"strings"
+)
+
+// Names of the registers in cpu.TraceRegisters
+var registerNames = [len(cpu.TraceRegisters)]string{"A", "C", "X", "Y", "Z", "B", "SP", "D", "DBR", "PBR", "Flags", "E"}
+
+const indexFlags = 10
+const indexE = 11
+
+// Header describes the CPU whose instructions are contained in a trace
+type Header struct {
+	Model cpu.CpuModel
+	// Bit i is set if cpu.TraceRegisters[i] exists in the CPU
+	RegisterMask uint16
+	FlagNames    string
+}
+
+// NewHeader determines the header for traces of the instructions executed by p
+func NewHeader(p cpu.Processor) Header {
+	res := Header{
+		Model:     p.Model(),
+		FlagNames: p.FlagNames(),
+	}
+
+	for i, j := range cpu.TraceRegisters {
+		if _, ok := p.GetRegister(j); ok {
+			res.RegisterMask |= 1 << i
+		}
+	}
+
+	return res
+}
+
+// Formatter turns trace records into lines of text
+type Formatter struct {
+	header Header
+	dis    *disasm.Disassembler
+}
+
+func NewFormatter(h Header, labels map[uint16][]string) *Formatter {
+	res := &Formatter{
+		header: h,
+		dis:    disasm.New(h.Model),
+	}
+
+	res.dis.SetLabels(labels)
+
+	return res
+}
+
+func (f *Formatter) registers(r *cpu.TraceRecord) string {
+	res := []string{}
+
+	for i, j := range r.Registers {
+		if (f.header.RegisterMask & (1 << i)) == 0 {
+			continue
+		}
+
+		switch i {
+		case indexFlags:
+			flags := ""
+			for bit := 0; bit < 8; bit++ {
+				if (j & (0x80 >> bit)) != 0 {
+					flags += string(f.header.FlagNames[bit])
+				} else {
+					flags += "."
+				}
+			}
+
+			res = append(res, "Flags="+flags)
+		case indexE:
+			res = append(res, fmt.Sprintf("E=%d", j))
+		default:
+			res = append(res, fmt.Sprintf("%s=$%02X", registerNames[i], j))
+		}
+	}
+
+	return strings.Join(res, " ")
+}
+
+// Format returns the clock cycle count, the disassembled instruction, the registers before the
+// instruction has been executed and the effective address in one line
+func (f *Formatter) Format(r *cpu.TraceRecord) string {
+	if f.header.Model == cpu.Model65816 {
+		flags := uint8(r.Registers[indexFlags])
+		native := r.Registers[indexE] == 0
+		f.dis.SetRegisterWidths(native && ((flags&cpu.Flag_M) == 0), native && ((flags&cpu.Flag_X) == 0))
+	}
+
+	instr := f.dis.Decode(r.Bytes[:r.NumBytes], r.PC)
+	res := fmt.Sprintf("%12d  %-40s  %s", r.Cycle, instr.String(), f.registers(r))
+
+	if r.HasEffectiveAddress {
+		access := "r"
+		if r.EffectiveWrite {
+			access = "w"
+		}
+
+		res += fmt.Sprintf("  EA=%s (%s)", disasm.FormatAddress(r.EffectiveAddress), access)
+	}
+
+	return res
+}
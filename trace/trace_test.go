@@ -0,0 +1,144 @@
+package trace
+
+import (
+	"6502profiler/cpu"
+	"6502profiler/memory"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	labels := map[uint16][]string{0x0900: {"sub"}}
+
+	f, err := ParseFilter("range=$0800-0x0810, range=sub, sub=sub, from=10, to=20", labels)
+	if err != nil {
+		t.Fatalf("unable to parse filter: %v", err)
+	}
+
+	if (len(f.Ranges) != 2) || (f.Ranges[0] != AddrRange{0x0800, 0x0810}) || (f.Ranges[1] != AddrRange{0x0900, 0x0900}) {
+		t.Fatalf("wrong ranges: %v", f.Ranges)
+	}
+
+	if (len(f.Subroutines) != 1) || (f.Subroutines[0] != 0x0900) || (f.StartCycle != 10) || (f.StopCycle != 20) {
+		t.Fatalf("wrong filter: %+v", f)
+	}
+
+	for _, j := range []string{"range=$0810-$0800", "range=unknown", "from=x", "foo=1", "range"} {
+		if _, err := ParseFilter(j, labels); err == nil {
+			t.Fatalf("filter '%s' accepted", j)
+		}
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	f, err := ParseFilter("range=100-200,from=5,to=50", nil)
+	if err != nil {
+		t.Fatalf("unable to parse filter: %v", err)
+	}
+
+	checks := []struct {
+		pc       uint32
+		cycle    uint64
+		expected bool
+	}{
+		{150, 10, true},
+		{150, 4, false},
+		{150, 50, false},
+		{99, 10, false},
+		{200, 10, true},
+	}
+
+	for _, j := range checks {
+		if f.Matches(nil, &cpu.TraceRecord{PC: j.pc, Cycle: j.cycle}) != j.expected {
+			t.Fatalf("wrong result for PC %d, cycle %d", j.pc, j.cycle)
+		}
+	}
+}
+
+func traceProgram(t *testing.T, w *Writer, prog []byte) {
+	t.Helper()
+
+	p := cpu.New6502(cpu.Model6502)
+	p.Init(memory.NewLinearMemory(65536))
+	p.CopyToMem(prog, 0x0800)
+	p.SetTracer(w)
+
+	if err := p.Run(0x0800); err != nil {
+		t.Fatalf("program failed: %v", err)
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unable to write trace: %v", err)
+	}
+}
+
+func TestSubroutineFilter(t *testing.T) {
+	// jsr sub
+	// inx
+	// brk
+	// sub iny
+	// rts
+	out := &bytes.Buffer{}
+	traceProgram(t, NewWriter(out, false, &Filter{Subroutines: []uint32{0x0805}}, nil), []byte{0x20, 0x05, 0x08, 0xE8, 0x00, 0xC8, 0x60})
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if (len(lines) != 2) || !strings.Contains(lines[0], "INY") || !strings.Contains(lines[1], "RTS") {
+		t.Fatalf("wrong trace:\n%s", out.String())
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	// lda #$05
+	// sta $0720
+	// ldx $0720
+	// brk
+	prog := []byte{0xA9, 0x05, 0x8D, 0x20, 0x07, 0xAE, 0x20, 0x07, 0x00}
+
+	text := &bytes.Buffer{}
+	traceProgram(t, NewWriter(text, false, nil, nil), prog)
+
+	bin := &bytes.Buffer{}
+	traceProgram(t, NewWriter(bin, true, nil, nil), prog)
+
+	reader, err := NewReader(bin)
+	if err != nil {
+		t.Fatalf("unable to read trace header: %v", err)
+	}
+
+	if (reader.Header.Model != cpu.Model6502) || (reader.Header.FlagNames == "") {
+		t.Fatalf("wrong header: %+v", reader.Header)
+	}
+
+	formatter := NewFormatter(reader.Header, nil)
+	decoded := ""
+
+	for {
+		r, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			t.Fatalf("unable to read trace record: %v", err)
+		}
+
+		decoded += formatter.Format(r) + "\n"
+	}
+
+	if decoded != text.String() {
+		t.Fatalf("binary trace differs from text trace:\n%s\n%s", decoded, text.String())
+	}
+
+	if !strings.Contains(decoded, "EA=0720 (r)") || !strings.Contains(decoded, "EA=0720 (w)") {
+		t.Fatalf("effective addresses missing:\n%s", decoded)
+	}
+}
+
+func TestReaderRejectsOtherFiles(t *testing.T) {
+	if _, err := NewReader(strings.NewReader("this is not a trace")); err == nil {
+		t.Fatal("text accepted as binary trace")
+	}
+}
@@ -0,0 +1,77 @@
+package trace
+
+import (
+	"6502profiler/cpu"
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Writer is a cpu.Tracer which writes the records accepted by its filter either as text or in the
+// binary format
+type Writer struct {
+	out       *bufio.Writer
+	binary    bool
+	filter    *Filter
+	labels    map[uint16][]string
+	header    *Header
+	formatter *Formatter
+	buf       []byte
+	lastCycle uint64
+	err       error
+}
+
+// NewWriter creates a Writer. If filter is nil all instructions are written. The labels are only
+// used for the text format.
+func NewWriter(w io.Writer, binary bool, filter *Filter, labels map[uint16][]string) *Writer {
+	if filter == nil {
+		filter = &Filter{}
+	}
+
+	return &Writer{
+		out:    bufio.NewWriter(w),
+		binary: binary,
+		filter: filter,
+		labels: labels,
+	}
+}
+
+func (w *Writer) writeHeader(p cpu.Processor) {
+	h := NewHeader(p)
+	w.header = &h
+
+	if w.binary {
+		_, w.err = w.out.Write(appendHeader(nil, h))
+	} else {
+		w.formatter = NewFormatter(h, w.labels)
+	}
+}
+
+// Trace implements cpu.Tracer. The header of the trace is determined by the CPU which executes the
+// first instruction.
+func (w *Writer) Trace(p cpu.Processor, r *cpu.TraceRecord) {
+	if w.header == nil {
+		w.writeHeader(p)
+	}
+
+	if !w.filter.Matches(p, r) || (w.err != nil) {
+		return
+	}
+
+	if w.binary {
+		w.buf = appendRecord(w.buf[:0], *w.header, r, w.lastCycle)
+		w.lastCycle = r.Cycle
+		_, w.err = w.out.Write(w.buf)
+	} else {
+		_, w.err = fmt.Fprintln(w.out, w.formatter.Format(r))
+	}
+}
+
+// Flush writes buffered data. It returns the first error which occurred while writing the trace.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	return w.out.Flush()
+}
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"sort"
@@ -12,6 +13,16 @@ const (
 	ExitOk          int = 0
 )
 
+// ExitCodeError is returned by a command which has completed but has to end the process with the
+// given exit code, e.g. because the simulated program has written it to its exit port
+type ExitCodeError struct {
+	Code int
+}
+
+func (e *ExitCodeError) Error() string {
+	return fmt.Sprintf("exit code %d", e.Code)
+}
+
 // CommandFunc is a type for functions that implement a subcommand
 type CommandFunc func(arguments []string) error
 
@@ -40,8 +51,9 @@ func (s *SubCommParser) AddCommand(name string, f CommandFunc, usage string) {
 	}
 }
 
-// Execute parses the command line and calls the appropriate command function
-func (s *SubCommParser) Execute() {
+// Execute parses the command line and calls the appropriate command function. It returns the exit
+// code of the process.
+func (s *SubCommParser) Execute() int {
 	switch commandLineLength := len(os.Args); {
 	case commandLineLength < 2:
 		s.PrintDefaults()
@@ -60,11 +72,19 @@ func (s *SubCommParser) Execute() {
 		}
 
 		err := subCommand.command(os.Args[2:])
+
+		var exitCodeErr *ExitCodeError
+		if errors.As(err, &exitCodeErr) {
+			return exitCodeErr.Code
+		}
+
 		if err != nil {
 			fmt.Println(err)
-			os.Exit(ExitError)
+			return ExitError
 		}
 	}
+
+	return ExitOk
 }
 
 // PrintDefaults prints a description of the availbale commands
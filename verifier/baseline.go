@@ -0,0 +1,78 @@
+package verifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Baseline maps the file names of test cases to the clock cycles they have used when the baseline
+// has been recorded
+type Baseline map[string]uint64
+
+func NewBaseline() Baseline {
+	return Baseline{}
+}
+
+// LoadBaseline reads a baseline file written by Save
+func LoadBaseline(fileName string) (Baseline, error) {
+	res := NewBaseline()
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load baseline file %s: %v", fileName, err)
+	}
+
+	err = json.Unmarshal(data, &res)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load baseline file %s: %v", fileName, err)
+	}
+
+	return res, nil
+}
+
+// Save writes the baseline as a JSON object
+func (b Baseline) Save(fileName string) error {
+	data, err := json.MarshalIndent(b, "", "    ")
+	if err != nil {
+		return fmt.Errorf("unable to save baseline file %s: %v", fileName, err)
+	}
+
+	err = os.WriteFile(fileName, data, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to save baseline file %s: %v", fileName, err)
+	}
+
+	return nil
+}
+
+// baselineKey returns the name under which a test case is stored. The extension of the test case
+// file can be omitted.
+func baselineKey(caseName string) string {
+	if !strings.HasSuffix(caseName, TestCaseExtension) {
+		caseName += TestCaseExtension
+	}
+
+	return caseName
+}
+
+// Record stores the clock cycles used by a test case
+func (b Baseline) Record(caseName string, cycles uint64) {
+	b[baselineKey(caseName)] = cycles
+}
+
+// Check returns an error if a test case has used more than tolerance percent clock cycles above its
+// baseline. Test cases which are not part of the baseline always pass.
+func (b Baseline) Check(caseName string, cycles uint64, tolerance float64) error {
+	base, ok := b[baselineKey(caseName)]
+	if !ok {
+		return nil
+	}
+
+	if float64(cycles) > float64(base)*(1.0+tolerance/100.0) {
+		return fmt.Errorf("%d clock cycles used, which exceeds the baseline of %d by more than %.2f%%", cycles, base, tolerance)
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package verifier
+
+import (
+	"path"
+	"testing"
+)
+
+func TestCheckCycles(t *testing.T) {
+	c := NewTestCase("Test case 1", "test1")
+
+	if c.checkCycles(1000) != nil {
+		t.Fatal("Test case without budget failed")
+	}
+
+	c.MaxCycles = 100
+
+	if (c.checkCycles(100) != nil) || (c.checkCycles(101) == nil) {
+		t.Fatal("MaxCycles not checked correctly")
+	}
+
+	c.MaxCycles = 0
+	c.ExpectedCycles = 200
+	c.CycleTolerance = 5.0
+
+	if (c.checkCycles(190) != nil) || (c.checkCycles(210) != nil) {
+		t.Fatal("Cycles within tolerance rejected")
+	}
+
+	if (c.checkCycles(189) == nil) || (c.checkCycles(211) == nil) {
+		t.Fatal("Cycles outside of tolerance accepted")
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	b := NewBaseline()
+	b.Record("test1", 1000)
+	b.Record("test2.json", 200)
+
+	fileName := path.Join(t.TempDir(), "baseline")
+	if err := b.Save(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := LoadBaseline(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if (b["test1.json"] != 1000) || (b["test2.json"] != 200) {
+		t.Fatalf("Wrong baseline: %v", b)
+	}
+
+	if (b.Check("test1.json", 1000, 0.0) != nil) || (b.Check("test1", 999, 0.0) != nil) {
+		t.Fatal("Test case within baseline failed")
+	}
+
+	if b.Check("test1", 1001, 0.0) == nil {
+		t.Fatal("Test case exceeding baseline passed")
+	}
+
+	if (b.Check("test2", 220, 10.0) != nil) || (b.Check("test2", 221, 10.0) == nil) {
+		t.Fatal("Tolerance not applied correctly")
+	}
+
+	if b.Check("test3", 100000, 0.0) != nil {
+		t.Fatal("Test case without baseline failed")
+	}
+}
@@ -0,0 +1,203 @@
+package verifier
+
+import (
+	"6502profiler/assembler"
+	"6502profiler/cpu"
+	"6502profiler/disasm"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// BenchRun describes one execution of a test driver during a benchmark
+type BenchRun struct {
+	Run    uint
+	Input  string
+	Cycles uint64
+}
+
+// HistogramBucket counts the runs which have used between From and To clock cycles (both inclusive)
+type HistogramBucket struct {
+	From  uint64
+	To    uint64
+	Count int
+}
+
+// BenchResult contains the runs of a benchmark
+type BenchResult struct {
+	Runs []BenchRun
+	// sorted contains the runs in ascending order of their clock cycles
+	sorted []BenchRun
+}
+
+func NewBenchResult(runs []BenchRun) *BenchResult {
+	sorted := append([]BenchRun{}, runs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Cycles < sorted[j].Cycles })
+
+	return &BenchResult{Runs: runs, sorted: sorted}
+}
+
+func (b *BenchResult) Min() uint64 {
+	return b.Percentile(0.0)
+}
+
+func (b *BenchResult) Max() uint64 {
+	return b.Percentile(100.0)
+}
+
+func (b *BenchResult) Median() uint64 {
+	return b.Percentile(50.0)
+}
+
+func (b *BenchResult) Mean() float64 {
+	if len(b.Runs) == 0 {
+		return 0.0
+	}
+
+	sum := 0.0
+	for _, j := range b.Runs {
+		sum += float64(j.Cycles)
+	}
+
+	return sum / float64(len(b.Runs))
+}
+
+func (b *BenchResult) StdDev() float64 {
+	if len(b.Runs) == 0 {
+		return 0.0
+	}
+
+	mean := b.Mean()
+	sum := 0.0
+
+	for _, j := range b.Runs {
+		sum += (float64(j.Cycles) - mean) * (float64(j.Cycles) - mean)
+	}
+
+	return math.Sqrt(sum / float64(len(b.Runs)))
+}
+
+// Percentile returns the smallest number of clock cycles which is not exceeded by p percent of the
+// runs (nearest rank method)
+func (b *BenchResult) Percentile(p float64) uint64 {
+	if len(b.sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p / 100.0 * float64(len(b.sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	if rank > len(b.sorted) {
+		rank = len(b.sorted)
+	}
+
+	return b.sorted[rank-1].Cycles
+}
+
+// Best returns at most n runs which have used the fewest clock cycles
+func (b *BenchResult) Best(n int) []BenchRun {
+	if n > len(b.sorted) {
+		n = len(b.sorted)
+	}
+
+	return b.sorted[:n]
+}
+
+// Worst returns at most n runs which have used the most clock cycles. The worst run comes first.
+func (b *BenchResult) Worst(n int) []BenchRun {
+	if n > len(b.sorted) {
+		n = len(b.sorted)
+	}
+
+	res := []BenchRun{}
+	for i := len(b.sorted) - 1; i >= len(b.sorted)-n; i-- {
+		res = append(res, b.sorted[i])
+	}
+
+	return res
+}
+
+// Histogram distributes the runs over at most numBuckets buckets of equal width
+func (b *BenchResult) Histogram(numBuckets int) []HistogramBucket {
+	res := []HistogramBucket{}
+
+	if (len(b.sorted) == 0) || (numBuckets < 1) {
+		return res
+	}
+
+	min, max := b.Min(), b.Max()
+	width := (max - min + uint64(numBuckets)) / uint64(numBuckets)
+
+	for from := min; from <= max; from += width {
+		res = append(res, HistogramBucket{From: from, To: from + width - 1})
+	}
+
+	for _, j := range b.sorted {
+		res[(j.Cycles-min)/width].Count++
+	}
+
+	return res
+}
+
+// WriteCSV writes one record for each run
+func (b *BenchResult) WriteCSV(w io.Writer) error {
+	c := csv.NewWriter(w)
+
+	_ = c.Write([]string{"Run", "Cycles", "Input"})
+
+	for _, j := range b.Runs {
+		_ = c.Write([]string{fmt.Sprint(j.Run), fmt.Sprint(j.Cycles), j.Input})
+	}
+
+	c.Flush()
+
+	return c.Error()
+}
+
+// Bench runs the test driver numRuns times. The memory is restored to the state it had after the test
+// driver has been loaded and the CPU is reset before each run. Then the arrange function of the test
+// script is called with the number of the run. It generates the input of the run and may return a
+// description of it. The assert function has to accept the results of each run.
+func (t *TestCase) Bench(cpu cpu.Processor, asm assembler.Assembler, scriptPath string, numRuns uint, id string) (*BenchResult, error) {
+	s, err := t.start(cpu, asm, scriptPath, nil, id)
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+
+	cpu.GetMem().TakeSnapshot()
+	runs := []BenchRun{}
+
+	for i := uint(0); i < numRuns; i++ {
+		cpu.GetMem().RestoreSnapshot()
+		cpu.Reset()
+		cpu.SetPC(s.loadAddress)
+
+		input, err := s.ctx.CallArrangeRun(i)
+		if err != nil {
+			return nil, fmt.Errorf("unable to arrange run %d of '%s': %v", i, t.Name, err)
+		}
+
+		err = disasm.AnnotateError(cpu, cpu.RunExt(cpu.GetPC(), false))
+		if err != nil {
+			return nil, fmt.Errorf("unable to execute run %d of '%s' (input %s): %v", i, t.Name, input, err)
+		}
+
+		ok, msg, err := s.ctx.CallAssert()
+		if err != nil {
+			return nil, fmt.Errorf("unable to assert run %d of '%s': %v", i, t.Name, err)
+		}
+
+		if !ok {
+			return nil, fmt.Errorf("run %d of '%s' failed (input %s): %s", i, t.Name, input, msg)
+		}
+
+		runs = append(runs, BenchRun{Run: i, Input: input, Cycles: cpu.NumCycles()})
+	}
+
+	return NewBenchResult(runs), nil
+}
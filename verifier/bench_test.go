@@ -0,0 +1,50 @@
+package verifier
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBenchStatistics(t *testing.T) {
+	runs := []BenchRun{}
+	for i, j := range []uint64{50, 10, 40, 20, 30, 100, 60, 70, 80, 90} {
+		runs = append(runs, BenchRun{Run: uint(i), Input: string(rune('a' + i)), Cycles: j})
+	}
+
+	b := NewBenchResult(runs)
+
+	if (b.Min() != 10) || (b.Max() != 100) || (b.Median() != 50) || (b.Mean() != 55.0) {
+		t.Fatalf("Wrong statistics: %d %d %d %f", b.Min(), b.Max(), b.Median(), b.Mean())
+	}
+
+	if (b.Percentile(90.0) != 90) || (b.Percentile(91.0) != 100) || (b.Percentile(10.0) != 10) {
+		t.Fatal("Wrong percentiles")
+	}
+
+	if best := b.Best(2); (len(best) != 2) || (best[0].Input != "b") || (best[1].Input != "d") {
+		t.Fatalf("Wrong best runs: %v", best)
+	}
+
+	if worst := b.Worst(20); (len(worst) != 10) || (worst[0].Input != "f") || (worst[9].Input != "b") {
+		t.Fatalf("Wrong worst runs: %v", worst)
+	}
+
+	h := b.Histogram(4)
+	if (len(h) != 4) || (h[0].From != 10) || (h[0].To != 32) || (h[0].Count != 3) || (h[3].To != 101) || (h[3].Count != 3) {
+		t.Fatalf("Wrong histogram: %v", h)
+	}
+
+	h = NewBenchResult([]BenchRun{{Cycles: 7}, {Cycles: 7}}).Histogram(10)
+	if (len(h) != 1) || (h[0].Count != 2) {
+		t.Fatalf("Wrong histogram for constant values: %v", h)
+	}
+
+	out := &strings.Builder{}
+	if err := b.WriteCSV(out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(out.String(), "Run,Cycles,Input\n0,50,a\n") {
+		t.Fatalf("Wrong CSV output: %s", out.String())
+	}
+}
@@ -3,9 +3,11 @@ package verifier
 import (
 	"6502profiler/assembler"
 	"6502profiler/cpu"
+	"6502profiler/disasm"
 	"6502profiler/memory"
 	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"path"
 	"strings"
@@ -33,6 +35,21 @@ type TestCase struct {
 	Name             string
 	TestDriverSource string
 	TestScript       string
+	// CycleLimit and InstructionLimit override the execution limits of the config if they are not zero
+	CycleLimit       uint64 `json:",omitempty"`
+	InstructionLimit uint64 `json:",omitempty"`
+	// StopOnReturn and StopAddresses are added to the termination conditions of the config
+	StopOnReturn  bool     `json:",omitempty"`
+	StopAddresses []uint32 `json:",omitempty"`
+	// Writing to ExitPort ends the test driver if it is set
+	ExitPort *uint16 `json:",omitempty"`
+	// Writing to IrqAckPort deasserts the IRQ line while the test driver runs if it is set
+	IrqAckPort *uint16 `json:",omitempty"`
+	// The test fails if all iterations together use more than MaxCycles clock cycles or if they differ
+	// from ExpectedCycles by more than CycleTolerance percent. Zero values disable these checks.
+	MaxCycles      uint64  `json:",omitempty"`
+	ExpectedCycles uint64  `json:",omitempty"`
+	CycleTolerance float64 `json:",omitempty"`
 }
 
 func NewTestCase(description string, caseName string) *TestCase {
@@ -67,39 +84,129 @@ func NewTestCaseFromFile(fileName string) (*TestCase, error) {
 	return res, nil
 }
 
-func (t *TestCase) Execute(cpu *cpu.CPU6502, asm assembler.Assembler, scriptPath string, subcaseProc SubcaseProcessor, p *memory.PlaceholderWrapper, id string) error {
-	var testRes bool = true
-	var testMsg string
-	var i uint
+// termination returns the conditions which end the test driver
+func (t *TestCase) termination(defaults cpu.TerminationConditions) cpu.TerminationConditions {
+	res := cpu.TerminationConditions{
+		StopOnReturn:  defaults.StopOnReturn || t.StopOnReturn,
+		StopAddresses: append([]uint32{}, defaults.StopAddresses...),
+	}
+
+	res.StopAddresses = append(res.StopAddresses, t.StopAddresses...)
+
+	return res
+}
+
+// addExitPort installs the exit port of the test case. The returned function removes it.
+func (t *TestCase) addExitPort(p cpu.Processor) func() {
+	return cpu.AddExitPort(p, *t.ExitPort)
+}
+
+// addIrqAckPort installs the IRQ acknowledge port of the test case. The returned function removes it.
+func (t *TestCase) addIrqAckPort(p cpu.Processor) func() {
+	return cpu.AddIrqAckPort(p, *t.IrqAckPort)
+}
+
+// executionLimits returns the limits which apply to the test case
+func (t *TestCase) executionLimits(defaults cpu.ExecutionLimits) cpu.ExecutionLimits {
+	res := defaults
+
+	if t.CycleLimit != 0 {
+		res.MaxCycles = t.CycleLimit
+	}
+
+	if t.InstructionLimit != 0 {
+		res.MaxInstructions = t.InstructionLimit
+	}
+
+	return res
+}
+
+// checkCycles tests whether the clock cycles used by the test driver are within the budget of the
+// test case
+func (t *TestCase) checkCycles(cycles uint64) error {
+	if (t.MaxCycles != 0) && (cycles > t.MaxCycles) {
+		return fmt.Errorf("%d clock cycles used, which exceeds the maximum of %d", cycles, t.MaxCycles)
+	}
+
+	if t.ExpectedCycles == 0 {
+		return nil
+	}
+
+	deviation := 100.0 * math.Abs(float64(cycles)-float64(t.ExpectedCycles)) / float64(t.ExpectedCycles)
+	if deviation > t.CycleTolerance {
+		return fmt.Errorf("%d clock cycles used, which differs from the expected %d by %.2f%%", cycles, t.ExpectedCycles, deviation)
+	}
+
+	return nil
+}
+
+// session contains the state of a test case whose test driver has been loaded and whose test script
+// has been started
+type session struct {
+	L           *lua.LState
+	ctx         *luabridge.LuaCtx
+	loadAddress uint16
+	cleanup     []func()
+}
 
+// close restores the state of the CPU and stops the test script
+func (s *session) close() {
+	for i := len(s.cleanup) - 1; i >= 0; i-- {
+		s.cleanup[i]()
+	}
+}
+
+// start assembles and loads the test driver, installs the limits and termination conditions of the
+// test case and starts the test script. The returned session has to be closed.
+func (t *TestCase) start(cpu cpu.Processor, asm assembler.Assembler, scriptPath string, p *memory.PlaceholderWrapper, id string) (*session, error) {
 	binaryToTest, err := asm.Assemble(t.TestDriverSource)
 	if err != nil {
-		return fmt.Errorf("unable to execute test case '%s': %v", t.Name, err)
+		return nil, fmt.Errorf("unable to execute test case '%s': %v", t.Name, err)
 	}
 
 	loadAdress, progLen, err := cpu.Load(binaryToTest)
 	if err != nil {
-		return fmt.Errorf("unable to execute test case '%s': %v", t.Name, err)
+		return nil, fmt.Errorf("unable to execute test case '%s': %v", t.Name, err)
 	}
 
 	scriptToRun := path.Join(scriptPath, t.TestScript)
 
 	L := lua.NewState()
-	defer L.Close()
+	res := &session{L: L, loadAddress: loadAdress, cleanup: []func(){L.Close}}
 
 	ctx := luabridge.NewLuaCtx(cpu, scriptPath, L)
 	ctx.SetIdent(id)
+	res.ctx = ctx
 
 	err = ctx.RegisterGlobals(L, loadAdress, progLen)
 	if err != nil {
-		return fmt.Errorf("unable to register Lua functions: %v", err)
+		res.close()
+		return nil, fmt.Errorf("unable to register Lua functions: %v", err)
 	}
 
-	cpu.PC = loadAdress
+	cpu.SetPC(loadAdress)
+
+	// The CPU may be reused by the next test case
+	defaultLimits := cpu.GetExecutionLimits()
+	cpu.SetExecutionLimits(t.executionLimits(defaultLimits))
+	res.cleanup = append(res.cleanup, func() { cpu.SetExecutionLimits(defaultLimits) })
+
+	defaultTermination := cpu.GetTermination()
+	cpu.SetTermination(t.termination(defaultTermination))
+	res.cleanup = append(res.cleanup, func() { cpu.SetTermination(defaultTermination) })
+
+	if t.ExitPort != nil {
+		res.cleanup = append(res.cleanup, t.addExitPort(cpu))
+	}
+
+	if t.IrqAckPort != nil {
+		res.cleanup = append(res.cleanup, t.addIrqAckPort(cpu))
+	}
 
 	err = L.DoFile(scriptToRun)
 	if err != nil {
-		return fmt.Errorf("unable to load test script: %v", err)
+		res.close()
+		return nil, fmt.Errorf("unable to load test script: %v", err)
 	}
 
 	if p != nil {
@@ -109,11 +216,28 @@ func (t *TestCase) Execute(cpu *cpu.CPU6502, asm assembler.Assembler, scriptPath
 				panic(fmt.Sprintf("unable to call trap function: %v", err))
 			}
 		})
-		defer func() {
+		res.cleanup = append(res.cleanup, func() {
 			_ = ctx.CallCleanup()
-		}()
+		})
 	}
 
+	return res, nil
+}
+
+func (t *TestCase) Execute(cpu cpu.Processor, asm assembler.Assembler, scriptPath string, subcaseProc SubcaseProcessor, p *memory.PlaceholderWrapper, id string) error {
+	var testRes bool = true
+	var testMsg string
+	var i uint
+
+	s, err := t.start(cpu, asm, scriptPath, p, id)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	ctx := s.ctx
+	startCycles := cpu.NumCycles()
+
 	numIters, err := ctx.CallNumIterations()
 	if err != nil {
 		numIters = 1
@@ -129,7 +253,7 @@ func (t *TestCase) Execute(cpu *cpu.CPU6502, asm assembler.Assembler, scriptPath
 			subcaseProc(i, numIters)
 		}
 
-		err = cpu.RunExt(cpu.PC, false)
+		err = disasm.AnnotateError(cpu, cpu.RunExt(cpu.GetPC(), false))
 		if err != nil {
 			return fmt.Errorf("unable to execute test case '%s': %v", t.Name, err)
 		}
@@ -144,5 +268,9 @@ func (t *TestCase) Execute(cpu *cpu.CPU6502, asm assembler.Assembler, scriptPath
 		return fmt.Errorf("test failed: %s", testMsg)
 	}
 
+	if err = t.checkCycles(cpu.NumCycles() - startCycles); err != nil {
+		return fmt.Errorf("test failed: %v", err)
+	}
+
 	return nil
 }
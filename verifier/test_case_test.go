@@ -0,0 +1,73 @@
+package verifier
+
+import (
+	"6502profiler/assembler"
+	"6502profiler/cpu"
+	"6502profiler/memory"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+const irqDriver = `* = $0800
+    cli
+    nop
+    brk
+irq
+    inx
+    sta $d019
+    rti
+`
+
+const irqScript = `function arrange()
+    write_byte(0xFFFE, 0x03)
+    write_byte(0xFFFF, 0x08)
+    set_xreg(0)
+    raise_irq()
+end
+
+function assert()
+    return get_xreg() == 1, string.format("IRQ handler called %d times", get_xreg())
+end
+`
+
+func TestIrqAcknowledgedByTestDriver(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.WriteFile(path.Join(dir, "irq.a"), []byte(irqDriver), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path.Join(dir, "irq.lua"), []byte(irqScript), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	asm := assembler.NewBuiltin(dir, dir, dir)
+	p := cpu.NewProcessor(cpu.Model6502)
+	p.Init(memory.NewLinearMemory(65536))
+	p.SetExecutionLimits(cpu.ExecutionLimits{MaxCycles: 10000})
+
+	// Without an acknowledge port the level triggered IRQ calls the handler again after each RTI
+	c := NewTestCaseWithDriver("IRQ test", "irq", "irq.a")
+	if err := c.Execute(p, asm, dir, nil, nil, ""); (err == nil) || !strings.Contains(err.Error(), "cycle limit") {
+		t.Fatalf("IRQ which is never acknowledged not detected: %v", err)
+	}
+
+	p.GetInterrupts().ClearIrq()
+
+	var ackPort uint16 = 0xD019
+	c.IrqAckPort = &ackPort
+
+	if err := c.Execute(p, asm, dir, nil, nil, ""); err != nil {
+		t.Fatalf("IRQ not acknowledged: %v", err)
+	}
+
+	if p.GetInterrupts().IrqActive() {
+		t.Fatal("IRQ line still asserted")
+	}
+
+	if _, ok := p.GetMem().(*memory.WrappingMemory); ok {
+		t.Fatal("Acknowledge port not removed after test case")
+	}
+}